@@ -171,12 +171,32 @@ func MinContainerAge() time.Duration {
 	return time.Second * time.Duration(interval)
 }
 
+// AgentCRIEndpoint returns the CRI socket of the container runtime the
+// container GC talks to with crictl, an empty endpoint disables the GC.
+func AgentCRIEndpoint() string {
+	return cfg.DefaultString("agent::CRIEndpoint", "unix:///run/containerd/containerd.sock")
+}
+
 // ServicesMonitScriptsDir returns the services monit scripts directory.
 func ServicesMonitScriptsDir() string {
 	return AbsPath(cfg.DefaultString("agent::ServicesMonitScriptsDir",
 		"monit"))
 }
 
+// ServiceMonitorInterval returns the interval the agent checks the services
+// of ServicesMonitScriptsDir, 0 disables the supervisor.
+func ServiceMonitorInterval() time.Duration {
+	interval := cfg.DefaultInt("agent::ServiceMonitorInterval", 30)
+	return time.Second * time.Duration(interval)
+}
+
+// ServiceActionTimeout returns the timeout of a status, start or stop action
+// of a service script.
+func ServiceActionTimeout() time.Duration {
+	timeout := cfg.DefaultInt("agent::ServiceActionTimeout", 60)
+	return time.Second * time.Duration(timeout)
+}
+
 // CacheRedisConfig returns the configuration of cache module that in Redis mode.
 func CacheRedisConfig() (string, error) {
 	rc := GetRedisConfig()
@@ -650,6 +670,7 @@ func JobScale() string {
 }
 
 // DiskNames returns the disk names split by comma, be used for disk I/O monitor.
+// "*" monitors all disks.
 func DiskNames() string {
 	return cfg.DefaultString("DiskNames", "sda")
 }
@@ -687,6 +708,13 @@ func UserMaxUID() int {
 	return cfg.DefaultInt("uid::UserMaxUID", 60000)
 }
 
+// AccountAdmins returns the cluster admins, they provision the accounts of
+// other users and manage the nodes and their agents. Everyone else only
+// provisions their own account.
+func AccountAdmins() []string {
+	return cfg.DefaultStrings("uid::Admins", nil)
+}
+
 // -------------    LDAP related    --------------------
 
 // LDAPHost returns the LDAP server IP address.
@@ -857,6 +885,29 @@ func HPCMySQLConfig() MySQLConfig {
 	}
 }
 
+// KubeConfigPath returns the kubeconfig file used to reach the cluster, an
+// empty path means the server runs inside the cluster.
+func KubeConfigPath() string {
+	return cfg.DefaultString("backend::KubeConfig", "")
+}
+
+// StorageBackend returns the storage backend of the server, "mysql" or the
+// embedded "file" backend for single node installs.
+func StorageBackend() string {
+	return cfg.DefaultString("backend::StorageBackend", "mysql")
+}
+
+// FileDBPath returns the database file of the embedded storage backend.
+func FileDBPath() string {
+	return AbsPath(cfg.DefaultString("backend::FileDBPath", "data/k8s-server.db"))
+}
+
+// FileDBMaxRecords returns how many audit logs and events the embedded storage
+// backend keeps, zero keeps everything.
+func FileDBMaxRecords() int {
+	return cfg.DefaultInt("backend::FileDBMaxRecords", 10000)
+}
+
 // ConfigVersion replaces the release version if it IS NOT empty.
 func ConfigVersion() string {
 	return cfg.String("version")
@@ -867,6 +918,179 @@ func AgentServerPort() int {
 	return cfg.DefaultInt("AgentServerPort", 6380)
 }
 
+// AgentProcRoot returns the procfs mount point read by the agent collector,
+// it differs from /proc when the agent runs in a container.
+func AgentProcRoot() string {
+	return cfg.DefaultString("agent::ProcRoot", "/proc")
+}
+
+// AgentSysRoot returns the sysfs mount point read by the agent collector.
+func AgentSysRoot() string {
+	return cfg.DefaultString("agent::SysRoot", "/sys")
+}
+
+// AgentToken returns the bearer token required by the agent API, an empty
+// token disables the check.
+func AgentToken() string {
+	return cfg.DefaultString("agent::Token", "")
+}
+
+// AgentSampleWindow returns the number of CPU and memory usage samples the
+// agent keeps, one is taken every MonitorInterval.
+func AgentSampleWindow() int {
+	return cfg.DefaultInt("agent::SampleWindow", 120)
+}
+
+// AgentNodeName returns the Kubernetes node name of the agent host, it
+// defaults to the hostname.
+func AgentNodeName() string {
+	hostname, _ := os.Hostname()
+	return cfg.DefaultString("agent::NodeName", hostname)
+}
+
+// AgentLabelInterval returns the interval the agent publishes the CPU topology
+// to its node labels, 0 disables publishing.
+func AgentLabelInterval() time.Duration {
+	interval := cfg.DefaultInt("agent::LabelInterval", 600)
+	return time.Second * time.Duration(interval)
+}
+
+// AgentTaintUnhealthy reports whether the agent taints its node while
+// critical health checks fail.
+func AgentTaintUnhealthy() bool {
+	return cfg.DefaultBool("agent::TaintUnhealthy", true)
+}
+
+// AgentUpdatePublicKey returns the base64 encoded ed25519 public key agent
+// updates are signed with, an empty key disables agent updates.
+func AgentUpdatePublicKey() string {
+	return cfg.DefaultString("agent::UpdatePublicKey", "")
+}
+
+// AgentUpdateConfirmTimeout returns how long an updated agent has to publish
+// a heartbeat before it rolls back to the previous binary.
+func AgentUpdateConfirmTimeout() time.Duration {
+	timeout := cfg.DefaultInt("agent::UpdateConfirmTimeout", 120)
+	return time.Second * time.Duration(timeout)
+}
+
+// AgentPowerOffCommand returns the command the agent powers off its host
+// with.
+func AgentPowerOffCommand() string {
+	return cfg.DefaultString("agent::PowerOffCommand", "systemctl poweroff")
+}
+
+// AgentRebootCommand returns the command the agent reboots its host with.
+func AgentRebootCommand() string {
+	return cfg.DefaultString("agent::RebootCommand", "systemctl reboot")
+}
+
+// DrainTimeout returns how long the pods of a node may take to be evicted
+// before a shutdown or reboot is aborted.
+func DrainTimeout() time.Duration {
+	timeout := cfg.DefaultInt("backend::DrainTimeout", 300)
+	return time.Second * time.Duration(timeout)
+}
+
+// PowerTimeout returns how long a node may take to go off or come back after
+// a power action before its power state is unknown.
+func PowerTimeout() time.Duration {
+	timeout := cfg.DefaultInt("backend::PowerTimeout", 600)
+	return time.Second * time.Duration(timeout)
+}
+
+// BatchNamespace returns the namespace of the batch jobs submitted without an
+// account.
+func BatchNamespace() string {
+	return cfg.DefaultString("batch::Namespace", "batch")
+}
+
+// BatchImage returns the container image batch scripts run in when they do
+// not ask for one with --container-image.
+func BatchImage() string {
+	return cfg.DefaultString("batch::Image", "centos:7")
+}
+
+// BatchPartitionLabel returns the node label selecting the nodes of a Slurm
+// partition.
+func BatchPartitionLabel() string {
+	return cfg.DefaultString("batch::PartitionLabel", "k8s-server/partition")
+}
+
+// BatchGPUTypes returns the gres GPU types as type=model pairs, the model is
+// the hardware.k8s-server/gpu-model label of the nodes, e.g.
+// "v100=Tesla-V100-PCIE-32GB;a100=NVIDIA-A100-SXM4-40GB".
+func BatchGPUTypes() []string {
+	return cfg.DefaultStrings("batch::GPUTypes", nil)
+}
+
+// BatchAccounts returns the accounts batch jobs may be charged to as
+// account=users pairs, the account is the namespace of the jobs and users a
+// comma separated list of the users allowed to use it, * allows everyone,
+// e.g. "physics=alice,bob;shared=*".
+func BatchAccounts() []string {
+	return cfg.DefaultStrings("batch::Accounts", nil)
+}
+
+// TemplateNamespaces returns the namespaces releases may be installed into as
+// namespace=users pairs, users is a comma separated list of the users allowed
+// to manage the releases of the namespace, * allows everyone, e.g.
+// "team-a=alice,bob;sandbox=*". The uid::Admins may use every namespace.
+func TemplateNamespaces() []string {
+	return cfg.DefaultStrings("template::Namespaces", nil)
+}
+
+// WebhookPort returns the HTTPS listen port of the admission webhook server.
+func WebhookPort() int {
+	return cfg.DefaultInt("webhook::Port", 8443)
+}
+
+// WebhookCertFile returns the TLS certificate of the admission webhook server,
+// the webhook server is disabled if it is empty.
+func WebhookCertFile() string {
+	return cfg.DefaultString("webhook::CertFile", "")
+}
+
+// WebhookKeyFile returns the TLS private key of the admission webhook server.
+func WebhookKeyFile() string {
+	return cfg.DefaultString("webhook::KeyFile", "")
+}
+
+// WebhookExemptNamespaces returns the namespaces the image policy does not
+// apply to.
+func WebhookExemptNamespaces() []string {
+	return cfg.DefaultStrings("webhook::ExemptNamespaces", []string{"kube-system"})
+}
+
+// WebhookAllowedRegistries returns the registries allowed besides
+// DockerRegistry and the Harbor server.
+func WebhookAllowedRegistries() []string {
+	return cfg.DefaultStrings("webhook::AllowedRegistries", []string{})
+}
+
+// WebhookDefaultRequests returns the resource requests set on containers that
+// do not request the resource, e.g. "cpu=100m;memory=128Mi".
+func WebhookDefaultRequests() map[string]string {
+	return parseResourceList(cfg.DefaultString("webhook::DefaultRequests", "cpu=100m;memory=128Mi"))
+}
+
+// WebhookDefaultLimits returns the resource limits set on containers that do
+// not limit the resource, e.g. "cpu=1;memory=1Gi".
+func WebhookDefaultLimits() map[string]string {
+	return parseResourceList(cfg.DefaultString("webhook::DefaultLimits", "cpu=1;memory=1Gi"))
+}
+
+func parseResourceList(s string) map[string]string {
+	list := make(map[string]string)
+	for _, item := range strings.Split(s, ";") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) == 2 && kv[0] != "" && kv[1] != "" {
+			list[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return list
+}
+
 // UDPListenPort returns the UDP listen port.
 func UDPListenPort() int {
 	return cfg.DefaultInt("UDPListenPort", 6382)
@@ -906,6 +1130,18 @@ func ConsulAddrs() []string {
 	return cfg.Strings("ConsulAddrs")
 }
 
+// ConsulDeregisterAfter returns how long an agent may miss its heartbeats
+// before consul deregisters it, 0 keeps it registered. (default 0)
+func ConsulDeregisterAfter() time.Duration {
+	return time.Second * time.Duration(cfg.DefaultInt("ConsulDeregisterAfter", 0))
+}
+
+// Override sets a configuration value of this process without saving it,
+// e.g. the per-node overrides of the agents.
+func Override(key, value string) error {
+	return cfg.Set(key, value)
+}
+
 // RemoteServiceAddr returns the remote connect service HTTP addr.
 func RemoteServiceAddr() string {
 	return cfg.DefaultString("RemoteServiceAddr", "http://192.168.2.21:8080")
@@ -942,6 +1178,13 @@ func JWTSecret() string {
 		"18ddf30d665538d3ab90b8e0bf6c96879be4fa6d")
 }
 
+// AccessTokenMaxAge returns the max lifetime of personal access tokens, zero
+// means tokens never expire unless an expiry is requested. (default 90 days)
+func AccessTokenMaxAge() time.Duration {
+	days := cfg.DefaultInt("backend::AccessTokenMaxAgeDays", 90)
+	return 24 * time.Hour * time.Duration(days)
+}
+
 // RBACDebugOn represents the RBAC debug switch.
 func RBACDebugOn() bool {
 	return cfg.DefaultBool("RBACDebugOn", false)
@@ -969,6 +1212,13 @@ func SingularityImages() string {
 		path.Join(HPCRootPath(), "conf/singularity.yml")))
 }
 
+// ImageCatalogInterval returns how often the image catalog files are checked
+// for changes.
+func ImageCatalogInterval() time.Duration {
+	interval := cfg.DefaultInt("backend::ImageCatalogInterval", 60)
+	return time.Second * time.Duration(interval)
+}
+
 // CheckListFilePath returns the checklist file path
 func CheckListFilePath() string {
 	return AbsPath(cfg.DefaultString("backend::ChecklistFilePath", "conf/checklist.json"))
@@ -1014,7 +1264,8 @@ func CustomImagePath() string {
 	return AbsPath(cfg.DefaultString("backend::CustomImagePath", "/usr/hpc/upload/images/public"))
 }
 
-// ImageMaxSpace returns the maximum space of each user can use
+// ImageMaxSpace returns the maximum space in MB the custom images of each
+// user can use
 func ImageMaxSpace() int {
 	return cfg.DefaultInt("backend::ImageMaxSpace", 500)
 }
@@ -1129,6 +1380,30 @@ func ImageMaxNumber() int {
 	return cfg.DefaultInt("backend::ImageMaxNumber", 10)
 }
 
+// ImageBuildNamespace returns the namespace the custom image build Jobs run
+// in.
+func ImageBuildNamespace() string {
+	return cfg.DefaultString("backend::ImageBuildNamespace", "image-build")
+}
+
+// ImageBuilderImage returns the rootless builder image that builds the custom
+// images.
+func ImageBuilderImage() string {
+	return cfg.DefaultString("backend::ImageBuilderImage", "moby/buildkit:v0.6.4-rootless")
+}
+
+// ImagePushImage returns the image that pushes the built custom images, it
+// needs skopeo.
+func ImagePushImage() string {
+	return cfg.DefaultString("backend::ImagePushImage", "quay.io/skopeo/stable:v1.2.0")
+}
+
+// ImageBuildTimeout returns the time a custom image build may run.
+func ImageBuildTimeout() time.Duration {
+	timeout := cfg.DefaultInt("backend::ImageBuildTimeout", 3600)
+	return time.Second * time.Duration(timeout)
+}
+
 // HarborServerName return the harbor server url
 func DockerRegistry() string {
 	return cfg.DefaultString("backend::DockerRegistry", "harbor.hpc.com")
@@ -1139,7 +1414,58 @@ func HarborPublicRepo() string {
 	return cfg.DefaultString("backend::HarborPublicRepo", "public")
 }
 
-// GetUploadLimit return the file size limit of uploading file
+// HarborPullSecretName returns the name of the image pull secret synced into
+// every namespace.
+func HarborPullSecretName() string {
+	return cfg.DefaultString("backend::HarborPullSecretName", "harbor-pull-secret")
+}
+
+// HarborPullUserName returns the read-only account used by the image pull
+// secret, the pull secrets are not synced if it is not set. It must not be
+// the HarborUserName, the secrets are readable in every namespace.
+func HarborPullUserName() string {
+	return cfg.DefaultString("backend::HarborPullUserName", "")
+}
+
+// HarborPullPassword returns the password of the image pull secret account.
+func HarborPullPassword() string {
+	return cfg.DefaultString("backend::HarborPullPassword", "")
+}
+
+// HarborSecretSyncInterval returns the interval of image pull secret sync,
+// zero disables the sync.
+func HarborSecretSyncInterval() time.Duration {
+	interval := cfg.DefaultInt("backend::HarborSecretSyncInterval", 300)
+	return time.Second * time.Duration(interval)
+}
+
+// HarborSecretExcludeNamespaces returns the namespaces the image pull secret
+// is not synced into.
+func HarborSecretExcludeNamespaces() []string {
+	return cfg.DefaultStrings("backend::HarborSecretExcludeNamespaces",
+		[]string{"kube-system", "kube-public", "kube-node-lease"})
+}
+
+// InventoryInterval returns the interval the node inventory is reconciled
+// with the Kubernetes nodes, 0 disables it.
+func InventoryInterval() time.Duration {
+	interval := cfg.DefaultInt("backend::InventoryInterval", 300)
+	return time.Second * time.Duration(interval)
+}
+
+// NodeLabelAllowlist returns the hardware label keys the inventory may write
+// to nodes, empty means all of them.
+func NodeLabelAllowlist() []string {
+	return cfg.DefaultStrings("backend::NodeLabelAllowlist", nil)
+}
+
+// NodeLabelDryRun returns whether the inventory only reports the hardware
+// label changes instead of writing them.
+func NodeLabelDryRun() bool {
+	return cfg.DefaultBool("backend::NodeLabelDryRun", false)
+}
+
+// GetUploadLimit return the file size limit in MB of uploading file
 func GetUploadLimit() int64 {
 	return cfg.DefaultInt64("backend::UploadLimit", 10240)
 }
@@ -1164,9 +1490,35 @@ func SharedStoragePath() string {
 	return cfg.DefaultString("virtual-desktop::SharedStoragePath", "/home")
 }
 
-// ClientMountPath the path of vd client mount
+// ClientMountPath the path of vd client mount, the shared storage is mounted
+// there on this server (default SharedStoragePath)
 func ClientMountPath() string {
-	return cfg.DefaultString("virtual-desktop::SharedStoragePath", "/home")
+	return cfg.DefaultString("virtual-desktop::ClientMountPath", SharedStoragePath())
+}
+
+// SharedStorageNFSServer returns the NFS server exporting the shared storage,
+// the shared volumes are hostPath volumes of SharedStoragePath if it is not
+// set.
+func SharedStorageNFSServer() string {
+	return cfg.DefaultString("storage::NFSServer", "")
+}
+
+// SharedStorageNFSExport returns the NFS export of the shared storage.
+// (default SharedStoragePath)
+func SharedStorageNFSExport() string {
+	return cfg.DefaultString("storage::NFSExport", SharedStoragePath())
+}
+
+// SharedStorageClass returns the storage class of the shared volumes, it is
+// only used to bind the claims to the static volumes.
+func SharedStorageClass() string {
+	return cfg.DefaultString("storage::StorageClass", "shared")
+}
+
+// ProjectStorageDir returns the directory below SharedStoragePath holding
+// the project volumes.
+func ProjectStorageDir() string {
+	return cfg.DefaultString("storage::ProjectDir", "projects")
 }
 
 // NetworkNodes returns the network nodes hostnames.
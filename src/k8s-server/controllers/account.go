@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"k8s-server/modules"
+	"k8s-server/modules/account"
+)
+
+// Account provisions the UID and home directory of the users.
+type Account struct {
+	BaseController
+	manager *account.Manager
+}
+
+func (a *Account) nestPrepare() {
+	a.manager = modules.KubernetesServer.AccountManager
+}
+
+// List returns the users with their UID and home directory.
+// @router / [get]
+func (a *Account) List() {
+	users, err := a.manager.List()
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(users)
+}
+
+// Identity returns the identity the pods of the current user run with, the
+// user is provisioned first if needed.
+// @router /me [get]
+func (a *Account) Identity() {
+	id, err := a.manager.Identity(a.username)
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(id)
+}
+
+// Get returns a user.
+// @router /:username [get]
+func (a *Account) Get() {
+	user, err := a.manager.Get(a.GetString(":username"))
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(user)
+}
+
+// Provision allocates the UID and creates the home directory of a user, it
+// only makes sure the home exists for a provisioned user. Only the user and
+// the account admins may provision it.
+// @router /:username [post]
+func (a *Account) Provision() {
+	user, err := a.manager.Provision(a.username, a.GetString(":username"))
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(user)
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s-server/def"
+	"k8s-server/modules"
+	"k8s-server/modules/agent"
+	"k8s-server/modules/registry"
+	"k8s-server/utils/errors"
+)
+
+// Agent lists the node agents and their liveness.
+type Agent struct {
+	BaseController
+	manager *registry.Manager
+}
+
+func (a *Agent) nestPrepare() {
+	a.manager = modules.KubernetesServer.RegistryManager
+}
+
+// List returns the registered agents with their last heartbeat.
+// @router / [get]
+func (a *Agent) List() {
+	a.jsonResult(a.manager.List())
+}
+
+// Versions returns the version skew between the server and the agents.
+// @router /versions [get]
+func (a *Agent) Versions() {
+	a.jsonResult(a.manager.Versions())
+}
+
+// Update pushes a signed agent binary to the agents. The multipart form
+// carries the binary, its version, the signature expiry in seconds since the
+// epoch, allowDowngrade, the base64 ed25519 signature and optionally the comma
+// separated hostnames, else every outdated agent is updated. Only admins may
+// update, deregister and override the agents.
+// @router /update [post]
+func (a *Agent) Update() {
+	a.requireAdmin()
+	file, _, err := a.GetFile("binary")
+	if err != nil {
+		a.errorResult(http.StatusBadRequest, errors.Wrap(err, def.ErrGeneralBadRequest, "missing agent binary"))
+	}
+	defer file.Close()
+	binary, err := ioutil.ReadAll(http.MaxBytesReader(a.Ctx.ResponseWriter, file, agent.MaxUpdateSize))
+	if err != nil {
+		a.errorResult(http.StatusBadRequest, errors.Wrap(err, def.ErrGeneralBadRequest, "read agent binary failed"))
+	}
+	signature, err := base64.StdEncoding.DecodeString(a.GetString("signature"))
+	if err != nil {
+		a.errorResult(http.StatusBadRequest, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid signature"))
+	}
+	expires, err := a.GetInt64("expires")
+	if err != nil {
+		a.errorResult(http.StatusBadRequest, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid expires"))
+	}
+	allowDowngrade, err := a.GetBool("allowDowngrade", false)
+	if err != nil {
+		a.errorResult(http.StatusBadRequest, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid allowDowngrade"))
+	}
+	update := registry.Update{
+		Version:        a.GetString("version"),
+		Binary:         binary,
+		Expires:        time.Unix(expires, 0),
+		AllowDowngrade: allowDowngrade,
+		Signature:      signature,
+	}
+	var hostnames []string
+	for _, h := range strings.Split(a.GetString("hostnames"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hostnames = append(hostnames, h)
+		}
+	}
+	results, err := a.manager.PushUpdate(update, hostnames)
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(results)
+}
+
+// Get returns an agent.
+// @router /:hostname [get]
+func (a *Agent) Get() {
+	agent, err := a.manager.Get(a.GetString(":hostname"))
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(agent)
+}
+
+// Deregister removes an offline agent from the registry.
+// @router /:hostname [delete]
+func (a *Agent) Deregister() {
+	a.requireAdmin()
+	if err := a.manager.Deregister(a.GetString(":hostname")); err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(map[string]bool{"deregistered": true})
+}
+
+// Health returns the latest health check results of an agent.
+// @router /:hostname/health [get]
+func (a *Agent) Health() {
+	report, err := a.manager.Health(a.GetString(":hostname"))
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(report)
+}
+
+// Services returns the states of the services an agent supervises.
+// @router /:hostname/services [get]
+func (a *Agent) Services() {
+	services, err := a.manager.Services(a.GetString(":hostname"))
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(services)
+}
+
+// GC returns the latest container GC report of an agent.
+// @router /:hostname/gc [get]
+func (a *Agent) GC() {
+	report, err := a.manager.GC(a.GetString(":hostname"))
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(report)
+}
+
+// Events returns the latest online, offline, version and update events of an
+// agent.
+// @router /:hostname/events [get]
+func (a *Agent) Events() {
+	limit, _ := a.GetInt("limit", 50)
+	events, err := a.manager.Events(a.GetString(":hostname"), limit)
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(events)
+}
+
+// Overrides returns the configuration overrides of an agent.
+// @router /:hostname/overrides [get]
+func (a *Agent) Overrides() {
+	overrides, err := a.manager.Overrides(a.GetString(":hostname"))
+	if err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(overrides)
+}
+
+// SetOverride overrides a configuration key of an agent with the value of
+// the body, the agent applies it when it restarts.
+// @router /:hostname/overrides/:key [put]
+func (a *Agent) SetOverride() {
+	a.requireAdmin()
+	var body struct {
+		Value string `json:"value"`
+	}
+	a.parseBody(&body)
+	hostname, key := a.GetString(":hostname"), a.GetString(":key")
+	if err := a.manager.SetOverride(hostname, key, body.Value); err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(map[string]string{"key": key, "value": body.Value})
+}
+
+// DeleteOverride deletes a configuration override of an agent.
+// @router /:hostname/overrides/:key [delete]
+func (a *Agent) DeleteOverride() {
+	a.requireAdmin()
+	key := a.GetString(":key")
+	if err := a.manager.DeleteOverride(a.GetString(":hostname"), key); err != nil {
+		a.errorResult(statusOf(err), err)
+	}
+	a.jsonResult(map[string]string{"key": key})
+}
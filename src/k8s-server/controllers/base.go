@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/astaxie/beego"
+
+	"k8s-server/def"
+	"k8s-server/filters"
+	"k8s-server/modules/account"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// nestPreparer is implemented by controllers that need their own preparation
+// after BaseController.Prepare.
+type nestPreparer interface {
+	nestPrepare()
+}
+
+// BaseController is embedded by all API controllers.
+type BaseController struct {
+	beego.Controller
+	username string
+	authType string
+}
+
+// Prepare loads the identity set by the auth filter and runs the nested
+// preparation of the concrete controller.
+func (b *BaseController) Prepare() {
+	b.username, _ = b.Ctx.Input.GetData(filters.CtxUsername).(string)
+	b.authType, _ = b.Ctx.Input.GetData(filters.CtxAuthType).(string)
+	if app, ok := b.AppController.(nestPreparer); ok {
+		app.nestPrepare()
+	}
+}
+
+// jsonResult serves data as the JSON response.
+func (b *BaseController) jsonResult(data interface{}) {
+	b.Data["json"] = data
+	b.ServeJSON()
+}
+
+// errorResult logs err and serves it with the given HTTP status.
+func (b *BaseController) errorResult(status int, err error) {
+	logs.Error("%s %s failed: %v", b.Ctx.Input.Method(), b.Ctx.Input.URL(), err)
+	b.Ctx.Output.SetStatus(status)
+	b.Data["json"] = map[string]string{
+		"error": err.Error(),
+		"code":  errors.ErrorCode(err),
+	}
+	b.ServeJSON()
+	b.StopRun()
+}
+
+// requireAdmin responds with 403 unless the caller is one of the uid::Admins.
+func (b *BaseController) requireAdmin() {
+	if !account.IsAdmin(b.username) {
+		b.errorResult(http.StatusForbidden,
+			errors.Errorf(def.ErrGeneralForbidden, "%s is not an admin", b.username))
+	}
+}
+
+// parseBody decodes the JSON request body into v, it responds with 400 if the
+// body is malformed.
+func (b *BaseController) parseBody(v interface{}) {
+	if err := json.Unmarshal(b.Ctx.Input.RequestBody, v); err != nil {
+		b.errorResult(http.StatusBadRequest,
+			errors.Wrap(err, def.ErrGeneralBadRequest, "invalid request body"))
+	}
+}
+
+// statusOf maps the outermost error code of err to an HTTP status.
+func statusOf(err error) int {
+	code := errors.ErrorCode(err)
+	if len(code) < 4 {
+		return http.StatusInternalServerError
+	}
+	switch c, _ := strconv.Atoi(code[:4]); c {
+	case def.ErrGeneralBadRequest:
+		return http.StatusBadRequest
+	case def.ErrGeneralUnauthorized:
+		return http.StatusUnauthorized
+	case def.ErrGeneralForbidden:
+		return http.StatusForbidden
+	case def.ErrGeneralNotFound:
+		return http.StatusNotFound
+	case def.ErrGeneralConflict:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models/types"
+	"k8s-server/modules"
+	"k8s-server/modules/imagebuild"
+	"k8s-server/utils/errors"
+)
+
+// Build builds the custom images of the users and pushes them to Harbor.
+type Build struct {
+	BaseController
+	manager *imagebuild.Manager
+}
+
+func (b *Build) nestPrepare() {
+	b.manager = modules.KubernetesServer.BuildManager
+}
+
+// Submit starts a build from a multipart upload: the image name and tag, the
+// Dockerfile as a file or a field, and the optional context as a tar or
+// tar.gz file.
+// @router / [post]
+func (b *Build) Submit() {
+	req := imagebuild.BuildRequest{Name: b.GetString("name"), Tag: b.GetString("tag")}
+	limit := conf.GetUploadLimit() << 20
+	if b.Ctx.Request.ContentLength > limit {
+		b.errorResult(http.StatusRequestEntityTooLarge,
+			errors.Errorf(def.ErrGeneralBadRequest, "the upload exceeds the limit of %d MB", limit>>20))
+	}
+	if f, _, err := b.GetFile("dockerfile"); err == nil {
+		content, err := ioutil.ReadAll(io.LimitReader(f, limit))
+		f.Close()
+		if err != nil {
+			b.errorResult(http.StatusBadRequest,
+				errors.Wrap(err, def.ErrGeneralBadRequest, "read Dockerfile failed"))
+		}
+		req.Dockerfile = string(content)
+	} else {
+		req.Dockerfile = b.GetString("dockerfile")
+	}
+	if f, _, err := b.GetFile("context"); err == nil {
+		defer f.Close()
+		req.Context = f
+	}
+	build, err := b.manager.Submit(b.username, req)
+	if err != nil {
+		b.errorResult(statusOf(err), err)
+	}
+	b.Ctx.Output.SetStatus(http.StatusCreated)
+	b.jsonResult(build)
+}
+
+// List returns the builds of the user, active=true selects the builds that
+// did not finish.
+// @router / [get]
+func (b *Build) List() {
+	active, _ := b.GetBool("active", false)
+	builds, err := b.manager.List(types.ImageBuildFilter{Owner: b.username, Active: active})
+	if err != nil {
+		b.errorResult(statusOf(err), err)
+	}
+	b.jsonResult(builds)
+}
+
+// Quota returns the image quota of the user.
+// @router /quota [get]
+func (b *Build) Quota() {
+	quota, err := b.manager.Quota(b.username)
+	if err != nil {
+		b.errorResult(statusOf(err), err)
+	}
+	b.jsonResult(quota)
+}
+
+// Get returns a build.
+// @router /:id [get]
+func (b *Build) Get() {
+	build, err := b.manager.Get(b.buildID())
+	if err != nil {
+		b.errorResult(statusOf(err), err)
+	}
+	b.jsonResult(build)
+}
+
+// Logs serves the builder output as text, follow=true streams it until the
+// build finishes.
+// @router /:id/logs [get]
+func (b *Build) Logs() {
+	follow, _ := b.GetBool("follow", false)
+	rc, err := b.manager.Logs(b.username, b.buildID(), follow)
+	if err != nil {
+		b.errorResult(statusOf(err), err)
+	}
+	defer rc.Close()
+	w := b.Ctx.ResponseWriter
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	buf := make([]byte, 4096)
+	for {
+		n, err := rc.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				// the client went away
+				return
+			}
+			w.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Delete cancels a running build, or deletes a finished build and its image.
+// @router /:id [delete]
+func (b *Build) Delete() {
+	build, err := b.manager.Delete(b.username, b.buildID())
+	if err != nil {
+		b.errorResult(statusOf(err), err)
+	}
+	b.jsonResult(build)
+}
+
+func (b *Build) buildID() int64 {
+	id, err := b.GetInt64(":id")
+	if err != nil {
+		b.errorResult(http.StatusBadRequest,
+			errors.Wrap(err, def.ErrGeneralBadRequest, "invalid build id"))
+	}
+	return id
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"k8s-server/modules"
+	"k8s-server/modules/harbor"
+)
+
+// Harbor browses the images of the Harbor registry.
+type Harbor struct {
+	BaseController
+	manager *harbor.Manager
+}
+
+func (h *Harbor) nestPrepare() {
+	h.manager = modules.KubernetesServer.HarborManager
+}
+
+// ListProjects returns the registry projects.
+// @router /projects [get]
+func (h *Harbor) ListProjects() {
+	projects, err := h.manager.ListProjects()
+	if err != nil {
+		h.errorResult(statusOf(err), err)
+	}
+	h.jsonResult(projects)
+}
+
+// ListRepositories returns the repositories of a project.
+// @router /projects/:project/repositories [get]
+func (h *Harbor) ListRepositories() {
+	repos, err := h.manager.ListRepositories(h.GetString(":project"))
+	if err != nil {
+		h.errorResult(statusOf(err), err)
+	}
+	h.jsonResult(repos)
+}
+
+// ListTags returns the tags of a repository with their vulnerability
+// summaries.
+// @router /repositories/:project/:repo/tags [get]
+func (h *Harbor) ListTags() {
+	tags, err := h.manager.ListTags(h.GetString(":project") + "/" + h.GetString(":repo"))
+	if err != nil {
+		h.errorResult(statusOf(err), err)
+	}
+	h.jsonResult(tags)
+}
+
+// SyncPullSecrets syncs the image pull secret into all namespaces now instead
+// of waiting for the next periodic sync.
+// @router /pullsecrets/sync [post]
+func (h *Harbor) SyncPullSecrets() {
+	if err := h.manager.SyncPullSecrets(); err != nil {
+		h.errorResult(statusOf(err), err)
+	}
+	h.jsonResult(map[string]bool{"synced": true})
+}
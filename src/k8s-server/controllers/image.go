@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"k8s-server/modules"
+	"k8s-server/modules/catalog"
+)
+
+// Image serves the Docker and Singularity image catalogs.
+type Image struct {
+	BaseController
+	manager *catalog.Manager
+}
+
+func (i *Image) nestPrepare() {
+	i.manager = modules.KubernetesServer.CatalogManager
+}
+
+// List returns the catalog, ?type=docker or ?type=singularity selects one
+// image type.
+// @router / [get]
+func (i *Image) List() {
+	c, err := i.manager.List(i.GetString("type"))
+	if err != nil {
+		i.errorResult(statusOf(err), err)
+	}
+	i.jsonResult(c)
+}
+
+// Get returns a catalog entry.
+// @router /:name [get]
+func (i *Image) Get() {
+	entry, err := i.manager.Get(i.GetString(":name"))
+	if err != nil {
+		i.errorResult(statusOf(err), err)
+	}
+	i.jsonResult(entry)
+}
+
+// Reload reloads the catalog files without waiting for the next check.
+// @router /reload [post]
+func (i *Image) Reload() {
+	i.jsonResult(i.manager.Reload(true))
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"k8s-server/modules"
+	"k8s-server/modules/inventory"
+)
+
+// Inventory reports how the node agents match the Kubernetes nodes.
+type Inventory struct {
+	BaseController
+	manager *inventory.Manager
+}
+
+func (i *Inventory) nestPrepare() {
+	i.manager = modules.KubernetesServer.InventoryManager
+}
+
+// Get returns the report of the latest periodic reconciliation.
+// @router / [get]
+func (i *Inventory) Get() {
+	report, err := i.manager.LastReport()
+	if err != nil {
+		i.errorResult(statusOf(err), err)
+	}
+	i.jsonResult(report)
+}
+
+// Reconcile reconciles now, with dryRun=true the label changes are only
+// reported.
+// @router /reconcile [post]
+func (i *Inventory) Reconcile() {
+	dryRun, _ := i.GetBool("dryRun", false)
+	report, err := i.manager.Reconcile(dryRun)
+	if err != nil {
+		i.errorResult(statusOf(err), err)
+	}
+	i.jsonResult(report)
+}
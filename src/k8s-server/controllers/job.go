@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http"
+
+	"k8s-server/def"
+	"k8s-server/models/types"
+	"k8s-server/modules"
+	"k8s-server/modules/batch"
+	"k8s-server/utils/errors"
+)
+
+// Job submits Slurm-style batch scripts and lists them like squeue.
+type Job struct {
+	BaseController
+	manager *batch.Manager
+}
+
+func (j *Job) nestPrepare() {
+	j.manager = modules.KubernetesServer.BatchManager
+}
+
+// Submit translates a batch script to a Kubernetes Job like sbatch.
+// @router / [post]
+func (j *Job) Submit() {
+	var req batch.SubmitRequest
+	j.parseBody(&req)
+	result, err := j.manager.Submit(j.username, req)
+	if err != nil {
+		j.errorResult(statusOf(err), err)
+	}
+	j.Ctx.Output.SetStatus(http.StatusCreated)
+	j.jsonResult(result)
+}
+
+// List returns the pending and running jobs like squeue, user filters by
+// submitter, all=true includes the ended jobs and format=squeue serves the
+// squeue text output.
+// @router / [get]
+func (j *Job) List() {
+	all, _ := j.GetBool("all", false)
+	statuses, err := j.manager.List(types.BatchJobFilter{User: j.GetString("user"), Active: !all})
+	if err != nil {
+		j.errorResult(statusOf(err), err)
+	}
+	if j.GetString("format") == "squeue" {
+		j.Ctx.Output.Header("Content-Type", "text/plain; charset=utf-8")
+		j.Ctx.WriteString(batch.FormatSqueue(statuses))
+		return
+	}
+	j.jsonResult(statuses)
+}
+
+// Get returns the status of a job.
+// @router /:id [get]
+func (j *Job) Get() {
+	id, err := j.GetInt64(":id")
+	if err != nil {
+		j.errorResult(http.StatusBadRequest,
+			errors.Wrap(err, def.ErrGeneralBadRequest, "invalid job id"))
+	}
+	status, err := j.manager.Get(id)
+	if err != nil {
+		j.errorResult(statusOf(err), err)
+	}
+	j.jsonResult(status)
+}
+
+// Cancel cancels a pending or running job like scancel.
+// @router /:id [delete]
+func (j *Job) Cancel() {
+	id, err := j.GetInt64(":id")
+	if err != nil {
+		j.errorResult(http.StatusBadRequest,
+			errors.Wrap(err, def.ErrGeneralBadRequest, "invalid job id"))
+	}
+	status, err := j.manager.Cancel(j.username, id)
+	if err != nil {
+		j.errorResult(statusOf(err), err)
+	}
+	j.jsonResult(status)
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"k8s-server/modules"
+	"k8s-server/modules/power"
+)
+
+// Power wakes, shuts down and reboots the nodes.
+type Power struct {
+	BaseController
+	manager *power.Manager
+}
+
+func (p *Power) nestPrepare() {
+	p.manager = modules.KubernetesServer.PowerManager
+}
+
+// List returns the last known power states of the nodes.
+// @router / [get]
+func (p *Power) List() {
+	p.jsonResult(p.manager.List())
+}
+
+// Get returns the last known power state of a node.
+// @router /:hostname [get]
+func (p *Power) Get() {
+	state, err := p.manager.Get(p.GetString(":hostname"))
+	if err != nil {
+		p.errorResult(statusOf(err), err)
+	}
+	p.jsonResult(state)
+}
+
+// Wake sends a Wake-on-LAN packet to an offline node, only admins may wake,
+// shut down and reboot the nodes.
+// @router /:hostname/wake [post]
+func (p *Power) Wake() {
+	p.requireAdmin()
+	state, err := p.manager.Wake(p.GetString(":hostname"))
+	if err != nil {
+		p.errorResult(statusOf(err), err)
+	}
+	p.jsonResult(state)
+}
+
+// Shutdown drains a node and powers it off, the returned state is draining
+// and the progress is reported by Get.
+// @router /:hostname/shutdown [post]
+func (p *Power) Shutdown() {
+	p.requireAdmin()
+	state, err := p.manager.Shutdown(p.GetString(":hostname"))
+	if err != nil {
+		p.errorResult(statusOf(err), err)
+	}
+	p.jsonResult(state)
+}
+
+// Reboot drains a node and reboots it, the node is uncordoned when its agent
+// comes back.
+// @router /:hostname/reboot [post]
+func (p *Power) Reboot() {
+	p.requireAdmin()
+	state, err := p.manager.Reboot(p.GetString(":hostname"))
+	if err != nil {
+		p.errorResult(statusOf(err), err)
+	}
+	p.jsonResult(state)
+}
+
+// Events returns the latest power events of a node.
+// @router /:hostname/events [get]
+func (p *Power) Events() {
+	limit, _ := p.GetInt("limit", 50)
+	events, err := p.manager.Events(p.GetString(":hostname"), limit)
+	if err != nil {
+		p.errorResult(statusOf(err), err)
+	}
+	p.jsonResult(events)
+}
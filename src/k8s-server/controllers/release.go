@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"net/http"
+
+	"k8s-server/modules"
+	"k8s-server/modules/apptemplate"
+)
+
+// Release manages the releases installed from application templates.
+type Release struct {
+	BaseController
+	manager *apptemplate.Manager
+}
+
+func (r *Release) nestPrepare() {
+	r.manager = modules.KubernetesServer.TemplateManager
+}
+
+// ListAll returns the releases in all namespaces.
+// @router / [get]
+func (r *Release) ListAll() {
+	releases, err := r.manager.ListReleases("")
+	if err != nil {
+		r.errorResult(statusOf(err), err)
+	}
+	r.jsonResult(releases)
+}
+
+// List returns the releases in a namespace.
+// @router /namespaces/:namespace [get]
+func (r *Release) List() {
+	releases, err := r.manager.ListReleases(r.GetString(":namespace"))
+	if err != nil {
+		r.errorResult(statusOf(err), err)
+	}
+	r.jsonResult(releases)
+}
+
+// Get returns a release with its values and objects.
+// @router /namespaces/:namespace/:name [get]
+func (r *Release) Get() {
+	release, err := r.manager.GetRelease(r.GetString(":namespace"), r.GetString(":name"))
+	if err != nil {
+		r.errorResult(statusOf(err), err)
+	}
+	r.jsonResult(release)
+}
+
+// Install instantiates a template into a namespace, the releases of a
+// namespace are managed by the users of template::Namespaces and the admins.
+// @router /namespaces/:namespace [post]
+func (r *Release) Install() {
+	var req apptemplate.InstallRequest
+	r.parseBody(&req)
+	release, err := r.manager.Install(r.username, r.GetString(":namespace"), req)
+	if err != nil {
+		r.errorResult(statusOf(err), err)
+	}
+	r.Ctx.Output.SetStatus(http.StatusCreated)
+	r.jsonResult(release)
+}
+
+// Upgrade re-renders a release from the latest template with new values.
+// @router /namespaces/:namespace/:name [put]
+func (r *Release) Upgrade() {
+	var req apptemplate.UpgradeRequest
+	r.parseBody(&req)
+	release, err := r.manager.Upgrade(r.username, r.GetString(":namespace"), r.GetString(":name"), req)
+	if err != nil {
+		r.errorResult(statusOf(err), err)
+	}
+	r.jsonResult(release)
+}
+
+// Uninstall deletes the objects of a release and the release itself.
+// @router /namespaces/:namespace/:name [delete]
+func (r *Release) Uninstall() {
+	namespace, name := r.GetString(":namespace"), r.GetString(":name")
+	if err := r.manager.Uninstall(r.username, namespace, name); err != nil {
+		r.errorResult(statusOf(err), err)
+	}
+	r.jsonResult(map[string]string{"namespace": namespace, "name": name})
+}
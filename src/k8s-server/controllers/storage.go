@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"net/http"
+
+	"k8s-server/modules"
+	"k8s-server/modules/storage"
+)
+
+// Storage manages the volumes of the shared filesystem.
+type Storage struct {
+	BaseController
+	manager *storage.Manager
+}
+
+func (s *Storage) nestPrepare() {
+	s.manager = modules.KubernetesServer.StorageManager
+}
+
+// ListVolumes returns the PersistentVolumes.
+// @router /volumes [get]
+func (s *Storage) ListVolumes() {
+	volumes, err := s.manager.ListVolumes()
+	if err != nil {
+		s.errorResult(statusOf(err), err)
+	}
+	s.jsonResult(volumes)
+}
+
+// Provision creates the shared volume of a user or project, users provision
+// their own volume and admins any.
+// @router /volumes [post]
+func (s *Storage) Provision() {
+	var req storage.ProvisionRequest
+	s.parseBody(&req)
+	v, err := s.manager.Provision(s.username, req)
+	if err != nil {
+		s.errorResult(statusOf(err), err)
+	}
+	s.Ctx.Output.SetStatus(http.StatusCreated)
+	s.jsonResult(v)
+}
+
+// DeleteVolume deletes a shared volume that is not bound, its data is kept.
+// Only admins may delete volumes.
+// @router /volumes/:name [delete]
+func (s *Storage) DeleteVolume() {
+	name := s.GetString(":name")
+	if err := s.manager.DeleteVolume(s.username, name); err != nil {
+		s.errorResult(statusOf(err), err)
+	}
+	s.jsonResult(map[string]string{"name": name})
+}
+
+// ListClaims returns the claims with the pods mounting them, ?namespace=
+// selects a namespace.
+// @router /claims [get]
+func (s *Storage) ListClaims() {
+	claims, err := s.manager.ListClaims(s.GetString("namespace"))
+	if err != nil {
+		s.errorResult(statusOf(err), err)
+	}
+	s.jsonResult(claims)
+}
+
+// ListStorageClasses returns the storage classes.
+// @router /classes [get]
+func (s *Storage) ListStorageClasses() {
+	classes, err := s.manager.ListStorageClasses()
+	if err != nil {
+		s.errorResult(statusOf(err), err)
+	}
+	s.jsonResult(classes)
+}
+
+// Usage returns the usage of the shared filesystem reported by the agents.
+// @router /usage [get]
+func (s *Storage) Usage() {
+	s.jsonResult(s.manager.Usage())
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"net/http"
+
+	"k8s-server/modules"
+	"k8s-server/modules/apptemplate"
+)
+
+// Template manages the application template catalog.
+type Template struct {
+	BaseController
+	manager *apptemplate.Manager
+}
+
+func (t *Template) nestPrepare() {
+	t.manager = modules.KubernetesServer.TemplateManager
+}
+
+// List returns all templates.
+// @router / [get]
+func (t *Template) List() {
+	templates, err := t.manager.ListTemplates()
+	if err != nil {
+		t.errorResult(statusOf(err), err)
+	}
+	t.jsonResult(templates)
+}
+
+// Get returns a template with its parameter schema.
+// @router /:name [get]
+func (t *Template) Get() {
+	template, err := t.manager.GetTemplate(t.GetString(":name"))
+	if err != nil {
+		t.errorResult(statusOf(err), err)
+	}
+	t.jsonResult(template)
+}
+
+// Create adds a template to the catalog, only admins may author templates.
+// @router / [post]
+func (t *Template) Create() {
+	t.requireAdmin()
+	var req apptemplate.TemplateRequest
+	t.parseBody(&req)
+	template, err := t.manager.CreateTemplate(t.username, req)
+	if err != nil {
+		t.errorResult(statusOf(err), err)
+	}
+	t.Ctx.Output.SetStatus(http.StatusCreated)
+	t.jsonResult(template)
+}
+
+// Update replaces the content and parameters of a template.
+// @router /:name [put]
+func (t *Template) Update() {
+	t.requireAdmin()
+	var req apptemplate.TemplateRequest
+	t.parseBody(&req)
+	template, err := t.manager.UpdateTemplate(t.GetString(":name"), req)
+	if err != nil {
+		t.errorResult(statusOf(err), err)
+	}
+	t.jsonResult(template)
+}
+
+// Delete removes a template that has no releases.
+// @router /:name [delete]
+func (t *Template) Delete() {
+	t.requireAdmin()
+	name := t.GetString(":name")
+	if err := t.manager.DeleteTemplate(name); err != nil {
+		t.errorResult(statusOf(err), err)
+	}
+	t.jsonResult(map[string]string{"name": name})
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"net/http"
+
+	"k8s-server/def"
+	"k8s-server/filters"
+	"k8s-server/modules"
+	"k8s-server/modules/token"
+	"k8s-server/utils/errors"
+)
+
+// Token manages the personal access tokens of the current user.
+type Token struct {
+	BaseController
+	manager *token.Manager
+}
+
+func (t *Token) nestPrepare() {
+	t.manager = modules.KubernetesServer.TokenManager
+}
+
+// List returns the tokens of the current user, secrets are never included.
+// @router / [get]
+func (t *Token) List() {
+	tokens, err := t.manager.List(t.username)
+	if err != nil {
+		t.errorResult(http.StatusInternalServerError, err)
+	}
+	t.jsonResult(tokens)
+}
+
+// Create issues a new token, the secret is only returned in this response.
+// Tokens can only be created from a login session, never by another token.
+// @router / [post]
+func (t *Token) Create() {
+	if t.authType != filters.AuthSession {
+		t.errorResult(http.StatusForbidden, errors.New(def.ErrGeneralForbidden,
+			"access tokens can not create tokens"))
+	}
+	var req token.CreateRequest
+	t.parseBody(&req)
+	created, err := t.manager.Create(t.username, req)
+	if err != nil {
+		t.errorResult(statusOf(err), err)
+	}
+	t.Ctx.Output.SetStatus(http.StatusCreated)
+	t.jsonResult(created)
+}
+
+// Revoke revokes a token of the current user.
+// @router /:id [delete]
+func (t *Token) Revoke() {
+	id, err := t.GetInt64(":id")
+	if err != nil {
+		t.errorResult(http.StatusBadRequest,
+			errors.Wrap(err, def.ErrGeneralBadRequest, "invalid token id"))
+	}
+	if err = t.manager.Revoke(t.username, id); err != nil {
+		t.errorResult(statusOf(err), err)
+	}
+	t.jsonResult(map[string]int64{"id": id})
+}
@@ -0,0 +1,103 @@
+// Package def defines the error codes shared by modules and controllers, the
+// codes are chained by errors.Wrap so a wrapped error reports the full path
+// from the controller down to the failed module.
+package def
+
+// General errors.
+const (
+	ErrGeneralDBConnect    = 1001
+	ErrGeneralBadRequest   = 1002
+	ErrGeneralUnauthorized = 1003
+	ErrGeneralForbidden    = 1004
+	ErrGeneralNotFound     = 1005
+	ErrGeneralInternal     = 1006
+	ErrGeneralConflict     = 1007
+)
+
+// Module initialization errors.
+const (
+	ErrPodModule       = 2001
+	ErrTokenModule     = 2002
+	ErrTemplateModule  = 2003
+	ErrHarborModule    = 2004
+	ErrRegistryModule  = 2005
+	ErrInventoryModule = 2006
+	ErrPowerModule     = 2007
+	ErrBatchModule     = 2008
+	ErrCatalogModule   = 2009
+	ErrBuildModule     = 2010
+	ErrStorageModule   = 2011
+	ErrAccountModule   = 2012
+)
+
+// Access token errors.
+const (
+	ErrTokenCreate  = 3001
+	ErrTokenList    = 3002
+	ErrTokenRevoke  = 3003
+	ErrTokenInvalid = 3004
+	ErrTokenExpired = 3005
+	ErrTokenRevoked = 3006
+)
+
+// Application template and release errors.
+const (
+	ErrTemplateSave     = 4001
+	ErrTemplateList     = 4002
+	ErrTemplateDelete   = 4003
+	ErrTemplateRender   = 4004
+	ErrReleaseInstall   = 4005
+	ErrReleaseUpgrade   = 4006
+	ErrReleaseUninstall = 4007
+	ErrReleaseList      = 4008
+)
+
+// Harbor registry errors.
+const (
+	ErrHarborNotConfigured = 5001
+	ErrHarborRequest       = 5002
+	ErrHarborSecretSync    = 5003
+)
+
+// Agent registry errors.
+const (
+	ErrAgentRegistry = 6001
+	ErrAgentUpdate   = 6002
+)
+
+// Node inventory errors.
+const (
+	ErrInventoryReconcile = 7001
+)
+
+// Node power errors.
+const (
+	ErrPowerAction = 8001
+)
+
+// Batch job errors.
+const (
+	ErrBatchSubmit = 9001
+	ErrBatchList   = 9002
+	ErrBatchCancel = 9003
+)
+
+// Custom image build errors.
+const (
+	ErrBuildSubmit = 9101
+	ErrBuildList   = 9102
+	ErrBuildDelete = 9103
+)
+
+// Shared storage errors.
+const (
+	ErrStorageList      = 9201
+	ErrStorageProvision = 9202
+	ErrStorageDelete    = 9203
+)
+
+// User account provisioning errors.
+const (
+	ErrAccountProvision = 9301
+	ErrAccountList      = 9302
+)
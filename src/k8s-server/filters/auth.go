@@ -0,0 +1,139 @@
+// Package filters contains the beego filters shared by all API routes.
+package filters
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/astaxie/beego/context"
+
+	"k8s-server/conf"
+	"k8s-server/modules"
+	"k8s-server/modules/token"
+	"k8s-server/utils/logs"
+)
+
+// Keys of the request scoped data set by Auth.
+const (
+	CtxUsername = "username"
+	CtxAuthType = "authType"
+	CtxToken    = "token"
+)
+
+// Authentication types stored under CtxAuthType.
+const (
+	AuthSession = "session"
+	AuthToken   = "token"
+)
+
+// legacyRoutes are the API routes served before the auth filter existed.
+// Their clients send no credentials, so they stay open until they move to
+// login sessions or access tokens.
+var legacyRoutes = []string{"/api/pods"}
+
+// Auth authenticates API requests. A bearer credential is either a JWT login
+// session signed with conf.JWTSecret or a personal access token, tokens are
+// further limited to the verbs, resources and namespaces of their scope.
+// Rejected credentials are answered with a plain "unauthorized", the cause is
+// only logged.
+func Auth(ctx *context.Context) {
+	if legacy(ctx.Input.URL()) {
+		return
+	}
+	credential := bearer(ctx.Input.Header("Authorization"))
+	if credential == "" {
+		unauthorized(ctx, "missing bearer credential")
+		return
+	}
+
+	if !strings.HasPrefix(credential, token.Prefix) {
+		username, err := parseSession(credential, conf.JWTSecret())
+		if err != nil {
+			unauthorized(ctx, "invalid session: "+err.Error())
+			return
+		}
+		ctx.Input.SetData(CtxUsername, username)
+		ctx.Input.SetData(CtxAuthType, AuthSession)
+		return
+	}
+
+	t, err := modules.KubernetesServer.TokenManager.Authenticate(credential,
+		ctx.Input.IP())
+	if err != nil {
+		unauthorized(ctx, "invalid access token: "+err.Error())
+		return
+	}
+	verb, resource, namespace := requestAttributes(ctx)
+	if !t.Scope.Allows(verb, resource, namespace) {
+		abort(ctx, http.StatusForbidden, "token scope does not allow "+verb+
+			" on "+resource)
+		return
+	}
+	ctx.Input.SetData(CtxUsername, t.Username)
+	ctx.Input.SetData(CtxAuthType, AuthToken)
+	ctx.Input.SetData(CtxToken, t)
+}
+
+// legacy reports whether the path is served by a legacy route.
+func legacy(path string) bool {
+	for _, route := range legacyRoutes {
+		if path == route || strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func bearer(header string) string {
+	const scheme = "Bearer "
+	if len(header) > len(scheme) && strings.EqualFold(header[:len(scheme)], scheme) {
+		return strings.TrimSpace(header[len(scheme):])
+	}
+	return ""
+}
+
+// requestAttributes maps a request to the verb, resource and namespace a token
+// scope is checked against. The resource is the first path segment under /api,
+// the namespace comes from a "namespaces/<name>" path segment or the namespace
+// query parameter.
+func requestAttributes(ctx *context.Context) (verb, resource, namespace string) {
+	switch ctx.Input.Method() {
+	case http.MethodPost:
+		verb = token.VerbCreate
+	case http.MethodPut, http.MethodPatch:
+		verb = token.VerbUpdate
+	case http.MethodDelete:
+		verb = token.VerbDelete
+	default:
+		verb = token.VerbGet
+	}
+	segments := strings.Split(strings.Trim(ctx.Input.URL(), "/"), "/")
+	if len(segments) > 1 && segments[0] == "api" {
+		resource = segments[1]
+	}
+	for i := 1; i < len(segments)-1; i++ {
+		if segments[i] == "namespaces" {
+			namespace = segments[i+1]
+			break
+		}
+	}
+	if namespace == "" {
+		namespace = ctx.Input.Query("namespace")
+	}
+	return
+}
+
+// unauthorized rejects the credential of the request, the cause is logged
+// rather than told to the client.
+func unauthorized(ctx *context.Context, cause string) {
+	logs.Warn("reject %s %s from %s: %s", ctx.Input.Method(), ctx.Input.URL(), ctx.Input.IP(), cause)
+	abort(ctx, http.StatusUnauthorized, "unauthorized")
+}
+
+// abort answers the request with an error, the request is recorded here as
+// beego skips the FinishRouter filters once a filter wrote the response.
+func abort(ctx *context.Context, status int, msg string) {
+	ctx.Output.SetStatus(status)
+	ctx.Output.JSON(map[string]string{"error": msg}, false, false)
+	observeRequest(ctx)
+}
@@ -0,0 +1,67 @@
+package filters
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sessionClaims holds the JWT claims issued for a login session.
+type sessionClaims struct {
+	Username  string `json:"username"`
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// parseSession verifies a HS256 signed JWT with secret and returns the user
+// name it was issued for.
+func parseSession(token, secret string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "HS256" {
+		return "", fmt.Errorf("unexpected signing method %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed signature: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", fmt.Errorf("signature mismatch")
+	}
+	var claims sessionClaims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() > claims.ExpiresAt {
+		return "", fmt.Errorf("session expired")
+	}
+	if claims.Username != "" {
+		return claims.Username, nil
+	}
+	if claims.Subject != "" {
+		return claims.Subject, nil
+	}
+	return "", fmt.Errorf("token has no subject")
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("malformed segment: %v", err)
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,58 @@
+package filters
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego/context"
+
+	"k8s-server/utils/metrics"
+)
+
+const ctxRequestStart = "requestStart"
+
+// routeUnmatched labels requests that were answered before routing, e.g.
+// rejected by Auth, so arbitrary paths do not create new series.
+const routeUnmatched = "unmatched"
+
+var (
+	requestsTotal = metrics.NewCounterVec("k8s_server_http_requests_total",
+		"HTTP requests by method, route and status code.", "method", "route", "code")
+	requestDuration = metrics.NewHistogramVec("k8s_server_http_request_duration_seconds",
+		"HTTP request latency by method and route.", metrics.DefBuckets, "method", "route")
+)
+
+// RequestStart records the start of a request, it runs before every other
+// filter.
+func RequestStart(ctx *context.Context) {
+	ctx.Input.SetData(ctxRequestStart, time.Now())
+}
+
+// RequestFinish records the request metrics once the response is written, it
+// must be inserted at beego.FinishRouter with returnOnOutput false.
+func RequestFinish(ctx *context.Context) {
+	observeRequest(ctx)
+}
+
+// Metrics returns the request metrics for the /metrics endpoint.
+func Metrics() []metrics.Family {
+	return []metrics.Family{requestsTotal.Collect(), requestDuration.Collect()}
+}
+
+func observeRequest(ctx *context.Context) {
+	start, ok := ctx.Input.GetData(ctxRequestStart).(time.Time)
+	if !ok {
+		return
+	}
+	route, _ := ctx.Input.GetData("RouterPattern").(string)
+	if route == "" {
+		route = routeUnmatched
+	}
+	status := ctx.ResponseWriter.Status
+	if status == 0 {
+		status = 200
+	}
+	method := ctx.Input.Method()
+	requestsTotal.Inc(method, route, strconv.Itoa(status))
+	requestDuration.Observe(time.Since(start).Seconds(), method, route)
+}
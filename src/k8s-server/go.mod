@@ -4,6 +4,14 @@ go 1.12
 
 require (
 	github.com/astaxie/beego v1.12.0
+	github.com/go-gorp/gorp v2.2.0+incompatible
+	github.com/go-sql-driver/mysql v1.4.1
+	github.com/gomodule/redigo v2.0.0+incompatible
 	github.com/kubernetes-client/go v0.0.0-20190625181339-cd8e39e789c7 // indirect
 	github.com/shiena/ansicolor v0.0.0-20151119151921-a422bbe96644 // indirect
+	golang.org/x/crypto v0.0.0-20190611184440-5c40567a22f8
+	gopkg.in/yaml.v2 v2.2.2
+	k8s.io/api v0.0.0-20190717022910-653c86b0609b
+	k8s.io/apimachinery v0.0.0-20190717022731-0bb8574e0887
+	k8s.io/client-go v0.0.0-20190717023132-0c47f9da0001
 )
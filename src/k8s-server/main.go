@@ -1,11 +1,73 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+
 	_ "k8s-server/routers"
+	"k8s-server/conf"
+	"k8s-server/models/mysqldb"
+	"k8s-server/modules/admission"
+	"k8s-server/modules"
+	"k8s-server/utils/logs"
 	"github.com/astaxie/beego"
 )
 
+var (
+	pendingMigrations = flag.Bool("pending-migrations", false,
+		"print the database migrations that are not applied yet and exit")
+	rollbackMigrations = flag.Int("rollback-migrations", 0,
+		"revert the last N applied database migrations and exit")
+)
+
 func main() {
+	flag.Parse()
+	if *pendingMigrations {
+		printPendingMigrations()
+		return
+	}
+	if *rollbackMigrations > 0 {
+		if err := mysqldb.RollbackMigrations(*rollbackMigrations); err != nil {
+			fmt.Fprintf(os.Stderr, "revert migrations failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if _, err := modules.NewBackend(); err != nil {
+		logs.Critical("init backend failed: %+v", err)
+		return
+	}
+	if conf.WebhookCertFile() != "" {
+		go runWebhook()
+	}
 	beego.Run()
 }
 
+// runWebhook serves the admission webhooks beside the API server.
+func runWebhook() {
+	server, err := admission.NewServer()
+	if err != nil {
+		logs.Critical("init admission webhook failed: %v", err)
+		return
+	}
+	logs.Info("admission webhook listening on :%d", conf.WebhookPort())
+	if err = server.Run(); err != nil {
+		logs.Critical("admission webhook stopped: %v", err)
+	}
+}
+
+func printPendingMigrations() {
+	pending, err := mysqldb.PendingMigrations()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list pending migrations failed: %v\n", err)
+		os.Exit(1)
+	}
+	if len(pending) == 0 {
+		fmt.Println("database schema is up to date")
+		return
+	}
+	for _, mig := range pending {
+		fmt.Printf("%6d  %-16s %s\n", mig.Version, mig.Group, mig.Name)
+	}
+}
@@ -1,92 +1,56 @@
-/*
-Copyright 2016 The Kubernetes Authors.
-Licensed under the Apache License, Version 2.0 (the "License");
-you may not use this file except in compliance with the License.
-You may obtain a copy of the License at
-    http://www.apache.org/licenses/LICENSE-2.0
-Unless required by applicable law or agreed to in writing, software
-distributed under the License is distributed on an "AS IS" BASIS,
-WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-See the License for the specific language governing permissions and
-limitations under the License.
-*/
-
-// Note: the example only works with the code within the same release/branch.
-package main
-
-import (
-	"flag"
-	"fmt"
-	"os"
-	"path/filepath"
-	"time"
-
-	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	//
-	// Uncomment to load all auth plugins
-	// _ "k8s.io/client-go/plugin/pkg/client/auth"
-	//
-	// Or uncomment to load specific auth plugins
-	// _ "k8s.io/client-go/plugin/pkg/client/auth/azure"
-	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
-	// _ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
-	// _ "k8s.io/client-go/plugin/pkg/client/auth/openstack"
-)
-
-func main() {
-	var kubeconfig *string
-	if home := homeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
-	}
-	flag.Parse()
-
-	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	// create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		panic(err.Error())
-	}
-	for {
-		pods, err := clientset.CoreV1().Pods("").List(metav1.ListOptions{})
-		if err != nil {
-			panic(err.Error())
-		}
-		fmt.Printf("There are %d pods in the cluster\n", len(pods.Items))
-
-		// Examples for error handling:
-		// - Use helper functions like e.g. errors.IsNotFound()
-		// - And/or cast to StatusError and use its properties like e.g. ErrStatus.Message
-		namespace := "default"
-		pod := "example-xxxxx"
-		_, err = clientset.CoreV1().Pods(namespace).Get(pod, metav1.GetOptions{})
-		if errors.IsNotFound(err) {
-			fmt.Printf("Pod %s in namespace %s not found\n", pod, namespace)
-		} else if statusError, isStatus := err.(*errors.StatusError); isStatus {
-			fmt.Printf("Error getting pod %s in namespace %s: %v\n",
-				pod, namespace, statusError.ErrStatus.Message)
-		} else if err != nil {
-			panic(err.Error())
-		} else {
-			fmt.Printf("Found pod %s in namespace %s\n", pod, namespace)
-		}
-
-		time.Sleep(10 * time.Second)
-	}
-}
-
-func homeDir() string {
-	if h := os.Getenv("HOME"); h != "" {
-		return h
-	}
-	return os.Getenv("USERPROFILE") // windows
-}
\ No newline at end of file
+// Command agent is the node agent, it serves the hardware information of the
+// node to the server on AgentServerPort.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gomodule/redigo/redis"
+
+	"k8s-server/conf"
+	"k8s-server/modules/agent"
+	"k8s-server/utils/logs"
+	"k8s-server/utils/redisutil"
+)
+
+var (
+	printVersion = flag.Bool("version", false, "print the agent version and exit")
+	supervise    = flag.String(agent.SuperviseFlag, "", "supervise the update of the agent at this path, set by the updater")
+)
+
+func main() {
+	flag.Parse()
+	if *printVersion {
+		fmt.Println(agent.BuildVersion())
+		return
+	}
+	if *supervise != "" {
+		os.Exit(agent.SuperviseUpdate(*supervise))
+	}
+	var pool *redis.Pool
+	if conf.RedisHost() != "" {
+		pool = redisutil.NewPool(conf.MgmtRedisConfig())
+	}
+	disc, err := agent.NewDiscovery(pool)
+	if err != nil {
+		logs.Critical("init agent discovery failed: %v", err)
+		os.Exit(1)
+	}
+	server, err := agent.NewServer(pool, disc)
+	if err != nil {
+		logs.Critical("init agent failed: %v", err)
+		os.Exit(1)
+	}
+	server.ResumeUpdate()
+	go server.RunHeartbeat(nil)
+	go server.RunNodeLabels(nil)
+	go server.RunHealthChecks(nil)
+	go server.RunServiceMonitor(nil)
+	go server.RunContainerGC(nil)
+	if err = server.Run(); err != nil {
+		logs.Critical("agent stopped: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,39 @@
+package filedb
+
+import (
+	"k8s-server/models/types"
+)
+
+// AddAuditLog inserts an audit log, the oldest logs beyond the record limit
+// are dropped.
+func (m *Model) AddAuditLog(log *types.AuditLog) error {
+	return m.update(func(d *data) error {
+		log.ID = d.nextID("audit_logs")
+		d.AuditLogs = append(d.AuditLogs, *log)
+		if m.maxRecords > 0 && len(d.AuditLogs) > m.maxRecords {
+			d.AuditLogs = append([]types.AuditLog(nil),
+				d.AuditLogs[len(d.AuditLogs)-m.maxRecords:]...)
+		}
+		return nil
+	})
+}
+
+// ListAuditLogs returns the audit logs matching filter, newest first.
+func (m *Model) ListAuditLogs(filter types.AuditFilter) ([]types.AuditLog, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var logs []types.AuditLog
+	for i := len(m.data.AuditLogs) - 1; i >= 0; i-- {
+		l := m.data.AuditLogs[i]
+		if (filter.Username != "" && l.Username != filter.Username) ||
+			(filter.Resource != "" && l.Resource != filter.Resource) ||
+			(!filter.Since.IsZero() && l.CreatedAt.Before(filter.Since)) {
+			continue
+		}
+		logs = append(logs, l)
+		if filter.Limit > 0 && len(logs) == filter.Limit {
+			break
+		}
+	}
+	return logs, nil
+}
@@ -0,0 +1,53 @@
+package filedb
+
+import (
+	"k8s-server/models/types"
+)
+
+// AddBatchJob inserts a batch job and assigns its ID.
+func (m *Model) AddBatchJob(job *types.BatchJob) error {
+	return m.update(func(d *data) error {
+		job.ID = d.nextID("batch_jobs")
+		d.BatchJobs = append(d.BatchJobs, *job)
+		return nil
+	})
+}
+
+// GetBatchJob returns the batch job by ID.
+func (m *Model) GetBatchJob(id int64) (*types.BatchJob, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, j := range m.data.BatchJobs {
+		if j.ID == id {
+			return &j, nil
+		}
+	}
+	return nil, types.ErrNotFound
+}
+
+// ListBatchJobs returns the batch jobs matching filter ordered by ID.
+func (m *Model) ListBatchJobs(filter types.BatchJobFilter) ([]types.BatchJob, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var jobs []types.BatchJob
+	for _, j := range m.data.BatchJobs {
+		if (filter.User != "" && j.SubmittedBy != filter.User) || (filter.Active && j.Ended()) {
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// UpdateBatchJob updates the batch job by its ID.
+func (m *Model) UpdateBatchJob(job *types.BatchJob) error {
+	return m.update(func(d *data) error {
+		for i := range d.BatchJobs {
+			if d.BatchJobs[i].ID == job.ID {
+				d.BatchJobs[i] = *job
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
@@ -0,0 +1,69 @@
+package filedb
+
+import (
+	"sort"
+
+	"k8s-server/models/types"
+)
+
+// AddCluster inserts a new cluster, names are unique. types.ErrDuplicate is
+// returned if a cluster has the name.
+func (m *Model) AddCluster(cluster *types.Cluster) error {
+	return m.update(func(d *data) error {
+		for _, c := range d.Clusters {
+			if c.Name == cluster.Name {
+				return types.ErrDuplicate
+			}
+		}
+		cluster.ID = d.nextID("clusters")
+		d.Clusters = append(d.Clusters, *cluster)
+		return nil
+	})
+}
+
+// GetCluster returns the cluster by name.
+func (m *Model) GetCluster(name string) (*types.Cluster, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, c := range m.data.Clusters {
+		if c.Name == name {
+			return &c, nil
+		}
+	}
+	return nil, types.ErrNotFound
+}
+
+// ListClusters returns all clusters ordered by name.
+func (m *Model) ListClusters() ([]types.Cluster, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	clusters := append([]types.Cluster(nil), m.data.Clusters...)
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	return clusters, nil
+}
+
+// UpdateCluster updates the cluster by its ID.
+func (m *Model) UpdateCluster(cluster *types.Cluster) error {
+	return m.update(func(d *data) error {
+		for i := range d.Clusters {
+			if d.Clusters[i].ID == cluster.ID {
+				d.Clusters[i] = *cluster
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
+
+// DeleteCluster deletes the cluster by name.
+func (m *Model) DeleteCluster(name string) error {
+	return m.update(func(d *data) error {
+		for i := range d.Clusters {
+			if d.Clusters[i].Name == name {
+				d.Clusters = append(d.Clusters[:i], d.Clusters[i+1:]...)
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
@@ -0,0 +1,39 @@
+package filedb
+
+import (
+	"k8s-server/models/types"
+)
+
+// AddEvent inserts an event, the oldest events beyond the record limit are
+// dropped.
+func (m *Model) AddEvent(event *types.Event) error {
+	return m.update(func(d *data) error {
+		event.ID = d.nextID("events")
+		d.Events = append(d.Events, *event)
+		if m.maxRecords > 0 && len(d.Events) > m.maxRecords {
+			d.Events = append([]types.Event(nil),
+				d.Events[len(d.Events)-m.maxRecords:]...)
+		}
+		return nil
+	})
+}
+
+// ListEvents returns the events matching filter, newest first.
+func (m *Model) ListEvents(filter types.EventFilter) ([]types.Event, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var events []types.Event
+	for i := len(m.data.Events) - 1; i >= 0; i-- {
+		e := m.data.Events[i]
+		if (filter.Kind != "" && e.Kind != filter.Kind) ||
+			(filter.Object != "" && e.Object != filter.Object) ||
+			(!filter.Since.IsZero() && e.CreatedAt.Before(filter.Since)) {
+			continue
+		}
+		events = append(events, e)
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
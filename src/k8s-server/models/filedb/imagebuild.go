@@ -0,0 +1,66 @@
+package filedb
+
+import (
+	"k8s-server/models/types"
+)
+
+// AddImageBuild inserts an image build and assigns its ID.
+func (m *Model) AddImageBuild(build *types.ImageBuild) error {
+	return m.update(func(d *data) error {
+		build.ID = d.nextID("image_builds")
+		d.ImageBuilds = append(d.ImageBuilds, *build)
+		return nil
+	})
+}
+
+// GetImageBuild returns the image build by ID.
+func (m *Model) GetImageBuild(id int64) (*types.ImageBuild, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, b := range m.data.ImageBuilds {
+		if b.ID == id {
+			return &b, nil
+		}
+	}
+	return nil, types.ErrNotFound
+}
+
+// ListImageBuilds returns the image builds matching filter ordered by ID.
+func (m *Model) ListImageBuilds(filter types.ImageBuildFilter) ([]types.ImageBuild, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var builds []types.ImageBuild
+	for _, b := range m.data.ImageBuilds {
+		if (filter.Owner != "" && b.Owner != filter.Owner) || (filter.Active && b.Finished()) {
+			continue
+		}
+		builds = append(builds, b)
+	}
+	return builds, nil
+}
+
+// UpdateImageBuild updates the image build by its ID.
+func (m *Model) UpdateImageBuild(build *types.ImageBuild) error {
+	return m.update(func(d *data) error {
+		for i := range d.ImageBuilds {
+			if d.ImageBuilds[i].ID == build.ID {
+				d.ImageBuilds[i] = *build
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
+
+// DeleteImageBuild deletes the image build by ID.
+func (m *Model) DeleteImageBuild(id int64) error {
+	return m.update(func(d *data) error {
+		for i := range d.ImageBuilds {
+			if d.ImageBuilds[i].ID == id {
+				d.ImageBuilds = append(d.ImageBuilds[:i], d.ImageBuilds[i+1:]...)
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
@@ -0,0 +1,135 @@
+// Package filedb is an embedded storage backend for single node installs and
+// tests. All records are kept in memory and written to one gob encoded file
+// after every change, no database server is required.
+package filedb
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"k8s-server/conf"
+	"k8s-server/models/types"
+)
+
+var (
+	model     *Model
+	modelLock = new(sync.Mutex)
+)
+
+// Model is the file backed implementation of models.Model.
+type Model struct {
+	path       string
+	maxRecords int
+
+	lock sync.RWMutex
+	data data
+}
+
+// data is the content of the database file.
+type data struct {
+	Seq         map[string]int64
+	Tokens      []types.AccessToken
+	Clusters    []types.Cluster
+	AuditLogs   []types.AuditLog
+	Events      []types.Event
+	Templates   []types.Template
+	Releases    []types.Release
+	Users       []types.User
+	BatchJobs   []types.BatchJob
+	ImageBuilds []types.ImageBuild
+}
+
+// GetModel returns the model stored at the configured path.
+func GetModel() (*Model, error) {
+	modelLock.Lock()
+	defer modelLock.Unlock()
+	if model != nil {
+		return model, nil
+	}
+	m, err := NewModel(conf.FileDBPath(), conf.FileDBMaxRecords())
+	if err != nil {
+		return nil, err
+	}
+	model = m
+	return model, nil
+}
+
+// NewModel opens or creates the database file at path. Audit logs and events
+// are trimmed to the newest maxRecords entries, zero keeps everything.
+func NewModel(path string, maxRecords int) (*Model, error) {
+	m := &Model{path: path, maxRecords: maxRecords}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Model) load() error {
+	m.data = data{Seq: make(map[string]int64)}
+	f, err := os.Open(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = gob.NewDecoder(f).Decode(&m.data); err != nil {
+		return fmt.Errorf("decode %s failed: %v", m.path, err)
+	}
+	if m.data.Seq == nil {
+		m.data.Seq = make(map[string]int64)
+	}
+	return nil
+}
+
+// save writes the data to a temporary file and renames it over the database
+// file, so a crash never leaves a partially written database.
+func (m *Model) save() error {
+	f, err := ioutil.TempFile(filepath.Dir(m.path), filepath.Base(m.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err = gob.NewEncoder(f).Encode(&m.data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), m.path)
+}
+
+// update runs fn under the write lock and persists the result. fn must not
+// change anything when it returns an error, if saving fails the in-memory
+// data is reloaded from the file.
+func (m *Model) update(fn func(d *data) error) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := fn(&m.data); err != nil {
+		return err
+	}
+	err := m.save()
+	if err != nil {
+		if loadErr := m.load(); loadErr != nil {
+			return fmt.Errorf("%v, reload failed: %v", err, loadErr)
+		}
+	}
+	return err
+}
+
+func (d *data) nextID(kind string) int64 {
+	d.Seq[kind]++
+	return d.Seq[kind]
+}
@@ -0,0 +1,154 @@
+package filedb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s-server/models/types"
+)
+
+func newTestModel(t *testing.T, maxRecords int) (*Model, string) {
+	dir, err := ioutil.TempDir("", "filedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "data", "test.db")
+	m, err := NewModel(path, maxRecords)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return m, dir
+}
+
+func TestClusterPersistence(t *testing.T) {
+	m, dir := newTestModel(t, 0)
+	defer os.RemoveAll(dir)
+
+	c := &types.Cluster{Name: "dev", APIServer: "https://10.0.0.1:6443",
+		KubeConfig: "secret", CreatedAt: time.Now()}
+	if err := m.AddCluster(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddCluster(&types.Cluster{Name: "dev"}); err != types.ErrDuplicate {
+		t.Error("duplicated cluster accepted")
+	}
+	c.Description = "development"
+	if err := m.UpdateCluster(c); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewModel(m.path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.GetCluster("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != c.ID || got.Description != "development" || got.KubeConfig != "secret" {
+		t.Errorf("reloaded cluster = %+v", got)
+	}
+	if err = reopened.DeleteCluster("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reopened.GetCluster("dev"); err != types.ErrNotFound {
+		t.Errorf("GetCluster after delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	m, dir := newTestModel(t, 0)
+	defer os.RemoveAll(dir)
+
+	uid := 2001
+	if err := m.AddUser(&types.User{Username: "alice", UID: &uid}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddUser(&types.User{Username: "alice"}); err != types.ErrDuplicate {
+		t.Errorf("duplicated username: err = %v, want ErrDuplicate", err)
+	}
+	same := uid
+	if err := m.AddUser(&types.User{Username: "bob", UID: &same}); err != types.ErrDuplicate {
+		t.Errorf("duplicated UID: err = %v, want ErrDuplicate", err)
+	}
+	if err := m.AddUser(&types.User{Username: "carol"}); err != nil {
+		t.Errorf("unprovisioned user: %v", err)
+	}
+
+	if err := m.AddRelease(&types.Release{Namespace: "dev", Name: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddRelease(&types.Release{Namespace: "dev", Name: "web"}); err != types.ErrDuplicate {
+		t.Errorf("duplicated release: err = %v, want ErrDuplicate", err)
+	}
+	if err := m.AddRelease(&types.Release{Namespace: "prod", Name: "web"}); err != nil {
+		t.Errorf("release in another namespace: %v", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	m, dir := newTestModel(t, 0)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a", "b"} {
+		if err := m.AddAccessToken(&types.AccessToken{Username: "alice",
+			Name: name, Hash: "hash-" + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tokens, _ := m.ListAccessTokens("alice")
+	if len(tokens) != 2 || tokens[0].Name != "b" {
+		t.Fatalf("ListAccessTokens = %+v, want newest first", tokens)
+	}
+	if n, _ := m.RevokeAccessToken(tokens[0].ID, "bob"); n != 0 {
+		t.Error("token revoked by another user")
+	}
+	if n, _ := m.RevokeAccessToken(tokens[0].ID, "alice"); n != 1 {
+		t.Error("token not revoked")
+	}
+	now := time.Now()
+	if err := m.TouchAccessToken(tokens[0].ID, "10.0.0.1", now); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.GetAccessTokenByHash("hash-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Revoked || got.LastUsedIP != "10.0.0.1" || !got.LastUsedAt.Equal(now) {
+		t.Errorf("token = %+v", got)
+	}
+}
+
+func TestEventsAreTrimmed(t *testing.T) {
+	m, dir := newTestModel(t, 3)
+	defer os.RemoveAll(dir)
+
+	base := time.Now()
+	for i := 0; i < 5; i++ {
+		kind := "Agent"
+		if i%2 == 1 {
+			kind = "Node"
+		}
+		err := m.AddEvent(&types.Event{Kind: kind, Object: "node1",
+			CreatedAt: base.Add(time.Duration(i) * time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, _ := m.ListEvents(types.EventFilter{})
+	if len(all) != 3 || all[0].ID != 5 || all[2].ID != 3 {
+		t.Fatalf("ListEvents = %+v, want events 5..3", all)
+	}
+	agents, _ := m.ListEvents(types.EventFilter{Kind: "Agent", Limit: 1})
+	if len(agents) != 1 || agents[0].ID != 5 {
+		t.Errorf("filtered events = %+v", agents)
+	}
+	recent, _ := m.ListEvents(types.EventFilter{Since: base.Add(4 * time.Minute)})
+	if len(recent) != 1 {
+		t.Errorf("events since = %+v", recent)
+	}
+}
@@ -0,0 +1,80 @@
+package filedb
+
+import (
+	"sort"
+
+	"k8s-server/models/types"
+)
+
+// AddRelease inserts a release, names are unique per namespace.
+// types.ErrDuplicate is returned if the namespace has a release of the name.
+func (m *Model) AddRelease(release *types.Release) error {
+	return m.update(func(d *data) error {
+		for _, r := range d.Releases {
+			if r.Namespace == release.Namespace && r.Name == release.Name {
+				return types.ErrDuplicate
+			}
+		}
+		release.ID = d.nextID("releases")
+		d.Releases = append(d.Releases, *release)
+		return nil
+	})
+}
+
+// GetRelease returns the release by namespace and name.
+func (m *Model) GetRelease(namespace, name string) (*types.Release, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, r := range m.data.Releases {
+		if r.Namespace == namespace && r.Name == name {
+			return &r, nil
+		}
+	}
+	return nil, types.ErrNotFound
+}
+
+// ListReleases returns the releases in namespace, or in all namespaces if
+// namespace is empty.
+func (m *Model) ListReleases(namespace string) ([]types.Release, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var releases []types.Release
+	for _, r := range m.data.Releases {
+		if namespace == "" || r.Namespace == namespace {
+			releases = append(releases, r)
+		}
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		if releases[i].Namespace != releases[j].Namespace {
+			return releases[i].Namespace < releases[j].Namespace
+		}
+		return releases[i].Name < releases[j].Name
+	})
+	return releases, nil
+}
+
+// UpdateRelease updates the release by its ID.
+func (m *Model) UpdateRelease(release *types.Release) error {
+	return m.update(func(d *data) error {
+		for i := range d.Releases {
+			if d.Releases[i].ID == release.ID {
+				d.Releases[i] = *release
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
+
+// DeleteRelease deletes the release by namespace and name.
+func (m *Model) DeleteRelease(namespace, name string) error {
+	return m.update(func(d *data) error {
+		for i, r := range d.Releases {
+			if r.Namespace == namespace && r.Name == name {
+				d.Releases = append(d.Releases[:i], d.Releases[i+1:]...)
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
@@ -0,0 +1,69 @@
+package filedb
+
+import (
+	"sort"
+
+	"k8s-server/models/types"
+)
+
+// AddTemplate inserts an application template, names are unique.
+// types.ErrDuplicate is returned if a template has the name.
+func (m *Model) AddTemplate(template *types.Template) error {
+	return m.update(func(d *data) error {
+		for _, t := range d.Templates {
+			if t.Name == template.Name {
+				return types.ErrDuplicate
+			}
+		}
+		template.ID = d.nextID("templates")
+		d.Templates = append(d.Templates, *template)
+		return nil
+	})
+}
+
+// GetTemplate returns the template by name.
+func (m *Model) GetTemplate(name string) (*types.Template, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, t := range m.data.Templates {
+		if t.Name == name {
+			return &t, nil
+		}
+	}
+	return nil, types.ErrNotFound
+}
+
+// ListTemplates returns all templates ordered by name.
+func (m *Model) ListTemplates() ([]types.Template, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	templates := append([]types.Template(nil), m.data.Templates...)
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// UpdateTemplate updates the template by its ID.
+func (m *Model) UpdateTemplate(template *types.Template) error {
+	return m.update(func(d *data) error {
+		for i := range d.Templates {
+			if d.Templates[i].ID == template.ID {
+				d.Templates[i] = *template
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
+
+// DeleteTemplate deletes the template by name.
+func (m *Model) DeleteTemplate(name string) error {
+	return m.update(func(d *data) error {
+		for i := range d.Templates {
+			if d.Templates[i].Name == name {
+				d.Templates = append(d.Templates[:i], d.Templates[i+1:]...)
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
@@ -0,0 +1,72 @@
+package filedb
+
+import (
+	"sort"
+	"time"
+
+	"k8s-server/models/types"
+)
+
+// AddAccessToken inserts a new access token.
+func (m *Model) AddAccessToken(token *types.AccessToken) error {
+	return m.update(func(d *data) error {
+		token.ID = d.nextID("tokens")
+		d.Tokens = append(d.Tokens, *token)
+		return nil
+	})
+}
+
+// GetAccessTokenByHash returns the token whose secret hashes to hash.
+func (m *Model) GetAccessTokenByHash(hash string) (*types.AccessToken, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, t := range m.data.Tokens {
+		if t.Hash == hash {
+			return &t, nil
+		}
+	}
+	return nil, types.ErrNotFound
+}
+
+// ListAccessTokens returns all tokens owned by username, newest first.
+func (m *Model) ListAccessTokens(username string) ([]types.AccessToken, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var tokens []types.AccessToken
+	for _, t := range m.data.Tokens {
+		if t.Username == username {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID > tokens[j].ID })
+	return tokens, nil
+}
+
+// RevokeAccessToken marks the token as revoked and returns the number of
+// affected tokens.
+func (m *Model) RevokeAccessToken(id int64, username string) (int64, error) {
+	var n int64
+	err := m.update(func(d *data) error {
+		for i := range d.Tokens {
+			if d.Tokens[i].ID == id && d.Tokens[i].Username == username {
+				d.Tokens[i].Revoked = true
+				n = 1
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// TouchAccessToken records the last time and client IP the token was used.
+func (m *Model) TouchAccessToken(id int64, ip string, at time.Time) error {
+	return m.update(func(d *data) error {
+		for i := range d.Tokens {
+			if d.Tokens[i].ID == id {
+				d.Tokens[i].LastUsedAt = &at
+				d.Tokens[i].LastUsedIP = ip
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,77 @@
+package filedb
+
+import (
+	"sort"
+
+	"k8s-server/models/types"
+)
+
+// AddUser inserts a user, usernames and UIDs are unique. types.ErrDuplicate
+// is returned if a user has the username or UID.
+func (m *Model) AddUser(user *types.User) error {
+	return m.update(func(d *data) error {
+		for _, u := range d.Users {
+			if u.Username == user.Username || (user.UID != nil && u.UID != nil && *u.UID == *user.UID) {
+				return types.ErrDuplicate
+			}
+		}
+		user.ID = d.nextID("users")
+		d.Users = append(d.Users, *user)
+		return nil
+	})
+}
+
+// GetUser returns the user by username.
+func (m *Model) GetUser(username string) (*types.User, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, u := range m.data.Users {
+		if u.Username == username {
+			return &u, nil
+		}
+	}
+	return nil, types.ErrNotFound
+}
+
+// ListUsers returns all users ordered by username.
+func (m *Model) ListUsers() ([]types.User, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	users := append([]types.User(nil), m.data.Users...)
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// UpdateUser updates the user by its ID, types.ErrDuplicate is returned if
+// another user has the UID.
+func (m *Model) UpdateUser(user *types.User) error {
+	return m.update(func(d *data) error {
+		if user.UID != nil {
+			for _, u := range d.Users {
+				if u.ID != user.ID && u.UID != nil && *u.UID == *user.UID {
+					return types.ErrDuplicate
+				}
+			}
+		}
+		for i := range d.Users {
+			if d.Users[i].ID == user.ID {
+				d.Users[i] = *user
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
+
+// DeleteUser deletes the user by username.
+func (m *Model) DeleteUser(username string) error {
+	return m.update(func(d *data) error {
+		for i := range d.Users {
+			if d.Users[i].Username == username {
+				d.Users = append(d.Users[:i], d.Users[i+1:]...)
+				return nil
+			}
+		}
+		return types.ErrNotFound
+	})
+}
@@ -0,0 +1,124 @@
+// Package models defines the storage interface of the server and selects the
+// backend configured by backend::StorageBackend.
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/models/filedb"
+	"k8s-server/models/mysqldb"
+	"k8s-server/models/types"
+)
+
+// Storage backends.
+const (
+	BackendMySQL = "mysql"
+	BackendFile  = "file"
+)
+
+var (
+	_ Model = (*mysqldb.Model)(nil)
+	_ Model = (*filedb.Model)(nil)
+)
+
+// Model is the storage used by the modules, it is implemented by MySQL and by
+// an embedded file backend for single node installs and tests.
+type Model interface {
+	ClusterStore
+	AuditStore
+	EventStore
+	TemplateStore
+	ReleaseStore
+	UserStore
+	TokenStore
+	BatchJobStore
+	ImageBuildStore
+}
+
+// ClusterStore persists the managed clusters.
+type ClusterStore interface {
+	AddCluster(cluster *types.Cluster) error
+	GetCluster(name string) (*types.Cluster, error)
+	ListClusters() ([]types.Cluster, error)
+	UpdateCluster(cluster *types.Cluster) error
+	DeleteCluster(name string) error
+}
+
+// AuditStore persists audit logs.
+type AuditStore interface {
+	AddAuditLog(log *types.AuditLog) error
+	ListAuditLogs(filter types.AuditFilter) ([]types.AuditLog, error)
+}
+
+// EventStore persists events.
+type EventStore interface {
+	AddEvent(event *types.Event) error
+	ListEvents(filter types.EventFilter) ([]types.Event, error)
+}
+
+// TemplateStore persists application templates.
+type TemplateStore interface {
+	AddTemplate(template *types.Template) error
+	GetTemplate(name string) (*types.Template, error)
+	ListTemplates() ([]types.Template, error)
+	UpdateTemplate(template *types.Template) error
+	DeleteTemplate(name string) error
+}
+
+// ReleaseStore persists the releases created from application templates.
+type ReleaseStore interface {
+	AddRelease(release *types.Release) error
+	GetRelease(namespace, name string) (*types.Release, error)
+	ListReleases(namespace string) ([]types.Release, error)
+	UpdateRelease(release *types.Release) error
+	DeleteRelease(namespace, name string) error
+}
+
+// UserStore persists users.
+type UserStore interface {
+	AddUser(user *types.User) error
+	GetUser(username string) (*types.User, error)
+	ListUsers() ([]types.User, error)
+	UpdateUser(user *types.User) error
+	DeleteUser(username string) error
+}
+
+// TokenStore persists personal access tokens.
+type TokenStore interface {
+	AddAccessToken(token *types.AccessToken) error
+	GetAccessTokenByHash(hash string) (*types.AccessToken, error)
+	ListAccessTokens(username string) ([]types.AccessToken, error)
+	RevokeAccessToken(id int64, username string) (int64, error)
+	TouchAccessToken(id int64, ip string, at time.Time) error
+}
+
+// BatchJobStore persists the Slurm-style batch jobs.
+type BatchJobStore interface {
+	AddBatchJob(job *types.BatchJob) error
+	GetBatchJob(id int64) (*types.BatchJob, error)
+	ListBatchJobs(filter types.BatchJobFilter) ([]types.BatchJob, error)
+	UpdateBatchJob(job *types.BatchJob) error
+}
+
+// ImageBuildStore persists the custom image builds.
+type ImageBuildStore interface {
+	AddImageBuild(build *types.ImageBuild) error
+	GetImageBuild(id int64) (*types.ImageBuild, error)
+	ListImageBuilds(filter types.ImageBuildFilter) ([]types.ImageBuild, error)
+	UpdateImageBuild(build *types.ImageBuild) error
+	DeleteImageBuild(id int64) error
+}
+
+// GetModel returns the model of the configured storage backend.
+func GetModel() (Model, error) {
+	switch backend := conf.StorageBackend(); backend {
+	case BackendMySQL:
+		return mysqldb.GetModel()
+	case BackendFile:
+		return filedb.GetModel()
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
@@ -0,0 +1,54 @@
+package mysqldb
+
+import (
+	"strings"
+
+	"k8s-server/models/types"
+)
+
+func init() {
+	registerTable("audit_logs", types.AuditLog{}, true, "ID")
+}
+
+// AddAuditLog inserts an audit log.
+func (m *Model) AddAuditLog(log *types.AuditLog) error {
+	return m.db.Insert(log)
+}
+
+// ListAuditLogs returns the audit logs matching filter, newest first.
+func (m *Model) ListAuditLogs(filter types.AuditFilter) ([]types.AuditLog, error) {
+	var conds []string
+	var args []interface{}
+	if filter.Username != "" {
+		conds = append(conds, "username = ?")
+		args = append(args, filter.Username)
+	}
+	if filter.Resource != "" {
+		conds = append(conds, "resource = ?")
+		args = append(args, filter.Resource)
+	}
+	if !filter.Since.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	query, args := selectQuery("audit_logs", conds, args, filter.Limit)
+	var logs []types.AuditLog
+	_, err := m.db.Select(&logs, query, args...)
+	return logs, err
+}
+
+// selectQuery builds a newest first SELECT on table with the AND-ed
+// conditions and an optional limit.
+func selectQuery(table string, conds []string, args []interface{},
+	limit int) (string, []interface{}) {
+	query := "SELECT * FROM " + table
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return query, args
+}
@@ -0,0 +1,56 @@
+package mysqldb
+
+import (
+	"strings"
+
+	"k8s-server/models/types"
+)
+
+func init() {
+	registerTable("batch_jobs", types.BatchJob{}, true, "ID")
+}
+
+// AddBatchJob inserts a batch job, its ID is assigned by the database.
+func (m *Model) AddBatchJob(job *types.BatchJob) error {
+	return m.db.Insert(job)
+}
+
+// GetBatchJob returns the batch job by ID.
+func (m *Model) GetBatchJob(id int64) (*types.BatchJob, error) {
+	job := &types.BatchJob{}
+	err := m.db.SelectOne(job, "SELECT * FROM batch_jobs WHERE id = ?", id)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return job, nil
+}
+
+// ListBatchJobs returns the batch jobs matching filter ordered by ID.
+func (m *Model) ListBatchJobs(filter types.BatchJobFilter) ([]types.BatchJob, error) {
+	var where []string
+	var args []interface{}
+	if filter.User != "" {
+		where = append(where, "submitted_by = ?")
+		args = append(args, filter.User)
+	}
+	if filter.Active {
+		where = append(where, "state IN (?, ?, ?)")
+		args = append(args, types.BatchPending, types.BatchRunning, types.BatchCompleting)
+	}
+	query := "SELECT * FROM batch_jobs"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	var jobs []types.BatchJob
+	_, err := m.db.Select(&jobs, query+" ORDER BY id", args...)
+	return jobs, err
+}
+
+// UpdateBatchJob updates the batch job by its ID.
+func (m *Model) UpdateBatchJob(job *types.BatchJob) error {
+	n, err := m.db.Update(job)
+	if err == nil && n == 0 {
+		return types.ErrNotFound
+	}
+	return err
+}
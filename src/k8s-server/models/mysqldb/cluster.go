@@ -0,0 +1,60 @@
+package mysqldb
+
+import (
+	"k8s-server/models/types"
+)
+
+func init() {
+	registerTable("clusters", types.Cluster{}, true, "ID")
+}
+
+// AddCluster inserts a new cluster.
+func (m *Model) AddCluster(cluster *types.Cluster) error {
+	return duplicate(m.db.Insert(cluster))
+}
+
+// GetCluster returns the cluster by name.
+func (m *Model) GetCluster(name string) (*types.Cluster, error) {
+	cluster := &types.Cluster{}
+	err := m.db.SelectOne(cluster, "SELECT * FROM clusters WHERE name = ?", name)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return cluster, nil
+}
+
+// ListClusters returns all clusters ordered by name.
+func (m *Model) ListClusters() ([]types.Cluster, error) {
+	var clusters []types.Cluster
+	_, err := m.db.Select(&clusters, "SELECT * FROM clusters ORDER BY name")
+	return clusters, err
+}
+
+// UpdateCluster updates the cluster by its ID.
+func (m *Model) UpdateCluster(cluster *types.Cluster) error {
+	n, err := m.db.Update(cluster)
+	if err == nil && n == 0 {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// DeleteCluster deletes the cluster by name.
+func (m *Model) DeleteCluster(name string) error {
+	return m.deleteByName("DELETE FROM clusters WHERE name = ?", name)
+}
+
+// deleteByName runs a delete statement and reports types.ErrNotFound if
+// nothing was deleted.
+func (m *Model) deleteByName(query, name string) error {
+	result, err := m.db.Exec(query, name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return types.ErrNotFound
+	}
+	return nil
+}
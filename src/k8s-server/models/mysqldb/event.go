@@ -0,0 +1,36 @@
+package mysqldb
+
+import (
+	"k8s-server/models/types"
+)
+
+func init() {
+	registerTable("events", types.Event{}, true, "ID")
+}
+
+// AddEvent inserts an event.
+func (m *Model) AddEvent(event *types.Event) error {
+	return m.db.Insert(event)
+}
+
+// ListEvents returns the events matching filter, newest first.
+func (m *Model) ListEvents(filter types.EventFilter) ([]types.Event, error) {
+	var conds []string
+	var args []interface{}
+	if filter.Kind != "" {
+		conds = append(conds, "kind = ?")
+		args = append(args, filter.Kind)
+	}
+	if filter.Object != "" {
+		conds = append(conds, "object = ?")
+		args = append(args, filter.Object)
+	}
+	if !filter.Since.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	query, args := selectQuery("events", conds, args, filter.Limit)
+	var events []types.Event
+	_, err := m.db.Select(&events, query, args...)
+	return events, err
+}
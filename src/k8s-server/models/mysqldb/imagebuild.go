@@ -0,0 +1,70 @@
+package mysqldb
+
+import (
+	"strings"
+
+	"k8s-server/models/types"
+)
+
+func init() {
+	registerTable("image_builds", types.ImageBuild{}, true, "ID")
+}
+
+// AddImageBuild inserts an image build, its ID is assigned by the database.
+func (m *Model) AddImageBuild(build *types.ImageBuild) error {
+	return m.db.Insert(build)
+}
+
+// GetImageBuild returns the image build by ID.
+func (m *Model) GetImageBuild(id int64) (*types.ImageBuild, error) {
+	build := &types.ImageBuild{}
+	err := m.db.SelectOne(build, "SELECT * FROM image_builds WHERE id = ?", id)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return build, nil
+}
+
+// ListImageBuilds returns the image builds matching filter ordered by ID.
+func (m *Model) ListImageBuilds(filter types.ImageBuildFilter) ([]types.ImageBuild, error) {
+	var where []string
+	var args []interface{}
+	if filter.Owner != "" {
+		where = append(where, "owner = ?")
+		args = append(args, filter.Owner)
+	}
+	if filter.Active {
+		where = append(where, "state IN (?, ?)")
+		args = append(args, types.BuildPending, types.BuildRunning)
+	}
+	query := "SELECT * FROM image_builds"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	var builds []types.ImageBuild
+	_, err := m.db.Select(&builds, query+" ORDER BY id", args...)
+	return builds, err
+}
+
+// UpdateImageBuild updates the image build by its ID.
+func (m *Model) UpdateImageBuild(build *types.ImageBuild) error {
+	n, err := m.db.Update(build)
+	if err == nil && n == 0 {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// DeleteImageBuild deletes the image build by ID.
+func (m *Model) DeleteImageBuild(id int64) error {
+	result, err := m.db.Exec("DELETE FROM image_builds WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return types.ErrNotFound
+	}
+	return nil
+}
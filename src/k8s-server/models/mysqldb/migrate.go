@@ -0,0 +1,293 @@
+package mysqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"k8s-server/conf"
+	"k8s-server/utils/logs"
+)
+
+// Schema groups, a group is a set of tables owned by one product feature and
+// is only created when it is enabled by mysql::Schema. The standard group is
+// always enabled.
+const (
+	GroupStandard       = "standard"
+	GroupCloud          = "cloud"
+	GroupVirtualDesktop = "virtual-desktop"
+	GroupTraining       = "training"
+)
+
+var knownGroups = map[string]bool{
+	GroupStandard:       true,
+	GroupCloud:          true,
+	GroupVirtualDesktop: true,
+	GroupTraining:       true,
+}
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT NOT NULL,
+	schema_group VARCHAR(32) NOT NULL,
+	name VARCHAR(128) NOT NULL,
+	applied_at DATETIME NOT NULL,
+	PRIMARY KEY (version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// errNoSuchTable is the MySQL error of a missing table.
+const errNoSuchTable = 1146
+
+// migrationLock is the named lock held while migrations are applied or
+// reverted, so servers starting together do not apply a migration twice.
+const (
+	migrationLock        = "k8s-server.schema_migrations"
+	migrationLockTimeout = 300
+)
+
+// Migration is a versioned schema change, Up and Down hold one or more SQL
+// statements separated by semicolons at the end of a line.
+type Migration struct {
+	Version int
+	Group   string
+	Name    string
+	Up      string
+	Down    string
+}
+
+// appliedMigration is a row of the schema_migrations table.
+type appliedMigration struct {
+	Version   int       `db:"version"`
+	Group     string    `db:"schema_group"`
+	Name      string    `db:"name"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+func init() {
+	registerTable("schema_migrations", appliedMigration{}, false, "Version")
+}
+
+// Migrate applies the pending migrations of the enabled schema groups in
+// version order.
+func (m *Model) Migrate() error {
+	return m.locked(m.migrate)
+}
+
+func (m *Model) migrate() error {
+	if _, err := m.db.Exec(createMigrationsTable); err != nil {
+		return fmt.Errorf("create schema_migrations failed: %v", err)
+	}
+	pending, err := m.pendingMigrations()
+	if err != nil {
+		return err
+	}
+	for _, mig := range pending {
+		logs.Info("applying migration %d (%s) %s", mig.Version, mig.Group, mig.Name)
+		if err = m.execStatements(mig.Up); err != nil {
+			return fmt.Errorf("apply migration %d %s failed: %v",
+				mig.Version, mig.Name, err)
+		}
+		err = m.db.Insert(&appliedMigration{
+			Version:   mig.Version,
+			Group:     mig.Group,
+			Name:      mig.Name,
+			AppliedAt: time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("record migration %d failed: %v", mig.Version, err)
+		}
+	}
+	return nil
+}
+
+// Rollback reverts the last steps applied migrations in reverse order.
+func (m *Model) Rollback(steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("invalid number of migrations to revert %d", steps)
+	}
+	return m.locked(func() error { return m.rollback(steps) })
+}
+
+func (m *Model) rollback(steps int) error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	if len(applied) > steps {
+		applied = applied[:steps]
+	}
+	byVersion := make(map[int]Migration, len(migrations))
+	for _, mig := range migrations {
+		byVersion[mig.Version] = mig
+	}
+	for i := range applied {
+		mig, ok := byVersion[applied[i].Version]
+		if !ok {
+			return fmt.Errorf("migration %d is applied but unknown to this build",
+				applied[i].Version)
+		}
+		logs.Info("reverting migration %d (%s) %s", mig.Version, mig.Group, mig.Name)
+		if err = m.execStatements(mig.Down); err != nil {
+			return fmt.Errorf("revert migration %d %s failed: %v",
+				mig.Version, mig.Name, err)
+		}
+		if _, err = m.db.Delete(&applied[i]); err != nil {
+			return fmt.Errorf("unrecord migration %d failed: %v", mig.Version, err)
+		}
+	}
+	return nil
+}
+
+// locked runs fn while holding the migration lock, it waits up to
+// migrationLockTimeout seconds for another server to release it. MySQL named
+// locks belong to a connection, one is kept aside until fn returns.
+func (m *Model) locked(fn func() error) error {
+	ctx := context.Background()
+	conn, err := m.db.Db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("connect for the migration lock failed: %v", err)
+	}
+	defer conn.Close()
+	var got sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLock, migrationLockTimeout).Scan(&got)
+	if err != nil {
+		return fmt.Errorf("acquire the migration lock failed: %v", err)
+	}
+	if !got.Valid || got.Int64 != 1 {
+		return fmt.Errorf("acquire the migration lock timed out after %ds", migrationLockTimeout)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLock); err != nil {
+			logs.Warn("release the migration lock failed: %v", err)
+		}
+	}()
+	return fn()
+}
+
+// PendingMigrations connects to the configured database and returns the
+// migrations of the enabled schema groups that have not been applied yet, the
+// database is only read.
+func PendingMigrations() ([]Migration, error) {
+	m, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer m.db.Db.Close()
+	return m.pendingMigrations()
+}
+
+// RollbackMigrations connects to the configured database and reverts the last
+// steps applied migrations.
+func RollbackMigrations(steps int) error {
+	m, err := open()
+	if err != nil {
+		return err
+	}
+	defer m.db.Db.Close()
+	return m.Rollback(steps)
+}
+
+// open connects to the configured database without migrating it.
+func open() (*Model, error) {
+	m := &Model{config: conf.HPCMySQLConfig()}
+	dbMap, err := m.newDBMap()
+	if err != nil {
+		return nil, err
+	}
+	m.db = dbMap
+	return m, nil
+}
+
+// applied returns the applied migrations newest first, none if the
+// schema_migrations table does not exist yet.
+func (m *Model) applied() ([]appliedMigration, error) {
+	var applied []appliedMigration
+	_, err := m.db.Select(&applied, "SELECT * FROM schema_migrations ORDER BY version DESC")
+	if e, ok := err.(*mysql.MySQLError); ok && e.Number == errNoSuchTable {
+		return nil, nil
+	}
+	return applied, err
+}
+
+func (m *Model) pendingMigrations() ([]Migration, error) {
+	groups, err := enabledGroups(m.config.Schema)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+	return pending(migrations, groups, done), nil
+}
+
+// pending returns the migrations of groups that are not done, sorted by
+// version.
+func pending(all []Migration, groups map[string]bool, done map[int]bool) []Migration {
+	var list []Migration
+	for _, mig := range all {
+		if groups[mig.Group] && !done[mig.Version] {
+			list = append(list, mig)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// enabledGroups parses the semicolon separated schema groups of mysql::Schema.
+func enabledGroups(schema string) (map[string]bool, error) {
+	groups := map[string]bool{GroupStandard: true}
+	for _, g := range strings.Split(schema, ";") {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		if !knownGroups[g] {
+			return nil, fmt.Errorf("unknown schema group %q", g)
+		}
+		groups[g] = true
+	}
+	return groups, nil
+}
+
+func (m *Model) execStatements(text string) error {
+	for _, stmt := range splitStatements(text) {
+		if _, err := m.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitStatements splits SQL text on semicolons that end a line, the driver
+// runs one statement per Exec.
+func splitStatements(text string) []string {
+	var stmts []string
+	var cur []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasSuffix(trimmed, ";") {
+			cur = append(cur, strings.TrimSuffix(trimmed, ";"))
+			stmts = appendStatement(stmts, cur)
+			cur = nil
+			continue
+		}
+		cur = append(cur, line)
+	}
+	return appendStatement(stmts, cur)
+}
+
+func appendStatement(stmts, lines []string) []string {
+	if stmt := strings.TrimSpace(strings.Join(lines, "\n")); stmt != "" {
+		return append(stmts, stmt)
+	}
+	return stmts
+}
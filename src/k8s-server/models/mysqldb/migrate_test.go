@@ -0,0 +1,72 @@
+package mysqldb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	last := 0
+	for _, mig := range migrations {
+		if mig.Version <= last {
+			t.Errorf("migration %d %q is not after version %d", mig.Version,
+				mig.Name, last)
+		}
+		last = mig.Version
+		if !knownGroups[mig.Group] {
+			t.Errorf("migration %d has unknown group %q", mig.Version, mig.Group)
+		}
+		if len(splitStatements(mig.Up)) == 0 || len(splitStatements(mig.Down)) == 0 {
+			t.Errorf("migration %d must have up and down statements", mig.Version)
+		}
+	}
+}
+
+func TestEnabledGroups(t *testing.T) {
+	groups, err := enabledGroups("cloud; training;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{GroupStandard: true, GroupCloud: true, GroupTraining: true}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("enabledGroups = %v, want %v", groups, want)
+	}
+	if _, err = enabledGroups("standard;bogus"); err == nil {
+		t.Error("unknown group accepted")
+	}
+}
+
+func TestPending(t *testing.T) {
+	all := []Migration{
+		{Version: 3, Group: GroupTraining},
+		{Version: 1, Group: GroupStandard},
+		{Version: 2, Group: GroupCloud},
+		{Version: 4, Group: GroupStandard},
+	}
+	groups := map[string]bool{GroupStandard: true, GroupTraining: true}
+	got := pending(all, groups, map[int]bool{1: true})
+	var versions []int
+	for _, mig := range got {
+		versions = append(versions, mig.Version)
+	}
+	if !reflect.DeepEqual(versions, []int{3, 4}) {
+		t.Errorf("pending versions = %v, want [3 4]", versions)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	text := `
+CREATE TABLE a (
+	id INT
+);
+INSERT INTO a VALUES (1);
+DROP TABLE b`
+	want := []string{
+		"CREATE TABLE a (\n\tid INT\n)",
+		"INSERT INTO a VALUES (1)",
+		"DROP TABLE b",
+	}
+	if got := splitStatements(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitStatements = %q, want %q", got, want)
+	}
+}
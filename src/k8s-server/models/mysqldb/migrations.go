@@ -0,0 +1,212 @@
+package mysqldb
+
+// migrations lists every schema change in version order. Versions are never
+// reused or edited once released, add a new migration instead.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Group:   GroupStandard,
+		Name:    "create access_tokens",
+		Up: `
+CREATE TABLE IF NOT EXISTS access_tokens (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	username VARCHAR(64) NOT NULL,
+	name VARCHAR(128) NOT NULL,
+	prefix VARCHAR(16) NOT NULL,
+	hash CHAR(64) NOT NULL,
+	scope TEXT NOT NULL,
+	expires_at DATETIME NULL,
+	last_used_at DATETIME NULL,
+	last_used_ip VARCHAR(64) NOT NULL DEFAULT '',
+	revoked TINYINT(1) NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY uk_access_tokens_hash (hash),
+	KEY idx_access_tokens_username (username)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		Down: `DROP TABLE IF EXISTS access_tokens;`,
+	},
+	{
+		Version: 2,
+		Group:   GroupStandard,
+		Name:    "create clusters",
+		Up: `
+CREATE TABLE IF NOT EXISTS clusters (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	name VARCHAR(64) NOT NULL,
+	api_server VARCHAR(255) NOT NULL,
+	kube_config MEDIUMTEXT NOT NULL,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY uk_clusters_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		Down: `DROP TABLE IF EXISTS clusters;`,
+	},
+	{
+		Version: 3,
+		Group:   GroupStandard,
+		Name:    "create audit_logs",
+		Up: `
+CREATE TABLE IF NOT EXISTS audit_logs (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	username VARCHAR(64) NOT NULL,
+	action VARCHAR(32) NOT NULL,
+	resource VARCHAR(64) NOT NULL,
+	namespace VARCHAR(64) NOT NULL DEFAULT '',
+	detail TEXT NOT NULL,
+	ip VARCHAR(64) NOT NULL DEFAULT '',
+	success TINYINT(1) NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY idx_audit_logs_username (username),
+	KEY idx_audit_logs_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		Down: `DROP TABLE IF EXISTS audit_logs;`,
+	},
+	{
+		Version: 4,
+		Group:   GroupStandard,
+		Name:    "create events",
+		Up: `
+CREATE TABLE IF NOT EXISTS events (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	type VARCHAR(16) NOT NULL,
+	kind VARCHAR(32) NOT NULL,
+	object VARCHAR(255) NOT NULL,
+	reason VARCHAR(64) NOT NULL,
+	message TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY idx_events_object (kind, object),
+	KEY idx_events_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		Down: `DROP TABLE IF EXISTS events;`,
+	},
+	{
+		Version: 5,
+		Group:   GroupStandard,
+		Name:    "create users",
+		Up: `
+CREATE TABLE IF NOT EXISTS users (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	username VARCHAR(64) NOT NULL,
+	email VARCHAR(128) NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY uk_users_username (username)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		Down: `DROP TABLE IF EXISTS users;`,
+	},
+	{
+		Version: 6,
+		Group:   GroupTraining,
+		Name:    "create templates",
+		Up: `
+CREATE TABLE IF NOT EXISTS templates (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	name VARCHAR(64) NOT NULL,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	content MEDIUMTEXT NOT NULL,
+	created_by VARCHAR(64) NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY uk_templates_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		Down: `DROP TABLE IF EXISTS templates;`,
+	},
+	{
+		Version: 7,
+		Group:   GroupTraining,
+		Name:    "add template parameters and create releases",
+		Up: `
+ALTER TABLE templates
+	ADD COLUMN parameters TEXT NOT NULL AFTER content,
+	ADD COLUMN revision INT NOT NULL DEFAULT 1 AFTER parameters;
+CREATE TABLE IF NOT EXISTS releases (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	name VARCHAR(64) NOT NULL,
+	namespace VARCHAR(64) NOT NULL,
+	template VARCHAR(64) NOT NULL,
+	template_revision INT NOT NULL,
+	revision INT NOT NULL,
+	` + "`values`" + ` MEDIUMTEXT NOT NULL,
+	objects MEDIUMTEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	message TEXT NOT NULL,
+	created_by VARCHAR(64) NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY uk_releases_namespace_name (namespace, name),
+	KEY idx_releases_template (template)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		Down: `
+DROP TABLE IF EXISTS releases;
+ALTER TABLE templates DROP COLUMN revision, DROP COLUMN parameters;`,
+	},
+	{
+		Version: 8,
+		Group:   GroupStandard,
+		Name:    "create batch_jobs",
+		Up: `
+CREATE TABLE IF NOT EXISTS batch_jobs (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	name VARCHAR(128) NOT NULL,
+	namespace VARCHAR(64) NOT NULL,
+	` + "`partition`" + ` VARCHAR(64) NOT NULL DEFAULT '',
+	nodes INT NOT NULL,
+	time_limit BIGINT NOT NULL DEFAULT 0,
+	script MEDIUMTEXT NOT NULL,
+	state VARCHAR(4) NOT NULL,
+	reason VARCHAR(255) NOT NULL DEFAULT '',
+	node_list TEXT NOT NULL,
+	submitted_by VARCHAR(64) NOT NULL,
+	submitted_at DATETIME NOT NULL,
+	started_at DATETIME NULL,
+	ended_at DATETIME NULL,
+	PRIMARY KEY (id),
+	KEY idx_batch_jobs_submitted_by (submitted_by),
+	KEY idx_batch_jobs_state (state)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		Down: `DROP TABLE IF EXISTS batch_jobs;`,
+	},
+	{
+		Version: 9,
+		Group:   GroupStandard,
+		Name:    "create image_builds",
+		Up: `
+CREATE TABLE IF NOT EXISTS image_builds (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	owner VARCHAR(64) NOT NULL,
+	name VARCHAR(128) NOT NULL,
+	tag VARCHAR(128) NOT NULL,
+	repository VARCHAR(255) NOT NULL,
+	image VARCHAR(512) NOT NULL,
+	state VARCHAR(16) NOT NULL,
+	message TEXT NOT NULL,
+	context_size BIGINT NOT NULL DEFAULT 0,
+	image_size BIGINT NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	started_at DATETIME NULL,
+	finished_at DATETIME NULL,
+	PRIMARY KEY (id),
+	KEY idx_image_builds_owner (owner),
+	KEY idx_image_builds_state (state)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		Down: `DROP TABLE IF EXISTS image_builds;`,
+	},
+	{
+		Version: 10,
+		Group:   GroupStandard,
+		Name:    "add user uid and home_dir",
+		Up: `
+ALTER TABLE users
+	ADD COLUMN uid INT NULL AFTER email,
+	ADD COLUMN home_dir VARCHAR(255) NOT NULL DEFAULT '' AFTER uid,
+	ADD UNIQUE KEY uk_users_uid (uid);`,
+		Down: `ALTER TABLE users DROP KEY uk_users_uid, DROP COLUMN home_dir, DROP COLUMN uid;`,
+	},
+}
@@ -1,43 +1,119 @@
-package mysqldb
-
-import (
-	"github.com/go-gorp/gorp"
-	"github.com/astaxie/beego"
-	"k8s-server/conf"
-)
-
-var (
-	cfg       = beego.AppConfig
-	model     Model
-	modelLock = new(sync.Mutex)
-)
-
-// Model is an public struct but should be initialized only once.
-type Model struct {
-	db     *gorp.DbMap
-	inited bool
-	config conf.MySQLConfig
-}
-
-// GetModel returns initiated model.
-func GetModel() (*Model, error) {
-	modelLock.Lock()
-	defer modelLock.Unlock()
-	var err error
-	if !model.inited {
-		if err = model.initDB(); err == nil {
-			model.inited = true
-		}
-	}
-	return &model, err
-}
-
-func (m *Model) initDB() error {
-	m.config = conf.HPCMySQLConfig()
-	dbMap, err := m.newDBMap()
-	if err != nil {
-		return err
-	}
-	m.db = dbMap
-	return nil
+package mysqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/go-gorp/gorp"
+	"github.com/astaxie/beego"
+	"github.com/go-sql-driver/mysql"
+	"k8s-server/conf"
+	"k8s-server/models/types"
+)
+
+var (
+	cfg       = beego.AppConfig
+	model     Model
+	modelLock = new(sync.Mutex)
+	tables    []table
+)
+
+// table describes a struct mapped to a database table, tables are registered
+// by the files that define them and bound to the DbMap when it is created.
+type table struct {
+	name     string
+	holder   interface{}
+	autoIncr bool
+	keys     []string
+}
+
+// registerTable adds a table mapping with the given primary keys, autoIncr is
+// only allowed with a single key.
+func registerTable(name string, holder interface{}, autoIncr bool, keys ...string) {
+	tables = append(tables, table{name: name, holder: holder, autoIncr: autoIncr,
+		keys: keys})
+}
+
+// Model is an public struct but should be initialized only once.
+type Model struct {
+	db     *gorp.DbMap
+	inited bool
+	config conf.MySQLConfig
+}
+
+// GetModel returns initiated model.
+func GetModel() (*Model, error) {
+	modelLock.Lock()
+	defer modelLock.Unlock()
+	var err error
+	if !model.inited {
+		if err = model.initDB(); err == nil {
+			model.inited = true
+		}
+	}
+	return &model, err
+}
+
+func (m *Model) initDB() error {
+	m.config = conf.HPCMySQLConfig()
+	dbMap, err := m.newDBMap()
+	if err != nil {
+		return err
+	}
+	m.db = dbMap
+	return m.Migrate()
+}
+
+// newDBMap opens the database and binds all registered tables.
+func (m *Model) newDBMap() (*gorp.DbMap, error) {
+	db, err := sql.Open("mysql", dsn(m.config))
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to mysql %s:%d failed: %v",
+			m.config.Host, m.config.Port, err)
+	}
+	dbMap := &gorp.DbMap{
+		Db:      db,
+		Dialect: gorp.MySQLDialect{Engine: "InnoDB", Encoding: "utf8mb4"},
+	}
+	for _, t := range tables {
+		tm := dbMap.AddTableWithName(t.holder, t.name)
+		if len(t.keys) > 0 {
+			tm.SetKeys(t.autoIncr, t.keys...)
+		}
+	}
+	if m.config.Debug {
+		dbMap.TraceOn("[gorp]", log.New(os.Stdout, "", log.LstdFlags))
+	}
+	return dbMap, nil
+}
+
+func dsn(c conf.MySQLConfig) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=true&loc=Local",
+		c.User, c.Password, c.Host, c.Port, c.Db)
+}
+
+// notFound translates the driver's no rows error to types.ErrNotFound.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// errDupEntry is the MySQL error of a unique key violation.
+const errDupEntry = 1062
+
+// duplicate maps a unique key violation to types.ErrDuplicate.
+func duplicate(err error) error {
+	if e, ok := err.(*mysql.MySQLError); ok && e.Number == errDupEntry {
+		return types.ErrDuplicate
+	}
+	return err
 }
\ No newline at end of file
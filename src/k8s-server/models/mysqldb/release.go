@@ -0,0 +1,64 @@
+package mysqldb
+
+import (
+	"k8s-server/models/types"
+)
+
+func init() {
+	registerTable("releases", types.Release{}, true, "ID")
+}
+
+// AddRelease inserts a release.
+func (m *Model) AddRelease(release *types.Release) error {
+	return duplicate(m.db.Insert(release))
+}
+
+// GetRelease returns the release by namespace and name.
+func (m *Model) GetRelease(namespace, name string) (*types.Release, error) {
+	release := &types.Release{}
+	err := m.db.SelectOne(release,
+		"SELECT * FROM releases WHERE namespace = ? AND name = ?", namespace, name)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return release, nil
+}
+
+// ListReleases returns the releases in namespace, or in all namespaces if
+// namespace is empty.
+func (m *Model) ListReleases(namespace string) ([]types.Release, error) {
+	var releases []types.Release
+	var err error
+	if namespace == "" {
+		_, err = m.db.Select(&releases,
+			"SELECT * FROM releases ORDER BY namespace, name")
+	} else {
+		_, err = m.db.Select(&releases,
+			"SELECT * FROM releases WHERE namespace = ? ORDER BY name", namespace)
+	}
+	return releases, err
+}
+
+// UpdateRelease updates the release by its ID.
+func (m *Model) UpdateRelease(release *types.Release) error {
+	n, err := m.db.Update(release)
+	if err == nil && n == 0 {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// DeleteRelease deletes the release by namespace and name.
+func (m *Model) DeleteRelease(namespace, name string) error {
+	result, err := m.db.Exec(
+		"DELETE FROM releases WHERE namespace = ? AND name = ?", namespace, name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return types.ErrNotFound
+	}
+	return nil
+}
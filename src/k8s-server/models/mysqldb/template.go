@@ -0,0 +1,45 @@
+package mysqldb
+
+import (
+	"k8s-server/models/types"
+)
+
+func init() {
+	registerTable("templates", types.Template{}, true, "ID")
+}
+
+// AddTemplate inserts an application template.
+func (m *Model) AddTemplate(template *types.Template) error {
+	return duplicate(m.db.Insert(template))
+}
+
+// GetTemplate returns the template by name.
+func (m *Model) GetTemplate(name string) (*types.Template, error) {
+	template := &types.Template{}
+	err := m.db.SelectOne(template, "SELECT * FROM templates WHERE name = ?", name)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return template, nil
+}
+
+// ListTemplates returns all templates ordered by name.
+func (m *Model) ListTemplates() ([]types.Template, error) {
+	var templates []types.Template
+	_, err := m.db.Select(&templates, "SELECT * FROM templates ORDER BY name")
+	return templates, err
+}
+
+// UpdateTemplate updates the template by its ID.
+func (m *Model) UpdateTemplate(template *types.Template) error {
+	n, err := m.db.Update(template)
+	if err == nil && n == 0 {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// DeleteTemplate deletes the template by name.
+func (m *Model) DeleteTemplate(name string) error {
+	return m.deleteByName("DELETE FROM templates WHERE name = ?", name)
+}
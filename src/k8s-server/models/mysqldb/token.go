@@ -0,0 +1,56 @@
+package mysqldb
+
+import (
+	"time"
+
+	"k8s-server/models/types"
+)
+
+func init() {
+	registerTable("access_tokens", types.AccessToken{}, true, "ID")
+}
+
+// AddAccessToken inserts a new access token.
+func (m *Model) AddAccessToken(token *types.AccessToken) error {
+	return m.db.Insert(token)
+}
+
+// GetAccessTokenByHash returns the token whose secret hashes to hash.
+func (m *Model) GetAccessTokenByHash(hash string) (*types.AccessToken, error) {
+	token := &types.AccessToken{}
+	err := m.db.SelectOne(token,
+		"SELECT * FROM access_tokens WHERE hash = ?", hash)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return token, nil
+}
+
+// ListAccessTokens returns all tokens owned by username, newest first.
+func (m *Model) ListAccessTokens(username string) ([]types.AccessToken, error) {
+	var tokens []types.AccessToken
+	_, err := m.db.Select(&tokens,
+		"SELECT * FROM access_tokens WHERE username = ? ORDER BY id DESC",
+		username)
+	return tokens, err
+}
+
+// RevokeAccessToken marks the token as revoked, it returns the number of
+// affected rows so callers can tell a missing token from a revoked one.
+func (m *Model) RevokeAccessToken(id int64, username string) (int64, error) {
+	result, err := m.db.Exec(
+		"UPDATE access_tokens SET revoked = 1 WHERE id = ? AND username = ?",
+		id, username)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// TouchAccessToken records the last time and client IP the token was used.
+func (m *Model) TouchAccessToken(id int64, ip string, at time.Time) error {
+	_, err := m.db.Exec(
+		"UPDATE access_tokens SET last_used_at = ?, last_used_ip = ? WHERE id = ?",
+		at, ip, id)
+	return err
+}
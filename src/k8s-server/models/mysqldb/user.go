@@ -0,0 +1,46 @@
+package mysqldb
+
+import (
+	"k8s-server/models/types"
+)
+
+func init() {
+	registerTable("users", types.User{}, true, "ID")
+}
+
+// AddUser inserts a user.
+func (m *Model) AddUser(user *types.User) error {
+	return duplicate(m.db.Insert(user))
+}
+
+// GetUser returns the user by username.
+func (m *Model) GetUser(username string) (*types.User, error) {
+	user := &types.User{}
+	err := m.db.SelectOne(user, "SELECT * FROM users WHERE username = ?", username)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return user, nil
+}
+
+// ListUsers returns all users ordered by username.
+func (m *Model) ListUsers() ([]types.User, error) {
+	var users []types.User
+	_, err := m.db.Select(&users, "SELECT * FROM users ORDER BY username")
+	return users, err
+}
+
+// UpdateUser updates the user by its ID, types.ErrDuplicate is returned if
+// another user has the UID.
+func (m *Model) UpdateUser(user *types.User) error {
+	n, err := m.db.Update(user)
+	if err == nil && n == 0 {
+		return types.ErrNotFound
+	}
+	return duplicate(err)
+}
+
+// DeleteUser deletes the user by username.
+func (m *Model) DeleteUser(username string) error {
+	return m.deleteByName("DELETE FROM users WHERE username = ?", username)
+}
@@ -0,0 +1,26 @@
+package types
+
+import (
+	"time"
+)
+
+// AuditLog records an operation performed through the API.
+type AuditLog struct {
+	ID        int64     `db:"id" json:"id"`
+	Username  string    `db:"username" json:"username"`
+	Action    string    `db:"action" json:"action"`
+	Resource  string    `db:"resource" json:"resource"`
+	Namespace string    `db:"namespace" json:"namespace"`
+	Detail    string    `db:"detail" json:"detail"`
+	IP        string    `db:"ip" json:"ip"`
+	Success   bool      `db:"success" json:"success"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+// AuditFilter selects audit logs, zero values match everything.
+type AuditFilter struct {
+	Username string
+	Resource string
+	Since    time.Time
+	Limit    int
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"time"
+)
+
+// Batch job states, named by their squeue codes.
+const (
+	BatchPending    = "PD"
+	BatchRunning    = "R"
+	BatchCompleting = "CG"
+	BatchCompleted  = "CD"
+	BatchFailed     = "F"
+	BatchTimeout    = "TO"
+	BatchCancelled  = "CA"
+)
+
+// BatchJob is a Slurm-style batch script run as a Kubernetes Job, ID is the
+// job ID shown to the user. TimeLimit is in seconds, 0 is unlimited.
+type BatchJob struct {
+	ID          int64      `db:"id" json:"id"`
+	Name        string     `db:"name" json:"name"`
+	Namespace   string     `db:"namespace" json:"namespace"`
+	Partition   string     `db:"partition" json:"partition"`
+	Nodes       int        `db:"nodes" json:"nodes"`
+	TimeLimit   int64      `db:"time_limit" json:"timeLimit"`
+	Script      string     `db:"script" json:"script"`
+	State       string     `db:"state" json:"state"`
+	Reason      string     `db:"reason" json:"reason"`
+	NodeList    string     `db:"node_list" json:"nodeList"`
+	SubmittedBy string     `db:"submitted_by" json:"submittedBy"`
+	SubmittedAt time.Time  `db:"submitted_at" json:"submittedAt"`
+	StartedAt   *time.Time `db:"started_at" json:"startedAt"`
+	EndedAt     *time.Time `db:"ended_at" json:"endedAt"`
+}
+
+// Ended reports whether the job reached a final state.
+func (j *BatchJob) Ended() bool {
+	switch j.State {
+	case BatchCompleted, BatchFailed, BatchTimeout, BatchCancelled:
+		return true
+	}
+	return false
+}
+
+// BatchJobFilter selects batch jobs, zero fields match all jobs.
+type BatchJobFilter struct {
+	User string
+	// Active selects the jobs that did not end.
+	Active bool
+}
@@ -0,0 +1,16 @@
+package types
+
+import (
+	"time"
+)
+
+// Cluster represents a Kubernetes cluster managed by the server.
+type Cluster struct {
+	ID          int64     `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	APIServer   string    `db:"api_server" json:"apiServer"`
+	KubeConfig  string    `db:"kube_config" json:"-"`
+	Description string    `db:"description" json:"description"`
+	CreatedAt   time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updatedAt"`
+}
@@ -0,0 +1,31 @@
+package types
+
+import (
+	"time"
+)
+
+// Event types.
+const (
+	EventNormal  = "Normal"
+	EventWarning = "Warning"
+)
+
+// Event records a state change of an object observed by the server, e.g. an
+// agent going offline.
+type Event struct {
+	ID        int64     `db:"id" json:"id"`
+	Type      string    `db:"type" json:"type"`
+	Kind      string    `db:"kind" json:"kind"`
+	Object    string    `db:"object" json:"object"`
+	Reason    string    `db:"reason" json:"reason"`
+	Message   string    `db:"message" json:"message"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+// EventFilter selects events, zero values match everything.
+type EventFilter struct {
+	Kind   string
+	Object string
+	Since  time.Time
+	Limit  int
+}
@@ -0,0 +1,50 @@
+package types
+
+import (
+	"time"
+)
+
+// Image build states.
+const (
+	BuildPending   = "Pending"
+	BuildRunning   = "Running"
+	BuildSucceeded = "Succeeded"
+	BuildFailed    = "Failed"
+	BuildCancelled = "Cancelled"
+)
+
+// ImageBuild is a custom image built from an uploaded Dockerfile and context
+// and pushed to Harbor. A succeeded build is the user's image until it is
+// deleted, its size counts against the user's space quota. The sizes are in
+// bytes.
+type ImageBuild struct {
+	ID          int64      `db:"id" json:"id"`
+	Owner       string     `db:"owner" json:"owner"`
+	Name        string     `db:"name" json:"name"`
+	Tag         string     `db:"tag" json:"tag"`
+	Repository  string     `db:"repository" json:"repository"`
+	Image       string     `db:"image" json:"image"`
+	State       string     `db:"state" json:"state"`
+	Message     string     `db:"message" json:"message"`
+	ContextSize int64      `db:"context_size" json:"contextSize"`
+	ImageSize   int64      `db:"image_size" json:"imageSize"`
+	CreatedAt   time.Time  `db:"created_at" json:"createdAt"`
+	StartedAt   *time.Time `db:"started_at" json:"startedAt"`
+	FinishedAt  *time.Time `db:"finished_at" json:"finishedAt"`
+}
+
+// Finished reports whether the build reached a final state.
+func (b *ImageBuild) Finished() bool {
+	switch b.State {
+	case BuildSucceeded, BuildFailed, BuildCancelled:
+		return true
+	}
+	return false
+}
+
+// ImageBuildFilter selects image builds, zero fields match all builds.
+type ImageBuildFilter struct {
+	Owner string
+	// Active selects the builds that did not finish.
+	Active bool
+}
@@ -0,0 +1,54 @@
+package types
+
+import (
+	"time"
+)
+
+// Release statuses.
+const (
+	ReleasePending  = "pending"
+	ReleaseDeployed = "deployed"
+	ReleaseFailed   = "failed"
+)
+
+// Template is an application template that can be instantiated into a
+// namespace. Parameters holds the JSON encoded parameter schema, Revision is
+// increased on every update.
+type Template struct {
+	ID          int64     `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	Content     string    `db:"content" json:"content"`
+	Parameters  string    `db:"parameters" json:"parameters"`
+	Revision    int       `db:"revision" json:"revision"`
+	CreatedBy   string    `db:"created_by" json:"createdBy"`
+	CreatedAt   time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// Release is an instance of a template in a namespace. Values holds the JSON
+// encoded parameter values without secrets, Objects the JSON encoded
+// ObjectRefs created by the release.
+type Release struct {
+	ID               int64     `db:"id" json:"id"`
+	Name             string    `db:"name" json:"name"`
+	Namespace        string    `db:"namespace" json:"namespace"`
+	Template         string    `db:"template" json:"template"`
+	TemplateRevision int       `db:"template_revision" json:"templateRevision"`
+	Revision         int       `db:"revision" json:"revision"`
+	Values           string    `db:"values" json:"values"`
+	Objects          string    `db:"objects" json:"objects"`
+	Status           string    `db:"status" json:"status"`
+	Message          string    `db:"message" json:"message"`
+	CreatedBy        string    `db:"created_by" json:"createdBy"`
+	CreatedAt        time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt        time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// ObjectRef identifies a Kubernetes object created by a release.
+type ObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+}
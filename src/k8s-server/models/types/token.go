@@ -0,0 +1,21 @@
+package types
+
+import (
+	"time"
+)
+
+// AccessToken represents a personal access token, only the SHA-256 hash of
+// the secret is stored, the plain secret is shown to the owner once.
+type AccessToken struct {
+	ID         int64      `db:"id" json:"id"`
+	Username   string     `db:"username" json:"username"`
+	Name       string     `db:"name" json:"name"`
+	Prefix     string     `db:"prefix" json:"prefix"`
+	Hash       string     `db:"hash" json:"-"`
+	Scope      string     `db:"scope" json:"scope"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expiresAt"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"lastUsedAt"`
+	LastUsedIP string     `db:"last_used_ip" json:"lastUsedIP"`
+	Revoked    bool       `db:"revoked" json:"revoked"`
+	CreatedAt  time.Time  `db:"created_at" json:"createdAt"`
+}
@@ -0,0 +1,16 @@
+// Package types defines the records persisted by the storage backends, they
+// are shared by the MySQL and the embedded file implementation of
+// models.Model.
+package types
+
+import (
+	"errors"
+)
+
+// ErrNotFound is returned by the storage backends when a record does not
+// exist.
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned by the storage backends when a record violates a
+// unique key.
+var ErrDuplicate = errors.New("duplicate record")
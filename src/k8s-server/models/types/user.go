@@ -0,0 +1,21 @@
+package types
+
+import (
+	"time"
+)
+
+// User is a user known to the server. UID is nil and HomeDir is empty until
+// the user is provisioned, the UIDs are unique.
+type User struct {
+	ID        int64     `db:"id" json:"id"`
+	Username  string    `db:"username" json:"username"`
+	Email     string    `db:"email" json:"email"`
+	UID       *int      `db:"uid" json:"uid"`
+	HomeDir   string    `db:"home_dir" json:"homeDir"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+// Provisioned reports whether the user has a UID and a home directory.
+func (u *User) Provisioned() bool {
+	return u.UID != nil && *u.UID > 0 && u.HomeDir != ""
+}
@@ -0,0 +1,254 @@
+// Package account provisions the POSIX identity of the users: a unique UID
+// in [UserMinUID, UserMaxUID] and a home directory on the shared storage.
+// The pods the users launch run with this identity and mount the home, so
+// HPC users are the same user inside containers as on the login nodes.
+package account
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models"
+	"k8s-server/models/types"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// HomeVolume is the name of the home volume added to the pods.
+const HomeVolume = "user-home"
+
+var reUsername = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]{0,31}$`)
+
+// Identity is the identity the pods of a user run with.
+type Identity struct {
+	Username string `json:"username"`
+	UID      int64  `json:"uid"`
+	GID      int64  `json:"gid"`
+	// Home is the home directory on the nodes, it is mounted at the same
+	// path in the containers.
+	Home  string `json:"home"`
+	Shell string `json:"shell"`
+}
+
+// Env returns the login environment of the identity.
+func (id *Identity) Env() [][2]string {
+	return [][2]string{{"HOME", id.Home}, {"USER", id.Username}, {"SHELL", id.Shell}}
+}
+
+// Manager represents the user account manager.
+type Manager struct {
+	store models.UserStore
+	// sharedPath is the shared filesystem on the nodes, localPath is where
+	// this server mounts it
+	sharedPath string
+	localPath  string
+	mkhome     func(dir string, uid, gid int) error
+	now        func() time.Time
+	// lock serializes the UID allocation
+	lock sync.Mutex
+}
+
+// NewManager returns the account manager.
+func NewManager(store models.UserStore) (*Manager, error) {
+	if conf.UserMinUID() <= 0 || conf.UserMinUID() > conf.UserMaxUID() {
+		return nil, errors.Errorf(def.ErrAccountModule, "invalid uid range [%d, %d]",
+			conf.UserMinUID(), conf.UserMaxUID())
+	}
+	return &Manager{
+		store:      store,
+		sharedPath: conf.SharedStoragePath(),
+		localPath:  conf.ClientMountPath(),
+		mkhome:     mkhome,
+		now:        time.Now,
+	}, nil
+}
+
+// List returns the users.
+func (m *Manager) List() ([]types.User, error) {
+	users, err := m.store.ListUsers()
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrAccountList, "list users failed")
+	}
+	return users, nil
+}
+
+// Get returns a user.
+func (m *Manager) Get(username string) (*types.User, error) {
+	user, err := m.store.GetUser(username)
+	if err == types.ErrNotFound {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "user %s not found", username)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrAccountList, "get user failed")
+	}
+	return user, nil
+}
+
+// maxAllocations bounds the UID allocations of a user, an allocation is lost
+// when another server assigns the same UID first.
+const maxAllocations = 5
+
+// Provision allocates the UID of a user and creates the home directory, the
+// user is added if it is unknown. Provisioning a provisioned user only makes
+// sure the home directory exists. Users provision themselves, the
+// uid::Admins provision anyone.
+func (m *Manager) Provision(caller, username string) (*types.User, error) {
+	if caller != username && !IsAdmin(caller) {
+		return nil, errors.Errorf(def.ErrGeneralForbidden, "%s may not provision user %s", caller, username)
+	}
+	return m.provision(username)
+}
+
+func (m *Manager) provision(username string) (*types.User, error) {
+	if !reUsername.MatchString(username) {
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "invalid username %q", username)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	user, err := m.store.GetUser(username)
+	if err == types.ErrNotFound {
+		user = &types.User{Username: username, CreatedAt: m.now()}
+		if err = m.store.AddUser(user); err != nil {
+			return nil, errors.Wrap(err, def.ErrAccountProvision, "add user failed")
+		}
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrAccountProvision, "get user failed")
+	}
+
+	homeDir := user.HomeDir
+	if !user.Provisioned() {
+		homeDir = path.Join(conf.DefaultHomeDir(), username)
+	}
+	local, err := m.local(homeDir)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrAccountProvision, "invalid home directory")
+	}
+	if !user.Provisioned() {
+		user.HomeDir = homeDir
+		if err = m.assign(user); err != nil {
+			return nil, err
+		}
+		logs.Info("user %s provisioned with uid %d", username, *user.UID)
+	}
+	uid := *user.UID
+	if err = m.mkhome(local, uid, gid(uid)); err != nil {
+		return nil, errors.Wrap(err, def.ErrAccountProvision, "create the home directory failed")
+	}
+	return user, nil
+}
+
+// Identity returns the identity of a user, the user is provisioned first if
+// needed.
+func (m *Manager) Identity(username string) (*Identity, error) {
+	user, err := m.store.GetUser(username)
+	if err != nil || !user.Provisioned() {
+		if user, err = m.provision(username); err != nil {
+			return nil, err
+		}
+	}
+	return &Identity{
+		Username: user.Username,
+		UID:      int64(*user.UID),
+		GID:      int64(gid(*user.UID)),
+		Home:     user.HomeDir,
+		Shell:    conf.DefaultShell(),
+	}, nil
+}
+
+// assign stores the user, a user without a UID gets the lowest free one. The
+// unique UIDs of the store reject a UID another server assigned meanwhile,
+// the allocation is retried then.
+func (m *Manager) assign(user *types.User) error {
+	for i := 0; i < maxAllocations; i++ {
+		if user.UID == nil {
+			uid, err := m.allocate()
+			if err != nil {
+				return err
+			}
+			user.UID = &uid
+		}
+		err := m.store.UpdateUser(user)
+		if err != types.ErrDuplicate {
+			if err != nil {
+				return errors.Wrap(err, def.ErrAccountProvision, "update user failed")
+			}
+			return nil
+		}
+		logs.Warn("uid %d of user %s was taken meanwhile, allocate another", *user.UID, user.Username)
+		user.UID = nil
+	}
+	return errors.Errorf(def.ErrAccountProvision, "allocate a uid of user %s failed %d times",
+		user.Username, maxAllocations)
+}
+
+// allocate returns the lowest UID of the range that is not used.
+func (m *Manager) allocate() (int, error) {
+	users, err := m.store.ListUsers()
+	if err != nil {
+		return 0, errors.Wrap(err, def.ErrAccountProvision, "list users failed")
+	}
+	used := make(map[int]bool, len(users))
+	for _, u := range users {
+		if u.UID != nil {
+			used[*u.UID] = true
+		}
+	}
+	for uid := conf.UserMinUID(); uid <= conf.UserMaxUID(); uid++ {
+		if !used[uid] {
+			return uid, nil
+		}
+	}
+	return 0, errors.Errorf(def.ErrAccountProvision, "no free uid in [%d, %d]",
+		conf.UserMinUID(), conf.UserMaxUID())
+}
+
+// local returns the path of a node path below the shared path on this server.
+// Other paths are refused, the home would be created on the server itself.
+func (m *Manager) local(p string) (string, error) {
+	if rel, err := filepath.Rel(m.sharedPath, p); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+		return filepath.Join(m.localPath, rel), nil
+	}
+	return "", fmt.Errorf("%s is not below the shared path %s", p, m.sharedPath)
+}
+
+// IsAdmin reports whether the user is one of the uid::Admins.
+func IsAdmin(username string) bool {
+	for _, admin := range conf.AccountAdmins() {
+		if admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+// gid returns the primary group of the users, the HPC group if it is set and
+// the user private group otherwise.
+func gid(uid int) int {
+	if g := conf.HPCGroupID(); g > 0 {
+		return g
+	}
+	return uid
+}
+
+// mkhome creates the home directory owned by the user, an existing directory
+// is left as it is.
+func mkhome(dir string, uid, gid int) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := os.Mkdir(dir, conf.HomeDirPerm()); err != nil {
+		return err
+	}
+	// the permission of Mkdir is masked by the umask
+	if err := os.Chmod(dir, conf.HomeDirPerm()); err != nil {
+		return err
+	}
+	return os.Chown(dir, uid, gid)
+}
@@ -0,0 +1,218 @@
+package account
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego"
+
+	"k8s-server/def"
+	"k8s-server/models/filedb"
+	"k8s-server/models/types"
+	"k8s-server/utils/errors"
+)
+
+func hasCode(err error, code int) bool {
+	c := errors.ErrorCode(err)
+	return len(c) >= 4 && c[:4] == strconv.Itoa(code)
+}
+
+// home is a home directory created by the test manager.
+type home struct {
+	dir      string
+	uid, gid int
+}
+
+func newTestManager(t *testing.T) (*Manager, *[]home, func()) {
+	dir, err := ioutil.TempDir("", "account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := filedb.NewModel(filepath.Join(dir, "test.db"), 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	m, err := NewManager(store)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	var homes []home
+	m.sharedPath, m.localPath = "/home", "/mnt/home"
+	m.mkhome = func(dir string, uid, gid int) error {
+		homes = append(homes, home{dir, uid, gid})
+		return nil
+	}
+	return m, &homes, func() { os.RemoveAll(dir) }
+}
+
+func TestProvision(t *testing.T) {
+	m, homes, cleanup := newTestManager(t)
+	defer cleanup()
+	// a user known before the provisioning keeps its record
+	if err := m.store.AddUser(&types.User{Username: "bob", Email: "bob@example.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"alice", "bob", "alice"} {
+		if _, err := m.Provision(username, username); err != nil {
+			t.Fatalf("provision %s: %v", username, err)
+		}
+	}
+	alice, err := m.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := m.Get("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *alice.UID != 10000 || alice.HomeDir != "/home/alice" || *bob.UID != 10001 || bob.Email != "bob@example.com" {
+		t.Errorf("alice is %+v, bob is %+v", alice, bob)
+	}
+	want := []home{{"/mnt/home/alice", 10000, 10000}, {"/mnt/home/bob", 10001, 10001}, {"/mnt/home/alice", 10000, 10000}}
+	if !reflect.DeepEqual(*homes, want) {
+		t.Errorf("homes %v created, want %v", *homes, want)
+	}
+
+	// the uid of a deleted user is reused
+	if err = m.store.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.Identity("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.UID != 10000 || id.GID != 10000 || id.Home != "/home/carol" || id.Shell != "sh" {
+		t.Errorf("carol is %+v", id)
+	}
+
+	if _, err = m.Provision("../root", "../root"); !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("provision an invalid username: %v", err)
+	}
+	if _, err = m.Get("dave"); !hasCode(err, def.ErrGeneralNotFound) {
+		t.Errorf("get an unknown user: %v", err)
+	}
+	m.mkhome = func(string, int, int) error { return fmt.Errorf("permission denied") }
+	if _, err = m.Provision("dave", "dave"); !hasCode(err, def.ErrAccountProvision) {
+		t.Errorf("provision without a home: %v", err)
+	}
+}
+
+func TestUIDRange(t *testing.T) {
+	beego.AppConfig.Set("uid::UserMaxUID", "10001")
+	beego.AppConfig.Set("backend::HPCGroupID", "500")
+	defer func() {
+		beego.AppConfig.Set("uid::UserMaxUID", "")
+		beego.AppConfig.Set("backend::HPCGroupID", "")
+	}()
+	m, homes, cleanup := newTestManager(t)
+	defer cleanup()
+
+	for _, username := range []string{"alice", "bob"} {
+		if _, err := m.Provision(username, username); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Provision("carol", "carol"); !hasCode(err, def.ErrAccountProvision) {
+		t.Errorf("provision beyond the uid range: %v", err)
+	}
+	if h := (*homes)[1]; h.uid != 10001 || h.gid != 500 {
+		t.Errorf("bob's home is %+v", h)
+	}
+}
+
+func TestProvisionOthers(t *testing.T) {
+	beego.AppConfig.Set("uid::Admins", "root")
+	defer beego.AppConfig.Set("uid::Admins", "")
+	m, _, cleanup := newTestManager(t)
+	defer cleanup()
+
+	if _, err := m.Provision("mallory", "alice"); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("provision another user: %v", err)
+	}
+	if _, err := m.Get("alice"); !hasCode(err, def.ErrGeneralNotFound) {
+		t.Errorf("forbidden provisioning added alice: %v", err)
+	}
+	if _, err := m.Provision("root", "alice"); err != nil {
+		t.Errorf("admin provisioning alice: %v", err)
+	}
+}
+
+// racingStore assigns the UIDs handed out to the users to another user just
+// before they are stored, as another server allocating at the same time.
+type racingStore struct {
+	*filedb.Model
+	races, others int
+}
+
+func (s *racingStore) UpdateUser(user *types.User) error {
+	if s.races > 0 && user.UID != nil {
+		s.races--
+		s.others++
+		uid := *user.UID
+		other := &types.User{Username: fmt.Sprintf("other%d", s.others), CreatedAt: time.Now()}
+		if err := s.Model.AddUser(other); err != nil {
+			return err
+		}
+		other.UID, other.HomeDir = &uid, "/home/"+other.Username
+		if err := s.Model.UpdateUser(other); err != nil {
+			return err
+		}
+	}
+	return s.Model.UpdateUser(user)
+}
+
+func TestAllocationRace(t *testing.T) {
+	m, _, cleanup := newTestManager(t)
+	defer cleanup()
+	store := &racingStore{Model: m.store.(*filedb.Model), races: 2}
+	m.store = store
+
+	user, err := m.Provision("alice", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *user.UID != 10002 {
+		t.Errorf("alice got uid %d after losing two allocations, want 10002", *user.UID)
+	}
+
+	store.races = maxAllocations
+	if _, err = m.Provision("bob", "bob"); !hasCode(err, def.ErrAccountProvision) || store.races != 0 {
+		t.Errorf("provision losing every allocation: %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	m := &Manager{sharedPath: "/share", localPath: "/mnt/share"}
+	if got, err := m.local("/share/alice"); err != nil || got != "/mnt/share/alice" {
+		t.Errorf("local(/share/alice) = %s, %v", got, err)
+	}
+	for _, p := range []string{"/home/alice", "/shared/alice", "/share/../etc/"} {
+		if got, err := m.local(p); err == nil {
+			t.Errorf("local(%s) = %s, want an error", p, got)
+		}
+	}
+}
+
+func TestProvisionOutsideShared(t *testing.T) {
+	m, homes, cleanup := newTestManager(t)
+	defer cleanup()
+	m.sharedPath = "/share"
+	if _, err := m.Provision("alice", "alice"); !hasCode(err, def.ErrAccountProvision) {
+		t.Errorf("provision outside the shared path: %v", err)
+	}
+	if user, err := m.Get("alice"); err == nil && user.Provisioned() {
+		t.Errorf("uid assigned to %+v", user)
+	}
+	if len(*homes) != 0 {
+		t.Errorf("homes %+v created", *homes)
+	}
+}
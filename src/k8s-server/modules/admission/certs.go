@@ -0,0 +1,88 @@
+package admission
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"k8s-server/utils/logs"
+)
+
+// certCheckInterval limits how often the certificate files are checked for
+// changes during handshakes.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the certificate of a cert/key file pair and reloads it
+// when either file changes, so rotated certificates are picked up without a
+// restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock      sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. A failed reload keeps
+// serving the previous certificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if now := time.Now(); now.Sub(r.checkedAt) >= certCheckInterval {
+		r.checkedAt = now
+		if modTime, err := r.latestModTime(); err != nil {
+			logs.Warn("check webhook certificate failed: %v", err)
+		} else if modTime.After(r.modTime) {
+			if err = r.loadLocked(); err != nil {
+				logs.Error("reload webhook certificate failed: %v", err)
+			} else {
+				logs.Info("webhook certificate reloaded from %s", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) load() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.loadLocked()
+}
+
+func (r *certReloader) loadLocked() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime, r.checkedAt = &cert, modTime, time.Now()
+	return nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,233 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const dockerHub = "docker.io"
+
+// Policy is the image and resource policy enforced on pods.
+type Policy struct {
+	// Registry is the internal registry short image names are rewritten to.
+	Registry string
+	// PublicProject is the registry project of single component image names,
+	// e.g. "nginx" becomes "<Registry>/<PublicProject>/nginx".
+	PublicProject string
+	// Allowed are the registry hosts images may be pulled from, Registry is
+	// always allowed.
+	Allowed []string
+	// Exempt namespaces are admitted unchanged.
+	Exempt          []string
+	DefaultRequests map[string]string
+	DefaultLimits   map[string]string
+}
+
+// pod is the subset of a Pod the policy looks at.
+type pod struct {
+	Spec struct {
+		InitContainers []container `json:"initContainers"`
+		Containers     []container `json:"containers"`
+	} `json:"spec"`
+}
+
+type container struct {
+	Name      string     `json:"name"`
+	Image     string     `json:"image"`
+	Resources *resources `json:"resources,omitempty"`
+}
+
+type resources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+// patchOp is a JSONPatch operation.
+type patchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Validate rejects pods with images from registries that are not allowed.
+func (p *Policy) Validate(req *request) (bool, string, []patchOp) {
+	spec, skip, err := p.decode(req)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	if skip {
+		return true, "", nil
+	}
+	var denied []string
+	for _, c := range allContainers(spec) {
+		if host := registryHost(c.Image); !p.allowed(host) {
+			denied = append(denied, fmt.Sprintf("%s (%s)", c.Image, host))
+		}
+	}
+	if len(denied) > 0 {
+		return false, "images from registries that are not allowed: " + strings.Join(denied, ", "), nil
+	}
+	return true, "", nil
+}
+
+// Mutate rewrites short image names to the internal registry and sets the
+// default requests and limits of containers. Only created pods are mutated,
+// the containers of a pod can not be changed by later updates.
+func (p *Policy) Mutate(req *request) (bool, string, []patchOp) {
+	if req.Operation != "CREATE" || req.SubResource != "" {
+		return true, "", nil
+	}
+	spec, skip, err := p.decode(req)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	if skip {
+		return true, "", nil
+	}
+	var patch []patchOp
+	fields := []struct {
+		name string
+		list []container
+	}{
+		{"initContainers", spec.Spec.InitContainers},
+		{"containers", spec.Spec.Containers},
+	}
+	for _, field := range fields {
+		for i, c := range field.list {
+			path := fmt.Sprintf("/spec/%s/%d", field.name, i)
+			if image := p.rewrite(c.Image); image != c.Image {
+				patch = append(patch, patchOp{Op: "replace", Path: path + "/image", Value: image})
+			}
+			if res, changed := p.defaults(c.Resources); changed {
+				// add replaces an existing member, so it works whether
+				// resources is set or not
+				patch = append(patch, patchOp{Op: "add", Path: path + "/resources", Value: res})
+			}
+		}
+	}
+	return true, "", patch
+}
+
+// decode returns the pod of req, skip is set for requests the policy does not
+// apply to.
+func (p *Policy) decode(req *request) (*pod, bool, error) {
+	if req.Kind.Group != "" || req.Kind.Kind != "Pod" || req.Operation == "DELETE" {
+		return nil, true, nil
+	}
+	for _, ns := range p.Exempt {
+		if ns == req.Namespace {
+			return nil, true, nil
+		}
+	}
+	spec := &pod{}
+	if err := json.Unmarshal(req.Object, spec); err != nil {
+		return nil, false, fmt.Errorf("decode pod failed: %v", err)
+	}
+	return spec, false, nil
+}
+
+func (p *Policy) allowed(host string) bool {
+	host = normalizeHost(host)
+	if host == normalizeHost(p.Registry) {
+		return true
+	}
+	for _, a := range p.Allowed {
+		if host == normalizeHost(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite prefixes image names without a registry host with the internal
+// registry.
+func (p *Policy) rewrite(image string) string {
+	if image == "" || hasRegistryHost(image) {
+		return image
+	}
+	if !strings.Contains(nameOf(image), "/") {
+		return p.Registry + "/" + p.PublicProject + "/" + image
+	}
+	return p.Registry + "/" + image
+}
+
+// defaults returns res with the default requests and limits filled in. A
+// request is not defaulted when a limit is set, Kubernetes then uses the
+// limit, and a default limit below the request is raised to the request.
+func (p *Policy) defaults(res *resources) (*resources, bool) {
+	out := &resources{Requests: map[string]string{}, Limits: map[string]string{}}
+	if res != nil {
+		for k, v := range res.Requests {
+			out.Requests[k] = v
+		}
+		for k, v := range res.Limits {
+			out.Limits[k] = v
+		}
+	}
+	changed := false
+	for name, value := range p.DefaultRequests {
+		_, hasRequest := out.Requests[name]
+		_, hasLimit := out.Limits[name]
+		if !hasRequest && !hasLimit {
+			out.Requests[name] = value
+			changed = true
+		}
+	}
+	for name, value := range p.DefaultLimits {
+		if _, ok := out.Limits[name]; ok {
+			continue
+		}
+		if request, ok := out.Requests[name]; ok && quantityLess(value, request) {
+			value = request
+		}
+		out.Limits[name] = value
+		changed = true
+	}
+	return out, changed
+}
+
+func allContainers(p *pod) []container {
+	return append(append([]container{}, p.Spec.InitContainers...), p.Spec.Containers...)
+}
+
+// registryHost returns the registry of an image reference, references
+// without a host are pulled from Docker Hub.
+func registryHost(image string) string {
+	if !hasRegistryHost(image) {
+		return dockerHub
+	}
+	return image[:strings.Index(image, "/")]
+}
+
+// normalizeHost returns the canonical form of a registry host, hosts are case
+// insensitive and the default HTTPS port is dropped.
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ":443")
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return dockerHub
+	}
+	return host
+}
+
+// hasRegistryHost follows the Docker reference rules, the first component is
+// a host if it contains a dot or a port or is localhost.
+func hasRegistryHost(image string) bool {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return false
+	}
+	first := image[:i]
+	return strings.ContainsAny(first, ".:") || first == "localhost"
+}
+
+// nameOf strips the tag and digest of an image reference.
+func nameOf(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
@@ -0,0 +1,44 @@
+package admission
+
+import (
+	"strconv"
+	"strings"
+)
+
+var quantitySuffixes = []struct {
+	suffix string
+	factor float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	{"n", 1e-9}, {"u", 1e-6}, {"m", 1e-3},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+}
+
+// parseQuantity parses a resource quantity like "500m" or "2Gi", it is only
+// precise enough to compare quantities.
+func parseQuantity(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	factor := 1.0
+	for _, q := range quantitySuffixes {
+		if strings.HasSuffix(s, q.suffix) {
+			s, factor = strings.TrimSuffix(s, q.suffix), q.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n * factor, true
+}
+
+// quantityLess reports whether a is less than b, unparsable quantities are
+// never less.
+func quantityLess(a, b string) bool {
+	x, ok := parseQuantity(a)
+	if !ok {
+		return false
+	}
+	y, ok := parseQuantity(b)
+	return ok && x < y
+}
@@ -0,0 +1,101 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Supported AdmissionReview versions, both share the same JSON layout.
+const (
+	apiVersionV1      = "admission.k8s.io/v1"
+	apiVersionV1beta1 = "admission.k8s.io/v1beta1"
+
+	patchTypeJSON = "JSONPatch"
+	maxReviewSize = 4 << 20
+)
+
+// review is the subset of AdmissionReview read and written by the webhook.
+type review struct {
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Request    *request  `json:"request,omitempty"`
+	Response   *response `json:"response,omitempty"`
+}
+
+type request struct {
+	UID         string           `json:"uid"`
+	Kind        groupVersionKind `json:"kind"`
+	SubResource string           `json:"subResource"`
+	Namespace   string           `json:"namespace"`
+	Name        string           `json:"name"`
+	Operation   string           `json:"operation"`
+	Object      json.RawMessage  `json:"object"`
+}
+
+type groupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+type response struct {
+	UID       string  `json:"uid"`
+	Allowed   bool    `json:"allowed"`
+	Result    *status `json:"status,omitempty"`
+	Patch     []byte  `json:"patch,omitempty"`
+	PatchType *string `json:"patchType,omitempty"`
+}
+
+type status struct {
+	Code    int32  `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// admitFunc decides on a request, a non-empty patch is sent as JSONPatch.
+type admitFunc func(req *request) (allowed bool, message string, patch []patchOp)
+
+// serveReview decodes the AdmissionReview of r, runs admit and writes the
+// response in the API version of the request.
+func serveReview(w http.ResponseWriter, r *http.Request, admit admitFunc) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxReviewSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var in review
+	if err = json.Unmarshal(body, &in); err != nil {
+		http.Error(w, fmt.Sprintf("decode admission review failed: %v", err), http.StatusBadRequest)
+		return
+	}
+	if in.APIVersion != apiVersionV1 && in.APIVersion != apiVersionV1beta1 {
+		http.Error(w, fmt.Sprintf("unsupported admission review version %q", in.APIVersion),
+			http.StatusBadRequest)
+		return
+	}
+	if in.Request == nil {
+		http.Error(w, "admission review has no request", http.StatusBadRequest)
+		return
+	}
+
+	allowed, message, patch := admit(in.Request)
+	resp := &response{UID: in.Request.UID, Allowed: allowed}
+	if !allowed {
+		resp.Result = &status{Code: http.StatusForbidden, Message: message}
+	} else if len(patch) > 0 {
+		if resp.Patch, err = json.Marshal(patch); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		patchType := patchTypeJSON
+		resp.PatchType = &patchType
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review{APIVersion: in.APIVersion, Kind: "AdmissionReview", Response: resp})
+}
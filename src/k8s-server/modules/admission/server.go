@@ -0,0 +1,86 @@
+// Package admission implements the admission webhook server enforcing the
+// image policy: images must come from the internal registry or Harbor, short
+// image names are rewritten to the internal registry and containers get
+// default resource requests and limits.
+package admission
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"k8s-server/conf"
+)
+
+// Server is the HTTPS admission webhook server, it serves the validating
+// webhook on /validate and the mutating webhook on /mutate.
+type Server struct {
+	policy *Policy
+	certs  *certReloader
+	addr   string
+}
+
+// NewPolicy returns the policy built from the configuration.
+func NewPolicy() *Policy {
+	p := &Policy{
+		Registry:        conf.DockerRegistry(),
+		PublicProject:   conf.HarborPublicRepo(),
+		Allowed:         conf.WebhookAllowedRegistries(),
+		Exempt:          conf.WebhookExemptNamespaces(),
+		DefaultRequests: conf.WebhookDefaultRequests(),
+		DefaultLimits:   conf.WebhookDefaultLimits(),
+	}
+	if u, err := url.Parse(conf.HarborServer()); err == nil && u.Host != "" {
+		p.Allowed = append(p.Allowed, u.Host)
+	}
+	return p
+}
+
+// NewServer returns the webhook server with the configured certificate.
+func NewServer() (*Server, error) {
+	certs, err := newCertReloader(conf.WebhookCertFile(), conf.WebhookKeyFile())
+	if err != nil {
+		return nil, fmt.Errorf("load webhook certificate failed: %v", err)
+	}
+	return &Server{
+		policy: NewPolicy(),
+		certs:  certs,
+		addr:   fmt.Sprintf(":%d", conf.WebhookPort()),
+	}, nil
+}
+
+// Handler returns the HTTP handler of the webhooks.
+func (s *Server) Handler() http.Handler {
+	return handler(s.policy)
+}
+
+func handler(policy *Policy) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+		serveReview(w, r, policy.Validate)
+	})
+	mux.HandleFunc("/mutate", func(w http.ResponseWriter, r *http.Request) {
+		serveReview(w, r, policy.Mutate)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
+
+// Run serves the webhooks until the listener fails.
+func (s *Server) Run() error {
+	server := &http.Server{
+		Addr:         s.addr,
+		Handler:      s.Handler(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: s.certs.GetCertificate,
+		},
+	}
+	return server.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,241 @@
+package admission
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testPolicy = &Policy{
+	Registry:        "harbor.hpc.com",
+	PublicProject:   "public",
+	Allowed:         []string{"harbor.example.com:8443"},
+	Exempt:          []string{"kube-system"},
+	DefaultRequests: map[string]string{"cpu": "100m", "memory": "128Mi"},
+	DefaultLimits:   map[string]string{"cpu": "1", "memory": "1Gi"},
+}
+
+func reviewPayload(apiVersion, namespace, podSpec string) []byte {
+	return reviewOperation(apiVersion, namespace, "CREATE", "", podSpec)
+}
+
+func reviewOperation(apiVersion, namespace, operation, subResource, podSpec string) []byte {
+	return []byte(`{
+		"apiVersion": "` + apiVersion + `",
+		"kind": "AdmissionReview",
+		"request": {
+			"uid": "705ab4f5-6393-11e8-b7cc-42010a800002",
+			"kind": {"group": "", "version": "v1", "kind": "Pod"},
+			"resource": {"group": "", "version": "v1", "resource": "pods"},
+			"subResource": "` + subResource + `",
+			"namespace": "` + namespace + `",
+			"operation": "` + operation + `",
+			"userInfo": {"username": "alice"},
+			"object": {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web"}, "spec": ` + podSpec + `}
+		}
+	}`)
+}
+
+func post(t *testing.T, path string, payload []byte) review {
+	rec := httptest.NewRecorder()
+	handler(testPolicy).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST %s responded %d: %s", path, rec.Code, rec.Body.String())
+	}
+	var out review
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if out.Response == nil || out.Response.UID != "705ab4f5-6393-11e8-b7cc-42010a800002" {
+		t.Fatalf("response does not echo the request uid: %+v", out.Response)
+	}
+	return out
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		namespace, spec string
+		allowed         bool
+	}{
+		{"team-a", `{"containers": [{"name": "a", "image": "harbor.hpc.com/hpc/app:1.0"}]}`, true},
+		{"team-a", `{"containers": [{"name": "a", "image": "harbor.example.com:8443/lib/app@sha256:ab"}]}`, true},
+		{"team-a", `{"containers": [{"name": "a", "image": "nginx"}]}`, false},
+		{"team-a", `{"containers": [{"name": "a", "image": "quay.io/coreos/etcd:v3"}]}`, false},
+		{"team-a", `{"initContainers": [{"name": "i", "image": "busybox"}],
+			"containers": [{"name": "a", "image": "harbor.hpc.com/hpc/app"}]}`, false},
+		{"kube-system", `{"containers": [{"name": "a", "image": "k8s.gcr.io/pause:3.1"}]}`, true},
+		// hosts are compared in their canonical form, other ports are other registries
+		{"team-a", `{"containers": [{"name": "a", "image": "Harbor.HPC.com:443/hpc/app:1.0"}]}`, true},
+		{"team-a", `{"containers": [{"name": "a", "image": "harbor.hpc.com:5000/hpc/app:1.0"}]}`, false},
+		{"team-a", `{"containers": [{"name": "a", "image": "harbor.example.com/lib/app"}]}`, false},
+	}
+	for _, version := range []string{apiVersionV1beta1, apiVersionV1} {
+		for i, c := range cases {
+			out := post(t, "/validate", reviewPayload(version, c.namespace, c.spec))
+			if out.APIVersion != version {
+				t.Errorf("%s case %d: responded with %s", version, i, out.APIVersion)
+			}
+			if out.Response.Allowed != c.allowed {
+				t.Errorf("%s case %d: allowed = %v, want %v (%+v)", version, i,
+					out.Response.Allowed, c.allowed, out.Response.Result)
+			}
+		}
+	}
+}
+
+func TestMutate(t *testing.T) {
+	spec := `{"containers": [
+		{"name": "a", "image": "nginx:1.17"},
+		{"name": "b", "image": "team/tool", "resources": {"requests": {"memory": "2Gi"}, "limits": {"cpu": "500m"}}},
+		{"name": "c", "image": "harbor.hpc.com/hpc/app", "resources": {
+			"requests": {"cpu": "1", "memory": "1Mi"}, "limits": {"cpu": "2", "memory": "1Gi"}}}
+	]}`
+	out := post(t, "/mutate", reviewPayload(apiVersionV1, "team-a", spec))
+	if !out.Response.Allowed || out.Response.PatchType == nil || *out.Response.PatchType != patchTypeJSON {
+		t.Fatalf("unexpected response %+v", out.Response)
+	}
+	var patch []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(out.Response.Patch, &patch); err != nil {
+		t.Fatalf("decode patch failed: %v", err)
+	}
+	got := make(map[string]string)
+	for _, op := range patch {
+		got[op.Op+" "+op.Path] = string(op.Value)
+	}
+	want := map[string]string{
+		"replace /spec/containers/0/image": `"harbor.hpc.com/public/nginx:1.17"`,
+		"add /spec/containers/0/resources": `{"requests":{"cpu":"100m","memory":"128Mi"},"limits":{"cpu":"1","memory":"1Gi"}}`,
+		"replace /spec/containers/1/image": `"harbor.hpc.com/team/tool"`,
+		"add /spec/containers/1/resources": `{"requests":{"memory":"2Gi"},"limits":{"cpu":"500m","memory":"2Gi"}}`,
+	}
+	if len(got) != len(want) {
+		t.Errorf("patch has %d operations, want %d: %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %s, want %s", k, got[k], v)
+		}
+	}
+}
+
+func TestMutateOnlyCreate(t *testing.T) {
+	spec := `{"containers": [{"name": "a", "image": "nginx:1.17"}]}`
+	for _, c := range []struct{ operation, subResource string }{
+		{"UPDATE", ""},
+		{"CREATE", "ephemeralcontainers"},
+		{"UPDATE", "status"},
+	} {
+		out := post(t, "/mutate", reviewOperation(apiVersionV1, "team-a", c.operation, c.subResource, spec))
+		if !out.Response.Allowed || out.Response.Patch != nil {
+			t.Errorf("%s %s: unexpected response %+v", c.operation, c.subResource, out.Response)
+		}
+	}
+}
+
+func TestReviewErrors(t *testing.T) {
+	cases := map[string][]byte{
+		"malformed":   []byte(`{"apiVersion":`),
+		"old version": reviewPayload("admission.k8s.io/v1alpha1", "team-a", `{}`),
+		"no request":  []byte(`{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview"}`),
+	}
+	for name, payload := range cases {
+		rec := httptest.NewRecorder()
+		handler(testPolicy).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(payload)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: responded %d, want 400", name, rec.Code)
+		}
+	}
+}
+
+func writeCert(t *testing.T, dir, cn string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err = ioutil.WriteFile(filepath.Join(dir, "tls.crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "tls.key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeCert(t, dir, "first")
+	r, err := newCertReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	if err != nil {
+		t.Fatalf("load certificate failed: %v", err)
+	}
+	commonName := func() string {
+		cert, _ := r.GetCertificate(nil)
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Subject.CommonName
+	}
+	if cn := commonName(); cn != "first" {
+		t.Fatalf("serving %s, want first", cn)
+	}
+
+	writeCert(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{"tls.crt", "tls.key"} {
+		os.Chtimes(filepath.Join(dir, name), later, later)
+	}
+	if cn := commonName(); cn != "first" {
+		t.Errorf("reloaded before the check interval, serving %s", cn)
+	}
+	r.checkedAt = time.Time{}
+	if cn := commonName(); cn != "second" {
+		t.Errorf("serving %s after rotation, want second", cn)
+	}
+
+	// a broken rotation keeps the last good certificate
+	ioutil.WriteFile(filepath.Join(dir, "tls.crt"), []byte("garbage"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "tls.crt"), later, later)
+	r.checkedAt = time.Time{}
+	if cn := commonName(); cn != "second" {
+		t.Errorf("serving %s after a broken rotation, want second", cn)
+	}
+}
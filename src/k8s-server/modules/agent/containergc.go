@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/modules/agent/containergc"
+	"k8s-server/utils/logs"
+)
+
+// GCKeyPrefix+hostname holds the latest container GC report of an agent as
+// JSON.
+const GCKeyPrefix = "gc:"
+
+// RunContainerGC removes the exited containers and dangling images older
+// than MinContainerAge every ContainerGCInterval until stop is closed.
+func (s *Server) RunContainerGC(stop <-chan struct{}) {
+	interval := conf.ContainerGCInterval()
+	endpoint := conf.AgentCRIEndpoint()
+	if interval <= 0 || endpoint == "" {
+		return
+	}
+	collector, err := containergc.New(endpoint, conf.MinContainerAge())
+	if err != nil {
+		logs.Error("init container gc failed: %v", err)
+		return
+	}
+	hostname := s.collector.Collect().Hostname
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report := collector.Collect(hostname)
+		logs.Info("container gc removed %d containers and %d images, reclaimed %d bytes",
+			len(report.Containers), len(report.Images), report.ReclaimedBytes)
+		for _, e := range report.Errors {
+			logs.Warn("container gc: %s", e)
+		}
+		s.lock.Lock()
+		s.gc = &report
+		s.lock.Unlock()
+		if err = s.publishGC(report); err != nil {
+			logs.Warn("publish container gc report failed: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishGC stores the report without expiry, the GC runs far less often
+// than the heartbeat.
+func (s *Server) publishGC(report containergc.Report) error {
+	if s.redisPool == nil {
+		return nil
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	conn := s.redisPool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", GCKeyPrefix+report.Hostname, b)
+	return err
+}
+
+// gcReport returns the latest container GC report.
+func (s *Server) gcReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.lock.Lock()
+	report := s.gc
+	s.lock.Unlock()
+	if report == nil {
+		writeError(w, http.StatusNotFound, "container gc did not run yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
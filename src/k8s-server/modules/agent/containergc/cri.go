@@ -0,0 +1,218 @@
+package containergc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CRI states the GC looks at.
+const (
+	sandboxReady    = "SANDBOX_READY"
+	containerExited = "CONTAINER_EXITED"
+)
+
+// runtime is the subset of the CRI used by the GC.
+type runtime interface {
+	ListPodSandboxes() ([]podSandbox, error)
+	ListContainers() ([]container, error)
+	ContainerStatus(id string) (*containerStatus, error)
+	// ContainerSizes returns the writable layer sizes by container id.
+	ContainerSizes() (map[string]uint64, error)
+	RemoveContainer(id string) error
+	ListImages() ([]image, error)
+	RemoveImage(id string) error
+}
+
+// The CRI objects as crictl prints them with -o json, only the fields used by
+// the GC. The protobuf JSON mapping quotes 64 bit integers.
+type podSandbox struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+}
+
+type containerMetadata struct {
+	Name    string `json:"name"`
+	Attempt uint32 `json:"attempt"`
+}
+
+type imageSpec struct {
+	Image string `json:"image"`
+}
+
+type container struct {
+	ID           string             `json:"id"`
+	PodSandboxID string             `json:"podSandboxId"`
+	Metadata     *containerMetadata `json:"metadata"`
+	Image        *imageSpec         `json:"image"`
+	ImageRef     string             `json:"imageRef"`
+	State        string             `json:"state"`
+	CreatedAt    timestamp          `json:"createdAt"`
+}
+
+type containerStatus struct {
+	ID         string    `json:"id"`
+	State      string    `json:"state"`
+	CreatedAt  timestamp `json:"createdAt"`
+	FinishedAt timestamp `json:"finishedAt"`
+}
+
+type containerStats struct {
+	Attributes *struct {
+		ID string `json:"id"`
+	} `json:"attributes"`
+	WritableLayer *struct {
+		UsedBytes *struct {
+			Value quotedUint `json:"value"`
+		} `json:"usedBytes"`
+	} `json:"writableLayer"`
+}
+
+type image struct {
+	ID          string     `json:"id"`
+	RepoTags    []string   `json:"repoTags"`
+	RepoDigests []string   `json:"repoDigests"`
+	Size        quotedUint `json:"size"`
+	Pinned      bool       `json:"pinned"`
+}
+
+// quotedUint is an unsigned integer that may be quoted.
+type quotedUint uint64
+
+func (n *quotedUint) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "null" {
+		return nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", b)
+	}
+	*n = quotedUint(v)
+	return nil
+}
+
+// timestamp is a time in nanoseconds since the epoch, quoted or not, or in
+// RFC 3339 as crictl inspect prints it. The zero time of either form is the
+// zero Time.
+type timestamp struct {
+	time.Time
+}
+
+func (t *timestamp) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "null" {
+		return nil
+	}
+	if ns, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if ns > 0 {
+			t.Time = time.Unix(0, ns)
+		}
+		return nil
+	}
+	v, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return fmt.Errorf("invalid time %s", b)
+	}
+	if v.Unix() > 0 {
+		t.Time = v
+	}
+	return nil
+}
+
+// crictl is the runtime reached with the crictl command line tool.
+type crictl struct {
+	path     string
+	endpoint string
+}
+
+// run runs a crictl command against the endpoint and decodes its JSON output
+// into v, a nil v ignores the output.
+func (c crictl) run(v interface{}, command string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	args = append([]string{"--runtime-endpoint", c.endpoint, "--image-endpoint", c.endpoint,
+		"--timeout", callTimeout.String(), command}, args...)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.path, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("crictl %s timed out after %s", command, callTimeout)
+		}
+		return fmt.Errorf("crictl %s: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), v); err != nil {
+		return fmt.Errorf("decode crictl %s output failed: %v", command, err)
+	}
+	return nil
+}
+
+func (c crictl) ListPodSandboxes() ([]podSandbox, error) {
+	var out struct {
+		Items []podSandbox `json:"items"`
+	}
+	err := c.run(&out, "pods", "-o", "json")
+	return out.Items, err
+}
+
+func (c crictl) ListContainers() ([]container, error) {
+	var out struct {
+		Containers []container `json:"containers"`
+	}
+	err := c.run(&out, "ps", "-a", "-o", "json")
+	return out.Containers, err
+}
+
+func (c crictl) ContainerStatus(id string) (*containerStatus, error) {
+	var out struct {
+		Status *containerStatus `json:"status"`
+	}
+	if err := c.run(&out, "inspect", "-o", "json", id); err != nil {
+		return nil, err
+	}
+	if out.Status == nil {
+		return nil, fmt.Errorf("no status of container %s", id)
+	}
+	return out.Status, nil
+}
+
+func (c crictl) ContainerSizes() (map[string]uint64, error) {
+	var out struct {
+		Stats []containerStats `json:"stats"`
+	}
+	if err := c.run(&out, "stats", "-a", "-o", "json"); err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]uint64, len(out.Stats))
+	for _, s := range out.Stats {
+		if s.Attributes != nil && s.WritableLayer != nil && s.WritableLayer.UsedBytes != nil {
+			sizes[s.Attributes.ID] = uint64(s.WritableLayer.UsedBytes.Value)
+		}
+	}
+	return sizes, nil
+}
+
+func (c crictl) RemoveContainer(id string) error {
+	return c.run(nil, "rm", id)
+}
+
+func (c crictl) ListImages() ([]image, error) {
+	var out struct {
+		Images []image `json:"images"`
+	}
+	err := c.run(&out, "images", "-o", "json")
+	return out.Images, err
+}
+
+func (c crictl) RemoveImage(id string) error {
+	return c.run(nil, "rmi", id)
+}
@@ -0,0 +1,224 @@
+// Package containergc removes exited containers and dangling images the
+// kubelet leaves behind, it talks to the container runtime through the CRI
+// socket with crictl.
+package containergc
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"k8s-server/utils/logs"
+)
+
+// callTimeout bounds every CRI call.
+const callTimeout = 30 * time.Second
+
+// Removed is a removed container or image.
+type Removed struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Bytes is the writable layer of a container or the size of an image.
+	Bytes uint64 `json:"bytes"`
+}
+
+// Report is the outcome of a collection.
+type Report struct {
+	Hostname       string    `json:"hostname"`
+	Containers     []Removed `json:"containers"`
+	Images         []Removed `json:"images"`
+	ReclaimedBytes uint64    `json:"reclaimedBytes"`
+	Errors         []string  `json:"errors,omitempty"`
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt"`
+}
+
+// Collector removes the exited containers and dangling images older than
+// the minimum age.
+type Collector struct {
+	runtime runtime
+	minAge  time.Duration
+	now     func() time.Time
+
+	// unusedSince is when a dangling image was first seen unused, the CRI
+	// does not report when an image was last used
+	unusedSince map[string]time.Time
+}
+
+// New returns a collector of the runtime at the CRI endpoint, e.g.
+// unix:///run/containerd/containerd.sock. crictl must be in the PATH.
+func New(endpoint string, minAge time.Duration) (*Collector, error) {
+	if strings.HasPrefix(endpoint, "/") {
+		endpoint = "unix://" + endpoint
+	}
+	path, err := exec.LookPath("crictl")
+	if err != nil {
+		return nil, fmt.Errorf("crictl not found: %v", err)
+	}
+	return newCollector(crictl{path: path, endpoint: endpoint}, minAge), nil
+}
+
+func newCollector(rt runtime, minAge time.Duration) *Collector {
+	return &Collector{runtime: rt, minAge: minAge, now: time.Now, unusedSince: make(map[string]time.Time)}
+}
+
+// Collect removes the exited containers that finished at least the minimum
+// age ago, then the dangling images unused for the minimum age. The latest
+// exited attempt of a container in a ready pod is kept for the kubelet, and
+// images referenced by any remaining container are never removed.
+func (c *Collector) Collect(hostname string) Report {
+	report := Report{
+		Hostname:   hostname,
+		Containers: []Removed{},
+		Images:     []Removed{},
+		StartedAt:  c.now(),
+	}
+	if err := c.collectContainers(&report); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	if err := c.collectImages(&report); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	report.FinishedAt = c.now()
+	return report
+}
+
+func (c *Collector) collectContainers(report *Report) error {
+	sandboxes, err := c.runtime.ListPodSandboxes()
+	if err != nil {
+		return fmt.Errorf("list pod sandboxes failed: %v", err)
+	}
+	ready := make(map[string]bool)
+	for _, s := range sandboxes {
+		if s.State == sandboxReady {
+			ready[s.ID] = true
+		}
+	}
+	containers, err := c.runtime.ListContainers()
+	if err != nil {
+		return fmt.Errorf("list containers failed: %v", err)
+	}
+	// like the kubelet keep the latest exited attempt of each container of a
+	// ready pod, it serves the previous logs and the last termination state
+	latest := make(map[string]uint32)
+	for _, ctr := range containers {
+		if !ready[ctr.PodSandboxID] || ctr.Metadata == nil || ctr.State != containerExited {
+			continue
+		}
+		key := ctr.PodSandboxID + "/" + ctr.Metadata.Name
+		if a, ok := latest[key]; !ok || ctr.Metadata.Attempt > a {
+			latest[key] = ctr.Metadata.Attempt
+		}
+	}
+	sizes, err := c.runtime.ContainerSizes()
+	if err != nil {
+		logs.Warn("list container stats failed, reclaimed bytes unknown: %v", err)
+	}
+
+	now := c.now()
+	var failed []string
+	for _, ctr := range containers {
+		if ctr.State != containerExited {
+			continue
+		}
+		name := ctr.ID
+		if ctr.Metadata != nil {
+			name = ctr.Metadata.Name
+			if a, ok := latest[ctr.PodSandboxID+"/"+name]; ok && a == ctr.Metadata.Attempt {
+				continue
+			}
+		}
+		status, err := c.runtime.ContainerStatus(ctr.ID)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("status of container %s: %v", ctr.ID, err))
+			continue
+		}
+		finished := status.FinishedAt.Time
+		if finished.IsZero() {
+			finished = ctr.CreatedAt.Time
+		}
+		if now.Sub(finished) < c.minAge {
+			continue
+		}
+		if err = c.runtime.RemoveContainer(ctr.ID); err != nil {
+			failed = append(failed, fmt.Sprintf("remove container %s: %v", ctr.ID, err))
+			continue
+		}
+		report.Containers = append(report.Containers, Removed{ID: ctr.ID, Name: name, Bytes: sizes[ctr.ID]})
+		report.ReclaimedBytes += sizes[ctr.ID]
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("container gc: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (c *Collector) collectImages(report *Report) error {
+	// list again, the removed containers no longer hold their images
+	containers, err := c.runtime.ListContainers()
+	if err != nil {
+		return fmt.Errorf("list containers failed: %v", err)
+	}
+	inUse := make(map[string]bool)
+	for _, ctr := range containers {
+		inUse[ctr.ImageRef] = true
+		if ctr.Image != nil {
+			inUse[ctr.Image.Image] = true
+		}
+	}
+	images, err := c.runtime.ListImages()
+	if err != nil {
+		return fmt.Errorf("list images failed: %v", err)
+	}
+
+	now := c.now()
+	seen := make(map[string]bool)
+	var failed []string
+	for _, img := range images {
+		if len(img.RepoTags) > 0 || img.Pinned || imageInUse(img, inUse) {
+			continue
+		}
+		seen[img.ID] = true
+		since, ok := c.unusedSince[img.ID]
+		if !ok {
+			c.unusedSince[img.ID] = now
+			continue
+		}
+		if now.Sub(since) < c.minAge {
+			continue
+		}
+		if err = c.runtime.RemoveImage(img.ID); err != nil {
+			failed = append(failed, fmt.Sprintf("remove image %s: %v", img.ID, err))
+			continue
+		}
+		name := img.ID
+		if len(img.RepoDigests) > 0 {
+			name = img.RepoDigests[0]
+		}
+		report.Images = append(report.Images, Removed{ID: img.ID, Name: name, Bytes: uint64(img.Size)})
+		report.ReclaimedBytes += uint64(img.Size)
+		delete(c.unusedSince, img.ID)
+	}
+	for id := range c.unusedSince {
+		if !seen[id] {
+			delete(c.unusedSince, id)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("image gc: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func imageInUse(img image, inUse map[string]bool) bool {
+	if inUse[img.ID] {
+		return true
+	}
+	for _, ref := range img.RepoDigests {
+		if inUse[ref] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package containergc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRuntime is a container runtime serving the CRI calls used by the GC.
+type fakeRuntime struct {
+	lock       sync.Mutex
+	sandboxes  []podSandbox
+	containers map[string]container
+	finished   map[string]time.Time
+	sizes      map[string]uint64
+	images     map[string]image
+}
+
+func (f *fakeRuntime) ListPodSandboxes() ([]podSandbox, error) {
+	return f.sandboxes, nil
+}
+
+func (f *fakeRuntime) ListContainers() ([]container, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var containers []container
+	for _, c := range f.containers {
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+
+func (f *fakeRuntime) ContainerStatus(id string) (*containerStatus, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("container %s not found", id)
+	}
+	return &containerStatus{ID: c.ID, State: c.State, CreatedAt: c.CreatedAt,
+		FinishedAt: timestamp{f.finished[c.ID]}}, nil
+}
+
+func (f *fakeRuntime) ContainerSizes() (map[string]uint64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	sizes := make(map[string]uint64, len(f.sizes))
+	for id, size := range f.sizes {
+		sizes[id] = size
+	}
+	return sizes, nil
+}
+
+func (f *fakeRuntime) RemoveContainer(id string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.containers, id)
+	return nil
+}
+
+func (f *fakeRuntime) ListImages() ([]image, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var images []image
+	for _, img := range f.images {
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+func (f *fakeRuntime) RemoveImage(id string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.images, id)
+	return nil
+}
+
+func newContainer(id, sandbox, name string, attempt uint32, state, ref string) container {
+	return container{
+		ID:           id,
+		PodSandboxID: sandbox,
+		Metadata:     &containerMetadata{Name: name, Attempt: attempt},
+		State:        state,
+		Image:        &imageSpec{Image: ref},
+		ImageRef:     ref,
+	}
+}
+
+func ids(removed []Removed) []string {
+	var ids []string
+	for _, r := range removed {
+		ids = append(ids, r.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestCollect(t *testing.T) {
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+	recent := now.Add(-time.Hour)
+	exited, running := containerExited, "CONTAINER_RUNNING"
+	f := &fakeRuntime{
+		sandboxes: []podSandbox{
+			{ID: "pod-ready", State: sandboxReady},
+			{ID: "pod-gone", State: "SANDBOX_NOTREADY"},
+		},
+		containers: map[string]container{
+			// the latest exited attempt of a ready pod is kept, older ones go
+			"app-0":    newContainer("app-0", "pod-ready", "app", 0, exited, "sha256:app"),
+			"app-1":    newContainer("app-1", "pod-ready", "app", 1, exited, "sha256:app"),
+			"app-2":    newContainer("app-2", "pod-ready", "app", 2, running, "sha256:app"),
+			"init-0":   newContainer("init-0", "pod-ready", "init", 0, exited, "sha256:init"),
+			"job-0":    newContainer("job-0", "pod-gone", "job", 0, exited, "sha256:job"),
+			"fresh-0":  newContainer("fresh-0", "pod-gone", "fresh", 0, exited, "sha256:fresh"),
+			"unknown0": newContainer("unknown0", "pod-gone", "unknown", 0, exited, "sha256:job"),
+		},
+		finished: map[string]time.Time{
+			"app-0": old, "app-1": old, "init-0": old, "job-0": old, "fresh-0": recent, "unknown0": old,
+		},
+		sizes: map[string]uint64{"app-0": 100, "app-1": 200, "job-0": 1000, "unknown0": 10},
+		images: map[string]image{
+			"sha256:app":      {ID: "sha256:app", RepoTags: []string{"app:1.0"}, Size: 1 << 20},
+			"sha256:job":      {ID: "sha256:job", RepoDigests: []string{"job@sha256:job"}, Size: 1 << 30},
+			"sha256:fresh":    {ID: "sha256:fresh", Size: 1 << 10},
+			"sha256:init":     {ID: "sha256:init", Size: 1 << 10},
+			"sha256:pinned":   {ID: "sha256:pinned", Pinned: true, Size: 1 << 10},
+			"sha256:dangling": {ID: "sha256:dangling", Size: 1 << 20},
+		},
+	}
+
+	c := newCollector(f, 24*time.Hour)
+	c.now = func() time.Time { return now }
+
+	report := c.Collect("node1")
+	if len(report.Errors) > 0 {
+		t.Fatalf("collect failed: %v", report.Errors)
+	}
+	if got := fmt.Sprint(ids(report.Containers)); got != "[app-0 job-0 unknown0]" {
+		t.Errorf("removed containers %s, want [app-0 job-0 unknown0]", got)
+	}
+	if len(report.Images) != 0 {
+		t.Errorf("images removed on first sight: %v", ids(report.Images))
+	}
+	if report.ReclaimedBytes != 1110 {
+		t.Errorf("reclaimed %d bytes, want 1110", report.ReclaimedBytes)
+	}
+
+	// the dangling images stay unused for the minimum age
+	now = now.Add(25 * time.Hour)
+	report = c.Collect("node1")
+	if got := fmt.Sprint(ids(report.Containers)); got != "[fresh-0]" {
+		t.Errorf("removed containers %s, want [fresh-0]", got)
+	}
+	if got := fmt.Sprint(ids(report.Images)); got != "[sha256:dangling sha256:job]" {
+		t.Errorf("removed images %s, want [sha256:dangling sha256:job]", got)
+	}
+	if report.ReclaimedBytes != 1<<30+1<<20 {
+		t.Errorf("reclaimed %d bytes, want %d", report.ReclaimedBytes, 1<<30+1<<20)
+	}
+	for _, id := range []string{"sha256:app", "sha256:init", "sha256:pinned", "sha256:fresh"} {
+		if _, ok := f.images[id]; !ok {
+			t.Errorf("image %s was removed", id)
+		}
+	}
+}
+
+func TestCrictl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containergc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testdata, err := filepath.Abs("testdata/crictl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fake crictl logs its arguments and prints testdata/crictl/<command>.json
+	script := "#!/bin/sh\necho \"$@\" >> " + dir + "/calls\n" +
+		"[ -f " + testdata + "/$7.json ] && cat " + testdata + "/$7.json\nexit 0\n"
+	path := filepath.Join(dir, "crictl")
+	if err = ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rt := crictl{path: path, endpoint: "unix:///run/containerd/containerd.sock"}
+
+	sandboxes, err := rt.ListPodSandboxes()
+	if err != nil || !reflect.DeepEqual(sandboxes, []podSandbox{{ID: "5a2f", State: sandboxReady}}) {
+		t.Errorf("sandboxes %+v: %v", sandboxes, err)
+	}
+	containers, err := rt.ListContainers()
+	if err != nil || len(containers) != 1 {
+		t.Fatalf("containers %+v: %v", containers, err)
+	}
+	if c := containers[0]; c.PodSandboxID != "5a2f" || c.Metadata.Attempt != 1 || c.State != containerExited ||
+		c.Image.Image != "sha256:app" || !c.CreatedAt.Equal(time.Date(2019, 7, 2, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected container %+v", c)
+	}
+	status, err := rt.ContainerStatus("9d41")
+	if err != nil || !status.FinishedAt.Equal(time.Date(2019, 7, 2, 13, 0, 0, 5e8, time.UTC)) {
+		t.Errorf("status %+v: %v", status, err)
+	}
+	sizes, err := rt.ContainerSizes()
+	if err != nil || !reflect.DeepEqual(sizes, map[string]uint64{"9d41": 4096}) {
+		t.Errorf("sizes %v: %v", sizes, err)
+	}
+	images, err := rt.ListImages()
+	if err != nil || len(images) != 1 || images[0].Size != 1<<20 || images[0].RepoDigests[0] != "app@sha256:0f3c" {
+		t.Errorf("images %+v: %v", images, err)
+	}
+	if err = rt.RemoveContainer("9d41"); err != nil {
+		t.Error(err)
+	}
+	if err = rt.RemoveImage("sha256:app"); err != nil {
+		t.Error(err)
+	}
+	calls, err := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+	endpoints := "--runtime-endpoint unix:///run/containerd/containerd.sock --image-endpoint unix:///run/containerd/containerd.sock --timeout 30s "
+	if len(lines) != 7 || lines[0] != endpoints+"pods -o json" || lines[6] != endpoints+"rmi sha256:app" {
+		t.Errorf("unexpected calls %q", lines)
+	}
+
+	// a container that did not finish yet
+	var unfinished containerStatus
+	if err = json.Unmarshal([]byte(`{"createdAt": "0", "finishedAt": "0001-01-01T00:00:00Z"}`), &unfinished); err != nil ||
+		!unfinished.CreatedAt.IsZero() || !unfinished.FinishedAt.IsZero() {
+		t.Errorf("unfinished status %+v: %v", unfinished, err)
+	}
+}
@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"k8s-server/conf"
+	"k8s-server/modules/discovery"
+	"k8s-server/utils/logs"
+)
+
+// OverridesKeyPrefix is the Redis hash of the configuration overrides of a
+// host.
+const OverridesKeyPrefix = "overrides:"
+
+// overridable are the configuration keys a per-node override may set with
+// the check of their values. Only tuning values are listed, the commands,
+// paths, tokens and keys of the agent are never taken from the discovery as
+// whoever can write it could run code on every node.
+var overridable = map[string]func(string) error{
+	"agent::HealthCheckInterval":    checkPositive,
+	"agent::HeartBeatInterval":      checkPositive,
+	"agent::ContainerGCInterval":    checkCount,
+	"agent::MinContainerAge":        checkCount,
+	"agent::ServiceMonitorInterval": checkCount,
+	"agent::ServiceActionTimeout":   checkPositive,
+	"agent::SampleWindow":           checkPositive,
+	"agent::LabelInterval":          checkCount,
+	"agent::UpdateConfirmTimeout":   checkPositive,
+	"agent::TaintUnhealthy":         checkBool,
+	"backend::MonitorInterval":      checkCount,
+}
+
+// CheckOverride returns an error if key may not be overridden per node or
+// value is invalid for it.
+func CheckOverride(key, value string) error {
+	check, ok := overridable[key]
+	if !ok {
+		return fmt.Errorf("configuration key %q can not be overridden", key)
+	}
+	if err := check(value); err != nil {
+		return fmt.Errorf("invalid value of %s: %v", key, err)
+	}
+	return nil
+}
+
+func checkCount(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%q is not a non-negative integer", value)
+	}
+	return nil
+}
+
+// checkPositive checks the intervals and timeouts that 0 does not disable.
+func checkPositive(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("%q is not a positive integer", value)
+	}
+	return nil
+}
+
+func checkBool(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+// NewDiscovery returns the discovery of the agents, Consul if ConsulAddrs is
+// set, else the heartbeats in pool. It is nil if neither is configured.
+func NewDiscovery(pool *redis.Pool) (discovery.Discovery, error) {
+	if addrs := conf.ConsulAddrs(); len(addrs) > 0 {
+		return discovery.NewConsul(addrs, conf.ConsulServicePath(), conf.ConsulBasePath(),
+			conf.ConsulDeregisterAfter())
+	}
+	if pool != nil {
+		return NewRedisDiscovery(pool), nil
+	}
+	return nil, nil
+}
+
+// Instance returns the discovery instance announcing the heartbeat.
+func (hb Heartbeat) Instance() discovery.Instance {
+	return discovery.Instance{
+		Hostname: hb.Hostname,
+		Address:  hb.Address,
+		Port:     hb.Port,
+		Meta: map[string]string{
+			"mac":       hb.MAC,
+			"version":   hb.Version,
+			"startedAt": hb.StartedAt.UTC().Format(time.RFC3339),
+		},
+		SentAt:  hb.SentAt,
+		Healthy: true,
+	}
+}
+
+// HeartbeatOf returns the heartbeat announced by a discovery instance.
+func HeartbeatOf(inst discovery.Instance) Heartbeat {
+	hb := Heartbeat{
+		Hostname: inst.Hostname,
+		Address:  inst.Address,
+		MAC:      inst.Meta["mac"],
+		Port:     inst.Port,
+		Version:  inst.Meta["version"],
+		SentAt:   inst.SentAt,
+	}
+	hb.StartedAt, _ = time.Parse(time.RFC3339, inst.Meta["startedAt"])
+	return hb
+}
+
+// redisDiscovery is the discovery of the heartbeats published to Redis, the
+// overrides are the hash OverridesKeyPrefix+hostname.
+type redisDiscovery struct {
+	pool *redis.Pool
+}
+
+// NewRedisDiscovery returns the discovery of the heartbeats in pool.
+func NewRedisDiscovery(pool *redis.Pool) discovery.Discovery {
+	return redisDiscovery{pool: pool}
+}
+
+func (d redisDiscovery) Heartbeat(inst discovery.Instance, ttl time.Duration) error {
+	conn := d.pool.Get()
+	defer conn.Close()
+	return PublishHeartbeat(conn, HeartbeatOf(inst), ttl)
+}
+
+// Instances returns the agents in the AgentsKey set, an agent whose
+// heartbeat expired is unhealthy.
+func (d redisDiscovery) Instances() ([]discovery.Instance, error) {
+	conn := d.pool.Get()
+	defer conn.Close()
+	hostnames, err := redis.Strings(conn.Do("SMEMBERS", AgentsKey))
+	if err != nil {
+		return nil, fmt.Errorf("list agents failed: %v", err)
+	}
+	for _, hostname := range hostnames {
+		conn.Send("HGETALL", HeartbeatKeyPrefix+hostname)
+	}
+	if err = conn.Flush(); err != nil {
+		return nil, fmt.Errorf("read heartbeats failed: %v", err)
+	}
+	instances := make([]discovery.Instance, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		fields, err := redis.StringMap(conn.Receive())
+		if err != nil {
+			return nil, fmt.Errorf("read heartbeats failed: %v", err)
+		}
+		inst := discovery.Instance{Hostname: hostname}
+		if len(fields) > 0 {
+			hb, err := ParseHeartbeat(fields)
+			if err != nil {
+				logs.Warn("ignore heartbeat of %s: %v", hostname, err)
+			} else {
+				inst = hb.Instance()
+			}
+		}
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+func (d redisDiscovery) Deregister(hostname string) error {
+	conn := d.pool.Get()
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("SREM", AgentsKey, hostname)
+	conn.Send("DEL", HeartbeatKeyPrefix+hostname)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func (d redisDiscovery) Overrides(hostname string) (map[string]string, error) {
+	conn := d.pool.Get()
+	defer conn.Close()
+	return redis.StringMap(conn.Do("HGETALL", OverridesKeyPrefix+hostname))
+}
+
+func (d redisDiscovery) SetOverride(hostname, key, value string) error {
+	conn := d.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("HSET", OverridesKeyPrefix+hostname, key, value)
+	return err
+}
+
+func (d redisDiscovery) DeleteOverride(hostname, key string) error {
+	conn := d.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("HDEL", OverridesKeyPrefix+hostname, key)
+	return err
+}
+
+// applyOverrides applies the configuration overrides of this host, they are
+// read once at start. Overrides of keys that are not overridable are
+// ignored.
+func applyOverrides(d discovery.Discovery) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logs.Warn("read overrides failed: %v", err)
+		return
+	}
+	overrides, err := d.Overrides(hostname)
+	if err != nil {
+		logs.Warn("read overrides failed: %v", err)
+		return
+	}
+	for key, value := range overrides {
+		if err = CheckOverride(key, value); err != nil {
+			logs.Warn("ignore override: %v", err)
+			continue
+		}
+		if err = conf.Override(key, value); err != nil {
+			logs.Warn("apply override %s failed: %v", key, err)
+			continue
+		}
+		logs.Info("override %s = %q", key, value)
+	}
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/modules/discovery"
+)
+
+// fakeDiscovery only serves the overrides of the hosts.
+type fakeDiscovery struct {
+	discovery.Discovery
+	overrides map[string]map[string]string
+}
+
+func (d fakeDiscovery) Overrides(hostname string) (map[string]string, error) {
+	return d.overrides[hostname], nil
+}
+
+func TestApplyOverrides(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides := map[string]string{
+		"agent::HeartBeatInterval":    "3",
+		"agent::RebootCommand":        "touch /tmp/pwned",
+		"agent::Token":                "",
+		"agent::ContainerGCInterval":  "soon",
+		"agent::UpdateConfirmTimeout": "0",
+	}
+	defer func() {
+		for key := range overrides {
+			conf.Override(key, "")
+		}
+	}()
+	applyOverrides(fakeDiscovery{overrides: map[string]map[string]string{hostname: overrides}})
+
+	if interval := conf.HeartbeatInterval(); interval != 3*time.Second {
+		t.Errorf("heartbeat interval is %v, want 3s", interval)
+	}
+	if cmd := conf.AgentRebootCommand(); cmd != "systemctl reboot" {
+		t.Errorf("reboot command overridden with %q", cmd)
+	}
+	if interval := conf.ContainerGCInterval(); interval != 2*time.Hour {
+		t.Errorf("container gc interval overridden to %v", interval)
+	}
+	if timeout := conf.AgentUpdateConfirmTimeout(); timeout != 2*time.Minute {
+		t.Errorf("update confirm timeout overridden to %v", timeout)
+	}
+}
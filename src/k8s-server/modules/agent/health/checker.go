@@ -0,0 +1,218 @@
+package health
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s-server/modules/agent/sysinfo"
+)
+
+// Result is the outcome of a check.
+type Result struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Critical bool   `json:"critical"`
+	OK       bool   `json:"ok"`
+	Message  string `json:"message,omitempty"`
+}
+
+// Report is the outcome of a checklist run.
+type Report struct {
+	Hostname string   `json:"hostname"`
+	Results  []Result `json:"results"`
+	// Failing lists the failed critical checks, the node is healthy if it
+	// is empty.
+	Failing   []string  `json:"failing"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Healthy reports whether no critical check failed.
+func (r Report) Healthy() bool {
+	return len(r.Failing) == 0
+}
+
+// Checker runs the checks, the process, mount and sysctl checks read procfs
+// below procRoot.
+type Checker struct {
+	procRoot string
+	checks   []Check
+}
+
+// NewChecker returns a checker of the checks.
+func NewChecker(procRoot string, checks []Check) *Checker {
+	return &Checker{procRoot: procRoot, checks: checks}
+}
+
+// Run runs every check in order.
+func (c *Checker) Run(hostname string) Report {
+	report := Report{
+		Hostname:  hostname,
+		Results:   make([]Result, 0, len(c.checks)),
+		Failing:   []string{},
+		CheckedAt: time.Now(),
+	}
+	for _, check := range c.checks {
+		r := Result{Name: check.Name, Type: check.Type, Critical: check.Critical}
+		if err := c.run(check); err != nil {
+			r.Message = err.Error()
+			if check.Critical {
+				report.Failing = append(report.Failing, check.Name)
+			}
+		} else {
+			r.OK = true
+		}
+		report.Results = append(report.Results, r)
+	}
+	sort.Strings(report.Failing)
+	return report
+}
+
+func (c *Checker) run(check Check) error {
+	switch check.Type {
+	case TypeFile:
+		_, err := os.Stat(check.Path)
+		return err
+	case TypeProcess:
+		return c.checkProcess(check.Process)
+	case TypePort:
+		return checkPort(check)
+	case TypeMount:
+		return c.checkMount(check.Path, check.FSType)
+	case TypeCommand:
+		return checkCommand(check)
+	case TypeSysctl:
+		return c.checkSysctl(check.Key, check.Value)
+	case TypeCRI:
+		return checkSocket(check)
+	}
+	return fmt.Errorf("unknown check type %q", check.Type)
+}
+
+// checkProcess looks for a process whose command name or executable base
+// name is name.
+func (c *Checker) checkProcess(name string) error {
+	dirs, err := ioutil.ReadDir(c.procRoot)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if _, err := strconv.Atoi(d.Name()); err != nil || !d.IsDir() {
+			continue
+		}
+		comm, _ := ioutil.ReadFile(filepath.Join(c.procRoot, d.Name(), "comm"))
+		if strings.TrimSpace(string(comm)) == name {
+			return nil
+		}
+		// comm is truncated to 15 characters
+		cmdline, _ := ioutil.ReadFile(filepath.Join(c.procRoot, d.Name(), "cmdline"))
+		if i := bytes.IndexByte(cmdline, 0); i >= 0 {
+			cmdline = cmdline[:i]
+		}
+		if len(cmdline) > 0 && filepath.Base(string(cmdline)) == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("process %s is not running", name)
+}
+
+func checkPort(check Check) error {
+	host := check.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(check.Port)), check.timeout())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkSocket connects to the unix socket Path, a socket file is left behind
+// by a crashed runtime so its existence is not enough.
+func checkSocket(check Check) error {
+	path := strings.TrimPrefix(check.Path, "unix://")
+	if path == "" {
+		return fmt.Errorf("no container runtime socket configured")
+	}
+	conn, err := net.DialTimeout("unix", path, check.timeout())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (c *Checker) checkMount(path, fsType string) error {
+	f, err := os.Open(filepath.Join(c.procRoot, "mounts"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	path = filepath.Clean(path)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || sysinfo.UnescapeMount(fields[1]) != path {
+			continue
+		}
+		if fsType != "" && fields[2] != fsType {
+			return fmt.Errorf("%s is mounted as %s, want %s", path, fields[2], fsType)
+		}
+		return nil
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%s is not mounted", path)
+}
+
+func checkCommand(check Check) error {
+	ctx, cancel := context.WithTimeout(context.Background(), check.timeout())
+	defer cancel()
+	out, err := exec.CommandContext(ctx, check.Command[0], check.Command[1:]...).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", check.timeout())
+	}
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		return err
+	}
+	if code != check.ExitCode {
+		msg := fmt.Sprintf("exited with %d, want %d", code, check.ExitCode)
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			if len(out) > 256 {
+				out = out[:256]
+			}
+			msg += ": " + string(out)
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// checkSysctl compares the kernel parameter, whitespace separated values
+// like net.ipv4.tcp_rmem match regardless of the separators.
+func (c *Checker) checkSysctl(key, want string) error {
+	path := filepath.Join(c.procRoot, "sys", strings.Replace(key, ".", "/", -1))
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	got := strings.Join(strings.Fields(string(b)), " ")
+	if got != strings.Join(strings.Fields(want), " ") {
+		return fmt.Errorf("%s is %q, want %q", key, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package health
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadChecklist(t *testing.T) {
+	checks, err := LoadChecklist("testdata/checklist.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 7 || checks[0].Process != "kubelet" || !checks[0].Critical || checks[6].Command[1] != "-L" {
+		t.Errorf("unexpected checks %+v", checks)
+	}
+
+	dir, err := ioutil.TempDir("", "checklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	invalid := map[string]string{
+		"unknown type":   `{"checks": [{"name": "a", "type": "ping"}]}`,
+		"missing path":   `{"checks": [{"name": "a", "type": "mount"}]}`,
+		"invalid port":   `{"checks": [{"name": "a", "type": "port", "port": 70000}]}`,
+		"duplicate name": `{"checks": [{"name": "a", "type": "file", "path": "/"}, {"name": "a", "type": "file", "path": "/"}]}`,
+	}
+	for name, content := range invalid {
+		path := filepath.Join(dir, "checklist.json")
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = LoadChecklist(path); err == nil {
+			t.Errorf("%s: checklist loaded", name)
+		}
+	}
+}
+
+func TestChecker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	open, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	dir, err := ioutil.TempDir("", "health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runtime, err := net.Listen("unix", filepath.Join(dir, "containerd.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Close()
+
+	checks := []Check{
+		{Name: "kubelet", Type: TypeProcess, Process: "kubelet", Critical: true},
+		{Name: "slurmd", Type: TypeProcess, Process: "slurmd", Critical: true},
+		{Name: "home", Type: TypeMount, Path: "/home/", FSType: "nfs4", Critical: true},
+		{Name: "data", Type: TypeMount, Path: "/data disk"},
+		{Name: "scratch", Type: TypeMount, Path: "/scratch"},
+		{Name: "ip-forward", Type: TypeSysctl, Key: "net.ipv4.ip_forward", Value: "1", Critical: true},
+		{Name: "tcp-rmem", Type: TypeSysctl, Key: "net.ipv4.tcp_rmem", Value: "4096 87380 6291456"},
+		{Name: "swappiness", Type: TypeSysctl, Key: "vm.swappiness", Value: "10"},
+		{Name: "checklist", Type: TypeFile, Path: "testdata/checklist.json"},
+		{Name: "port-open", Type: TypePort, Port: open.Addr().(*net.TCPAddr).Port},
+		{Name: "port-closed", Type: TypePort, Port: port, Timeout: 1},
+		{Name: "true", Type: TypeCommand, Command: []string{"true"}},
+		{Name: "exit-3", Type: TypeCommand, Command: []string{"sh", "-c", "echo degraded; exit 3"}, Critical: true},
+		{Name: "expect-3", Type: TypeCommand, Command: []string{"sh", "-c", "exit 3"}, ExitCode: 3},
+		{Name: "containerd", Type: TypeCRI, Path: "unix://" + filepath.Join(dir, "containerd.sock")},
+		{Name: "docker", Type: TypeCRI, Path: filepath.Join(dir, "docker.sock")},
+	}
+	report := NewChecker("testdata/proc", checks).Run("node1")
+
+	want := map[string]bool{
+		"kubelet": true, "slurmd": false, "home": true, "data": true, "scratch": false,
+		"ip-forward": true, "tcp-rmem": true, "swappiness": false, "checklist": true,
+		"port-open": true, "port-closed": false, "true": true, "exit-3": false, "expect-3": true,
+		"containerd": true, "docker": false,
+	}
+	for _, r := range report.Results {
+		if r.OK != want[r.Name] {
+			t.Errorf("check %s ok = %v, want %v: %s", r.Name, r.OK, want[r.Name], r.Message)
+		}
+		if r.Name == "exit-3" && !strings.Contains(r.Message, "degraded") {
+			t.Errorf("command output missing from %q", r.Message)
+		}
+	}
+	if report.Healthy() || strings.Join(report.Failing, ",") != "exit-3,slurmd" {
+		t.Errorf("failing checks = %v, want exit-3, slurmd", report.Failing)
+	}
+}
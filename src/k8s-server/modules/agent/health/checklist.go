@@ -0,0 +1,125 @@
+// Package health runs the node health checks of the agent, the checks are
+// declared in a JSON checklist.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Check types.
+const (
+	TypeFile    = "file"
+	TypeProcess = "process"
+	TypePort    = "port"
+	TypeMount   = "mount"
+	TypeCommand = "command"
+	TypeSysctl  = "sysctl"
+	TypeCRI     = "cri"
+)
+
+// defaultTimeout bounds port and command checks without a timeout.
+const defaultTimeout = 5 * time.Second
+
+// Check is a declared health check, the fields used depend on the type:
+//
+//	file:    Path exists
+//	process: a process named Process runs, the name is matched against the
+//	         command name and the executable of the command line
+//	port:    a TCP connection to Host (default 127.0.0.1) and Port succeeds
+//	mount:   Path is a mount point, of FSType if it is set
+//	command: Command exits with ExitCode within Timeout
+//	sysctl:  the kernel parameter Key, e.g. net.ipv4.ip_forward, is Value
+//	cri:     the container runtime accepts connections on the unix socket
+//	         Path, the agent fills an empty Path with agent::CRIEndpoint
+//
+// A failing critical check makes the node unhealthy.
+type Check struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Critical bool     `json:"critical"`
+	Path     string   `json:"path,omitempty"`
+	Process  string   `json:"process,omitempty"`
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port,omitempty"`
+	FSType   string   `json:"fsType,omitempty"`
+	Command  []string `json:"command,omitempty"`
+	ExitCode int      `json:"exitCode,omitempty"`
+	Key      string   `json:"key,omitempty"`
+	Value    string   `json:"value,omitempty"`
+	// Timeout is in seconds.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// Checklist is the checklist file.
+type Checklist struct {
+	Checks []Check `json:"checks"`
+}
+
+// LoadChecklist reads and validates the checklist file.
+func LoadChecklist(path string) ([]Check, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list Checklist
+	if err = json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("parse checklist %s failed: %v", path, err)
+	}
+	names := make(map[string]bool, len(list.Checks))
+	for i, c := range list.Checks {
+		if err = c.validate(); err != nil {
+			return nil, fmt.Errorf("checklist %s: check %d: %v", path, i, err)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("checklist %s: duplicate check %q", path, c.Name)
+		}
+		names[c.Name] = true
+	}
+	return list.Checks, nil
+}
+
+func (c Check) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	var missing string
+	switch c.Type {
+	case TypeFile, TypeMount:
+		if c.Path == "" {
+			missing = "path"
+		}
+	case TypeProcess:
+		if c.Process == "" {
+			missing = "process"
+		}
+	case TypePort:
+		if c.Port <= 0 || c.Port > 65535 {
+			return fmt.Errorf("%s: invalid port %d", c.Name, c.Port)
+		}
+	case TypeCommand:
+		if len(c.Command) == 0 {
+			missing = "command"
+		}
+	case TypeSysctl:
+		if c.Key == "" {
+			missing = "key"
+		}
+	case TypeCRI:
+	default:
+		return fmt.Errorf("%s: unknown type %q", c.Name, c.Type)
+	}
+	if missing != "" {
+		return fmt.Errorf("%s: %s check without %s", c.Name, c.Type, missing)
+	}
+	return nil
+}
+
+func (c Check) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/modules/agent/health"
+	"k8s-server/utils/logs"
+)
+
+// HealthKeyPrefix+hostname holds the latest health report of an agent as
+// JSON, it expires like the heartbeat.
+const HealthKeyPrefix = "health:"
+
+// Node metadata of the health checks, an unhealthy node is tainted with
+// TaintUnhealthy and the failing critical checks are annotated.
+const (
+	TaintUnhealthy          = "health.k8s-server/unhealthy"
+	AnnotationFailingChecks = "health.k8s-server/failing"
+)
+
+// RunHealthChecks runs the checklist every HealthCheckInterval until stop is
+// closed. The report is published to Redis and, with agent::TaintUnhealthy,
+// failing critical checks taint the node.
+func (s *Server) RunHealthChecks(stop <-chan struct{}) {
+	interval := conf.HealthCheckInterval()
+	if interval <= 0 {
+		return
+	}
+	path := conf.CheckListFilePath()
+	checks, err := health.LoadChecklist(path)
+	if os.IsNotExist(err) {
+		logs.Info("no checklist %s, health checks disabled", path)
+		return
+	}
+	if err != nil {
+		logs.Error("load checklist failed: %v", err)
+		return
+	}
+	// the runtime check follows the CRI endpoint, docker or containerd
+	for i := range checks {
+		if checks[i].Type == health.TypeCRI && checks[i].Path == "" {
+			checks[i].Path = conf.AgentCRIEndpoint()
+		}
+	}
+	checker := health.NewChecker(conf.AgentProcRoot(), checks)
+	hostname := s.collector.Collect().Hostname
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report := checker.Run(hostname)
+		s.lock.Lock()
+		s.health = &report
+		s.lock.Unlock()
+		if !report.Healthy() {
+			logs.Warn("node unhealthy, failing checks %v", report.Failing)
+		}
+		if err = s.publishHealth(report, HeartbeatMisses*interval); err != nil {
+			logs.Warn("publish health report failed: %v", err)
+		}
+		if conf.AgentTaintUnhealthy() {
+			if err = s.syncTaint(report); err != nil {
+				logs.Error("sync node taint failed: %v", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) publishHealth(report health.Report, ttl time.Duration) error {
+	if s.redisPool == nil {
+		return nil
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	seconds := int(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	conn := s.redisPool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", HealthKeyPrefix+report.Hostname, b, "EX", seconds)
+	return err
+}
+
+// healthReport returns the latest health report.
+func (s *Server) healthReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.lock.Lock()
+	report := s.health
+	s.lock.Unlock()
+	if report == nil {
+		writeError(w, http.StatusNotFound, "no health checks ran yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"k8s-server/conf"
+	"k8s-server/utils/logs"
+)
+
+// Redis keys of the agent registry. Every agent refreshes the hash
+// HeartbeatKeyPrefix+hostname with a TTL of HeartbeatMisses heartbeat
+// intervals and adds its hostname to the AgentsKey set, an agent in the set
+// whose hash expired is offline.
+const (
+	AgentsKey          = "agents"
+	HeartbeatKeyPrefix = "agents:"
+	HeartbeatMisses    = 3
+)
+
+// Heartbeat is the status an agent publishes to the discovery.
+type Heartbeat struct {
+	Hostname  string    `json:"hostname"`
+	Address   string    `json:"address"`
+	MAC       string    `json:"mac"`
+	Port      int       `json:"port"`
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"startedAt"`
+	SentAt    time.Time `json:"sentAt"`
+}
+
+// PublishHeartbeat stores the heartbeat with the given TTL.
+func PublishHeartbeat(conn redis.Conn, hb Heartbeat, ttl time.Duration) error {
+	key := HeartbeatKeyPrefix + hb.Hostname
+	seconds := int(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	conn.Send("MULTI")
+	conn.Send("HMSET", key,
+		"hostname", hb.Hostname,
+		"address", hb.Address,
+		"mac", hb.MAC,
+		"port", hb.Port,
+		"version", hb.Version,
+		"startedAt", hb.StartedAt.UTC().Format(time.RFC3339),
+		"sentAt", hb.SentAt.UTC().Format(time.RFC3339))
+	conn.Send("EXPIRE", key, seconds)
+	conn.Send("SADD", AgentsKey, hb.Hostname)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// ParseHeartbeat decodes the heartbeat hash read with HGETALL.
+func ParseHeartbeat(fields map[string]string) (Heartbeat, error) {
+	hb := Heartbeat{
+		Hostname: fields["hostname"],
+		Address:  fields["address"],
+		MAC:      fields["mac"],
+		Version:  fields["version"],
+	}
+	if hb.Hostname == "" {
+		return hb, fmt.Errorf("heartbeat without hostname")
+	}
+	var err error
+	if hb.Port, err = strconv.Atoi(fields["port"]); err != nil {
+		return hb, fmt.Errorf("heartbeat of %s has invalid port %q", hb.Hostname, fields["port"])
+	}
+	if hb.StartedAt, err = time.Parse(time.RFC3339, fields["startedAt"]); err != nil {
+		return hb, fmt.Errorf("heartbeat of %s has invalid startedAt: %v", hb.Hostname, err)
+	}
+	if hb.SentAt, err = time.Parse(time.RFC3339, fields["sentAt"]); err != nil {
+		return hb, fmt.Errorf("heartbeat of %s has invalid sentAt: %v", hb.Hostname, err)
+	}
+	return hb, nil
+}
+
+// RunHeartbeat publishes the heartbeat to the discovery every
+// HeartbeatInterval until stop is closed. The address on the management
+// network is looked up once, the agent is restarted when the node is
+// readdressed. The first published heartbeat confirms a pending update. No
+// heartbeat is published if the interval is not positive.
+func (s *Server) RunHeartbeat(stop <-chan struct{}) {
+	interval := conf.HeartbeatInterval()
+	if interval <= 0 {
+		logs.Warn("heartbeat disabled, agent::HeartBeatInterval is %v", interval)
+	}
+	if s.discovery == nil || interval <= 0 {
+		s.confirmUpdate()
+		return
+	}
+	info := s.collector.Collect()
+	hb := Heartbeat{
+		Hostname:  info.Hostname,
+		Address:   info.MgmtIP,
+		MAC:       info.MgmtMAC,
+		Port:      conf.AgentServerPort(),
+		Version:   BuildVersion(),
+		StartedAt: s.startedAt,
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		hb.SentAt = time.Now()
+		if err := s.discovery.Heartbeat(hb.Instance(), HeartbeatMisses*interval); err != nil {
+			logs.Warn("publish heartbeat failed: %v", err)
+		} else {
+			s.confirmUpdate()
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
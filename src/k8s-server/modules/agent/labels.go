@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s-server/modules/agent/sysinfo"
+)
+
+// Node labels and annotations published by the agent, every key under
+// topologyPrefix is owned by the agent and removed when no longer reported.
+const (
+	topologyPrefix     = "topology.k8s-server/"
+	LabelNUMANodes     = topologyPrefix + "numa-nodes"
+	AnnotationTopology = topologyPrefix + "numa"
+)
+
+// labeledFlags are the CPU flags published as labels, the ones pods are
+// usually built against.
+var labeledFlags = []string{
+	"avx", "avx2", "avx512f", "avx512bw", "avx512cd", "avx512dq", "avx512vl",
+	"avx512_vnni", "avx512_bf16", "amx_tile", "fma", "sse4_2", "aes", "sha_ni",
+}
+
+// numaAnnotation is the per-node layout published in AnnotationTopology.
+type numaAnnotation struct {
+	ID        int              `json:"id"`
+	CPUs      string           `json:"cpus"`
+	MemoryKB  int64            `json:"memoryKB"`
+	HugePages map[string]int64 `json:"hugepages,omitempty"`
+	Distances []int            `json:"distances,omitempty"`
+}
+
+// topologyMetadata returns the labels and annotations describing the CPU
+// topology, labels only hold short values usable in node selectors and
+// affinities, the full NUMA layout goes to an annotation.
+func topologyMetadata(cpu sysinfo.CPU) (labels, annotations map[string]string) {
+	labels = map[string]string{
+		LabelNUMANodes: strconv.Itoa(len(cpu.NUMANodes)),
+	}
+	flags := make(map[string]bool, len(cpu.Flags))
+	for _, f := range cpu.Flags {
+		flags[f] = true
+	}
+	for _, f := range labeledFlags {
+		if flags[f] {
+			labels[topologyPrefix+"cpu-"+strings.Replace(f, "_", "-", -1)] = "true"
+		}
+	}
+	for _, pool := range cpu.HugePages {
+		if pool.Total > 0 {
+			labels[topologyPrefix+"hugepages-"+pageSizeName(pool.PageSize)] = strconv.FormatInt(pool.Total, 10)
+		}
+	}
+
+	nodes := make([]numaAnnotation, 0, len(cpu.NUMANodes))
+	for _, n := range cpu.NUMANodes {
+		a := numaAnnotation{ID: n.ID, CPUs: n.CPUList, MemoryKB: n.MemTotal, Distances: n.Distances}
+		for _, pool := range n.HugePages {
+			if a.HugePages == nil {
+				a.HugePages = make(map[string]int64)
+			}
+			a.HugePages[pageSizeName(pool.PageSize)] = pool.Total
+		}
+		nodes = append(nodes, a)
+	}
+	b, _ := json.Marshal(nodes)
+	annotations = map[string]string{AnnotationTopology: string(b)}
+	return labels, annotations
+}
+
+// metadataPatch returns the JSON merge patch turning the current labels and
+// annotations into the desired ones, agent owned keys that are no longer
+// desired are removed. ok is false if nothing changes.
+func metadataPatch(currentLabels, currentAnnotations, labels, annotations map[string]string) ([]byte, bool) {
+	labelChanges := diffOwned(currentLabels, labels)
+	annotationChanges := diffOwned(currentAnnotations, annotations)
+	if len(labelChanges) == 0 && len(annotationChanges) == 0 {
+		return nil, false
+	}
+	metadata := make(map[string]interface{})
+	if len(labelChanges) > 0 {
+		metadata["labels"] = labelChanges
+	}
+	if len(annotationChanges) > 0 {
+		metadata["annotations"] = annotationChanges
+	}
+	b, _ := json.Marshal(map[string]interface{}{"metadata": metadata})
+	return b, true
+}
+
+// diffOwned returns the changes from current to desired, a nil value deletes
+// the key.
+func diffOwned(current, desired map[string]string) map[string]interface{} {
+	changes := make(map[string]interface{})
+	for k, v := range desired {
+		if old, ok := current[k]; !ok || old != v {
+			changes[k] = v
+		}
+	}
+	for k := range current {
+		if _, ok := desired[k]; !ok && strings.HasPrefix(k, topologyPrefix) {
+			changes[k] = nil
+		}
+	}
+	return changes
+}
+
+// pageSizeName formats a hugepage size in kB the way Kubernetes names
+// hugepage resources, e.g. 2048 is "2Mi".
+func pageSizeName(kb int64) string {
+	switch {
+	case kb%(1<<20) == 0:
+		return fmt.Sprintf("%dGi", kb>>20)
+	case kb%(1<<10) == 0:
+		return fmt.Sprintf("%dMi", kb>>10)
+	}
+	return fmt.Sprintf("%dKi", kb)
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s-server/modules/agent/sysinfo"
+)
+
+func TestTopologyLabels(t *testing.T) {
+	collector, err := sysinfo.NewCollector("sysinfo/testdata/proc", "sysinfo/testdata/sys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, annotations := topologyMetadata(collector.Collect().CPU)
+
+	for k, v := range map[string]string{
+		LabelNUMANodes:                      "2",
+		"topology.k8s-server/cpu-avx512f":   "true",
+		"topology.k8s-server/cpu-avx2":      "true",
+		"topology.k8s-server/hugepages-2Mi": "1024",
+		"topology.k8s-server/hugepages-1Gi": "2",
+	} {
+		if labels[k] != v {
+			t.Errorf("label %s = %q, want %q", k, labels[k], v)
+		}
+	}
+	var nodes []numaAnnotation
+	if err = json.Unmarshal([]byte(annotations[AnnotationTopology]), &nodes); err != nil {
+		t.Fatalf("decode topology annotation failed: %v", err)
+	}
+	if len(nodes) != 2 || nodes[1].CPUs != "2-3" || nodes[0].HugePages["2Mi"] != 512 {
+		t.Errorf("unexpected topology annotation %+v", nodes)
+	}
+}
+
+func TestMetadataPatch(t *testing.T) {
+	current := map[string]string{
+		"kubernetes.io/hostname":          "node1",
+		LabelNUMANodes:                    "2",
+		"topology.k8s-server/cpu-avx512f": "true",
+	}
+	desired := map[string]string{LabelNUMANodes: "2", "topology.k8s-server/cpu-avx2": "true"}
+	patch, ok := metadataPatch(current, nil, desired, nil)
+	if !ok {
+		t.Fatal("changed labels produced no patch")
+	}
+	want := `{"metadata":{"labels":{"topology.k8s-server/cpu-avx2":"true","topology.k8s-server/cpu-avx512f":null}}}`
+	if string(patch) != want {
+		t.Errorf("patch = %s, want %s", patch, want)
+	}
+	if _, ok = metadataPatch(desired, nil, desired, nil); ok {
+		t.Error("unchanged labels produced a patch")
+	}
+}
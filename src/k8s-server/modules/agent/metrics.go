@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"strconv"
+
+	"k8s-server/modules/agent/sysinfo"
+	"k8s-server/utils/metrics"
+)
+
+const metricPrefix = "k8s_agent_"
+
+// nodeMetrics converts the node information and the latest usage sample into
+// metric families, the sample is nil until the sampler ran once.
+func nodeMetrics(info sysinfo.Info, sample *sysinfo.Sample) []metrics.Family {
+	var families []*metrics.Family
+	add := func(name, help, typ string) *metrics.Family {
+		f := &metrics.Family{Name: metricPrefix + name, Help: help, Type: typ}
+		families = append(families, f)
+		return f
+	}
+	gauge := func(name, help string, value float64) {
+		add(name, help, metrics.Gauge).Add(value, nil)
+	}
+	kb := func(v int) float64 { return float64(v) * 1024 }
+
+	add("cpu_info", "CPU model of the node.", metrics.Gauge).Add(1, metrics.Labels{
+		"model": info.CPU.Model, "vendor": info.CPU.Vendor,
+	})
+	gauge("cpu_sockets", "Number of CPU sockets.", float64(info.CPU.CPU))
+	gauge("cpu_cores", "Number of physical CPU cores.", float64(info.CPU.Core))
+	gauge("cpu_threads", "Number of logical CPUs.", float64(info.CPU.Threads))
+	if sample != nil {
+		gauge("cpu_utilisation_ratio", "Busy ratio of all CPUs over the last sample interval.", sample.CPU/100)
+		f := add("cpu_core_utilisation_ratio", "Busy ratio of each CPU over the last sample interval.", metrics.Gauge)
+		for i, usage := range sample.Cores {
+			f.Add(usage/100, metrics.Labels{"cpu": strconv.Itoa(i)})
+		}
+		gauge("load1", "1 minute load average.", sample.Load.Load1)
+		gauge("load5", "5 minute load average.", sample.Load.Load5)
+		gauge("load15", "15 minute load average.", sample.Load.Load15)
+	}
+
+	mem := info.Memory
+	gauge("memory_total_bytes", "Total usable memory.", kb(mem.MemTotal))
+	gauge("memory_free_bytes", "Unused memory.", kb(mem.MemFree))
+	gauge("memory_available_bytes", "Memory available without swapping, including reclaimable cache.", kb(mem.MemAvailable))
+	gauge("memory_buffers_bytes", "Memory used by block device buffers.", kb(mem.Buffers))
+	gauge("memory_cached_bytes", "Memory used by the page cache.", kb(mem.Cached))
+	gauge("swap_total_bytes", "Total swap space.", kb(mem.SwapTotal))
+	gauge("swap_free_bytes", "Unused swap space.", kb(mem.SwapFree))
+
+	netInfo := add("network_info", "Addresses of the network interfaces.", metrics.Gauge)
+	for _, n := range info.Networks {
+		netInfo.Add(1, metrics.Labels{"device": n.Name, "address": n.IP, "mac": n.MACAddress})
+	}
+	netCounters := []struct {
+		name, help string
+		value      func(sysinfo.NetworkIO) uint64
+	}{
+		{"network_receive_bytes_total", "Bytes received.", func(n sysinfo.NetworkIO) uint64 { return n.RxBytes }},
+		{"network_receive_packets_total", "Packets received.", func(n sysinfo.NetworkIO) uint64 { return n.RxPackets }},
+		{"network_receive_errors_total", "Receive errors.", func(n sysinfo.NetworkIO) uint64 { return n.RxErrors }},
+		{"network_receive_drop_total", "Received packets dropped.", func(n sysinfo.NetworkIO) uint64 { return n.RxDropped }},
+		{"network_transmit_bytes_total", "Bytes transmitted.", func(n sysinfo.NetworkIO) uint64 { return n.TxBytes }},
+		{"network_transmit_packets_total", "Packets transmitted.", func(n sysinfo.NetworkIO) uint64 { return n.TxPackets }},
+		{"network_transmit_errors_total", "Transmit errors.", func(n sysinfo.NetworkIO) uint64 { return n.TxErrors }},
+		{"network_transmit_drop_total", "Transmitted packets dropped.", func(n sysinfo.NetworkIO) uint64 { return n.TxDropped }},
+	}
+	for _, c := range netCounters {
+		f := add(c.name, c.help, metrics.Counter)
+		for _, n := range info.NetworkIO {
+			f.Add(float64(c.value(n)), metrics.Labels{"device": n.Name})
+		}
+	}
+
+	diskIO := []struct {
+		name, help string
+		value      func(sysinfo.DiskIO) float64
+	}{
+		{"disk_reads_completed_total", "Reads completed.", func(d sysinfo.DiskIO) float64 { return float64(d.Reads) }},
+		{"disk_writes_completed_total", "Writes completed.", func(d sysinfo.DiskIO) float64 { return float64(d.Writes) }},
+		{"disk_read_bytes_total", "Bytes read.", func(d sysinfo.DiskIO) float64 { return float64(d.ReadBytes) }},
+		{"disk_written_bytes_total", "Bytes written.", func(d sysinfo.DiskIO) float64 { return float64(d.WriteBytes) }},
+		{"disk_io_time_seconds_total", "Time spent doing IO.", func(d sysinfo.DiskIO) float64 { return float64(d.IOTime) / 1000 }},
+	}
+	for _, c := range diskIO {
+		f := add(c.name, c.help, metrics.Counter)
+		for _, d := range info.DiskIO {
+			f.Add(c.value(d), metrics.Labels{"device": d.Name})
+		}
+	}
+	fsSize := add("filesystem_size_bytes", "Filesystem size.", metrics.Gauge)
+	fsAvail := add("filesystem_avail_bytes", "Filesystem space available to non-root users.", metrics.Gauge)
+	fsFiles := add("filesystem_files", "Filesystem inodes.", metrics.Gauge)
+	fsFilesFree := add("filesystem_files_free", "Free filesystem inodes.", metrics.Gauge)
+	for _, fs := range info.FileSystems {
+		labels := metrics.Labels{"device": fs.Device, "mountpoint": fs.MountPoint, "fstype": fs.Type}
+		fsSize.Add(float64(fs.Total), labels)
+		fsAvail.Add(float64(fs.Available), labels)
+		fsFiles.Add(float64(fs.Inodes), labels)
+		fsFilesFree.Add(float64(fs.InodesFree), labels)
+	}
+
+	gpuMetrics(info.GPU, add)
+
+	result := make([]metrics.Family, len(families))
+	for i, f := range families {
+		result[i] = *f
+	}
+	return result
+}
+
+// gpuMetrics adds the GPU families, readings the GPU does not support are
+// left out.
+func gpuMetrics(gpu sysinfo.GPU, add func(name, help, typ string) *metrics.Family) {
+	gpuInfo := add("gpu_info", "GPU model, UUID, PCI bus id and driver version.", metrics.Gauge)
+	readings := []struct {
+		name, help string
+		value      func(sysinfo.GPUDevice) *float64
+	}{
+		{"gpu_temperature_celsius", "GPU core temperature.", func(d sysinfo.GPUDevice) *float64 { return d.Temperature }},
+		{"gpu_memory_temperature_celsius", "GPU memory temperature.", func(d sysinfo.GPUDevice) *float64 { return d.MemoryTemperature }},
+		{"gpu_fan_speed_ratio", "Fan speed as a ratio of its maximum.", func(d sysinfo.GPUDevice) *float64 { return d.FanSpeed }},
+		{"gpu_memory_total_bytes", "Total frame buffer memory.", func(d sysinfo.GPUDevice) *float64 { return d.MemoryTotal }},
+		{"gpu_memory_used_bytes", "Used frame buffer memory.", func(d sysinfo.GPUDevice) *float64 { return d.MemoryUsed }},
+		{"gpu_memory_free_bytes", "Free frame buffer memory.", func(d sysinfo.GPUDevice) *float64 { return d.MemoryFree }},
+		{"gpu_utilisation_ratio", "Busy ratio of the GPU.", func(d sysinfo.GPUDevice) *float64 { return d.Utilization }},
+		{"gpu_memory_utilisation_ratio", "Busy ratio of the GPU memory.", func(d sysinfo.GPUDevice) *float64 { return d.MemoryUtilization }},
+		{"gpu_encoder_utilisation_ratio", "Busy ratio of the video encoder.", func(d sysinfo.GPUDevice) *float64 { return d.EncoderUtilization }},
+		{"gpu_decoder_utilisation_ratio", "Busy ratio of the video decoder.", func(d sysinfo.GPUDevice) *float64 { return d.DecoderUtilization }},
+		{"gpu_power_draw_watts", "Power drawn by the board.", func(d sysinfo.GPUDevice) *float64 { return d.PowerDraw }},
+		{"gpu_power_limit_watts", "Enforced power limit.", func(d sysinfo.GPUDevice) *float64 { return d.PowerLimit }},
+		{"gpu_ecc_volatile_corrected_errors", "Corrected ECC errors since the driver loaded.", func(d sysinfo.GPUDevice) *float64 { return d.ECCVolatileSingleBit }},
+		{"gpu_ecc_volatile_uncorrected_errors", "Uncorrected ECC errors since the driver loaded.", func(d sysinfo.GPUDevice) *float64 { return d.ECCVolatileDoubleBit }},
+		{"gpu_ecc_aggregate_corrected_errors", "Corrected ECC errors over the GPU lifetime.", func(d sysinfo.GPUDevice) *float64 { return d.ECCAggregateSingleBit }},
+		{"gpu_ecc_aggregate_uncorrected_errors", "Uncorrected ECC errors over the GPU lifetime.", func(d sysinfo.GPUDevice) *float64 { return d.ECCAggregateDoubleBit }},
+	}
+	families := make([]*metrics.Family, len(readings))
+	for i, r := range readings {
+		families[i] = add(r.name, r.help, metrics.Gauge)
+	}
+	clocks := add("gpu_clock_hertz", "Current clock frequencies.", metrics.Gauge)
+	processes := add("gpu_process_memory_used_bytes", "GPU memory used by each process, with the pod it runs in.", metrics.Gauge)
+
+	for _, d := range gpu.Devices {
+		index := strconv.Itoa(d.Index)
+		gpuInfo.Add(1, metrics.Labels{
+			"gpu": index, "name": d.Name, "uuid": d.UUID, "pci_bus_id": d.BusID, "driver_version": gpu.DriverVersion,
+		})
+		for i, r := range readings {
+			if v := r.value(d); v != nil {
+				families[i].Add(*v, metrics.Labels{"gpu": index})
+			}
+		}
+		for _, c := range []struct {
+			clock string
+			value *float64
+		}{
+			{"graphics", d.GraphicsClock},
+			{"sm", d.SMClock},
+			{"memory", d.MemoryClock},
+			{"video", d.VideoClock},
+		} {
+			if c.value != nil {
+				clocks.Add(*c.value, metrics.Labels{"gpu": index, "clock": c.clock})
+			}
+		}
+		for _, p := range d.Processes {
+			if p.UsedMemory != nil {
+				processes.Add(*p.UsedMemory, metrics.Labels{
+					"gpu": index, "pid": strconv.Itoa(p.PID), "process": p.Name, "pod_uid": p.PodUID,
+				})
+			}
+		}
+	}
+}
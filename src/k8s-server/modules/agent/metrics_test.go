@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s-server/modules/agent/sysinfo"
+	"k8s-server/utils/metrics"
+)
+
+func TestNodeMetrics(t *testing.T) {
+	collector, err := sysinfo.NewCollector("sysinfo/testdata/proc", "sysinfo/testdata/sys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := collector.Collect()
+	value := func(v float64) *float64 { return &v }
+	info.GPU = sysinfo.GPU{
+		DriverVersion: "418.87",
+		Devices: []sysinfo.GPUDevice{{
+			Name:        "Tesla V100",
+			UUID:        "GPU-4c8a3b1e",
+			BusID:       "00000000:3B:00.0",
+			Temperature: value(45),
+			MemoryUsed:  value(1 << 30),
+			SMClock:     value(1.53e9),
+			PowerDraw:   value(187.43),
+			Processes: []sysinfo.GPUProcess{
+				{PID: 4242, Name: "python", UsedMemory: value(1 << 30), PodUID: "0f5c3a4e-8d2b-11e9-b3f5-0cc47a6c2f10"},
+			},
+		}},
+	}
+	sample := &sysinfo.Sample{CPU: 50, Cores: []float64{100, 0}}
+
+	var b bytes.Buffer
+	if err = metrics.Write(&b, nodeMetrics(info, sample)); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		`k8s_agent_cpu_utilisation_ratio 0.5`,
+		`k8s_agent_cpu_core_utilisation_ratio{cpu="0"} 1`,
+		`k8s_agent_memory_available_bytes 1.2414418944e+10`,
+		`k8s_agent_network_receive_bytes_total{device="eth0"} 9.87654321e+08`,
+		`k8s_agent_gpu_info{driver_version="418.87",gpu="0",name="Tesla V100",pci_bus_id="00000000:3B:00.0",uuid="GPU-4c8a3b1e"} 1`,
+		`k8s_agent_gpu_power_draw_watts{gpu="0"} 187.43`,
+		`k8s_agent_gpu_process_memory_used_bytes{gpu="0",pid="4242",pod_uid="0f5c3a4e-8d2b-11e9-b3f5-0cc47a6c2f10",process="python"} 1.073741824e+09`,
+		`k8s_agent_gpu_temperature_celsius{gpu="0"} 45`,
+		`k8s_agent_gpu_memory_used_bytes{gpu="0"} 1.073741824e+09`,
+		`k8s_agent_gpu_clock_hertz{clock="sm",gpu="0"} 1.53e+09`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics miss %q", line)
+		}
+	}
+	for _, name := range []string{"k8s_agent_gpu_fan_speed_ratio{", "k8s_agent_gpu_memory_temperature_celsius{", `device="lo"`} {
+		if strings.Contains(out, name) {
+			t.Errorf("metrics contain %q", name)
+		}
+	}
+}
+
+func TestParseGPUValue(t *testing.T) {
+	for s, want := range map[string]float64{
+		"45 C": 45, "1024 MiB": 1 << 30, "30 %": 0.3, "1530 MHz": 1.53e9, "250.00 W": 250, "7": 7,
+	} {
+		if v, ok := sysinfo.ParseGPUValue(s); !ok || v != want {
+			t.Errorf("ParseGPUValue(%q) = %v, %v, want %v", s, v, ok, want)
+		}
+	}
+	for _, s := range []string{"N/A", "", "12 parsecs", "Enabled"} {
+		if _, ok := sysinfo.ParseGPUValue(s); ok {
+			t.Errorf("ParseGPUValue(%q) succeeded", s)
+		}
+	}
+}
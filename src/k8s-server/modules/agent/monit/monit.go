@@ -0,0 +1,316 @@
+// Package monit supervises the node services through monit-style scripts.
+// Every executable in the scripts directory is a service named after the
+// file, it is invoked with one of the actions status, start and stop and
+// reports success by exiting with 0.
+package monit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s-server/utils/logs"
+)
+
+// Script actions.
+const (
+	ActionStatus = "status"
+	ActionStart  = "start"
+	ActionStop   = "stop"
+)
+
+// Service states.
+const (
+	StateRunning = "running"
+	// StateFailed is a service that is down and restarted after a backoff.
+	StateFailed = "failed"
+	// StateStopped is a service stopped through the supervisor, it is not
+	// restarted until it is started again.
+	StateStopped = "stopped"
+	StateUnknown = "unknown"
+)
+
+// Restart backoff, doubled after every failed restart.
+const (
+	InitialBackoff = 10 * time.Second
+	MaxBackoff     = 10 * time.Minute
+)
+
+// Service is the supervised state of a service.
+type Service struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Restarts int       `json:"restarts"`
+	// Failures counts the restarts since the service was last seen
+	// running, the restart backoff grows with it.
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastCheck   time.Time `json:"lastCheck"`
+	NextRestart time.Time `json:"nextRestart,omitempty"`
+}
+
+// Supervisor watches the services of the scripts directory.
+type Supervisor struct {
+	dir     string
+	timeout time.Duration
+	// run runs an action of a script, now is the clock
+	run func(script, action string, timeout time.Duration) error
+	now func() time.Time
+
+	services map[string]*Service
+	// actions serializes the script invocations of the same service
+	actions map[string]*sync.Mutex
+	lock    sync.Mutex
+}
+
+// NewSupervisor returns the supervisor of the scripts in dir, every action
+// is killed after timeout.
+func NewSupervisor(dir string, timeout time.Duration) *Supervisor {
+	return &Supervisor{
+		dir:      dir,
+		timeout:  timeout,
+		run:      runScript,
+		now:      time.Now,
+		services: make(map[string]*Service),
+		actions:  make(map[string]*sync.Mutex),
+	}
+}
+
+// Run checks the services every interval until stop is closed, notify is
+// called with the service states after every round.
+func (s *Supervisor) Run(interval time.Duration, stop <-chan struct{}, notify func([]Service)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.Check()
+		if notify != nil {
+			notify(s.Services())
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check discovers the scripts and checks the status of every service, a
+// service that is down is restarted once its backoff passed.
+func (s *Supervisor) Check() {
+	names, err := s.discover()
+	if err != nil {
+		logs.Error("discover service scripts failed: %v", err)
+		return
+	}
+	for _, name := range names {
+		s.check(name)
+	}
+}
+
+// discover adds the services of new scripts and forgets removed ones.
+func (s *Supervisor) discover() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if f.IsDir() || f.Mode()&0111 == 0 || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	found := make(map[string]bool, len(names))
+	for _, name := range names {
+		found[name] = true
+		if _, ok := s.services[name]; !ok {
+			s.services[name] = &Service{Name: name, State: StateUnknown, Since: s.now()}
+			s.actions[name] = &sync.Mutex{}
+			logs.Info("supervise service %s", name)
+		}
+	}
+	for name := range s.services {
+		if !found[name] {
+			delete(s.services, name)
+			delete(s.actions, name)
+			logs.Info("service %s script removed, no longer supervised", name)
+		}
+	}
+	return names, nil
+}
+
+func (s *Supervisor) check(name string) {
+	mu := s.actionLock(name)
+	if mu == nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	s.lock.Lock()
+	svc, ok := s.services[name]
+	stopped := ok && svc.State == StateStopped
+	s.lock.Unlock()
+	if !ok || stopped {
+		return
+	}
+	err := s.run(s.script(name), ActionStatus, s.timeout)
+	now := s.now()
+	s.lock.Lock()
+	svc.LastCheck = now
+	if err == nil {
+		if svc.State != StateRunning {
+			logs.Info("service %s is running", name)
+			s.setState(svc, StateRunning, now)
+		}
+		svc.Failures, svc.LastError, svc.NextRestart = 0, "", time.Time{}
+		s.lock.Unlock()
+		return
+	}
+	if svc.State != StateFailed {
+		logs.Warn("service %s is down: %v", name, err)
+		s.setState(svc, StateFailed, now)
+		svc.LastError = err.Error()
+	}
+	due := !now.Before(svc.NextRestart)
+	s.lock.Unlock()
+	if !due {
+		return
+	}
+
+	err = s.run(s.script(name), ActionStart, s.timeout)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	svc.Restarts++
+	if err != nil {
+		svc.Failures++
+		svc.LastError = fmt.Sprintf("restart failed: %v", err)
+		svc.NextRestart = now.Add(backoff(svc.Failures))
+		logs.Error("restart service %s failed, retry after %s: %v", name,
+			svc.NextRestart.Format(time.RFC3339), err)
+		return
+	}
+	// the next status check confirms the restart, a service that does not
+	// stay up is restarted after the backoff
+	svc.Failures++
+	svc.NextRestart = now.Add(backoff(svc.Failures))
+	logs.Info("service %s restarted", name)
+}
+
+// Start starts a service and resumes supervising a stopped one.
+func (s *Supervisor) Start(name string) error {
+	return s.action(name, ActionStart)
+}
+
+// Stop stops a service, it is not restarted until it is started again.
+func (s *Supervisor) Stop(name string) error {
+	return s.action(name, ActionStop)
+}
+
+func (s *Supervisor) action(name, action string) error {
+	mu := s.actionLock(name)
+	if mu == nil {
+		return fmt.Errorf("service %s not found", name)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	err := s.run(s.script(name), action, s.timeout)
+	now := s.now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	svc, ok := s.services[name]
+	if !ok {
+		return fmt.Errorf("service %s not found", name)
+	}
+	if err != nil {
+		svc.LastError = fmt.Sprintf("%s failed: %v", action, err)
+		return fmt.Errorf("%s service %s failed: %v", action, name, err)
+	}
+	svc.Failures, svc.LastError, svc.NextRestart = 0, "", time.Time{}
+	if action == ActionStop {
+		s.setState(svc, StateStopped, now)
+	} else {
+		s.setState(svc, StateRunning, now)
+	}
+	logs.Info("service %s %s succeeded", name, action)
+	return nil
+}
+
+// Services returns the service states sorted by name.
+func (s *Supervisor) Services() []Service {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	services := make([]Service, 0, len(s.services))
+	for _, svc := range s.services {
+		services = append(services, *svc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+func (s *Supervisor) actionLock(name string) *sync.Mutex {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.actions[name]
+}
+
+func (s *Supervisor) setState(svc *Service, state string, now time.Time) {
+	svc.State, svc.Since = state, now
+}
+
+func (s *Supervisor) script(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// backoff returns the delay before the next restart after failures failed
+// restarts.
+func backoff(failures int) time.Duration {
+	d := InitialBackoff
+	for i := 1; i < failures && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+	return d
+}
+
+// runScript runs an action of a script, the output of a failed action is
+// part of the error. The script runs in its own process group, which is
+// killed on timeout so children holding the output open do not block.
+func runScript(script, action string, timeout time.Duration) error {
+	var out bytes.Buffer
+	cmd := exec.Command(script, action)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	err := cmd.Wait()
+	if !timer.Stop() {
+		return fmt.Errorf("%s timed out after %s", action, timeout)
+	}
+	if err != nil {
+		if b := bytes.TrimSpace(out.Bytes()); len(b) > 0 {
+			if len(b) > 256 {
+				b = b[:256]
+			}
+			return fmt.Errorf("%v: %s", err, b)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package monit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServices answers the script actions from a table of running services.
+type fakeServices struct {
+	running   map[string]bool
+	startFail map[string]bool
+	actions   []string
+}
+
+func (f *fakeServices) run(script, action string, timeout time.Duration) error {
+	name := filepath.Base(script)
+	f.actions = append(f.actions, name+" "+action)
+	switch action {
+	case ActionStatus:
+		if !f.running[name] {
+			return fmt.Errorf("exit status 3")
+		}
+	case ActionStart:
+		if f.startFail[name] {
+			return fmt.Errorf("exit status 1")
+		}
+		f.running[name] = true
+	case ActionStop:
+		f.running[name] = false
+	}
+	return nil
+}
+
+func scriptsDir(t *testing.T, scripts map[string]os.FileMode) (string, func()) {
+	dir, err := ioutil.TempDir("", "monit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range scripts {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func state(s *Supervisor, name string) Service {
+	for _, svc := range s.Services() {
+		if svc.Name == name {
+			return svc
+		}
+	}
+	return Service{}
+}
+
+func TestSupervisor(t *testing.T) {
+	dir, cleanup := scriptsDir(t, map[string]os.FileMode{
+		"kubelet": 0755, "containerd": 0755, "README": 0644, ".hidden": 0755,
+	})
+	defer cleanup()
+	fake := &fakeServices{running: map[string]bool{"kubelet": true}, startFail: map[string]bool{}}
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	s := NewSupervisor(dir, time.Second)
+	s.run = fake.run
+	s.now = func() time.Time { return now }
+
+	s.Check()
+	if services := s.Services(); len(services) != 2 {
+		t.Fatalf("supervised %+v, want containerd and kubelet", services)
+	}
+	if svc := state(s, "kubelet"); svc.State != StateRunning || svc.Restarts != 0 {
+		t.Errorf("kubelet = %+v", svc)
+	}
+	if svc := state(s, "containerd"); svc.Restarts != 1 || !fake.running["containerd"] {
+		t.Errorf("containerd was not restarted: %+v", svc)
+	}
+
+	// a failing start backs off exponentially
+	fake.running["containerd"] = false
+	fake.startFail["containerd"] = true
+	now = now.Add(InitialBackoff)
+	s.Check()
+	svc := state(s, "containerd")
+	if svc.State != StateFailed || svc.Failures != 2 || !svc.NextRestart.Equal(now.Add(2*InitialBackoff)) {
+		t.Errorf("containerd after failed restart = %+v", svc)
+	}
+	fake.actions = nil
+	now = now.Add(InitialBackoff)
+	s.Check()
+	if strings.Contains(strings.Join(fake.actions, ","), "containerd start") {
+		t.Errorf("containerd restarted before its backoff: %v", fake.actions)
+	}
+	fake.startFail["containerd"] = false
+	now = now.Add(InitialBackoff)
+	s.Check()
+	now = now.Add(time.Second)
+	s.Check()
+	if svc = state(s, "containerd"); svc.State != StateRunning || svc.Failures != 0 || svc.Restarts != 3 {
+		t.Errorf("containerd after recovery = %+v", svc)
+	}
+
+	// a stopped service is not restarted until it is started
+	if err := s.Stop("kubelet"); err != nil {
+		t.Fatal(err)
+	}
+	s.Check()
+	if svc = state(s, "kubelet"); svc.State != StateStopped || fake.running["kubelet"] {
+		t.Errorf("stopped kubelet = %+v", svc)
+	}
+	if err := s.Start("kubelet"); err != nil {
+		t.Fatal(err)
+	}
+	if svc = state(s, "kubelet"); svc.State != StateRunning || !fake.running["kubelet"] {
+		t.Errorf("started kubelet = %+v", svc)
+	}
+	if err := s.Start("slurmd"); err == nil {
+		t.Error("started an unknown service")
+	}
+
+	os.Remove(filepath.Join(dir, "kubelet"))
+	s.Check()
+	if services := s.Services(); len(services) != 1 || services[0].Name != "containerd" {
+		t.Errorf("removed script still supervised: %+v", services)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1: InitialBackoff, 2: 2 * InitialBackoff, 4: 8 * InitialBackoff, 20: MaxBackoff,
+	} {
+		if got := backoff(failures); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	dir, cleanup := scriptsDir(t, nil)
+	defer cleanup()
+	script := filepath.Join(dir, "svc")
+	content := "#!/bin/sh\ncase $1 in status) echo down; exit 3 ;; start) sleep 5 ;; esac\n"
+	if err := ioutil.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := runScript(script, ActionStop, time.Second); err != nil {
+		t.Errorf("stop failed: %v", err)
+	}
+	if err := runScript(script, ActionStatus, time.Second); err == nil || !strings.Contains(err.Error(), "down") {
+		t.Errorf("status returned %v, want the script output", err)
+	}
+	if err := runScript(script, ActionStart, 100*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("start returned %v, want a timeout", err)
+	}
+}
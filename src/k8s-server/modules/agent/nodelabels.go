@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"k8s-server/conf"
+	"k8s-server/utils/kube"
+	"k8s-server/utils/logs"
+)
+
+// RunNodeLabels publishes the CPU topology to the labels and annotations of
+// the node every agent::LabelInterval until stop is closed.
+func (s *Server) RunNodeLabels(stop <-chan struct{}) {
+	interval := conf.AgentLabelInterval()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.publishNodeLabels(); err != nil {
+			logs.Error("publish node labels failed: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) publishNodeLabels() error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	nodeName := conf.AgentNodeName()
+	node, err := cs.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	labels, annotations := topologyMetadata(s.collector.Collect().CPU)
+	patch, ok := metadataPatch(node.Labels, node.Annotations, labels, annotations)
+	if !ok {
+		return nil
+	}
+	_, err = cs.CoreV1().Nodes().Patch(nodeName, types.MergePatchType, patch)
+	if err == nil {
+		logs.Info("node %s topology labels updated", nodeName)
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s-server/conf"
+	"k8s-server/modules/agent/health"
+	"k8s-server/utils/kube"
+	"k8s-server/utils/logs"
+)
+
+// syncTaint taints the node NoSchedule while critical checks fail and
+// removes the taint once they pass, other taints are kept.
+func (s *Server) syncTaint(report health.Report) error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	nodeName := conf.AgentNodeName()
+	node, err := cs.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	failing := strings.Join(report.Failing, ",")
+	tainted := false
+	taints := make([]corev1.Taint, 0, len(node.Spec.Taints)+1)
+	for _, t := range node.Spec.Taints {
+		if t.Key == TaintUnhealthy {
+			tainted = true
+			continue
+		}
+		taints = append(taints, t)
+	}
+	if report.Healthy() {
+		if !tainted && node.Annotations[AnnotationFailingChecks] == "" {
+			return nil
+		}
+		delete(node.Annotations, AnnotationFailingChecks)
+	} else {
+		if tainted && node.Annotations[AnnotationFailingChecks] == failing {
+			return nil
+		}
+		now := metav1.Now()
+		taints = append(taints, corev1.Taint{
+			Key:       TaintUnhealthy,
+			Effect:    corev1.TaintEffectNoSchedule,
+			TimeAdded: &now,
+		})
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string)
+		}
+		node.Annotations[AnnotationFailingChecks] = failing
+	}
+	node.Spec.Taints = taints
+	if _, err = cs.CoreV1().Nodes().Update(node); err != nil {
+		return err
+	}
+	if report.Healthy() {
+		logs.Info("node %s healthy again, taint %s removed", nodeName, TaintUnhealthy)
+	} else {
+		logs.Warn("node %s tainted %s, failing checks %s", nodeName, TaintUnhealthy, failing)
+	}
+	return nil
+}
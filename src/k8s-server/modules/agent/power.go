@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/utils/logs"
+)
+
+// Power actions of POST /api/v1/power.
+const (
+	PowerOff    = "poweroff"
+	PowerReboot = "reboot"
+)
+
+// powerDelay lets the response reach the server before the host goes down.
+const powerDelay = 2 * time.Second
+
+// PowerRequest is the body of POST /api/v1/power.
+type PowerRequest struct {
+	Action string `json:"action"`
+}
+
+// runPowerCommand runs agent::PowerOffCommand or agent::RebootCommand.
+func runPowerCommand(action string) error {
+	command := conf.AgentPowerOffCommand()
+	if action == PowerReboot {
+		command = conf.AgentRebootCommand()
+	}
+	out, err := exec.Command("/bin/sh", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", command, err, out)
+	}
+	return nil
+}
+
+// powerAction powers off or reboots the host on POST /api/v1/power, the
+// server drains the node before. It responds 202 and runs the action after
+// powerDelay.
+func (s *Server) powerAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if s.power == nil {
+		writeError(w, http.StatusForbidden, "power actions are disabled")
+		return
+	}
+	var req PowerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid power request")
+		return
+	}
+	if req.Action != PowerOff && req.Action != PowerReboot {
+		writeError(w, http.StatusBadRequest, "unknown power action "+req.Action)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, req)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	logs.Critical("power action %s requested by %s", req.Action, r.RemoteAddr)
+	go func() {
+		time.Sleep(powerDelay)
+		if err := s.power(req.Action); err != nil {
+			logs.Critical("power action %s failed: %v", req.Action, err)
+		}
+	}()
+}
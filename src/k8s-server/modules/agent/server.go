@@ -0,0 +1,191 @@
+// Package agent implements the node agent daemon, it serves the node
+// information over HTTP/JSON to the server.
+package agent
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"k8s-server/conf"
+	"k8s-server/modules/agent/containergc"
+	"k8s-server/modules/agent/health"
+	"k8s-server/modules/agent/monit"
+	"k8s-server/modules/agent/sysinfo"
+	"k8s-server/modules/discovery"
+	"k8s-server/utils/logs"
+	"k8s-server/utils/metrics"
+)
+
+// Server is the HTTP/JSON API of the agent.
+type Server struct {
+	collector *sysinfo.Collector
+	sampler   *sysinfo.Sampler
+	redisPool *redis.Pool
+	discovery discovery.Discovery
+	updater   *updater
+	monit     *monit.Supervisor
+	power     func(action string) error
+	token     string
+	addr      string
+	mux       *http.ServeMux
+	startedAt time.Time
+
+	health *health.Report
+	gc     *containergc.Report
+	lock   sync.Mutex
+}
+
+// NewServer returns the agent server listening on AgentServerPort, the
+// reports are published to redisPool and the heartbeat to disc if they are
+// not nil. The overrides of the node are applied to the configuration first.
+func NewServer(redisPool *redis.Pool, disc discovery.Discovery) (*Server, error) {
+	if disc != nil {
+		applyOverrides(disc)
+	}
+	sampler := sysinfo.NewSampler(conf.AgentProcRoot(), conf.AgentSysRoot(), conf.AgentSampleWindow())
+	collector, err := sysinfo.NewCollector(conf.AgentProcRoot(), conf.AgentSysRoot(), sampler)
+	if err != nil {
+		return nil, err
+	}
+	updater, err := newUpdater()
+	if err != nil {
+		return nil, err
+	}
+	s := newServer(collector, sampler, conf.AgentToken(), fmt.Sprintf(":%d", conf.AgentServerPort()))
+	s.redisPool = redisPool
+	s.discovery = disc
+	s.updater = updater
+	s.monit = monit.NewSupervisor(conf.ServicesMonitScriptsDir(), conf.ServiceActionTimeout())
+	if s.power == nil {
+		logs.Warn("agent::Token is not set, power actions and updates are disabled")
+	}
+	return s, nil
+}
+
+func newServer(collector *sysinfo.Collector, sampler *sysinfo.Sampler, token, addr string) *Server {
+	s := &Server{
+		collector: collector,
+		sampler:   sampler,
+		token:     token,
+		addr:      addr,
+		mux:       http.NewServeMux(),
+		startedAt: time.Now(),
+	}
+	// without a token anyone reaching the agent could power off the host
+	if token != "" {
+		s.power = runPowerCommand
+	}
+	s.handle("/api/v1/sysinfo", s.sysinfo)
+	s.handle("/api/v1/samples", s.samples)
+	s.handle("/api/v1/health", s.healthReport)
+	s.handle("/api/v1/gc", s.gcReport)
+	s.handle("/api/v1/services", s.services)
+	s.handle("/api/v1/services/", s.services)
+	s.handle("/api/v1/update", s.update)
+	s.handle("/api/v1/power", s.powerAction)
+	s.handle("/metrics", metrics.Handler(s.collectMetrics).ServeHTTP)
+	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return s
+}
+
+// handle registers an authenticated handler.
+func (s *Server) handle(pattern string, fn func(w http.ResponseWriter, r *http.Request)) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			writeError(w, http.StatusUnauthorized, "invalid agent token")
+			return
+		}
+		fn(w, r)
+	})
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run samples the node usage every MonitorInterval, unless it is 0, and
+// serves the agent API until the listener fails.
+func (s *Server) Run() error {
+	if interval := time.Duration(conf.MonitorInterval()) * time.Second; interval > 0 {
+		go s.sampler.Run(interval, nil)
+	} else {
+		logs.Info("usage sampling disabled")
+	}
+	logs.Info("agent listening on %s", s.addr)
+	server := &http.Server{
+		Addr:         s.addr,
+		Handler:      s,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: time.Minute,
+	}
+	return server.ListenAndServe()
+}
+
+func (s *Server) sysinfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.collector.Collect())
+}
+
+// samples returns the usage samples in the rolling window, the last query
+// parameter limits them to the latest ones.
+func (s *Server) samples(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	samples := s.sampler.Samples()
+	if v := r.URL.Query().Get("last"); v != "" {
+		last, err := strconv.Atoi(v)
+		if err != nil || last < 0 {
+			writeError(w, http.StatusBadRequest, "invalid last "+v)
+			return
+		}
+		if last < len(samples) {
+			samples = samples[len(samples)-last:]
+		}
+	}
+	writeJSON(w, http.StatusOK, samples)
+}
+
+// collectMetrics collects the node information for a Prometheus scrape.
+func (s *Server) collectMetrics() []metrics.Family {
+	var latest *sysinfo.Sample
+	if samples := s.sampler.Samples(); len(samples) > 0 {
+		latest = &samples[len(samples)-1]
+	}
+	return nodeMetrics(s.collector.Collect(), latest)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logs.Warn("write agent response failed: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
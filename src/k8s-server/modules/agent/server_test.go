@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s-server/modules/agent/sysinfo"
+)
+
+func TestSysinfoAPI(t *testing.T) {
+	collector, err := sysinfo.NewCollector("sysinfo/testdata/proc", "sysinfo/testdata/sys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(collector, sysinfo.NewSampler("sysinfo/testdata/proc", "sysinfo/testdata/sys", 10), "secret", ":0")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/sysinfo", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("request without token responded %d, want 401", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sysinfo", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("responded %d: %s", rec.Code, rec.Body.String())
+	}
+	var info sysinfo.Info
+	if err = json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode sysinfo failed: %v", err)
+	}
+	if info.CPU.Threads != 4 || info.Memory.MemTotal != 16314744 {
+		t.Errorf("unexpected sysinfo %+v", info)
+	}
+}
+
+func TestSamplesAPI(t *testing.T) {
+	sampler := sysinfo.NewSampler("sysinfo/testdata/proc", "sysinfo/testdata/sys", 10)
+	for i := 0; i < 3; i++ {
+		if _, err := sampler.Sample(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newServer(nil, sampler, "", ":0")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/samples?last=2", nil))
+	var samples []sysinfo.Sample
+	if err := json.Unmarshal(rec.Body.Bytes(), &samples); err != nil {
+		t.Fatalf("decode samples failed: %v: %s", err, rec.Body.String())
+	}
+	if len(samples) != 2 {
+		t.Errorf("got %d samples, want 2", len(samples))
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/samples?last=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid last responded %d, want 400", rec.Code)
+	}
+}
+
+func TestPowerDisabled(t *testing.T) {
+	s := newServer(nil, nil, "", ":0")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/power",
+		strings.NewReader(`{"action":"reboot"}`)))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("power action without agent token responded %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/modules/agent/monit"
+	"k8s-server/utils/logs"
+)
+
+// ServicesKeyPrefix+hostname holds the service states of an agent as JSON,
+// it expires like the heartbeat.
+const ServicesKeyPrefix = "services:"
+
+// RunServiceMonitor supervises the services of ServicesMonitScriptsDir every
+// agent::ServiceMonitorInterval until stop is closed and publishes their
+// states to Redis.
+func (s *Server) RunServiceMonitor(stop <-chan struct{}) {
+	interval := conf.ServiceMonitorInterval()
+	if interval <= 0 || s.monit == nil {
+		return
+	}
+	if _, err := os.Stat(conf.ServicesMonitScriptsDir()); os.IsNotExist(err) {
+		logs.Info("no service scripts in %s, service monitor disabled", conf.ServicesMonitScriptsDir())
+		return
+	}
+	hostname := s.collector.Collect().Hostname
+	s.monit.Run(interval, stop, func(services []monit.Service) {
+		if err := s.publishServices(hostname, services, HeartbeatMisses*interval); err != nil {
+			logs.Warn("publish service states failed: %v", err)
+		}
+	})
+}
+
+func (s *Server) publishServices(hostname string, services []monit.Service, ttl time.Duration) error {
+	if s.redisPool == nil {
+		return nil
+	}
+	b, err := json.Marshal(services)
+	if err != nil {
+		return err
+	}
+	seconds := int(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	conn := s.redisPool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", ServicesKeyPrefix+hostname, b, "EX", seconds)
+	return err
+}
+
+// services lists the supervised services on GET /api/v1/services and runs
+// an action on POST /api/v1/services/<name>/<start|stop>.
+func (s *Server) services(w http.ResponseWriter, r *http.Request) {
+	if s.monit == nil {
+		writeError(w, http.StatusNotFound, "service monitor disabled")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/services"), "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && parts[0] == "":
+		writeJSON(w, http.StatusOK, s.monit.Services())
+	case r.Method == http.MethodPost && len(parts) == 2:
+		var err error
+		switch parts[1] {
+		case monit.ActionStart:
+			err = s.monit.Start(parts[0])
+		case monit.ActionStop:
+			err = s.monit.Stop(parts[0])
+		default:
+			writeError(w, http.StatusBadRequest, "unknown action "+parts[1])
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"service": parts[0], "action": parts[1]})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
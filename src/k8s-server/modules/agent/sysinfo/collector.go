@@ -0,0 +1,138 @@
+// Package sysinfo collects the hardware information of the node the agent
+// runs on from procfs, sysfs and nvidia-smi.
+package sysinfo
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/utils/logs"
+)
+
+// Info is the hardware information of a node.
+type Info struct {
+	Hostname    string
+	CPU         CPU
+	Memory      Memory
+	Networks    []Network
+	NetworkIO   []NetworkIO
+	MgmtIP      string // address on the management network
+	MgmtMAC     string
+	GPU         GPU
+	Disks       []Disk
+	FileSystems []FileSystem
+	DiskIO      []DiskIO
+	CollectedAt time.Time
+}
+
+// Collector collects the Info of the local node. Files are read below
+// procRoot and sysRoot, so tests can point them at fixture trees.
+type Collector struct {
+	Info Info
+
+	procRoot  string
+	sysRoot   string
+	nvidiaSMI string
+	statfs    statfsFunc
+	diskNames []string
+	hostname  string
+	mgmtIPNet *net.IPNet
+	// sampler provides the disk IO rates, they are zero without one
+	sampler *Sampler
+	// statfsTimeout bounds a statfs call, hung holds the mount points whose
+	// statfs did not return yet
+	statfsTimeout time.Duration
+	hung          map[string]bool
+	hungLock      sync.Mutex
+
+	lock sync.Mutex
+}
+
+// NewCollector returns a collector reading procfs at procRoot and sysfs at
+// sysRoot, the disk IO rates are those of the latest interval of sampler.
+func NewCollector(procRoot, sysRoot string, sampler *Sampler) (*Collector, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return &Collector{
+		procRoot:  procRoot,
+		sysRoot:   sysRoot,
+		nvidiaSMI: cmdNvidiaSMI,
+		statfs:    statfs,
+		diskNames: diskNames(),
+		hostname:  hostname,
+		mgmtIPNet: mgmtNetwork(),
+		sampler:   sampler,
+
+		statfsTimeout: defaultStatfsTimeout,
+		hung:          make(map[string]bool),
+	}, nil
+}
+
+// Collect gathers CPU, memory, network, GPU and disk information. A source
+// that can not be read leaves its part empty instead of failing the whole
+// collection.
+func (c *Collector) Collect() Info {
+	// statfs blocks on an unresponsive network filesystem, it runs with a
+	// timeout before the collection takes the lock
+	fileSystems := c.fileSystems()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Info = Info{Hostname: c.hostname, FileSystems: fileSystems}
+	c.getCPUInfo()
+	c.getTopologyInfo()
+	c.getMemoryInfo()
+	if err := c.getNetworkInfo(); err != nil {
+		logs.Warn("collect network info failed: %v", err)
+	}
+	c.getNetworkIO()
+	c.getGPUInfo()
+	c.getDiskInfo()
+	c.getDiskIO(c.diskNames)
+	c.Info.CollectedAt = time.Now()
+	return c.Info
+}
+
+func (c *Collector) procPath(name ...string) string {
+	return filepath.Join(append([]string{c.procRoot}, name...)...)
+}
+
+func (c *Collector) sysPath(name ...string) string {
+	return filepath.Join(append([]string{c.sysRoot}, name...)...)
+}
+
+// diskNames returns the disks whose IO is reported, nil means all disks.
+func diskNames() []string {
+	var names []string
+	for _, name := range strings.Split(conf.DiskNames(), ",") {
+		if name = strings.TrimSpace(name); name == "*" {
+			return nil
+		} else if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mgmtNetwork returns the management network, the subnet of the Redis host
+// on the management node.
+func mgmtNetwork() *net.IPNet {
+	host := conf.RedisHost()
+	ip := net.ParseIP(host)
+	if ip == nil && host != "" {
+		if ips, err := net.LookupIP(host); err == nil && len(ips) > 0 {
+			ip = ips[0]
+		}
+	}
+	mask := net.IPMask(net.ParseIP(conf.MgmtSubnetMask()).To4())
+	if ip == nil || ip.To4() == nil || mask == nil {
+		return nil
+	}
+	return &net.IPNet{IP: ip.To4().Mask(mask), Mask: mask}
+}
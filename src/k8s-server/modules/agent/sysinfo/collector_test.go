@@ -0,0 +1,59 @@
+package sysinfo
+
+import (
+	"testing"
+)
+
+func newTestCollector(t *testing.T) *Collector {
+	c, err := NewCollector("testdata/proc", "testdata/sys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.nvidiaSMI = "testdata/no-such-command"
+	c.diskNames = nil
+	return c
+}
+
+func TestCollect(t *testing.T) {
+	info := newTestCollector(t).Collect()
+
+	cpu := info.CPU
+	if cpu.CPU != 2 || cpu.Core != 4 || cpu.Threads != 4 {
+		t.Errorf("got %d sockets, %d cores, %d threads, want 2, 4, 4", cpu.CPU, cpu.Core, cpu.Threads)
+	}
+	if cpu.Vendor != "GenuineIntel" || cpu.Model != "Intel(R) Xeon(R) Gold 6130 CPU @ 2.10GHz" {
+		t.Errorf("unexpected CPU model %q by %q", cpu.Model, cpu.Vendor)
+	}
+	if cpu.Speed != 2100 || cpu.Cache != 22528 {
+		t.Errorf("speed = %d, cache = %d, want 2100, 22528", cpu.Speed, cpu.Cache)
+	}
+
+	mem := info.Memory
+	if mem.MemTotal != 16314744 || mem.MemFree != 8102340 || mem.SwapTotal != 2097148 || mem.SwapFree != 2097148 {
+		t.Errorf("unexpected memory %+v", mem)
+	}
+	if len(info.GPU.GPUs) != 0 {
+		t.Errorf("GPUs found without nvidia-smi: %+v", info.GPU)
+	}
+	if info.CollectedAt.IsZero() || info.Hostname == "" {
+		t.Errorf("collection time or hostname missing: %+v", info)
+	}
+}
+
+func TestCollectMissingRoot(t *testing.T) {
+	c := newTestCollector(t)
+	c.procRoot = "testdata/missing"
+	info := c.Collect()
+	if info.CPU.Vendor != "" || info.Memory.MemTotal != 0 {
+		t.Errorf("collected from a missing proc root: %+v", info)
+	}
+}
+
+func TestCollectResets(t *testing.T) {
+	c := newTestCollector(t)
+	c.Collect()
+	c.procRoot = "testdata/missing"
+	if info := c.Collect(); info.Memory.MemFree != 0 {
+		t.Errorf("stale memory kept across collections: %+v", info.Memory)
+	}
+}
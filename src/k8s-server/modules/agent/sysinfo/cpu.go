@@ -12,17 +12,21 @@ import (
 )
 
 type CPU struct {
-	Core    int
-	Threads int
-	Cache   int64
-	Speed   int64
-	CPU     int
-	Model   string
-	Vendor  string
+	Core      int
+	Threads   int
+	Cache     int64
+	Speed     int64
+	CPU       int
+	Model     string
+	Vendor    string
+	Flags     []string
+	NUMANodes []NUMANode
+	HugePages []HugePages
 }
 
 const (
-	CPUInfoFile = "/proc/cpuinfo"
+	// CPUInfoFile is the CPU information file relative to the proc root.
+	CPUInfoFile = "cpuinfo"
 )
 
 var (
@@ -32,7 +36,7 @@ var (
 )
 
 func (c *Collector) getCPUInfo() {
-	f, err := os.Open(CPUInfoFile)
+	f, err := os.Open(c.procPath(CPUInfoFile))
 	if err != nil {
 		return
 	}
@@ -42,11 +46,14 @@ func (c *Collector) getCPUInfo() {
 	core := make(map[string]bool)
 
 	var cpuID string
+	threads := 0
 
 	b := bufio.NewScanner(f)
 	for b.Scan() {
 		if col := reCPUSeparator.Split(b.Text(), 2); col != nil {
 			switch col[0] {
+			case "processor":
+				threads++
 			case "vendor_id":
 				if c.Info.CPU.Vendor == "" {
 					c.Info.CPU.Vendor = col[1]
@@ -63,6 +70,10 @@ func (c *Collector) getCPUInfo() {
 						c.Info.CPU.Speed = int64(speed)
 					}
 				}
+			case "flags":
+				if c.Info.CPU.Flags == nil {
+					c.Info.CPU.Flags = strings.Fields(col[1])
+				}
 			case "physical id":
 				cpuID = col[1]
 				cpu[cpuID] = true
@@ -88,5 +99,8 @@ func (c *Collector) getCPUInfo() {
 	c.Info.CPU.CPU = len(cpu)
 	c.Info.CPU.Core = len(core)
 
-	c.Info.CPU.Threads = runtime.NumCPU()
+	c.Info.CPU.Threads = threads
+	if threads == 0 {
+		c.Info.CPU.Threads = runtime.NumCPU()
+	}
 }
@@ -0,0 +1,217 @@
+package sysinfo
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	diskStatsFile = "diskstats"
+	sysBlockDir   = "block"
+	sectorSize    = 512
+)
+
+// Disk is a block device found in /sys/block.
+type Disk struct {
+	Name       string
+	Model      string
+	Serial     string
+	Size       int64 // bytes
+	Rotational bool
+	Removable  bool
+	NVMe       bool
+}
+
+// DiskIO is the IO activity of a disk. The counters are cumulative since
+// boot, the rates cover the latest interval of the Sampler and are zero until
+// it took two samples.
+type DiskIO struct {
+	Name         string
+	Reads        uint64
+	Writes       uint64
+	ReadBytes    uint64
+	WriteBytes   uint64
+	IOTime       uint64 // milliseconds spent doing IO
+	ReadIOPS     float64
+	WriteIOPS    float64
+	ReadBytesPS  float64
+	WriteBytesPS float64
+	Utilization  float64 // percent of time the disk was busy
+}
+
+// diskSample is a diskstats reading kept for the next rate computation.
+type diskSample struct {
+	at       time.Time
+	counters map[string]DiskIO
+}
+
+// virtualDisks are block devices that are not backed by hardware.
+var virtualDisks = []string{"loop", "ram", "zram", "dm-", "md", "sr", "fd", "nbd"}
+
+func isVirtualDisk(name string) bool {
+	for _, prefix := range virtualDisks {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Collector) getDiskInfo() {
+	entries, err := ioutil.ReadDir(c.sysPath(sysBlockDir))
+	if err != nil {
+		return
+	}
+	c.Info.Disks = []Disk{}
+	for _, e := range entries {
+		name := e.Name()
+		if isVirtualDisk(name) {
+			continue
+		}
+		dir := c.sysPath(sysBlockDir, name)
+		disk := Disk{
+			Name:       name,
+			Model:      readString(filepath.Join(dir, "device", "model")),
+			Serial:     readString(filepath.Join(dir, "device", "serial")),
+			Size:       readInt(filepath.Join(dir, "size")) * sectorSize,
+			Rotational: readString(filepath.Join(dir, "queue", "rotational")) == "1",
+			Removable:  readString(filepath.Join(dir, "removable")) == "1",
+			NVMe:       strings.HasPrefix(name, "nvme"),
+		}
+		if disk.Serial == "" {
+			disk.Serial = readString(filepath.Join(dir, "device", "wwid"))
+		}
+		c.Info.Disks = append(c.Info.Disks, disk)
+	}
+}
+
+// getDiskIO reads the counters of the disks and takes the rates of the
+// latest sampler interval. Only whole hardware disks are reported, limited to
+// the given names if any.
+func (c *Collector) getDiskIO(names []string) {
+	disks, err := readDiskIO(c.procPath(diskStatsFile), c.sysPath(sysBlockDir), names)
+	if err != nil {
+		return
+	}
+	var rates map[string]DiskIO
+	if c.sampler != nil {
+		rates = c.sampler.diskRates()
+	}
+	c.Info.DiskIO = make([]DiskIO, 0, len(disks))
+	for _, name := range sortedDisks(disks) {
+		cur := disks[name]
+		if rate, ok := rates[name]; ok {
+			cur.ReadIOPS, cur.WriteIOPS = rate.ReadIOPS, rate.WriteIOPS
+			cur.ReadBytesPS, cur.WriteBytesPS = rate.ReadBytesPS, rate.WriteBytesPS
+			cur.Utilization = rate.Utilization
+		}
+		c.Info.DiskIO = append(c.Info.DiskIO, cur)
+	}
+}
+
+// readDiskIO returns the counters of the whole hardware disks in diskstats by
+// name, limited to names if any. blockDir is /sys/block, it lists no
+// partitions.
+func readDiskIO(diskstats, blockDir string, names []string) (map[string]DiskIO, error) {
+	counters, err := readDiskStats(diskstats)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, n := range names {
+		wanted[n] = true
+	}
+	for name := range counters {
+		if isVirtualDisk(name) || (len(wanted) > 0 && !wanted[name]) {
+			delete(counters, name)
+		} else if _, err := os.Stat(filepath.Join(blockDir, name)); err != nil {
+			delete(counters, name)
+		}
+	}
+	return counters, nil
+}
+
+func sortedDisks(disks map[string]DiskIO) []string {
+	names := make([]string, 0, len(disks))
+	for name := range disks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// diskRates fills the rates of cur from the counter deltas since old. Counters
+// that went backwards, e.g. after a device was replaced, give zero rates.
+func diskRates(old, cur DiskIO, elapsed time.Duration) DiskIO {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return cur
+	}
+	delta := func(a, b uint64) float64 {
+		if b < a {
+			return 0
+		}
+		return float64(b - a)
+	}
+	cur.ReadIOPS = delta(old.Reads, cur.Reads) / seconds
+	cur.WriteIOPS = delta(old.Writes, cur.Writes) / seconds
+	cur.ReadBytesPS = delta(old.ReadBytes, cur.ReadBytes) / seconds
+	cur.WriteBytesPS = delta(old.WriteBytes, cur.WriteBytes) / seconds
+	cur.Utilization = delta(old.IOTime, cur.IOTime) / (seconds * 1000) * 100
+	if cur.Utilization > 100 {
+		cur.Utilization = 100
+	}
+	return cur
+}
+
+// readDiskStats parses /proc/diskstats, see Documentation/iostats.txt.
+func readDiskStats(path string) (map[string]DiskIO, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := make(map[string]DiskIO)
+	b := bufio.NewScanner(f)
+	for b.Scan() {
+		fields := strings.Fields(b.Text())
+		if len(fields) < 14 {
+			continue
+		}
+		n := make([]uint64, 11)
+		for i := range n {
+			n[i], _ = strconv.ParseUint(fields[i+3], 10, 64)
+		}
+		stats[fields[2]] = DiskIO{
+			Name:       fields[2],
+			Reads:      n[0],
+			ReadBytes:  n[2] * sectorSize,
+			Writes:     n[4],
+			WriteBytes: n[6] * sectorSize,
+			IOTime:     n[9],
+		}
+	}
+	return stats, b.Err()
+}
+
+// readString returns the trimmed content of a sysfs attribute, or "" if it
+// can not be read.
+func readString(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func readInt(path string) int64 {
+	n, _ := strconv.ParseInt(readString(path), 10, 64)
+	return n
+}
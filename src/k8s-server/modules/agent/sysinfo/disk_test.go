@@ -0,0 +1,135 @@
+package sysinfo
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskInfo(t *testing.T) {
+	c := newTestCollector(t)
+	c.getDiskInfo()
+	disks := c.Info.Disks
+	if len(disks) != 2 {
+		t.Fatalf("found %d disks, want 2 without loop devices: %+v", len(disks), disks)
+	}
+	nvme, sda := disks[0], disks[1]
+	if !nvme.NVMe || nvme.Rotational || nvme.Size != 3907029168*512 || nvme.Serial != "eui.0025385b71b0a1b2" {
+		t.Errorf("unexpected nvme disk %+v", nvme)
+	}
+	if sda.NVMe || !sda.Rotational || sda.Model != "ST1000NM0033-9ZM" || sda.Serial != "Z1W0ABCD" {
+		t.Errorf("unexpected sda disk %+v", sda)
+	}
+}
+
+func TestFileSystemInfo(t *testing.T) {
+	c := newTestCollector(t)
+	var statted []string
+	c.statfs = func(path string) (FileSystem, error) {
+		statted = append(statted, path)
+		if path == "/run" {
+			return FileSystem{}, fmt.Errorf("tmpfs must be skipped")
+		}
+		return FileSystem{Total: 1000, Used: 400, Available: 550, Inodes: 64, InodesFree: 60}, nil
+	}
+	fs := c.fileSystems()
+	if len(fs) != 2 {
+		t.Fatalf("found %d filesystems, want 2: %+v (statted %v)", len(fs), fs, statted)
+	}
+	if fs[0].MountPoint != "/" || fs[0].Type != "ext4" || fs[0].Used != 400 {
+		t.Errorf("unexpected root filesystem %+v", fs[0])
+	}
+	if fs[1].MountPoint != "/data disk" || fs[1].Device != "/dev/nvme0n1" {
+		t.Errorf("escaped mount point not decoded: %+v", fs[1])
+	}
+}
+
+func TestDiskIO(t *testing.T) {
+	c := newTestCollector(t)
+	c.getDiskIO(nil)
+	io := c.Info.DiskIO
+	if len(io) != 2 || io[0].Name != "nvme0n1" || io[1].Name != "sda" {
+		t.Fatalf("unexpected disks %+v", io)
+	}
+	sda := io[1]
+	if sda.Reads != 120000 || sda.ReadBytes != 9600000*512 || sda.WriteBytes != 5120000*512 || sda.IOTime != 150000 {
+		t.Errorf("unexpected sda counters %+v", sda)
+	}
+	if sda.ReadIOPS != 0 || sda.Utilization != 0 {
+		t.Errorf("rates without a sampler: %+v", sda)
+	}
+
+	c.getDiskIO([]string{"sda"})
+	if len(c.Info.DiskIO) != 1 {
+		t.Errorf("disk filter ignored: %+v", c.Info.DiskIO)
+	}
+
+	// the rates are those of the sampler interval, however often the
+	// collector runs
+	s := NewSampler("testdata/proc", "testdata/sys", 1)
+	at := time.Now()
+	s.sampleDisks(at, map[string]DiskIO{"sda": {Reads: 119800}})
+	s.sampleDisks(at.Add(2*time.Second), map[string]DiskIO{"sda": {Reads: 120000}})
+	c.sampler = s
+	for i := 0; i < 2; i++ {
+		c.getDiskIO(nil)
+		if sda = c.Info.DiskIO[1]; sda.Reads != 120000 || sda.ReadIOPS != 100 {
+			t.Errorf("collection %d: unexpected sda %+v", i, sda)
+		}
+	}
+}
+
+func TestStatfsTimeout(t *testing.T) {
+	c := newTestCollector(t)
+	c.statfsTimeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	calls := make(map[string]int)
+	var lock sync.Mutex
+	c.statfs = func(path string) (FileSystem, error) {
+		lock.Lock()
+		calls[path]++
+		lock.Unlock()
+		if path == "/" {
+			<-release
+		}
+		return FileSystem{Total: 1000}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		fs := c.fileSystems()
+		if len(fs) != 1 || fs[0].MountPoint != "/data disk" {
+			t.Errorf("collection %d with a hung root: %+v", i, fs)
+		}
+	}
+	lock.Lock()
+	if calls["/"] != 1 {
+		t.Errorf("hung statfs called %d times, want 1", calls["/"])
+	}
+	lock.Unlock()
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for len(c.fileSystems()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("root filesystem not reported after statfs returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDiskRates(t *testing.T) {
+	old := DiskIO{Reads: 100, Writes: 50, ReadBytes: 4096, WriteBytes: 8192, IOTime: 1000}
+	cur := DiskIO{Reads: 300, Writes: 50, ReadBytes: 4096 + 2<<20, WriteBytes: 4096, IOTime: 1500}
+	got := diskRates(old, cur, 2*time.Second)
+	if got.ReadIOPS != 100 || got.WriteIOPS != 0 || got.ReadBytesPS != 1<<20 {
+		t.Errorf("unexpected rates %+v", got)
+	}
+	if got.WriteBytesPS != 0 {
+		t.Errorf("counter going backwards gave %v bytes/s", got.WriteBytesPS)
+	}
+	if math.Abs(got.Utilization-25) > 1e-9 {
+		t.Errorf("utilization = %v, want 25", got.Utilization)
+	}
+}
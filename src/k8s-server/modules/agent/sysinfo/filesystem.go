@@ -0,0 +1,163 @@
+package sysinfo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s-server/utils/logs"
+)
+
+const mountsFile = "mounts"
+
+// defaultStatfsTimeout bounds the statfs of a mount point.
+const defaultStatfsTimeout = 5 * time.Second
+
+// FileSystem is the usage of a mounted filesystem, sizes are in bytes.
+type FileSystem struct {
+	Device     string
+	MountPoint string
+	Type       string
+	Total      uint64
+	Used       uint64
+	Available  uint64
+	Inodes     uint64
+	InodesFree uint64
+}
+
+// pseudoFileSystems are not backed by storage and are not reported.
+var pseudoFileSystems = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true, "cgroup2": true,
+	"configfs": true, "debugfs": true, "devpts": true, "devtmpfs": true, "fusectl": true,
+	"hugetlbfs": true, "mqueue": true, "nsfs": true, "overlay": true, "proc": true,
+	"pstore": true, "rpc_pipefs": true, "securityfs": true, "selinuxfs": true,
+	"squashfs": true, "sysfs": true, "tmpfs": true, "tracefs": true,
+}
+
+// statfsFunc returns the usage of the filesystem mounted at path.
+type statfsFunc func(path string) (FileSystem, error)
+
+func statfs(path string) (FileSystem, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return FileSystem{}, err
+	}
+	bsize := uint64(st.Bsize)
+	return FileSystem{
+		Total:      st.Blocks * bsize,
+		Used:       (st.Blocks - st.Bfree) * bsize,
+		Available:  st.Bavail * bsize,
+		Inodes:     st.Files,
+		InodesFree: st.Ffree,
+	}, nil
+}
+
+// fileSystems returns the usage of the filesystems in /proc/mounts, a device
+// mounted several times is reported once. A mount point whose statfs times
+// out is left out, also while that call is still hanging.
+func (c *Collector) fileSystems() []FileSystem {
+	f, err := os.Open(c.procPath(mountsFile))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	filesystems := []FileSystem{}
+	seen := make(map[string]bool)
+	b := bufio.NewScanner(f)
+	for b.Scan() {
+		fields := strings.Fields(b.Text())
+		if len(fields) < 3 || pseudoFileSystems[fields[2]] || seen[fields[0]] {
+			continue
+		}
+		mountPoint := UnescapeMount(fields[1])
+		fs, err := c.statfsWithTimeout(mountPoint)
+		if err != nil || fs.Total == 0 {
+			continue
+		}
+		seen[fields[0]] = true
+		fs.Device, fs.MountPoint, fs.Type = fields[0], mountPoint, fields[2]
+		filesystems = append(filesystems, fs)
+	}
+	return filesystems
+}
+
+// statfsWithTimeout runs statfs for at most statfsTimeout. A call that timed
+// out keeps running, the mount point is skipped until it returns so hung
+// calls do not pile up.
+func (c *Collector) statfsWithTimeout(path string) (FileSystem, error) {
+	c.hungLock.Lock()
+	hung := c.hung[path]
+	c.hungLock.Unlock()
+	if hung {
+		return FileSystem{}, fmt.Errorf("statfs %s is hanging", path)
+	}
+
+	type result struct {
+		fs  FileSystem
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		fs, err := c.statfs(path)
+		done <- result{fs, err}
+		c.hungLock.Lock()
+		delete(c.hung, path)
+		c.hungLock.Unlock()
+	}()
+	timer := time.NewTimer(c.statfsTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.fs, r.err
+	case <-timer.C:
+	}
+	c.hungLock.Lock()
+	defer c.hungLock.Unlock()
+	select {
+	case r := <-done:
+		// returned just now, before it could clear the mark
+		return r.fs, r.err
+	default:
+	}
+	c.hung[path] = true
+	logs.Warn("statfs %s did not return within %v, skip it until it does", path, c.statfsTimeout)
+	return FileSystem{}, fmt.Errorf("statfs %s timed out after %v", path, c.statfsTimeout)
+}
+
+// UnescapeMount decodes the octal escapes of /proc/mounts, e.g. "\040" for a
+// space.
+func UnescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, ok := octal(s[i+1 : i+4]); ok {
+				out = append(out, n)
+				i += 3
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
+
+func octal(s string) (byte, bool) {
+	if len(s) != 3 {
+		return 0, false
+	}
+	var n int
+	for _, ch := range s {
+		if ch < '0' || ch > '7' {
+			return 0, false
+		}
+		n = n*8 + int(ch-'0')
+	}
+	return byte(n), n < 256
+}
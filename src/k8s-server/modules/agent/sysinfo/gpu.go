@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"encoding/xml"
 	"os/exec"
+	"strconv"
+	"strings"
+
+	"k8s-server/utils/logs"
 )
 
 const (
@@ -13,16 +17,32 @@ const (
 //GPU contains complete GPU information
 type GPU struct {
 	DriverVersion string   `xml:"driver_version"`
+	CUDAVersion   string   `xml:"cuda_version"`
 	GPUs          []GPUXML `xml:"gpu"`
+	// Devices are the GPUs with readings converted to numbers.
+	Devices []GPUDevice `xml:"-"`
 }
 
 //GPUXML contains single GPU information from the XML file
 type GPUXML struct {
+	ID          string          `xml:"id,attr"`
 	ProductName string          `xml:"product_name"`
+	UUID        string          `xml:"uuid"`
+	MinorNumber string          `xml:"minor_number"`
+	PCI         PCIInfo         `xml:"pci"`
 	FanSpeed    string          `xml:"fan_speed"`
 	FBMemory    FBMemoryInfo    `xml:"fb_memory_usage"`
+	Utilization UtilizationInfo `xml:"utilization"`
+	ECCErrors   ECCErrorsInfo   `xml:"ecc_errors"`
 	Temperature TemperatureInfo `xml:"temperature"`
+	Power       PowerInfo       `xml:"power_readings"`
 	Clocks      ClocksInfo      `xml:"clocks"`
+	Processes   []ProcessInfo   `xml:"processes>process_info"`
+}
+
+// PCIInfo contains the PCI address of the GPU.
+type PCIInfo struct {
+	BusID string `xml:"pci_bus_id"`
 }
 
 // FBMemoryInfo contains on-board frame buffer memory information.
@@ -32,12 +52,36 @@ type FBMemoryInfo struct {
 	Free  string `xml:"free"`
 }
 
+// UtilizationInfo contains the busy percentage of the GPU engines over the
+// last sample period of the driver.
+type UtilizationInfo struct {
+	GPU     string `xml:"gpu_util"`
+	Memory  string `xml:"memory_util"`
+	Encoder string `xml:"encoder_util"`
+	Decoder string `xml:"decoder_util"`
+}
+
+// ECCErrorsInfo contains the corrected (single bit) and uncorrected (double
+// bit) ECC error counts since the driver loaded and over the GPU lifetime.
+type ECCErrorsInfo struct {
+	VolatileSingleBit  string `xml:"volatile>single_bit>total"`
+	VolatileDoubleBit  string `xml:"volatile>double_bit>total"`
+	AggregateSingleBit string `xml:"aggregate>single_bit>total"`
+	AggregateDoubleBit string `xml:"aggregate>double_bit>total"`
+}
+
 //TemperatureInfo contains readings from temperature sensors on the board.
 type TemperatureInfo struct {
 	GPUTemperature    string `xml:"gpu_temp"`
 	MemoryTemperature string `xml:"memory_temp"`
 }
 
+// PowerInfo contains the power draw and the enforced power limit.
+type PowerInfo struct {
+	PowerDraw  string `xml:"power_draw"`
+	PowerLimit string `xml:"power_limit"`
+}
+
 //ClocksInfo contains current frequency at which parts of the GPU are running.
 type ClocksInfo struct {
 	GraphicsClock string `xml:"graphics_clock"`
@@ -46,17 +90,185 @@ type ClocksInfo struct {
 	VideoClock    string `xml:"video_clock"`
 }
 
+// ProcessInfo is a process using the GPU.
+type ProcessInfo struct {
+	PID         string `xml:"pid"`
+	Type        string `xml:"type"`
+	ProcessName string `xml:"process_name"`
+	UsedMemory  string `xml:"used_memory"`
+}
+
+// GPUDevice is a GPU with its readings in base units: bytes, hertz, watts,
+// degrees Celsius and 0-1 ratios. Readings the GPU does not support, which
+// nvidia-smi reports as N/A, are nil.
+type GPUDevice struct {
+	Index       int
+	Name        string
+	UUID        string
+	BusID       string
+	MinorNumber int
+
+	Temperature       *float64
+	MemoryTemperature *float64
+	FanSpeed          *float64
+
+	MemoryTotal *float64
+	MemoryUsed  *float64
+	MemoryFree  *float64
+
+	Utilization        *float64
+	MemoryUtilization  *float64
+	EncoderUtilization *float64
+	DecoderUtilization *float64
+
+	PowerDraw  *float64
+	PowerLimit *float64
+
+	GraphicsClock *float64
+	SMClock       *float64
+	MemoryClock   *float64
+	VideoClock    *float64
+
+	ECCVolatileSingleBit  *float64
+	ECCVolatileDoubleBit  *float64
+	ECCAggregateSingleBit *float64
+	ECCAggregateDoubleBit *float64
+
+	Processes []GPUProcess
+}
+
+// GPUProcess is a process using a GPU, PodUID and ContainerID are set when
+// the process runs in a Kubernetes pod.
+type GPUProcess struct {
+	PID         int
+	Name        string
+	Type        string // C for compute, G for graphics
+	UsedMemory  *float64
+	PodUID      string
+	ContainerID string
+}
+
 func (c *Collector) getGPUInfo() {
-	output, err := exec.Command(cmdNvidiaSMI, "-q", "-x").Output()
+	output, err := exec.Command(c.nvidiaSMI, "-q", "-x").Output()
 	if err != nil {
 		return
 	}
-	var data GPU
-	r := bytes.NewReader(output)
-	x := xml.NewDecoder(r)
-	err = x.Decode(&data)
+	data, err := parseGPU(output)
 	if err != nil {
+		logs.Warn("parse nvidia-smi output failed: %v", err)
 		return
 	}
+	for i := range data.Devices {
+		c.resolveGPUProcesses(data.Devices[i].Processes)
+	}
 	c.Info.GPU = data
 }
+
+// parseGPU decodes the output of nvidia-smi -q -x.
+func parseGPU(output []byte) (GPU, error) {
+	var data GPU
+	if err := xml.NewDecoder(bytes.NewReader(output)).Decode(&data); err != nil {
+		return data, err
+	}
+	data.Devices = make([]GPUDevice, len(data.GPUs))
+	for i, g := range data.GPUs {
+		data.Devices[i] = g.device(i)
+	}
+	return data, nil
+}
+
+// device converts the readings of the GPU at index.
+func (g GPUXML) device(index int) GPUDevice {
+	d := GPUDevice{
+		Index: index,
+		Name:  g.ProductName,
+		UUID:  g.UUID,
+		BusID: g.PCI.BusID,
+
+		Temperature:       gpuValue(g.Temperature.GPUTemperature),
+		MemoryTemperature: gpuValue(g.Temperature.MemoryTemperature),
+		FanSpeed:          gpuValue(g.FanSpeed),
+
+		MemoryTotal: gpuValue(g.FBMemory.Total),
+		MemoryUsed:  gpuValue(g.FBMemory.Used),
+		MemoryFree:  gpuValue(g.FBMemory.Free),
+
+		Utilization:        gpuValue(g.Utilization.GPU),
+		MemoryUtilization:  gpuValue(g.Utilization.Memory),
+		EncoderUtilization: gpuValue(g.Utilization.Encoder),
+		DecoderUtilization: gpuValue(g.Utilization.Decoder),
+
+		PowerDraw:  gpuValue(g.Power.PowerDraw),
+		PowerLimit: gpuValue(g.Power.PowerLimit),
+
+		GraphicsClock: gpuValue(g.Clocks.GraphicsClock),
+		SMClock:       gpuValue(g.Clocks.SMClock),
+		MemoryClock:   gpuValue(g.Clocks.MEMClock),
+		VideoClock:    gpuValue(g.Clocks.VideoClock),
+
+		ECCVolatileSingleBit:  gpuValue(g.ECCErrors.VolatileSingleBit),
+		ECCVolatileDoubleBit:  gpuValue(g.ECCErrors.VolatileDoubleBit),
+		ECCAggregateSingleBit: gpuValue(g.ECCErrors.AggregateSingleBit),
+		ECCAggregateDoubleBit: gpuValue(g.ECCErrors.AggregateDoubleBit),
+
+		Processes: []GPUProcess{},
+	}
+	if d.BusID == "" {
+		d.BusID = g.ID
+	}
+	d.MinorNumber, _ = strconv.Atoi(g.MinorNumber)
+	for _, p := range g.Processes {
+		pid, err := strconv.Atoi(strings.TrimSpace(p.PID))
+		if err != nil {
+			continue
+		}
+		d.Processes = append(d.Processes, GPUProcess{
+			PID:        pid,
+			Name:       p.ProcessName,
+			Type:       p.Type,
+			UsedMemory: gpuValue(p.UsedMemory),
+		})
+	}
+	return d
+}
+
+// gpuValue returns the parsed reading or nil if it is missing.
+func gpuValue(s string) *float64 {
+	if v, ok := ParseGPUValue(s); ok {
+		return &v
+	}
+	return nil
+}
+
+// ParseGPUValue parses a reading of nvidia-smi such as "45 C", "1024 MiB",
+// "30 %" or "1530 MHz" into base units: bytes, hertz, a 0-1 ratio, watts
+// or degrees Celsius. ok is false for missing readings such as "N/A".
+func ParseGPUValue(s string) (v float64, ok bool) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	if len(fields) == 1 {
+		return v, true
+	}
+	switch fields[1] {
+	case "C", "W":
+	case "%":
+		v /= 100
+	case "KiB":
+		v *= 1 << 10
+	case "MiB":
+		v *= 1 << 20
+	case "GiB":
+		v *= 1 << 30
+	case "MHz":
+		v *= 1e6
+	default:
+		return 0, false
+	}
+	return v, true
+}
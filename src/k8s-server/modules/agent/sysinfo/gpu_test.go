@@ -0,0 +1,86 @@
+package sysinfo
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func readGPUFixture(t *testing.T, name string) GPU {
+	b, err := ioutil.ReadFile("testdata/nvidia-smi/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gpu, err := parseGPU(b)
+	if err != nil {
+		t.Fatalf("parse %s failed: %v", name, err)
+	}
+	c := newTestCollector(t)
+	for i := range gpu.Devices {
+		c.resolveGPUProcesses(gpu.Devices[i].Processes)
+	}
+	return gpu
+}
+
+func checkValue(t *testing.T, name string, got *float64, want float64) {
+	t.Helper()
+	if got == nil || *got != want {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
+
+func TestParseGPUTesla(t *testing.T) {
+	gpu := readGPUFixture(t, "tesla-v100.xml")
+	if gpu.DriverVersion != "418.87.01" || gpu.CUDAVersion != "10.1" || len(gpu.Devices) != 2 {
+		t.Fatalf("unexpected gpu info %+v", gpu)
+	}
+	d := gpu.Devices[0]
+	if d.UUID != "GPU-4c8a3b1e-27d5-6f1a-93c2-8e7d1f0b5a64" || d.BusID != "00000000:3B:00.0" || d.MinorNumber != 0 {
+		t.Errorf("unexpected identity %s %s %d", d.UUID, d.BusID, d.MinorNumber)
+	}
+	checkValue(t, "Temperature", d.Temperature, 61)
+	checkValue(t, "MemoryTotal", d.MemoryTotal, 32480<<20)
+	checkValue(t, "Utilization", d.Utilization, 0.87)
+	checkValue(t, "PowerDraw", d.PowerDraw, 187.43)
+	checkValue(t, "SMClock", d.SMClock, 1380e6)
+	checkValue(t, "ECCVolatileSingleBit", d.ECCVolatileSingleBit, 2)
+	checkValue(t, "ECCAggregateDoubleBit", d.ECCAggregateDoubleBit, 1)
+	if d.FanSpeed != nil {
+		t.Errorf("FanSpeed = %v, want nil for N/A", *d.FanSpeed)
+	}
+
+	// cgroupfs driver
+	p := d.Processes
+	if len(p) != 1 || p[0].PID != 4242 || p[0].PodUID != "0f5c3a4e-8d2b-11e9-b3f5-0cc47a6c2f10" ||
+		p[0].ContainerID != "0315b4020af3eccab7706679580ac87a710d82970733b8719e70af9b57e7b9e6" {
+		t.Errorf("unexpected processes of gpu 0 %+v", p)
+	}
+	checkValue(t, "UsedMemory", p[0].UsedMemory, 10240<<20)
+	// systemd driver
+	p = gpu.Devices[1].Processes
+	if len(p) != 1 || p[0].PodUID != "7a1e2b3c-4d5e-6f70-8192-a3b4c5d6e7f8" ||
+		p[0].ContainerID != "236e5fcfd21603c33b82ddd89bab7c428ed18e3830bf04af0ec7f80ea63124a7" {
+		t.Errorf("unexpected processes of gpu 1 %+v", p)
+	}
+}
+
+func TestParseGPUGeForce(t *testing.T) {
+	gpu := readGPUFixture(t, "geforce-gtx1080.xml")
+	if len(gpu.Devices) != 1 {
+		t.Fatalf("found %d GPUs, want 1", len(gpu.Devices))
+	}
+	d := gpu.Devices[0]
+	checkValue(t, "FanSpeed", d.FanSpeed, 0.35)
+	checkValue(t, "PowerLimit", d.PowerLimit, 180)
+	if d.ECCVolatileSingleBit != nil || d.MemoryTemperature != nil {
+		t.Errorf("unsupported readings are not nil: %v %v", d.ECCVolatileSingleBit, d.MemoryTemperature)
+	}
+	if p := d.Processes; len(p) != 1 || p[0].Type != "G" || p[0].PodUID != "" || p[0].ContainerID != "" {
+		t.Errorf("host process mapped to a pod: %+v", p)
+	}
+}
+
+func TestGetGPUInfoWithoutNvidiaSMI(t *testing.T) {
+	if gpu := newTestCollector(t).Collect().GPU; len(gpu.Devices) != 0 {
+		t.Errorf("found GPUs without nvidia-smi: %+v", gpu)
+	}
+}
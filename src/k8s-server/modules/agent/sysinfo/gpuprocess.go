@@ -0,0 +1,53 @@
+package sysinfo
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// rePodUID matches the pod directory of the kubelet cgroup hierarchy,
+	// "pod<uid>" with the cgroupfs driver and "kubepods-<qos>-pod<uid>.slice"
+	// with dashes replaced by underscores with the systemd driver.
+	rePodUID = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+	// reContainerID matches the container directory below the pod, with the
+	// runtime prefix and scope suffix of the systemd driver.
+	reContainerID = regexp.MustCompile(`/(?:docker-|cri-containerd-|crio-)?([0-9a-f]{64})(?:\.scope)?$`)
+)
+
+// resolveGPUProcesses sets the pod UID and container ID of the processes
+// running in pods from their cgroup paths. The PIDs must be visible below
+// procRoot, so an agent running in a container needs the host PID namespace.
+func (c *Collector) resolveGPUProcesses(procs []GPUProcess) {
+	for i := range procs {
+		b, err := ioutil.ReadFile(c.procPath(strconv.Itoa(procs[i].PID), "cgroup"))
+		if err != nil {
+			continue
+		}
+		procs[i].PodUID, procs[i].ContainerID = parseCgroup(string(b))
+	}
+}
+
+// parseCgroup returns the pod UID and container ID from the content of
+// /proc/<pid>/cgroup, they are empty for processes outside of pods.
+func parseCgroup(content string) (podUID, containerID string) {
+	for _, line := range strings.Split(content, "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || !strings.Contains(parts[2], "kubepods") {
+			continue
+		}
+		m := rePodUID.FindStringSubmatch(parts[2])
+		if m == nil {
+			continue
+		}
+		podUID = strings.Replace(m[1], "_", "-", -1)
+		if m := reContainerID.FindStringSubmatch(parts[2]); m != nil {
+			containerID = m[1]
+		}
+		return podUID, containerID
+	}
+	return "", ""
+}
@@ -8,15 +8,19 @@ import (
 	"strconv"
 )
 
+// Memory is the memory usage of a node in kB.
 type Memory struct {
-	MemTotal  int
-	MemFree   int
-	SwapTotal int
-	SwapFree  int
+	MemTotal     int
+	MemFree      int
+	MemAvailable int
+	Buffers      int
+	Cached       int
+	SwapTotal    int
+	SwapFree     int
 }
 
 const (
-	memInfoFile = "/proc/meminfo"
+	memInfoFile = "meminfo"
 )
 
 var (
@@ -36,41 +40,46 @@ func parseSize(s string) (i int, err error) {
 }
 
 func (c *Collector) getMemoryInfo() {
-	f, err := os.Open(memInfoFile)
+	if mem, err := readMemory(c.procPath(memInfoFile)); err == nil {
+		c.Info.Memory = mem
+	}
+}
+
+// readMemory parses a meminfo file, sizes are in kB.
+func readMemory(path string) (Memory, error) {
+	var mem Memory
+	f, err := os.Open(path)
 	if err != nil {
-		return
+		return mem, err
 	}
 	defer f.Close()
 
+	fields := map[string]*int{
+		"MemTotal":     &mem.MemTotal,
+		"MemFree":      &mem.MemFree,
+		"MemAvailable": &mem.MemAvailable,
+		"Buffers":      &mem.Buffers,
+		"Cached":       &mem.Cached,
+		"SwapTotal":    &mem.SwapTotal,
+		"SwapFree":     &mem.SwapFree,
+	}
 	b := bufio.NewScanner(f)
 	for b.Scan() {
-		if col := reMemSeparator.Split(b.Text(), 2); col != nil {
-			switch col[0] {
-			case "MemTotal":
-				if c.Info.Memory.MemTotal == 0 {
-					if memTotal, err := parseSize(col[1]); err == nil {
-						c.Info.Memory.MemTotal = memTotal
-					}
-				}
-			case "MemFree":
-				if c.Info.Memory.MemFree == 0 {
-					if memFree, err := parseSize(col[1]); err == nil {
-						c.Info.Memory.MemFree = memFree
-					}
-				}
-			case "SwapTotal":
-				if c.Info.Memory.SwapTotal == 0 {
-					if swapTotal, err := parseSize(col[1]); err == nil {
-						c.Info.Memory.SwapTotal = swapTotal
-					}
-				}
-			case "SwapFree":
-				if c.Info.Memory.SwapFree == 0 {
-					if swapFree, err := parseSize(col[1]); err == nil {
-						c.Info.Memory.SwapFree = swapFree
-					}
+		if col := reMemSeparator.Split(b.Text(), 2); len(col) == 2 {
+			if field, ok := fields[col[0]]; ok {
+				if size, err := parseSize(col[1]); err == nil {
+					*field = size
 				}
 			}
 		}
 	}
+	if err = b.Err(); err != nil {
+		return mem, err
+	}
+	// MemAvailable is missing before Linux 3.14, estimate it the way free
+	// does.
+	if mem.MemAvailable == 0 {
+		mem.MemAvailable = mem.MemFree + mem.Buffers + mem.Cached
+	}
+	return mem, nil
 }
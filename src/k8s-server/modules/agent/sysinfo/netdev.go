@@ -0,0 +1,62 @@
+package sysinfo
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const netDevFile = "net/dev"
+
+// NetworkIO are the traffic counters of a network interface since boot.
+type NetworkIO struct {
+	Name      string
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// getNetworkIO reads the interface counters from /proc/net/dev, the loopback
+// interface is skipped.
+func (c *Collector) getNetworkIO() {
+	c.Info.NetworkIO = []NetworkIO{}
+	f, err := os.Open(c.procPath(netDevFile))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	b := bufio.NewScanner(f)
+	for b.Scan() {
+		col := strings.SplitN(b.Text(), ":", 2)
+		if len(col) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(col[0])
+		fields := strings.Fields(col[1])
+		if name == "lo" || len(fields) < 12 {
+			continue
+		}
+		v := make([]uint64, 12)
+		for i := range v {
+			v[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		c.Info.NetworkIO = append(c.Info.NetworkIO, NetworkIO{
+			Name:      name,
+			RxBytes:   v[0],
+			RxPackets: v[1],
+			RxErrors:  v[2],
+			RxDropped: v[3],
+			TxBytes:   v[8],
+			TxPackets: v[9],
+			TxErrors:  v[10],
+			TxDropped: v[11],
+		})
+	}
+}
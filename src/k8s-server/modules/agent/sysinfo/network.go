@@ -1,7 +1,6 @@
 package sysinfo
 
 import (
-	"fmt"
 	"k8s-server/utils/logs"
 	"net"
 )
@@ -41,12 +40,11 @@ func (c *Collector) getNetworkInfo() error {
 			logs.Error("can't report whether the network includes ip: %v", err)
 			return err
 		}
-		// collect nodes IP and MAC info
-		if isSame && ipAddr != "" && network.MACAddress != "" {
-			conn := c.redisPool.Get()
-			defer conn.Close()
-			key := fmt.Sprintf("nodes:%s", c.hostname)
-			conn.Do("HMSET", key, "IP", ipAddr, "MAC", network.MACAddress)
+		// the address on the management network is announced by the
+		// agent heartbeat
+		if isSame && ipAddr != "" && network.MACAddress != "" && c.Info.MgmtIP == "" {
+			c.Info.MgmtIP = ipAddr
+			c.Info.MgmtMAC = network.MACAddress
 		}
 		c.Info.Networks = append(c.Info.Networks, network)
 	}
@@ -60,5 +58,8 @@ func (c *Collector) isSameNetworkSegment(ipStr string) (bool, string, error) {
 	if err != nil {
 		return false, "", err
 	}
+	if c.mgmtIPNet == nil {
+		return false, ip.String(), nil
+	}
 	return c.mgmtIPNet.Contains(ip), ip.String(), nil
 }
@@ -0,0 +1,271 @@
+package sysinfo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-server/utils/logs"
+)
+
+const (
+	statFile    = "stat"
+	loadAvgFile = "loadavg"
+)
+
+// Sample is the CPU and memory utilisation of a node over one interval.
+type Sample struct {
+	Time time.Time
+	// CPU is the utilisation of all CPUs in percent, Cores the utilisation
+	// of each core in the order of /proc/stat.
+	CPU   float64
+	Cores []float64
+	Load  LoadAverage
+	// Memory sizes are in kB, MemUsed excludes reclaimable buffers and
+	// cache.
+	MemTotal     int
+	MemAvailable int
+	MemUsed      int
+	Buffers      int
+	Cached       int
+	MemUsage     float64
+}
+
+// LoadAverage is the system load average over 1, 5 and 15 minutes.
+type LoadAverage struct {
+	Load1  float64
+	Load5  float64
+	Load15 float64
+}
+
+// cpuTimes are the jiffies a CPU spent busy and in total.
+type cpuTimes struct {
+	busy  uint64
+	total uint64
+}
+
+// Sampler samples CPU and memory utilisation from procfs and keeps the
+// latest samples in a rolling window. It also derives the disk IO rates over
+// its interval, the Collector reports them with the disk counters.
+type Sampler struct {
+	procRoot  string
+	sysRoot   string
+	size      int
+	diskNames []string
+
+	prevTotal cpuTimes
+	prevCores []cpuTimes
+	window    []Sample
+	prevDisks *diskSample
+	disks     map[string]DiskIO
+
+	lock sync.RWMutex
+}
+
+// NewSampler returns a sampler reading procfs at procRoot and sysfs at
+// sysRoot which keeps the last size samples.
+func NewSampler(procRoot, sysRoot string, size int) *Sampler {
+	if size < 1 {
+		size = 1
+	}
+	return &Sampler{procRoot: procRoot, sysRoot: sysRoot, size: size, diskNames: diskNames()}
+}
+
+// Run takes a sample every interval until stop is closed, it returns at once
+// if the interval is not positive.
+func (s *Sampler) Run(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Sample(); err != nil {
+			logs.Warn("sample cpu and memory usage failed: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sample takes a sample and adds it to the window. CPU utilisation is the
+// rate since the previous sample, so the first sample reports the average
+// since boot.
+func (s *Sampler) Sample() (Sample, error) {
+	total, cores, err := readCPUTimes(filepath.Join(s.procRoot, statFile))
+	if err != nil {
+		return Sample{}, err
+	}
+	mem, err := readMemory(filepath.Join(s.procRoot, memInfoFile))
+	if err != nil {
+		return Sample{}, err
+	}
+	load, err := readLoadAverage(filepath.Join(s.procRoot, loadAvgFile))
+	if err != nil {
+		return Sample{}, err
+	}
+	disks, err := readDiskIO(filepath.Join(s.procRoot, diskStatsFile),
+		filepath.Join(s.sysRoot, sysBlockDir), s.diskNames)
+	if err != nil {
+		logs.Warn("sample disk io failed: %v", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sampleDisks(time.Now(), disks)
+	sample := Sample{
+		Time:         time.Now(),
+		CPU:          utilisation(s.prevTotal, total),
+		Cores:        make([]float64, len(cores)),
+		Load:         load,
+		MemTotal:     mem.MemTotal,
+		MemAvailable: mem.MemAvailable,
+		MemUsed:      mem.MemTotal - mem.MemAvailable,
+		Buffers:      mem.Buffers,
+		Cached:       mem.Cached,
+	}
+	for i, cur := range cores {
+		var prev cpuTimes
+		if i < len(s.prevCores) {
+			prev = s.prevCores[i]
+		}
+		sample.Cores[i] = utilisation(prev, cur)
+	}
+	if mem.MemTotal > 0 {
+		sample.MemUsage = percent(float64(sample.MemUsed), float64(mem.MemTotal))
+	}
+	s.prevTotal, s.prevCores = total, cores
+
+	s.window = append(s.window, sample)
+	if len(s.window) > s.size {
+		s.window = append(s.window[:0], s.window[len(s.window)-s.size:]...)
+	}
+	return sample, nil
+}
+
+// sampleDisks derives the disk IO rates since the previous sample, the caller
+// holds the lock. Unreadable counters clear the rates.
+func (s *Sampler) sampleDisks(now time.Time, counters map[string]DiskIO) {
+	prev := s.prevDisks
+	s.disks = make(map[string]DiskIO, len(counters))
+	s.prevDisks = nil
+	if counters == nil {
+		return
+	}
+	s.prevDisks = &diskSample{at: now, counters: counters}
+	if prev == nil {
+		return
+	}
+	for name, cur := range counters {
+		if old, ok := prev.counters[name]; ok {
+			s.disks[name] = diskRates(old, cur, now.Sub(prev.at))
+		}
+	}
+}
+
+// diskRates returns the disk IO rates of the latest interval by disk name.
+func (s *Sampler) diskRates() map[string]DiskIO {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.disks
+}
+
+// Samples returns the samples in the window, oldest first.
+func (s *Sampler) Samples() []Sample {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	samples := make([]Sample, len(s.window))
+	copy(samples, s.window)
+	return samples
+}
+
+// readCPUTimes reads the aggregate and per-core CPU times from /proc/stat.
+func readCPUTimes(path string) (total cpuTimes, cores []cpuTimes, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return total, nil, err
+	}
+	defer f.Close()
+
+	found := false
+	b := bufio.NewScanner(f)
+	for b.Scan() {
+		fields := strings.Fields(b.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		times, err := parseCPUTimes(fields[1:])
+		if err != nil {
+			return total, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if fields[0] == "cpu" {
+			total, found = times, true
+		} else {
+			cores = append(cores, times)
+		}
+	}
+	if err = b.Err(); err != nil {
+		return total, nil, err
+	}
+	if !found {
+		return total, nil, fmt.Errorf("%s: no cpu line", path)
+	}
+	return total, cores, nil
+}
+
+// parseCPUTimes parses the columns user nice system idle iowait irq softirq
+// steal of a cpu line, guest time is already part of user and nice.
+func parseCPUTimes(fields []string) (cpuTimes, error) {
+	var t cpuTimes
+	for i, field := range fields {
+		if i >= 8 {
+			break
+		}
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return t, fmt.Errorf("invalid cpu time %q", field)
+		}
+		t.total += v
+		if i != 3 && i != 4 { // idle and iowait
+			t.busy += v
+		}
+	}
+	return t, nil
+}
+
+// readLoadAverage reads /proc/loadavg.
+func readLoadAverage(path string) (LoadAverage, error) {
+	var load LoadAverage
+	fields := strings.Fields(readString(path))
+	if len(fields) < 3 {
+		return load, fmt.Errorf("%s: invalid load average", path)
+	}
+	for i, v := range []*float64{&load.Load1, &load.Load5, &load.Load15} {
+		f, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return load, fmt.Errorf("%s: invalid load average %q", path, fields[i])
+		}
+		*v = f
+	}
+	return load, nil
+}
+
+// utilisation returns the busy percentage between two readings of a CPU.
+func utilisation(prev, cur cpuTimes) float64 {
+	if cur.total <= prev.total || cur.busy < prev.busy {
+		return 0
+	}
+	return percent(float64(cur.busy-prev.busy), float64(cur.total-prev.total))
+}
+
+func percent(part, whole float64) float64 {
+	return float64(int64(part/whole*10000+0.5)) / 100
+}
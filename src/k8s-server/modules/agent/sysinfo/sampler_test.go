@@ -0,0 +1,100 @@
+package sysinfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sampler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{statFile, memInfoFile, loadAvgFile, diskStatsFile} {
+		b, err := ioutil.ReadFile(filepath.Join("testdata/proc", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewSampler(dir, "testdata/sys", 2)
+	first, err := s.Sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.CPU != 25 || first.Load != (LoadAverage{1.25, 0.8, 0.5}) {
+		t.Errorf("first sample cpu = %v, load = %+v, want 25 and 1.25 0.8 0.5", first.CPU, first.Load)
+	}
+	if first.MemAvailable != 12123456 || first.MemUsed != 16314744-12123456 || first.MemUsage != 25.69 {
+		t.Errorf("unexpected memory usage %+v", first)
+	}
+
+	// cpu0 fully busy and cpu3 idle over the interval, the others half busy.
+	stat := `cpu  4400 0 1000 14300 1000 0 0 0 0 0
+cpu0 1200 0 250 3500 250 0 0 0 0 0
+cpu1 1100 0 250 3600 250 0 0 0 0 0
+cpu2 1100 0 250 3600 250 0 0 0 0 0
+cpu3 1000 0 250 3600 250 0 0 0 0 0
+`
+	if err = ioutil.WriteFile(filepath.Join(dir, statFile), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.CPU != 57.14 || !reflect.DeepEqual(second.Cores, []float64{100, 50, 50, 0}) {
+		t.Errorf("second sample cpu = %v, cores = %v", second.CPU, second.Cores)
+	}
+
+	if _, err = s.Sample(); err != nil {
+		t.Fatal(err)
+	}
+	samples := s.Samples()
+	if len(samples) != 2 || samples[0].CPU != 57.14 || samples[1].CPU != 0 {
+		t.Errorf("window holds %+v, want the last two samples", samples)
+	}
+}
+
+func TestSamplerDisabled(t *testing.T) {
+	s := NewSampler("/nonexistent", "/nonexistent", 2)
+	done := make(chan struct{})
+	go func() {
+		s.Run(0, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run with a zero interval did not return")
+	}
+	if samples := s.Samples(); len(samples) != 0 {
+		t.Errorf("disabled sampler took %+v", samples)
+	}
+}
+
+func TestReadMemoryWithoutMemAvailable(t *testing.T) {
+	f, err := ioutil.TempFile("", "meminfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("MemTotal: 1000 kB\nMemFree: 100 kB\nBuffers: 50 kB\nCached: 250 kB\n")
+	f.Close()
+
+	mem, err := readMemory(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.MemAvailable != 400 {
+		t.Errorf("MemAvailable = %d, want 400", mem.MemAvailable)
+	}
+}
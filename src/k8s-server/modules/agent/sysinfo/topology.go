@@ -0,0 +1,121 @@
+package sysinfo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	sysNodeDir      = "devices/system/node"
+	sysHugePagesDir = "kernel/mm/hugepages"
+)
+
+var (
+	reNodeDir      = regexp.MustCompile(`^node(\d+)$`)
+	reHugePagesDir = regexp.MustCompile(`^hugepages-(\d+)kB$`)
+	reNodeMemTotal = regexp.MustCompile(`MemTotal:\s+(\d+) kB`)
+)
+
+// NUMANode is a NUMA node with its CPUs and local memory.
+type NUMANode struct {
+	ID        int
+	CPUList   string // kernel cpulist format, e.g. "0-7,16-23"
+	CPUs      []int
+	MemTotal  int64 // kB
+	Distances []int
+	HugePages []HugePages
+}
+
+// HugePages is a hugepage pool of one page size.
+type HugePages struct {
+	PageSize int64 // kB
+	Total    int64
+	Free     int64
+}
+
+// getTopologyInfo reads the NUMA layout and the hugepage pools from sysfs.
+func (c *Collector) getTopologyInfo() {
+	c.Info.CPU.NUMANodes = []NUMANode{}
+	entries, err := ioutil.ReadDir(c.sysPath(sysNodeDir))
+	if err == nil {
+		for _, e := range entries {
+			m := reNodeDir.FindStringSubmatch(e.Name())
+			if m == nil {
+				continue
+			}
+			id, _ := strconv.Atoi(m[1])
+			dir := c.sysPath(sysNodeDir, e.Name())
+			node := NUMANode{
+				ID:        id,
+				CPUList:   readString(filepath.Join(dir, "cpulist")),
+				HugePages: readHugePages(filepath.Join(dir, "hugepages")),
+			}
+			node.CPUs, _ = parseCPUList(node.CPUList)
+			if m := reNodeMemTotal.FindStringSubmatch(readString(filepath.Join(dir, "meminfo"))); m != nil {
+				node.MemTotal, _ = strconv.ParseInt(m[1], 10, 64)
+			}
+			for _, d := range strings.Fields(readString(filepath.Join(dir, "distance"))) {
+				n, _ := strconv.Atoi(d)
+				node.Distances = append(node.Distances, n)
+			}
+			c.Info.CPU.NUMANodes = append(c.Info.CPU.NUMANodes, node)
+		}
+	}
+	sort.Slice(c.Info.CPU.NUMANodes, func(i, j int) bool {
+		return c.Info.CPU.NUMANodes[i].ID < c.Info.CPU.NUMANodes[j].ID
+	})
+	c.Info.CPU.HugePages = readHugePages(c.sysPath(sysHugePagesDir))
+}
+
+// readHugePages reads the hugepages-<size>kB pools below dir.
+func readHugePages(dir string) []HugePages {
+	pools := []HugePages{}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return pools
+	}
+	for _, e := range entries {
+		m := reHugePagesDir.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(m[1], 10, 64)
+		pools = append(pools, HugePages{
+			PageSize: size,
+			Total:    readInt(filepath.Join(dir, e.Name(), "nr_hugepages")),
+			Free:     readInt(filepath.Join(dir, e.Name(), "free_hugepages")),
+		})
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].PageSize < pools[j].PageSize })
+	return pools
+}
+
+// parseCPUList parses the kernel cpulist format, e.g. "0-3,8,10-11".
+func parseCPUList(list string) ([]int, error) {
+	cpus := []int{}
+	if list == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpu list %q", list)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
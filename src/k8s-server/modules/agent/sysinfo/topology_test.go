@@ -0,0 +1,53 @@
+package sysinfo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopologyInfo(t *testing.T) {
+	info := newTestCollector(t).Collect()
+	cpu := info.CPU
+
+	if len(cpu.NUMANodes) != 2 {
+		t.Fatalf("found %d NUMA nodes, want 2", len(cpu.NUMANodes))
+	}
+	node := cpu.NUMANodes[1]
+	if node.ID != 1 || node.CPUList != "2-3" || !reflect.DeepEqual(node.CPUs, []int{2, 3}) {
+		t.Errorf("unexpected node %+v", node)
+	}
+	if node.MemTotal != 8157372 || !reflect.DeepEqual(node.Distances, []int{21, 10}) {
+		t.Errorf("unexpected node memory or distances %+v", node)
+	}
+	want := []HugePages{{PageSize: 2048, Total: 512, Free: 500}, {PageSize: 1048576, Total: 2, Free: 2}}
+	if !reflect.DeepEqual(cpu.NUMANodes[0].HugePages, want) {
+		t.Errorf("node 0 hugepages = %+v, want %+v", cpu.NUMANodes[0].HugePages, want)
+	}
+	if len(cpu.HugePages) != 2 || cpu.HugePages[0].Total != 1024 || cpu.HugePages[0].Free != 1012 {
+		t.Errorf("unexpected hugepage pools %+v", cpu.HugePages)
+	}
+	if !hasFlag(cpu.Flags, "avx512f") || !hasFlag(cpu.Flags, "avx2") {
+		t.Errorf("flags missing from %v", cpu.Flags)
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("0-2,8,10-11")
+	if err != nil || !reflect.DeepEqual(cpus, []int{0, 1, 2, 8, 10, 11}) {
+		t.Errorf("got %v, %v", cpus, err)
+	}
+	for _, bad := range []string{"a", "3-1", "1-x", "1,,2"} {
+		if _, err := parseCPUList(bad); err == nil {
+			t.Errorf("invalid list %q accepted", bad)
+		}
+	}
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
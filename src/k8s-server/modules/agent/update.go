@@ -0,0 +1,446 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"k8s-server/conf"
+	"k8s-server/utils/logs"
+)
+
+// Headers of an update request, the request body is the agent binary. The
+// expiry is in seconds since the epoch, a downgrade is allowed by "true".
+const (
+	HeaderUpdateVersion        = "X-Agent-Version"
+	HeaderUpdateChecksum       = "X-Agent-Checksum"
+	HeaderUpdateExpires        = "X-Agent-Update-Expires"
+	HeaderUpdateAllowDowngrade = "X-Agent-Allow-Downgrade"
+	HeaderUpdateSignature      = "X-Agent-Signature"
+)
+
+// MaxUpdateSize is the largest agent binary accepted.
+const MaxUpdateSize = 256 << 20
+
+// SuperviseFlag is the flag the previous binary is restarted with to
+// supervise an update, its value is the path of the agent executable.
+const SuperviseFlag = "supervise-update"
+
+// PendingUpdate is an installed update waiting for the restarted agent to
+// publish a heartbeat.
+type PendingUpdate struct {
+	Version  string    `json:"version"`
+	Previous string    `json:"previous"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// invalidUpdate is an update rejected by verification.
+type invalidUpdate struct{ error }
+
+var errUpdatePending = fmt.Errorf("a previous update is not confirmed yet")
+
+// updater replaces the agent binary in place. The running binary is kept as
+// exe.old and the update is recorded in exe.update until the restarted agent
+// confirms it with a heartbeat, an unconfirmed update is rolled back. The
+// previous binary supervises the new one until then, so a binary that fails
+// before it could roll back itself is rolled back too.
+type updater struct {
+	exe       string
+	publicKey ed25519.PublicKey
+	timeout   time.Duration
+	running   string
+	// versionOf runs a binary to read its version, restart replaces the
+	// agent process with a binary and handOver with the previous binary
+	// supervising the update
+	versionOf func(path string) (string, error)
+	restart   func(path string) error
+	handOver  func(backup, exe string) error
+
+	timer *time.Timer
+	lock  sync.Mutex
+}
+
+// newUpdater returns the updater of the running executable, updates are
+// disabled and nil is returned if no public key is configured.
+func newUpdater() (*updater, error) {
+	key := conf.AgentUpdatePublicKey()
+	if key == "" {
+		return nil, nil
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid agent::UpdatePublicKey")
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("locate agent executable failed: %v", err)
+	}
+	return &updater{
+		exe:       exe,
+		publicKey: publicKey,
+		timeout:   conf.AgentUpdateConfirmTimeout(),
+		running:   BuildVersion(),
+		versionOf: binaryVersion,
+		restart:   execBinary,
+		handOver:  execSupervisor,
+	}, nil
+}
+
+func (u *updater) backup() string { return u.exe + ".old" }
+func (u *updater) marker() string { return u.exe + ".update" }
+
+// install verifies the update and replaces the agent binary, the caller
+// restarts the agent. Only newer versions are installed unless the manifest
+// allows a downgrade.
+func (u *updater) install(m UpdateManifest, signature []byte, body io.Reader) (PendingUpdate, error) {
+	var p PendingUpdate
+	version := m.Version
+	checksum := strings.ToLower(m.Checksum)
+	if err := VerifyUpdate(u.publicKey, m, signature); err != nil {
+		return p, invalidUpdate{err}
+	}
+	if !m.AllowDowngrade && !newerVersion(version, u.running) {
+		return p, invalidUpdate{fmt.Errorf("agent %s is not newer than the running %s", version, u.running)}
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if _, err := os.Stat(u.marker()); err == nil {
+		return p, errUpdatePending
+	}
+
+	// stage the binary next to the executable, the rename replacing it must
+	// not cross file systems
+	f, err := ioutil.TempFile(filepath.Dir(u.exe), ".agent-update-")
+	if err != nil {
+		return p, err
+	}
+	staged := f.Name()
+	defer os.Remove(staged)
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), body)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return p, fmt.Errorf("receive agent binary failed: %v", err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != checksum {
+		return p, invalidUpdate{fmt.Errorf("checksum mismatch, received %s", sum)}
+	}
+	if err = os.Chmod(staged, 0755); err != nil {
+		return p, err
+	}
+	got, err := u.versionOf(staged)
+	if err != nil {
+		return p, invalidUpdate{fmt.Errorf("run agent binary failed: %v", err)}
+	}
+	if got != version {
+		return p, invalidUpdate{fmt.Errorf("agent binary reports version %q, want %q", got, version)}
+	}
+
+	// the running binary is linked as the backup, the executable path is
+	// never missing
+	os.Remove(u.backup())
+	if err = os.Link(u.exe, u.backup()); err != nil {
+		return p, fmt.Errorf("back up agent binary failed: %v", err)
+	}
+	p = PendingUpdate{Version: version, Previous: u.running, Deadline: time.Now().Add(u.timeout)}
+	if err = writeFileAtomic(u.marker(), p); err != nil {
+		return p, err
+	}
+	if err = os.Rename(staged, u.exe); err != nil {
+		os.Remove(u.marker())
+		return p, fmt.Errorf("replace agent binary failed: %v", err)
+	}
+	logs.Info("agent updated from %s to %s, confirm before %s", p.Previous, p.Version,
+		p.Deadline.Format(time.RFC3339))
+	return p, nil
+}
+
+// resume continues a pending update after the restart. The update is rolled
+// back at once if its deadline passed, e.g. because the new binary keeps
+// crashing, else when it is not confirmed before the deadline.
+func (u *updater) resume() {
+	p, err := u.pending()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logs.Warn("read pending agent update failed: %v", err)
+		}
+		return
+	}
+	if p.Version != u.running {
+		// the binary was replaced by other means since
+		logs.Warn("discard pending agent update to %s, running %s", p.Version, u.running)
+		os.Remove(u.marker())
+		return
+	}
+	wait := time.Until(p.Deadline)
+	if wait <= 0 {
+		u.rollback()
+		return
+	}
+	u.lock.Lock()
+	u.timer = time.AfterFunc(wait, u.rollback)
+	u.lock.Unlock()
+}
+
+// confirm accepts a pending update once the agent published a heartbeat.
+func (u *updater) confirm() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.timer == nil {
+		return
+	}
+	u.timer.Stop()
+	u.timer = nil
+	os.Remove(u.marker())
+	os.Remove(u.backup())
+	logs.Info("agent update to %s confirmed", u.running)
+}
+
+// rollback restores the previous binary and restarts it.
+func (u *updater) rollback() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.timer = nil
+	p, err := u.pending()
+	if err != nil {
+		// confirmed meanwhile
+		return
+	}
+	logs.Warn("agent %s was not confirmed before %s, roll back to %s", p.Version,
+		p.Deadline.Format(time.RFC3339), p.Previous)
+	u.revert(p)
+}
+
+// revert restores the previous binary of the pending update and restarts it.
+func (u *updater) revert(p PendingUpdate) {
+	if err := os.Rename(u.backup(), u.exe); err != nil {
+		logs.Error("roll back agent update failed: %v", err)
+		return
+	}
+	os.Remove(u.marker())
+	if err := u.restart(u.exe); err != nil {
+		logs.Critical("restart agent %s failed: %v", p.Previous, err)
+	}
+}
+
+// supervise runs the updated binary with args as a child until the update is
+// confirmed. The update is rolled back if the child exits before, or is
+// killed if it did not confirm the update by the deadline. It returns the
+// exit status of the child once the update is confirmed.
+func (u *updater) supervise(args []string) int {
+	p, err := u.pending()
+	if err != nil {
+		// nothing to supervise
+		if err = u.restart(u.exe); err != nil {
+			logs.Critical("restart agent failed: %v", err)
+		}
+		return 1
+	}
+	cmd := exec.Command(u.exe, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Start(); err != nil {
+		logs.Error("start agent %s failed: %v", p.Version, err)
+		u.revert(p)
+		return 1
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	deadline := time.NewTimer(time.Until(p.Deadline))
+	defer deadline.Stop()
+	for {
+		select {
+		case sig := <-signals:
+			cmd.Process.Signal(sig)
+		case err = <-exited:
+			if _, perr := u.pending(); perr == nil {
+				logs.Warn("agent %s exited before the update was confirmed: %v", p.Version, err)
+				u.revert(p)
+				return 1
+			}
+			return cmd.ProcessState.ExitCode()
+		case <-deadline.C:
+			if _, perr := u.pending(); perr == nil {
+				logs.Warn("agent %s did not confirm the update before %s, roll back to %s", p.Version,
+					p.Deadline.Format(time.RFC3339), p.Previous)
+				cmd.Process.Kill()
+				<-exited
+				u.revert(p)
+				return 1
+			}
+		}
+	}
+}
+
+func (u *updater) pending() (PendingUpdate, error) {
+	var p PendingUpdate
+	b, err := ioutil.ReadFile(u.marker())
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(b, &p)
+	return p, err
+}
+
+// SuperviseUpdate supervises the update of the agent executable exe, it is
+// run by the previous binary restarted with SuperviseFlag. It returns the
+// exit status of the updated agent once the update is confirmed, a rollback
+// replaces the process with the previous binary.
+func SuperviseUpdate(exe string) int {
+	u := &updater{exe: exe, running: BuildVersion(), restart: execBinary}
+	return u.supervise(agentArgs(os.Args)[1:])
+}
+
+// ResumeUpdate rolls back or watches an update installed before the agent
+// restarted, it is called before the agent starts serving.
+func (s *Server) ResumeUpdate() {
+	if s.updater != nil {
+		s.updater.resume()
+	}
+}
+
+func (s *Server) confirmUpdate() {
+	if s.updater != nil {
+		s.updater.confirm()
+	}
+}
+
+// update installs the agent binary in the request body and restarts the
+// agent with it. Updates need an agent token, the updater of an agent without
+// one only confirms or rolls back a pending update.
+func (s *Server) update(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if s.updater == nil || s.token == "" {
+		writeError(w, http.StatusForbidden, "agent updates are disabled")
+		return
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(HeaderUpdateSignature))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid signature encoding")
+		return
+	}
+	m, err := updateManifest(r.Header)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, MaxUpdateSize)
+	p, err := s.updater.install(m, signature, body)
+	switch err.(type) {
+	case nil:
+	case invalidUpdate:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	default:
+		if err == errUpdatePending {
+			writeError(w, http.StatusConflict, err.Error())
+		} else {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusAccepted, p)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	go func() {
+		// let the response reach the server before the process is replaced
+		time.Sleep(time.Second)
+		if err := s.updater.handOver(s.updater.backup(), s.updater.exe); err != nil {
+			logs.Critical("restart agent %s failed: %v", p.Version, err)
+		}
+	}()
+}
+
+// updateManifest reads the manifest of an update request.
+func updateManifest(h http.Header) (UpdateManifest, error) {
+	m := UpdateManifest{
+		Version:  h.Get(HeaderUpdateVersion),
+		Checksum: h.Get(HeaderUpdateChecksum),
+	}
+	expires, err := strconv.ParseInt(h.Get(HeaderUpdateExpires), 10, 64)
+	if err != nil {
+		return m, fmt.Errorf("invalid update expiry")
+	}
+	m.Expires = time.Unix(expires, 0)
+	if v := h.Get(HeaderUpdateAllowDowngrade); v != "" {
+		if m.AllowDowngrade, err = strconv.ParseBool(v); err != nil {
+			return m, fmt.Errorf("invalid downgrade permission")
+		}
+	}
+	return m, nil
+}
+
+// binaryVersion returns the version printed by "<binary> -version".
+func binaryVersion(path string) (string, error) {
+	out, err := exec.Command(path, "-version").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// execBinary replaces the agent process with the binary.
+func execBinary(path string) error {
+	return syscall.Exec(path, agentArgs(os.Args), os.Environ())
+}
+
+// execSupervisor replaces the agent process with the previous binary backup
+// supervising the update of exe.
+func execSupervisor(backup, exe string) error {
+	args := agentArgs(os.Args)
+	args = append([]string{args[0], "-" + SuperviseFlag + "=" + exe}, args[1:]...)
+	return syscall.Exec(backup, args, os.Environ())
+}
+
+// agentArgs returns the command line without SuperviseFlag.
+func agentArgs(args []string) []string {
+	kept := make([]string, 0, len(args))
+	for _, arg := range args {
+		if !strings.HasPrefix(strings.TrimLeft(arg, "-"), SuperviseFlag+"=") {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+func writeFileAtomic(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,308 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// testUpdater returns an updater of a fake executable in a temporary
+// directory, binaries report the version in their first line.
+func testUpdater(t *testing.T, publicKey ed25519.PublicKey) (*updater, *[]string, func()) {
+	dir, err := ioutil.TempDir("", "agent-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "hpc-agent")
+	if err = ioutil.WriteFile(exe, []byte("1.0.0\nold binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var restarts []string
+	u := &updater{
+		exe:       exe,
+		publicKey: publicKey,
+		timeout:   time.Minute,
+		running:   "1.0.0",
+		versionOf: func(path string) (string, error) {
+			b, err := ioutil.ReadFile(path)
+			return strings.SplitN(string(b), "\n", 2)[0], err
+		},
+		restart: func(path string) error {
+			restarts = append(restarts, path)
+			return nil
+		},
+	}
+	return u, &restarts, func() { os.RemoveAll(dir) }
+}
+
+// manifest returns the manifest of an update valid for an hour.
+func manifest(version string, binary []byte) UpdateManifest {
+	return UpdateManifest{Version: version, Checksum: Checksum(binary), Expires: time.Now().Add(time.Hour)}
+}
+
+func sign(privateKey ed25519.PrivateKey, m UpdateManifest) []byte {
+	return ed25519.Sign(privateKey, UpdateMessage(m))
+}
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestUpdateInstall(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _, cleanup := testUpdater(t, publicKey)
+	defer cleanup()
+	binary := []byte("1.1.0\nnew binary")
+	m := manifest("1.1.0", binary)
+	expired := m
+	expired.Expires = time.Now().Add(-time.Minute)
+	downgrade := manifest("0.9.0", []byte("0.9.0\nold binary"))
+	reinstall := manifest("1.0.0", []byte("1.0.0\nold binary"))
+	extended := m
+	extended.Expires = m.Expires.Add(time.Hour)
+	allowed := downgrade
+	allowed.AllowDowngrade = true
+
+	tests := []struct {
+		name      string
+		manifest  UpdateManifest
+		binary    []byte
+		signature []byte
+	}{
+		{"unsigned", m, binary, make([]byte, ed25519.SignatureSize)},
+		{"other version", manifest("1.2.0", binary), binary, sign(privateKey, m)},
+		{"tampered binary", m, []byte("1.1.0\nevil binary"), sign(privateKey, m)},
+		{"version mismatch", manifest("1.2.0", binary), binary, sign(privateKey, manifest("1.2.0", binary))},
+		{"expired", expired, binary, sign(privateKey, expired)},
+		{"extended expiry", extended, binary, sign(privateKey, m)},
+		{"downgrade", downgrade, []byte("0.9.0\nold binary"), sign(privateKey, downgrade)},
+		{"same version", reinstall, []byte("1.0.0\nold binary"), sign(privateKey, reinstall)},
+		{"unsigned downgrade permission", allowed, []byte("0.9.0\nold binary"), sign(privateKey, downgrade)},
+	}
+	for _, tt := range tests {
+		_, err := u.install(tt.manifest, tt.signature, bytes.NewReader(tt.binary))
+		if _, ok := err.(invalidUpdate); !ok {
+			t.Errorf("%s: install returned %v, want an invalid update", tt.name, err)
+		}
+	}
+	if got := readFile(t, u.exe); got != "1.0.0\nold binary" {
+		t.Fatalf("rejected updates replaced the binary with %q", got)
+	}
+
+	p, err := u.install(m, sign(privateKey, m), bytes.NewReader(binary))
+	if err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	if p.Version != "1.1.0" || p.Previous != "1.0.0" {
+		t.Errorf("unexpected pending update %+v", p)
+	}
+	if got := readFile(t, u.exe); got != string(binary) {
+		t.Errorf("binary = %q after update", got)
+	}
+	if got := readFile(t, u.backup()); got != "1.0.0\nold binary" {
+		t.Errorf("backup = %q after update", got)
+	}
+	if _, err = u.install(m, sign(privateKey, m), bytes.NewReader(binary)); err != errUpdatePending {
+		t.Errorf("second install returned %v, want %v", err, errUpdatePending)
+	}
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(u.exe), ".agent-update-*"))
+	if len(files) != 0 {
+		t.Errorf("staged binaries left behind: %v", files)
+	}
+
+	// a signed downgrade is installed
+	u, _, cleanup = testUpdater(t, publicKey)
+	defer cleanup()
+	old := []byte("0.9.0\nold binary")
+	if _, err = u.install(allowed, sign(privateKey, allowed), bytes.NewReader(old)); err != nil {
+		t.Fatalf("install of an allowed downgrade failed: %v", err)
+	}
+	if got := readFile(t, u.exe); got != string(old) {
+		t.Errorf("binary = %q after downgrade", got)
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		version, running string
+		newer            bool
+	}{
+		{"1.1.0", "1.0.0", true},
+		{"1.10.0", "1.9.0", true},
+		{"v2.0", "1.9.9", true},
+		{"1.0.1", "1.0", true},
+		{"1.0.0", "1.0.0-rc.1", true},
+		{"1.0.0-rc.2", "1.0.0-rc.1", true},
+		{"1.0.0", "1.0.0", false},
+		{"1.0.0-rc.1", "1.0.0", false},
+		{"0.9.0", "1.0.0", false},
+		{"1.1.0", "0a1b2c3", false},
+		{"latest", "1.0.0", false},
+	}
+	for _, tt := range tests {
+		if got := newerVersion(tt.version, tt.running); got != tt.newer {
+			t.Errorf("newerVersion(%q, %q) = %v, want %v", tt.version, tt.running, got, tt.newer)
+		}
+	}
+}
+
+func TestUpdateResume(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := []byte("1.1.0\nnew binary")
+	install := func(u *updater) {
+		m := manifest("1.1.0", binary)
+		if _, err := u.install(m, sign(privateKey, m), bytes.NewReader(binary)); err != nil {
+			t.Fatalf("install failed: %v", err)
+		}
+		// the restarted agent runs the new version
+		u.running = "1.1.0"
+	}
+
+	// confirmed by a heartbeat
+	u, restarts, cleanup := testUpdater(t, publicKey)
+	defer cleanup()
+	install(u)
+	u.resume()
+	u.confirm()
+	if _, err = os.Stat(u.marker()); !os.IsNotExist(err) {
+		t.Errorf("pending update kept after confirm: %v", err)
+	}
+	if _, err = os.Stat(u.backup()); !os.IsNotExist(err) {
+		t.Errorf("backup kept after confirm: %v", err)
+	}
+	if len(*restarts) != 0 {
+		t.Errorf("confirmed update restarted %v", *restarts)
+	}
+
+	// restarted after the deadline
+	u, restarts, cleanup = testUpdater(t, publicKey)
+	defer cleanup()
+	u.timeout = -time.Second
+	install(u)
+	u.resume()
+	if got := readFile(t, u.exe); got != "1.0.0\nold binary" {
+		t.Errorf("binary = %q after rollback", got)
+	}
+	if len(*restarts) != 1 {
+		t.Errorf("rollback restarted %d times, want 1", len(*restarts))
+	}
+
+	// no heartbeat before the deadline
+	u, restarts, cleanup = testUpdater(t, publicKey)
+	defer cleanup()
+	u.timeout = 50 * time.Millisecond
+	install(u)
+	u.resume()
+	time.Sleep(200 * time.Millisecond)
+	u.confirm()
+	if got := readFile(t, u.exe); got != "1.0.0\nold binary" {
+		t.Errorf("binary = %q after rollback", got)
+	}
+	if _, err = os.Stat(u.marker()); !os.IsNotExist(err) {
+		t.Errorf("pending update kept after rollback: %v", err)
+	}
+}
+
+func TestUpdateSupervise(t *testing.T) {
+	for _, tt := range []struct {
+		name, script string
+		status       int
+		rollback     bool
+	}{
+		{"crash", "exit 3", 1, true},
+		{"hang", "exec sleep 10", 1, true},
+		{"confirm", `rm -f "$0.update"; exit 4`, 4, false},
+	} {
+		u, restarts, cleanup := testUpdater(t, nil)
+		script := "#!/bin/sh\n" + tt.script + "\n"
+		if err := ioutil.WriteFile(u.exe, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(u.backup(), []byte("old binary"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		p := PendingUpdate{Version: "1.1.0", Previous: "1.0.0", Deadline: time.Now().Add(200 * time.Millisecond)}
+		if err := writeFileAtomic(u.marker(), p); err != nil {
+			t.Fatal(err)
+		}
+		if status := u.supervise(nil); status != tt.status {
+			t.Errorf("%s: exit status %d, want %d", tt.name, status, tt.status)
+		}
+		if got, want := readFile(t, u.exe), script; tt.rollback {
+			if got != "old binary" || len(*restarts) != 1 {
+				t.Errorf("%s: binary %q after %d restarts, want a rollback", tt.name, got, len(*restarts))
+			}
+		} else if got != want || len(*restarts) != 0 {
+			t.Errorf("%s: binary %q after %d restarts, want no rollback", tt.name, got, len(*restarts))
+		}
+		if _, err := os.Stat(u.marker()); !os.IsNotExist(err) {
+			t.Errorf("%s: pending update kept: %v", tt.name, err)
+		}
+		cleanup()
+	}
+}
+
+func TestAgentArgs(t *testing.T) {
+	got := agentArgs([]string{"hpc-agent", "-supervise-update=/usr/bin/hpc-agent", "-v", "--supervise-update=x"})
+	if strings.Join(got, " ") != "hpc-agent -v" {
+		t.Errorf("agent args %q", got)
+	}
+}
+
+func TestUpdateAPI(t *testing.T) {
+	s := newServer(nil, nil, "", ":0")
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/update", strings.NewReader("binary"))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("update without key responded %d, want 403", rec.Code)
+	}
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _, cleanup := testUpdater(t, publicKey)
+	defer cleanup()
+	s.updater = u
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/update", strings.NewReader("binary")))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("update without agent token responded %d, want 403", rec.Code)
+	}
+
+	s = newServer(nil, nil, "secret", ":0")
+	s.updater = u
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/update", strings.NewReader("binary"))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set(HeaderUpdateVersion, "1.1.0")
+	req.Header.Set(HeaderUpdateChecksum, Checksum([]byte("binary")))
+	req.Header.Set(HeaderUpdateExpires, strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	req.Header.Set(HeaderUpdateSignature, base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize)))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unsigned update responded %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"k8s-server/conf"
+)
+
+// Version is the agent build version, release builds set it with
+// -ldflags "-X k8s-server/modules/agent.Version=<version>". The server is
+// stamped the same way, the agents are compared to its version.
+var Version string
+
+// BuildVersion returns the version of the running agent, the stamped build
+// version or else the backend commit of the installation.
+func BuildVersion() string {
+	if Version != "" {
+		return Version
+	}
+	return conf.CommitInfo().Backend
+}
+
+// Checksum returns the hex encoded SHA-256 of an agent binary.
+func Checksum(binary []byte) string {
+	sum := sha256.Sum256(binary)
+	return hex.EncodeToString(sum[:])
+}
+
+// UpdateManifest is the signed description of an agent update.
+type UpdateManifest struct {
+	Version  string
+	Checksum string
+	// Expires ends the validity of the signature, a signed update can not be
+	// replayed after it.
+	Expires time.Time
+	// AllowDowngrade accepts the update on agents running the same or a newer
+	// version, else they reject it.
+	AllowDowngrade bool
+}
+
+// UpdateMessage returns the message signed for an agent update, it binds the
+// version to the checksum of the binary so a signed binary can not be
+// announced as another version, and both to the expiry and the downgrade
+// permission.
+func UpdateMessage(m UpdateManifest) []byte {
+	return []byte("k8s-server-agent\n" + m.Version + "\n" + m.Checksum + "\n" +
+		strconv.FormatInt(m.Expires.Unix(), 10) + "\n" + strconv.FormatBool(m.AllowDowngrade) + "\n")
+}
+
+// VerifyUpdate checks the ed25519 signature of an agent update and that it
+// did not expire.
+func VerifyUpdate(publicKey ed25519.PublicKey, m UpdateManifest, signature []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("no valid update public key configured")
+	}
+	if !ed25519.Verify(publicKey, UpdateMessage(m), signature) {
+		return fmt.Errorf("invalid signature of agent %s", m.Version)
+	}
+	if !time.Now().Before(m.Expires) {
+		return fmt.Errorf("signature of agent %s expired at %s", m.Version, m.Expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// newerVersion reports whether version is newer than running. Versions are
+// dotted numbers with an optional "v" prefix and "-" pre-release suffix that
+// ranks below the release, anything else such as a commit is never newer.
+func newerVersion(version, running string) bool {
+	v, vpre, ok := parseVersion(version)
+	r, rpre, rok := parseVersion(running)
+	if !ok || !rok {
+		return false
+	}
+	for i := 0; i < len(v) || i < len(r); i++ {
+		var a, b uint64
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(r) {
+			b = r[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	switch {
+	case vpre == rpre:
+		return false
+	case vpre == "":
+		return true
+	case rpre == "":
+		return false
+	}
+	return vpre > rpre
+}
+
+func parseVersion(s string) ([]uint64, string, bool) {
+	s = strings.TrimPrefix(s, "v")
+	var pre string
+	if i := strings.Index(s, "-"); i >= 0 {
+		s, pre = s[:i], s[i+1:]
+	}
+	parts := strings.Split(s, ".")
+	nums := make([]uint64, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, "", false
+		}
+		nums[i] = n
+	}
+	return nums, pre, true
+}
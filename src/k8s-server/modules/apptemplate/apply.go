@@ -0,0 +1,135 @@
+package apptemplate
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"k8s-server/models/types"
+	"k8s-server/utils/kube"
+	"k8s-server/utils/logs"
+)
+
+// applier creates, updates and deletes the objects of a release. Objects
+// that are not labeled with the release are never changed, so a template can
+// not take over the objects of another release or created by hand.
+type applier interface {
+	// Apply creates or updates obj, it is refused if an object of the same
+	// name exists without the release label of obj.
+	Apply(obj map[string]interface{}) error
+	// Get returns the object of release, nil if it does not exist.
+	Get(release string, ref types.ObjectRef) (map[string]interface{}, error)
+	// Delete deletes the object of release, an already deleted object is not
+	// an error.
+	Delete(release string, ref types.ObjectRef) error
+}
+
+// kubeApplier applies objects with the dynamic client.
+type kubeApplier struct{}
+
+func (kubeApplier) Apply(obj map[string]interface{}) error {
+	u := &unstructured.Unstructured{Object: obj}
+	release := releaseOf(obj)
+	if release == "" {
+		return fmt.Errorf("%s %s has no release label", u.GetKind(), u.GetName())
+	}
+	ri, err := resource(u.GetAPIVersion(), u.GetKind(), u.GetNamespace())
+	if err != nil {
+		return err
+	}
+	existing, err := ri.Get(u.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = ri.Create(u, metav1.CreateOptions{})
+	} else if err == nil {
+		if owner := releaseOf(existing.Object); owner != release {
+			return fmt.Errorf("%s %s already exists and is not part of release %s", u.GetKind(), u.GetName(), release)
+		}
+		u.SetResourceVersion(existing.GetResourceVersion())
+		_, err = ri.Update(u, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("apply %s %s failed: %v", u.GetKind(), u.GetName(), err)
+	}
+	return nil
+}
+
+func (kubeApplier) Get(release string, ref types.ObjectRef) (map[string]interface{}, error) {
+	ri, err := resource(ref.APIVersion, ref.Kind, ref.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	u, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("get %s %s failed: %v", ref.Kind, ref.Name, err)
+	}
+	if releaseOf(u.Object) != release {
+		return nil, nil
+	}
+	return u.Object, nil
+}
+
+func (kubeApplier) Delete(release string, ref types.ObjectRef) error {
+	ri, err := resource(ref.APIVersion, ref.Kind, ref.Namespace)
+	if err != nil {
+		return err
+	}
+	existing, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("get %s %s failed: %v", ref.Kind, ref.Name, err)
+	}
+	if releaseOf(existing.Object) != release {
+		logs.Warn("skip deleting %s %s/%s, it is not part of release %s", ref.Kind, ref.Namespace, ref.Name, release)
+		return nil
+	}
+	// the precondition spares an object recreated meanwhile by someone else
+	uid := existing.GetUID()
+	policy := metav1.DeletePropagationBackground
+	err = ri.Delete(ref.Name, &metav1.DeleteOptions{
+		PropagationPolicy: &policy,
+		Preconditions:     &metav1.Preconditions{UID: &uid},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete %s %s failed: %v", ref.Kind, ref.Name, err)
+	}
+	return nil
+}
+
+// resource returns the namespaced dynamic client of the kind, releases may
+// only contain namespaced objects.
+func resource(apiVersion, kind, namespace string) (dynamic.ResourceInterface, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	mapper, err := kube.Mapper()
+	if err != nil {
+		return nil, err
+	}
+	gk := schema.GroupKind{Group: gv.Group, Kind: kind}
+	mapping, err := mapper.RESTMapping(gk, gv.Version)
+	if meta.IsNoMatchError(err) {
+		// the kind may have been installed after discovery was cached
+		mapper.Reset()
+		mapping, err = mapper.RESTMapping(gk, gv.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return nil, fmt.Errorf("%s is cluster scoped and can not be part of a release", kind)
+	}
+	client, err := kube.Dynamic()
+	if err != nil {
+		return nil, err
+	}
+	return client.Resource(mapping.Resource).Namespace(namespace), nil
+}
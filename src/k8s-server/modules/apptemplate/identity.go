@@ -0,0 +1,139 @@
+package apptemplate
+
+import (
+	"k8s-server/modules/account"
+)
+
+// IdentityProvider returns the identity the pods of a user run with.
+type IdentityProvider interface {
+	Identity(username string) (*account.Identity, error)
+}
+
+// podSpecPaths are the paths of the pod spec in the workload kinds.
+var podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// injectIdentity runs the pods of the objects as username with its home
+// mounted, nothing is changed without an identity provider.
+func (m *Manager) injectIdentity(username string, objects []map[string]interface{}) error {
+	if m.identities == nil {
+		return nil
+	}
+	id, err := m.identities.Identity(username)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if spec := podSpec(obj); spec != nil {
+			setIdentity(spec, id)
+		}
+	}
+	return nil
+}
+
+// podSpec returns the pod spec of a workload object, nil for other kinds.
+func podSpec(obj map[string]interface{}) map[string]interface{} {
+	kind, _ := obj["kind"].(string)
+	p, ok := podSpecPaths[kind]
+	if !ok {
+		return nil
+	}
+	v := obj
+	for _, field := range p {
+		next, _ := v[field].(map[string]interface{})
+		if next == nil {
+			return nil
+		}
+		v = next
+	}
+	return v
+}
+
+// setIdentity sets the user and group of the pod spec and mounts the home in
+// every container. The user and group set by containers are removed, the
+// template can not run a container as another user.
+func setIdentity(spec map[string]interface{}, id *account.Identity) {
+	sc := childMap(spec, "securityContext")
+	sc["runAsUser"] = id.UID
+	sc["runAsGroup"] = id.GID
+	sc["fsGroup"] = id.GID
+	sc["runAsNonRoot"] = true
+
+	// a volume of the template named like the home volume is replaced
+	home := map[string]interface{}{
+		"name":     account.HomeVolume,
+		"hostPath": map[string]interface{}{"path": id.Home, "type": "Directory"},
+	}
+	volumes, _ := spec["volumes"].([]interface{})
+	replaced := false
+	for i, item := range volumes {
+		if v, ok := item.(map[string]interface{}); ok && v["name"] == account.HomeVolume {
+			volumes[i], replaced = home, true
+		}
+	}
+	if !replaced {
+		volumes = append(volumes, home)
+	}
+	spec["volumes"] = volumes
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := spec[field].([]interface{})
+		for _, item := range containers {
+			c, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if csc, ok := c["securityContext"].(map[string]interface{}); ok {
+				delete(csc, "runAsUser")
+				delete(csc, "runAsGroup")
+			}
+			mounts, _ := c["volumeMounts"].([]interface{})
+			if !hasMount(mounts, id.Home) {
+				c["volumeMounts"] = append(mounts, map[string]interface{}{
+					"name":      account.HomeVolume,
+					"mountPath": id.Home,
+				})
+			}
+			env, _ := c["env"].([]interface{})
+			for _, kv := range id.Env() {
+				if !hasEnv(env, kv[0]) {
+					env = append(env, map[string]interface{}{"name": kv[0], "value": kv[1]})
+				}
+			}
+			c["env"] = env
+		}
+	}
+}
+
+func childMap(m map[string]interface{}, key string) map[string]interface{} {
+	child, _ := m[key].(map[string]interface{})
+	if child == nil {
+		child = make(map[string]interface{})
+		m[key] = child
+	}
+	return child
+}
+
+func hasMount(mounts []interface{}, mountPath string) bool {
+	for _, item := range mounts {
+		if mount, ok := item.(map[string]interface{}); ok && mount["mountPath"] == mountPath {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEnv(env []interface{}, name string) bool {
+	for _, item := range env {
+		if e, ok := item.(map[string]interface{}); ok && e["name"] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,571 @@
+// Package apptemplate implements the application template catalog, a small
+// in-house alternative to Helm for training environments. A template is a
+// multi-object YAML rendered with text/template from typed parameters, and
+// every instantiation into a namespace is tracked as a release.
+package apptemplate
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models"
+	"k8s-server/models/types"
+	"k8s-server/modules/account"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+var reName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// Store persists templates and releases.
+type Store interface {
+	models.TemplateStore
+	models.ReleaseStore
+}
+
+// Manager represents the application template manager.
+type Manager struct {
+	store   Store
+	applier applier
+	images  ImageResolver
+	// identities provides the identity the pods of the releases run with
+	identities IdentityProvider
+	// lock serializes release operations, so concurrent upgrades of one
+	// release can not interleave their object changes.
+	lock sync.Mutex
+}
+
+// TemplateRequest describes a template to be created or updated.
+type TemplateRequest struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Content     string      `json:"content"`
+	Parameters  []Parameter `json:"parameters"`
+}
+
+// TemplateView is the API view of a stored template.
+type TemplateView struct {
+	types.Template
+	Parameters []Parameter `json:"parameters"`
+}
+
+// InstallRequest describes a release to be installed.
+type InstallRequest struct {
+	Name     string                 `json:"name"`
+	Template string                 `json:"template"`
+	Values   map[string]interface{} `json:"values"`
+}
+
+// UpgradeRequest describes the new values of a release, values that are not
+// given keep their previous value. Secret values are not stored with the
+// release but in a Secret of the release namespace.
+type UpgradeRequest struct {
+	Values map[string]interface{} `json:"values"`
+}
+
+// ReleaseView is the API view of a stored release.
+type ReleaseView struct {
+	types.Release
+	Values  map[string]interface{} `json:"values"`
+	Objects []types.ObjectRef      `json:"objects"`
+}
+
+// NewManager returns a template manager backed by store, objects are applied
+// to the cluster configured by backend::KubeConfig. Image parameters are
+// resolved by images, and the pods of a release run as the user who
+// installed it with the identity of identities.
+func NewManager(store Store, images ImageResolver, identities IdentityProvider) (*Manager, error) {
+	return &Manager{store: store, applier: kubeApplier{}, images: images, identities: identities}, nil
+}
+
+// ListTemplates returns all templates.
+func (m *Manager) ListTemplates() ([]TemplateView, error) {
+	records, err := m.store.ListTemplates()
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrTemplateList, "list templates failed")
+	}
+	views := make([]TemplateView, 0, len(records))
+	for _, r := range records {
+		views = append(views, TemplateView{Template: r, Parameters: decodeParameters(r.Parameters)})
+	}
+	return views, nil
+}
+
+// GetTemplate returns the template by name.
+func (m *Manager) GetTemplate(name string) (*TemplateView, error) {
+	record, err := m.getTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateView{Template: *record, Parameters: decodeParameters(record.Parameters)}, nil
+}
+
+// CreateTemplate validates and saves a new template.
+func (m *Manager) CreateTemplate(username string, req TemplateRequest) (*TemplateView, error) {
+	if !reName.MatchString(req.Name) {
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "invalid template name %q", req.Name)
+	}
+	params, err := validateTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = m.store.GetTemplate(req.Name); err == nil {
+		return nil, errors.Errorf(def.ErrGeneralConflict, "template %s already exists", req.Name)
+	}
+	now := time.Now()
+	record := types.Template{
+		Name:        req.Name,
+		Description: req.Description,
+		Content:     req.Content,
+		Parameters:  params,
+		Revision:    1,
+		CreatedBy:   username,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err = m.store.AddTemplate(&record); err == types.ErrDuplicate {
+		return nil, errors.Errorf(def.ErrGeneralConflict, "template %s already exists", req.Name)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrTemplateSave, "save template failed")
+	}
+	return &TemplateView{Template: record, Parameters: req.Parameters}, nil
+}
+
+// UpdateTemplate replaces the content and parameters of a template and bumps
+// its revision, existing releases pick the change up on their next upgrade.
+func (m *Manager) UpdateTemplate(name string, req TemplateRequest) (*TemplateView, error) {
+	record, err := m.getTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	params, err := validateTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	record.Description = req.Description
+	record.Content = req.Content
+	record.Parameters = params
+	record.Revision++
+	record.UpdatedAt = time.Now()
+	if err = m.store.UpdateTemplate(record); err != nil {
+		return nil, errors.Wrap(err, def.ErrTemplateSave, "save template failed")
+	}
+	return &TemplateView{Template: *record, Parameters: req.Parameters}, nil
+}
+
+// DeleteTemplate deletes a template that has no releases.
+func (m *Manager) DeleteTemplate(name string) error {
+	if _, err := m.getTemplate(name); err != nil {
+		return err
+	}
+	releases, err := m.store.ListReleases("")
+	if err != nil {
+		return errors.Wrap(err, def.ErrTemplateDelete, "list releases failed")
+	}
+	for _, r := range releases {
+		if r.Template == name {
+			return errors.Errorf(def.ErrGeneralConflict,
+				"template %s is used by release %s/%s", name, r.Namespace, r.Name)
+		}
+	}
+	if err = m.store.DeleteTemplate(name); err != nil {
+		return errors.Wrap(err, def.ErrTemplateDelete, "delete template failed")
+	}
+	return nil
+}
+
+// ListReleases returns the releases in namespace, an empty namespace means
+// all namespaces.
+func (m *Manager) ListReleases(namespace string) ([]ReleaseView, error) {
+	records, err := m.store.ListReleases(namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrReleaseList, "list releases failed")
+	}
+	views := make([]ReleaseView, 0, len(records))
+	for _, r := range records {
+		views = append(views, releaseView(r))
+	}
+	return views, nil
+}
+
+// GetRelease returns the release by namespace and name.
+func (m *Manager) GetRelease(namespace, name string) (*ReleaseView, error) {
+	record, err := m.getRelease(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	view := releaseView(*record)
+	return &view, nil
+}
+
+// Install renders the template with the given values and creates its objects
+// in namespace, which must be open to the user. The release is recorded
+// before any object is applied, so a partially applied release can still be
+// uninstalled.
+func (m *Manager) Install(username, namespace string, req InstallRequest) (*ReleaseView, error) {
+	if !reName.MatchString(req.Name) {
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "invalid release name %q", req.Name)
+	}
+	if err := checkNamespace(username, namespace); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, err := m.store.GetRelease(namespace, req.Name); err == nil {
+		return nil, errors.Errorf(def.ErrGeneralConflict,
+			"release %s already exists in %s", req.Name, namespace)
+	}
+	tmpl, err := m.getTemplate(req.Template)
+	if err != nil {
+		return nil, err
+	}
+	params := decodeParameters(tmpl.Parameters)
+	values, err := resolveValues(params, req.Values)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid values")
+	}
+	rendered, err := renderValues(params, values, m.images)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid values")
+	}
+	info := releaseInfo{Name: req.Name, Namespace: namespace, Revision: 1}
+	objects, err := render(tmpl.Name, tmpl.Content, info, rendered)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "render template failed")
+	}
+	if err = m.injectIdentity(username, objects); err != nil {
+		return nil, err
+	}
+	if secrets := secretValues(params, values); len(secrets) > 0 {
+		objects = append(objects, valuesSecret(tmpl.Name, info, secrets))
+	}
+
+	now := time.Now()
+	record := &types.Release{
+		Name:             req.Name,
+		Namespace:        namespace,
+		Template:         tmpl.Name,
+		TemplateRevision: tmpl.Revision,
+		Revision:         1,
+		Values:           encode(publicValues(params, values)),
+		Objects:          encode(objectRefs(objects)),
+		Status:           types.ReleasePending,
+		CreatedBy:        username,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if err = m.store.AddRelease(record); err == types.ErrDuplicate {
+		return nil, errors.Errorf(def.ErrGeneralConflict, "release %s/%s already exists",
+			record.Namespace, record.Name)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrReleaseInstall, "save release failed")
+	}
+	if err = m.applyAll(objects); err != nil {
+		m.finish(record, err)
+		return nil, errors.Wrap(err, def.ErrReleaseInstall, "install release failed")
+	}
+	if err = m.finish(record, nil); err != nil {
+		return nil, errors.Wrap(err, def.ErrReleaseInstall, "save release failed")
+	}
+	view := releaseView(*record)
+	return &view, nil
+}
+
+// Upgrade renders the latest revision of the template with the merged values,
+// applies the result and deletes the objects that are no longer rendered.
+// The namespace must be open to the user.
+func (m *Manager) Upgrade(username, namespace, name string, req UpgradeRequest) (*ReleaseView, error) {
+	if err := checkNamespace(username, namespace); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	record, err := m.getRelease(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := m.getTemplate(record.Template)
+	if err != nil {
+		return nil, err
+	}
+	params := decodeParameters(tmpl.Parameters)
+	secrets, err := m.storedSecrets(namespace, name)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrReleaseUpgrade, "read secret values failed")
+	}
+	merged := make(map[string]interface{})
+	previous := decodeValues(record.Values)
+	for _, p := range params {
+		if v, ok := previous[p.Name]; ok {
+			merged[p.Name] = v
+		}
+		if v, ok := secrets[p.Name]; ok && p.Type == TypeSecret {
+			merged[p.Name] = v
+		} else if _, ok = req.Values[p.Name]; !ok && p.Type == TypeSecret && p.Default == "" && p.Required {
+			return nil, errors.Errorf(def.ErrGeneralBadRequest,
+				"secret parameter %s must be given again", p.Name)
+		}
+	}
+	for k, v := range req.Values {
+		merged[k] = v
+	}
+	values, err := resolveValues(params, merged)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid values")
+	}
+	rendered, err := renderValues(params, values, m.images)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid values")
+	}
+	info := releaseInfo{Name: name, Namespace: namespace, Revision: record.Revision + 1}
+	objects, err := render(tmpl.Name, tmpl.Content, info, rendered)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "render template failed")
+	}
+	if err = m.injectIdentity(record.CreatedBy, objects); err != nil {
+		return nil, err
+	}
+	if secrets = secretValues(params, values); len(secrets) > 0 {
+		objects = append(objects, valuesSecret(tmpl.Name, info, secrets))
+	}
+
+	oldRefs := decodeObjects(record.Objects)
+	newRefs := objectRefs(objects)
+	record.TemplateRevision = tmpl.Revision
+	record.Revision = info.Revision
+	record.Values = encode(publicValues(params, values))
+	// keep the old objects recorded until the stale ones are deleted, so a
+	// failed upgrade can still be uninstalled completely
+	record.Objects = encode(unionRefs(oldRefs, newRefs))
+	record.Status = types.ReleasePending
+	if err = m.store.UpdateRelease(record); err != nil {
+		return nil, errors.Wrap(err, def.ErrReleaseUpgrade, "save release failed")
+	}
+	if err = m.applyAll(objects); err == nil {
+		err = m.deleteAll(name, staleRefs(oldRefs, newRefs))
+	}
+	if err != nil {
+		m.finish(record, err)
+		return nil, errors.Wrap(err, def.ErrReleaseUpgrade, "upgrade release failed")
+	}
+	record.Objects = encode(newRefs)
+	if err = m.finish(record, nil); err != nil {
+		return nil, errors.Wrap(err, def.ErrReleaseUpgrade, "save release failed")
+	}
+	view := releaseView(*record)
+	return &view, nil
+}
+
+// Uninstall deletes the objects of a release and then the release itself,
+// the namespace must be open to the user.
+func (m *Manager) Uninstall(username, namespace, name string) error {
+	if err := checkNamespace(username, namespace); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	record, err := m.getRelease(namespace, name)
+	if err != nil {
+		return err
+	}
+	if err = m.deleteAll(name, decodeObjects(record.Objects)); err != nil {
+		m.finish(record, err)
+		return errors.Wrap(err, def.ErrReleaseUninstall, "uninstall release failed")
+	}
+	if err = m.store.DeleteRelease(namespace, name); err != nil {
+		return errors.Wrap(err, def.ErrReleaseUninstall, "delete release failed")
+	}
+	return nil
+}
+
+func (m *Manager) applyAll(objects []map[string]interface{}) error {
+	for _, obj := range objects {
+		if err := m.applier.Apply(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAll deletes the objects of release in reverse order, dependents are
+// usually listed after the objects they depend on.
+func (m *Manager) deleteAll(release string, refs []types.ObjectRef) error {
+	for i := len(refs) - 1; i >= 0; i-- {
+		if err := m.applier.Delete(release, refs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedSecrets returns the secret values kept for the release, none if the
+// release has no values Secret.
+func (m *Manager) storedSecrets(namespace, name string) (map[string]interface{}, error) {
+	obj, err := m.applier.Get(name, valuesSecretRef(namespace, name))
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return decodeValuesSecret(obj)
+}
+
+// finish records the outcome of a release operation.
+func (m *Manager) finish(record *types.Release, cause error) error {
+	record.Status, record.Message = types.ReleaseDeployed, ""
+	if cause != nil {
+		record.Status, record.Message = types.ReleaseFailed, cause.Error()
+	}
+	record.UpdatedAt = time.Now()
+	err := m.store.UpdateRelease(record)
+	if err != nil {
+		logs.Error("update release %s/%s failed: %v", record.Namespace, record.Name, err)
+	}
+	return err
+}
+
+func (m *Manager) getTemplate(name string) (*types.Template, error) {
+	record, err := m.store.GetTemplate(name)
+	if err == types.ErrNotFound {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "template %s not found", name)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrTemplateList, "get template failed")
+	}
+	return record, nil
+}
+
+func (m *Manager) getRelease(namespace, name string) (*types.Release, error) {
+	record, err := m.store.GetRelease(namespace, name)
+	if err == types.ErrNotFound {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "release %s not found in %s", name, namespace)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrReleaseList, "get release failed")
+	}
+	return record, nil
+}
+
+// validateTemplate checks the parameters and content of req and returns the
+// encoded parameters.
+// checkNamespace returns an error unless the user may manage the releases of
+// namespace, it must be one of template::Namespaces open to the user. The
+// uid::Admins manage the releases of every namespace.
+func checkNamespace(username, namespace string) error {
+	if !reName.MatchString(namespace) {
+		return errors.Errorf(def.ErrGeneralBadRequest, "invalid namespace %q", namespace)
+	}
+	if account.IsAdmin(username) {
+		return nil
+	}
+	for _, pair := range conf.TemplateNamespaces() {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != namespace {
+			continue
+		}
+		for _, user := range strings.Split(kv[1], ",") {
+			if user = strings.TrimSpace(user); user == "*" || user == username {
+				return nil
+			}
+		}
+		break
+	}
+	return errors.Errorf(def.ErrGeneralForbidden, "user %s may not use namespace %s", username, namespace)
+}
+
+func validateTemplate(req TemplateRequest) (string, error) {
+	if strings.TrimSpace(req.Content) == "" {
+		return "", errors.New(def.ErrGeneralBadRequest, "template content is required")
+	}
+	if err := validateParameters(req.Parameters); err != nil {
+		return "", errors.Wrap(err, def.ErrGeneralBadRequest, "invalid parameters")
+	}
+	if _, err := parseContent(req.Name, req.Content); err != nil {
+		return "", errors.Wrap(err, def.ErrGeneralBadRequest, "invalid template content")
+	}
+	if req.Parameters == nil {
+		req.Parameters = []Parameter{}
+	}
+	return encode(req.Parameters), nil
+}
+
+func releaseView(r types.Release) ReleaseView {
+	return ReleaseView{Release: r, Values: decodeValues(r.Values), Objects: decodeObjects(r.Objects)}
+}
+
+func objectRefs(objects []map[string]interface{}) []types.ObjectRef {
+	refs := make([]types.ObjectRef, 0, len(objects))
+	for _, obj := range objects {
+		metadata := obj["metadata"].(map[string]interface{})
+		refs = append(refs, types.ObjectRef{
+			APIVersion: obj["apiVersion"].(string),
+			Kind:       obj["kind"].(string),
+			Namespace:  metadata["namespace"].(string),
+			Name:       metadata["name"].(string),
+		})
+	}
+	return refs
+}
+
+// staleRefs returns the refs of old that are not in current.
+func staleRefs(old, current []types.ObjectRef) []types.ObjectRef {
+	keep := make(map[types.ObjectRef]bool, len(current))
+	for _, ref := range current {
+		keep[ref] = true
+	}
+	var stale []types.ObjectRef
+	for _, ref := range old {
+		if !keep[ref] {
+			stale = append(stale, ref)
+		}
+	}
+	return stale
+}
+
+func unionRefs(old, current []types.ObjectRef) []types.ObjectRef {
+	return append(append([]types.ObjectRef{}, current...), staleRefs(old, current)...)
+}
+
+func encode(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// decodeParameters decodes stored parameters, a corrupted schema has no
+// parameters.
+func decodeParameters(text string) []Parameter {
+	params := []Parameter{}
+	if text == "" {
+		return params
+	}
+	if err := json.Unmarshal([]byte(text), &params); err != nil {
+		logs.Error("decode template parameters failed: %v", err)
+	}
+	return params
+}
+
+func decodeValues(text string) map[string]interface{} {
+	values := make(map[string]interface{})
+	if text == "" {
+		return values
+	}
+	if err := json.Unmarshal([]byte(text), &values); err != nil {
+		logs.Error("decode release values failed: %v", err)
+	}
+	return values
+}
+
+func decodeObjects(text string) []types.ObjectRef {
+	refs := []types.ObjectRef{}
+	if text == "" {
+		return refs
+	}
+	if err := json.Unmarshal([]byte(text), &refs); err != nil {
+		logs.Error("decode release objects failed: %v", err)
+	}
+	return refs
+}
@@ -0,0 +1,250 @@
+package apptemplate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/astaxie/beego"
+
+	"k8s-server/def"
+	"k8s-server/models/filedb"
+	"k8s-server/models/types"
+	"k8s-server/modules/account"
+	"k8s-server/utils/errors"
+)
+
+func hasCode(err error, code int) bool {
+	c := errors.ErrorCode(err)
+	return len(c) >= 4 && c[:4] == strconv.Itoa(code)
+}
+
+// fakeApplier records the applied objects by ref and guards the objects of
+// the releases like kubeApplier.
+type fakeApplier struct {
+	objects map[types.ObjectRef]map[string]interface{}
+	failOn  string
+}
+
+func (a *fakeApplier) Apply(obj map[string]interface{}) error {
+	ref := objectRefs([]map[string]interface{}{obj})[0]
+	if ref.Kind == a.failOn {
+		return fmt.Errorf("apply %s refused", ref.Kind)
+	}
+	if existing, ok := a.objects[ref]; ok && releaseOf(existing) != releaseOf(obj) {
+		return fmt.Errorf("%s %s is not part of release %s", ref.Kind, ref.Name, releaseOf(obj))
+	}
+	a.objects[ref] = obj
+	return nil
+}
+
+func (a *fakeApplier) Get(release string, ref types.ObjectRef) (map[string]interface{}, error) {
+	if obj, ok := a.objects[ref]; ok && releaseOf(obj) == release {
+		return obj, nil
+	}
+	return nil, nil
+}
+
+func (a *fakeApplier) Delete(release string, ref types.ObjectRef) error {
+	if obj, ok := a.objects[ref]; ok && releaseOf(obj) == release {
+		delete(a.objects, ref)
+	}
+	return nil
+}
+
+// fakeIdentities gives every user the uid 10000.
+type fakeIdentities struct{}
+
+func (fakeIdentities) Identity(username string) (*account.Identity, error) {
+	return &account.Identity{Username: username, UID: 10000, GID: 500, Home: "/home/" + username, Shell: "bash"}, nil
+}
+
+func (f fakeIdentities) mustIdentity(username string) *account.Identity {
+	id, _ := f.Identity(username)
+	return id
+}
+
+func newTestManager(t *testing.T) (*Manager, *fakeApplier, func()) {
+	dir, err := ioutil.TempDir("", "apptemplate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := filedb.NewModel(filepath.Join(dir, "test.db"), 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	fake := &fakeApplier{objects: make(map[types.ObjectRef]map[string]interface{})}
+	m, _ := NewManager(store, fakeImages{"nginx": "nginx:1.17"}, fakeIdentities{})
+	m.applier = fake
+	beego.AppConfig.Set("template::Namespaces", "team-a=alice;sandbox=*")
+	return m, fake, func() {
+		beego.AppConfig.Set("template::Namespaces", "")
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReleaseLifecycle(t *testing.T) {
+	m, fake, cleanup := newTestManager(t)
+	defer cleanup()
+
+	_, err := m.CreateTemplate("alice", TemplateRequest{Name: "web", Content: webTemplate, Parameters: webParams})
+	if err != nil {
+		t.Fatalf("create template failed: %v", err)
+	}
+	release, err := m.Install("alice", "team-a", InstallRequest{
+		Name: "blog", Template: "web", Values: map[string]interface{}{"image": "nginx", "password": "pw"},
+	})
+	if err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	if release.Status != types.ReleaseDeployed || len(release.Objects) != 3 || len(fake.objects) != 3 {
+		t.Fatalf("unexpected release %+v with %d objects applied", release, len(fake.objects))
+	}
+	if _, ok := release.Values["password"]; ok {
+		t.Error("secret value stored in release")
+	}
+	if _, err = m.Install("alice", "team-a", InstallRequest{Name: "blog", Template: "web"}); err == nil {
+		t.Error("duplicate release installed")
+	}
+
+	// drop the Service from the template, the upgrade must delete it
+	withoutService := webTemplate[len("apiVersion: v1\nkind: Service\nmetadata:\n  name: {{ .Release.Name }}\nspec:\n  ports:\n  - port: {{ .Values.port }}\n---\n"):]
+	if _, err = m.UpdateTemplate("web", TemplateRequest{Content: withoutService, Parameters: webParams}); err != nil {
+		t.Fatalf("update template failed: %v", err)
+	}
+	release, err = m.Upgrade("alice", "team-a", "blog", UpgradeRequest{Values: map[string]interface{}{"replicas": 2}})
+	if err != nil {
+		t.Fatalf("upgrade failed: %v", err)
+	}
+	if release.Revision != 2 || release.TemplateRevision != 2 || len(release.Objects) != 2 {
+		t.Fatalf("unexpected upgraded release %+v", release)
+	}
+	deploy := fake.objects[release.Objects[0]]
+	if deploy == nil || deploy["spec"].(map[string]interface{})["replicas"] != int64(2) {
+		t.Fatalf("deployment not upgraded: %v", deploy)
+	}
+	// the pods run as the user who installed the release
+	if sc := podSpec(deploy)["securityContext"].(map[string]interface{}); sc["runAsUser"] != int64(10000) || sc["fsGroup"] != int64(500) {
+		t.Errorf("deployment runs as %v", sc)
+	}
+	if release.Values["image"] != "nginx" {
+		t.Errorf("previous value lost: %v", release.Values)
+	}
+	// the secret value is rendered from the values Secret
+	container := podSpec(deploy)["containers"].([]interface{})[0].(map[string]interface{})
+	if env := container["env"].([]interface{})[0].(map[string]interface{}); env["value"] != "cHc=" {
+		t.Errorf("secret value lost: %v", env)
+	}
+	if len(fake.objects) != 2 {
+		t.Errorf("stale objects left: %v", fake.objects)
+	}
+
+	if err = m.DeleteTemplate("web"); err == nil {
+		t.Error("template deleted while in use")
+	}
+	if err = m.Uninstall("alice", "team-a", "blog"); err != nil {
+		t.Fatalf("uninstall failed: %v", err)
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("objects left after uninstall: %v", fake.objects)
+	}
+	if _, err = m.GetRelease("team-a", "blog"); err == nil {
+		t.Error("release still recorded after uninstall")
+	}
+	if err = m.DeleteTemplate("web"); err != nil {
+		t.Errorf("delete template failed: %v", err)
+	}
+}
+
+func TestInstallFailure(t *testing.T) {
+	m, fake, cleanup := newTestManager(t)
+	defer cleanup()
+
+	if _, err := m.CreateTemplate("alice", TemplateRequest{Name: "web", Content: webTemplate, Parameters: webParams}); err != nil {
+		t.Fatal(err)
+	}
+	fake.failOn = "Deployment"
+	values := map[string]interface{}{"image": "nginx"}
+	if _, err := m.Install("alice", "team-a", InstallRequest{Name: "blog", Template: "web", Values: values}); err == nil {
+		t.Fatal("install succeeded")
+	}
+	release, err := m.GetRelease("team-a", "blog")
+	if err != nil {
+		t.Fatalf("failed release not recorded: %v", err)
+	}
+	if release.Status != types.ReleaseFailed || release.Message == "" {
+		t.Errorf("unexpected release status %s: %s", release.Status, release.Message)
+	}
+	// the applied Service must still be cleaned up by uninstall
+	if err = m.Uninstall("alice", "team-a", "blog"); err != nil || len(fake.objects) != 0 {
+		t.Errorf("uninstall of failed release: %v, objects left %v", err, fake.objects)
+	}
+}
+
+func TestForeignObjects(t *testing.T) {
+	m, fake, cleanup := newTestManager(t)
+	defer cleanup()
+
+	if _, err := m.CreateTemplate("alice", TemplateRequest{Name: "web", Content: webTemplate, Parameters: webParams}); err != nil {
+		t.Fatal(err)
+	}
+	// a Service created by hand with the name of the release
+	ref := types.ObjectRef{APIVersion: "v1", Kind: "Service", Namespace: "team-a", Name: "blog"}
+	handmade := map[string]interface{}{"metadata": map[string]interface{}{"name": "blog"}}
+	fake.objects[ref] = handmade
+	values := map[string]interface{}{"image": "nginx"}
+	if _, err := m.Install("alice", "team-a", InstallRequest{Name: "blog", Template: "web", Values: values}); err == nil {
+		t.Fatal("install took over an object of no release")
+	}
+	if err := m.Uninstall("alice", "team-a", "blog"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.objects[ref] == nil || len(fake.objects) != 1 {
+		t.Errorf("uninstall changed the objects of no release: %v", fake.objects)
+	}
+}
+
+func TestNamespaceAccess(t *testing.T) {
+	m, fake, cleanup := newTestManager(t)
+	defer cleanup()
+	beego.AppConfig.Set("uid::Admins", "root")
+	defer beego.AppConfig.Set("uid::Admins", "")
+
+	if _, err := m.CreateTemplate("root", TemplateRequest{Name: "web", Content: webTemplate, Parameters: webParams}); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]interface{}{"image": "nginx"}
+	for _, c := range []struct {
+		username, namespace string
+		code                int
+	}{
+		{"bob", "team-a", def.ErrGeneralForbidden},
+		{"bob", "kube-system", def.ErrGeneralForbidden},
+		{"alice", "kube-system", def.ErrGeneralForbidden},
+		{"bob", "sandbox", 0},
+		{"root", "kube-system", 0},
+	} {
+		_, err := m.Install(c.username, c.namespace, InstallRequest{Name: "blog", Template: "web", Values: values})
+		if c.code == 0 && err != nil {
+			t.Errorf("%s install into %s: %v", c.username, c.namespace, err)
+		} else if c.code != 0 && !hasCode(err, c.code) {
+			t.Errorf("%s install into %s: %v, want %d", c.username, c.namespace, err, c.code)
+		}
+	}
+	if _, err := m.Upgrade("bob", "kube-system", "blog", UpgradeRequest{}); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("upgrade of a foreign release: %v", err)
+	}
+	if err := m.Uninstall("alice", "kube-system", "blog"); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("uninstall of a foreign release: %v", err)
+	}
+	if err := m.Uninstall("root", "kube-system", "blog"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Uninstall("bob", "sandbox", "blog"); err != nil || len(fake.objects) != 0 {
+		t.Errorf("uninstall: %v, objects left %v", err, fake.objects)
+	}
+}
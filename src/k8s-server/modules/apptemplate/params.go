@@ -0,0 +1,207 @@
+package apptemplate
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// Parameter types.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeEnum   = "enum"
+	TypeSecret = "secret"
+	// TypeImage is the name of an image catalog entry, the template is
+	// rendered with its image reference.
+	TypeImage = "image"
+)
+
+var reParamName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// Parameter describes a value the template is rendered with, it is available
+// in the template content as {{ .Values.<Name> }}.
+type Parameter struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// ImageResolver returns the image reference of an image catalog entry.
+type ImageResolver interface {
+	Resolve(name string) (string, error)
+}
+
+// validateParameters checks the parameter schema of a template.
+func validateParameters(params []Parameter) error {
+	seen := make(map[string]bool, len(params))
+	for _, p := range params {
+		if !reParamName.MatchString(p.Name) {
+			return fmt.Errorf("invalid parameter name %q", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicated parameter %s", p.Name)
+		}
+		seen[p.Name] = true
+		switch p.Type {
+		case TypeString, TypeSecret, TypeImage:
+		case TypeInt:
+			if p.Default != "" {
+				if _, err := strconv.ParseInt(p.Default, 10, 64); err != nil {
+					return fmt.Errorf("default of %s is not an int", p.Name)
+				}
+			}
+		case TypeEnum:
+			if len(p.Options) == 0 {
+				return fmt.Errorf("enum parameter %s has no options", p.Name)
+			}
+			if p.Default != "" && !hasOption(p.Options, p.Default) {
+				return fmt.Errorf("default of %s is not one of its options", p.Name)
+			}
+		default:
+			return fmt.Errorf("parameter %s has unknown type %q", p.Name, p.Type)
+		}
+	}
+	return nil
+}
+
+// resolveValues checks the user supplied values against params, applies the
+// defaults and converts them to their typed form. Values of unknown
+// parameters are rejected.
+func resolveValues(params []Parameter, values map[string]interface{}) (map[string]interface{}, error) {
+	known := make(map[string]bool, len(params))
+	resolved := make(map[string]interface{}, len(params))
+	for _, p := range params {
+		known[p.Name] = true
+		v, ok := values[p.Name]
+		if !ok || v == nil || v == "" {
+			if p.Default == "" {
+				if p.Required {
+					return nil, fmt.Errorf("parameter %s is required", p.Name)
+				}
+				if p.Type == TypeInt {
+					resolved[p.Name] = int64(0)
+				} else {
+					resolved[p.Name] = ""
+				}
+				continue
+			}
+			v = p.Default
+		}
+		typed, err := convert(p, v)
+		if err != nil {
+			return nil, err
+		}
+		resolved[p.Name] = typed
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+	return resolved, nil
+}
+
+func convert(p Parameter, v interface{}) (interface{}, error) {
+	switch p.Type {
+	case TypeInt:
+		switch n := v.(type) {
+		case float64:
+			if n != math.Trunc(n) {
+				return nil, fmt.Errorf("parameter %s must be an integer", p.Name)
+			}
+			return int64(n), nil
+		case json.Number:
+			i, err := n.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s must be an integer", p.Name)
+			}
+			return i, nil
+		case int:
+			return int64(n), nil
+		case int64:
+			return n, nil
+		case string:
+			i, err := strconv.ParseInt(n, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s must be an integer", p.Name)
+			}
+			return i, nil
+		}
+		return nil, fmt.Errorf("parameter %s must be an integer", p.Name)
+	case TypeEnum:
+		s, ok := v.(string)
+		if !ok || !hasOption(p.Options, s) {
+			return nil, fmt.Errorf("parameter %s must be one of %v", p.Name, p.Options)
+		}
+		return s, nil
+	default:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("parameter %s must be a string", p.Name)
+		}
+		return s, nil
+	}
+}
+
+// renderValues returns a copy of values with the image parameters replaced by
+// the image references of their catalog entries. The release keeps the entry
+// names, so an upgrade picks up a changed catalog.
+func renderValues(params []Parameter, values map[string]interface{}, images ImageResolver) (map[string]interface{}, error) {
+	rendered := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		rendered[k] = v
+	}
+	for _, p := range params {
+		name, _ := values[p.Name].(string)
+		if p.Type != TypeImage || name == "" {
+			continue
+		}
+		if images == nil {
+			return nil, fmt.Errorf("parameter %s needs the image catalog", p.Name)
+		}
+		ref, err := images.Resolve(name)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %v", p.Name, err)
+		}
+		rendered[p.Name] = ref
+	}
+	return rendered, nil
+}
+
+// publicValues returns values without the secret parameters, the result is
+// what a release stores.
+func publicValues(params []Parameter, values map[string]interface{}) map[string]interface{} {
+	public := make(map[string]interface{}, len(values))
+	for _, p := range params {
+		if v, ok := values[p.Name]; ok && p.Type != TypeSecret {
+			public[p.Name] = v
+		}
+	}
+	return public
+}
+
+// secretValues returns the non-empty values of the secret parameters.
+func secretValues(params []Parameter, values map[string]interface{}) map[string]interface{} {
+	secrets := make(map[string]interface{})
+	for _, p := range params {
+		if v, ok := values[p.Name]; ok && v != "" && p.Type == TypeSecret {
+			secrets[p.Name] = v
+		}
+	}
+	return secrets
+}
+
+func hasOption(options []string, s string) bool {
+	for _, o := range options {
+		if o == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package apptemplate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+
+	"k8s-server/models/types"
+)
+
+// Labels set on every object created by a release.
+const (
+	LabelRelease  = "k8s-server/release"
+	LabelTemplate = "k8s-server/template"
+)
+
+var reDocSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// renderContext is the data a template is executed with.
+type renderContext struct {
+	Release releaseInfo
+	Values  map[string]interface{}
+}
+
+type releaseInfo struct {
+	Name      string
+	Namespace string
+	Revision  int
+}
+
+var funcs = template.FuncMap{
+	"quote":  strconv.Quote,
+	"b64enc": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"lower":  strings.ToLower,
+	"upper":  strings.ToUpper,
+	"trim":   strings.TrimSpace,
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" || v == int64(0) {
+			return def
+		}
+		return v
+	},
+	"indent": func(n int, s string) string {
+		pad := strings.Repeat(" ", n)
+		return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+	},
+}
+
+// parseContent parses the template content, unknown values are an error at
+// execution time.
+func parseContent(name, content string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(content)
+}
+
+// render executes the template content and decodes the resulting multi
+// document YAML into objects. Every object is placed in the release namespace
+// and labeled with the release and template name.
+func render(tmplName, content string, release releaseInfo,
+	values map[string]interface{}) ([]map[string]interface{}, error) {
+	t, err := parseContent(tmplName, content)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, renderContext{Release: release, Values: values}); err != nil {
+		return nil, err
+	}
+
+	var objects []map[string]interface{}
+	seen := make(map[string]bool)
+	for i, doc := range reDocSeparator.Split(buf.String(), -1) {
+		var raw interface{}
+		if err = yaml.Unmarshal([]byte(doc), &raw); err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+		if raw == nil {
+			continue
+		}
+		normalized, err := normalize(raw)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+		obj, ok := normalized.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("document %d is not an object", i)
+		}
+		if err = prepareObject(obj, tmplName, release); err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+		key := fmt.Sprintf("%s/%s/%s", obj["apiVersion"], obj["kind"], objectName(obj))
+		if seen[key] {
+			return nil, fmt.Errorf("document %d duplicates %s", i, key)
+		}
+		seen[key] = true
+		objects = append(objects, obj)
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("template rendered no objects")
+	}
+	return objects, nil
+}
+
+// prepareObject validates the identity of obj and sets its namespace and
+// release labels.
+func prepareObject(obj map[string]interface{}, tmplName string, release releaseInfo) error {
+	for _, field := range []string{"apiVersion", "kind"} {
+		if s, _ := obj[field].(string); s == "" {
+			return fmt.Errorf("%s is required", field)
+		}
+	}
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	if metadata == nil {
+		return fmt.Errorf("metadata is required")
+	}
+	if s, _ := metadata["name"].(string); s == "" {
+		return fmt.Errorf("metadata.name is required")
+	}
+	if ns, ok := metadata["namespace"]; ok && ns != release.Namespace {
+		return fmt.Errorf("object %s must not set a namespace other than %s",
+			metadata["name"], release.Namespace)
+	}
+	metadata["namespace"] = release.Namespace
+	labels, _ := metadata["labels"].(map[string]interface{})
+	if labels == nil {
+		labels = make(map[string]interface{})
+		metadata["labels"] = labels
+	}
+	labels[LabelRelease] = release.Name
+	labels[LabelTemplate] = tmplName
+	return nil
+}
+
+func objectName(obj map[string]interface{}) string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	return name
+}
+
+// releaseOf returns the release label of obj, empty if it was not created by
+// a release.
+func releaseOf(obj map[string]interface{}) string {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	labels, _ := metadata["labels"].(map[string]interface{})
+	release, _ := labels[LabelRelease].(string)
+	return release
+}
+
+// valuesSecretRef returns the ref of the Secret holding the secret values of
+// a release.
+func valuesSecretRef(namespace, name string) types.ObjectRef {
+	return types.ObjectRef{APIVersion: "v1", Kind: "Secret", Namespace: namespace, Name: "k8s-server.release." + name}
+}
+
+// valuesSecret returns the Secret holding the secret values of a release, an
+// upgrade renders the secret parameters that are not given again from it.
+func valuesSecret(tmplName string, release releaseInfo, secrets map[string]interface{}) map[string]interface{} {
+	ref := valuesSecretRef(release.Namespace, release.Name)
+	b, _ := json.Marshal(secrets)
+	obj := map[string]interface{}{
+		"apiVersion": ref.APIVersion,
+		"kind":       ref.Kind,
+		"metadata":   map[string]interface{}{"name": ref.Name},
+		"type":       "Opaque",
+		"data":       map[string]interface{}{"values": base64.StdEncoding.EncodeToString(b)},
+	}
+	prepareObject(obj, tmplName, release)
+	return obj
+}
+
+// decodeValuesSecret returns the secret values kept by a values Secret.
+func decodeValuesSecret(obj map[string]interface{}) (map[string]interface{}, error) {
+	data, _ := obj["data"].(map[string]interface{})
+	encoded, _ := data["values"].(string)
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	if err = json.Unmarshal(b, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// normalize converts the YAML decoded value into its JSON compatible form,
+// the form expected by unstructured objects.
+func normalize(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("non string key %v", k)
+			}
+			n, err := normalize(val)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = n
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(t))
+		for i, val := range t {
+			n, err := normalize(val)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = n
+		}
+		return list, nil
+	case int:
+		return int64(t), nil
+	case uint64:
+		return int64(t), nil
+	}
+	return v, nil
+}
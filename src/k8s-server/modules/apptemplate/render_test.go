@@ -0,0 +1,243 @@
+package apptemplate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const webTemplate = `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}
+spec:
+  ports:
+  - port: {{ .Values.port }}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+  labels:
+    tier: {{ .Values.tier }}
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    spec:
+      containers:
+      - name: web
+        image: {{ .Values.image | quote }}
+        env:
+        - name: PASSWORD
+          value: {{ .Values.password | b64enc | quote }}
+`
+
+var webParams = []Parameter{
+	{Name: "image", Type: TypeString, Required: true},
+	{Name: "port", Type: TypeInt, Default: "8080"},
+	{Name: "replicas", Type: TypeInt, Default: "1"},
+	{Name: "tier", Type: TypeEnum, Options: []string{"dev", "prod"}, Default: "dev"},
+	{Name: "password", Type: TypeSecret},
+}
+
+func TestResolveValues(t *testing.T) {
+	values, err := resolveValues(webParams, map[string]interface{}{
+		"image": "nginx", "replicas": float64(3), "password": "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("resolve values failed: %v", err)
+	}
+	if values["replicas"] != int64(3) || values["port"] != int64(8080) || values["tier"] != "dev" {
+		t.Errorf("unexpected values %v", values)
+	}
+	if _, ok := publicValues(webParams, values)["password"]; ok {
+		t.Error("secret value kept in public values")
+	}
+
+	bad := []map[string]interface{}{
+		{},
+		{"image": "nginx", "replicas": "many"},
+		{"image": "nginx", "replicas": 1.5},
+		{"image": "nginx", "tier": "staging"},
+		{"image": "nginx", "unknown": "x"},
+		{"image": 42},
+	}
+	for i, v := range bad {
+		if _, err := resolveValues(webParams, v); err == nil {
+			t.Errorf("case %d: values %v accepted", i, v)
+		}
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	if err := validateParameters(webParams); err != nil {
+		t.Fatalf("valid parameters rejected: %v", err)
+	}
+	bad := [][]Parameter{
+		{{Name: "1st", Type: TypeString}},
+		{{Name: "a", Type: TypeString}, {Name: "a", Type: TypeInt}},
+		{{Name: "a", Type: "bool"}},
+		{{Name: "a", Type: TypeInt, Default: "x"}},
+		{{Name: "a", Type: TypeEnum}},
+		{{Name: "a", Type: TypeEnum, Options: []string{"x"}, Default: "y"}},
+	}
+	for i, params := range bad {
+		if err := validateParameters(params); err == nil {
+			t.Errorf("case %d: parameters %v accepted", i, params)
+		}
+	}
+}
+
+// fakeImages is an image catalog of docker entries.
+type fakeImages map[string]string
+
+func (f fakeImages) Resolve(name string) (string, error) {
+	if ref, ok := f[name]; ok {
+		return ref, nil
+	}
+	return "", fmt.Errorf("image %s is not in the catalog", name)
+}
+
+func TestRenderValues(t *testing.T) {
+	params := []Parameter{
+		{Name: "image", Type: TypeImage, Required: true},
+		{Name: "sidecar", Type: TypeImage},
+	}
+	values, err := resolveValues(params, map[string]interface{}{"image": "tensorflow-gpu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := fakeImages{"tensorflow-gpu": "registry.local/ml/tensorflow:1.14-gpu"}
+	rendered, err := renderValues(params, values, images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered["image"] != "registry.local/ml/tensorflow:1.14-gpu" || rendered["sidecar"] != "" {
+		t.Errorf("rendered values %v", rendered)
+	}
+	if public := publicValues(params, values); public["image"] != "tensorflow-gpu" {
+		t.Errorf("public values %v keep the image reference", public)
+	}
+	if _, err = renderValues(params, map[string]interface{}{"image": "nginx"}, images); err == nil {
+		t.Error("image outside the catalog accepted")
+	}
+	if _, err = renderValues(params, values, nil); err == nil {
+		t.Error("image resolved without a catalog")
+	}
+}
+
+func TestRender(t *testing.T) {
+	values, err := resolveValues(webParams, map[string]interface{}{"image": "nginx", "password": "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := releaseInfo{Name: "web", Namespace: "team-a", Revision: 1}
+	objects, err := render("web", webTemplate, release, values)
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("rendered %d objects, want 2", len(objects))
+	}
+	deploy := objects[1]
+	metadata := deploy["metadata"].(map[string]interface{})
+	if metadata["namespace"] != "team-a" {
+		t.Errorf("namespace = %v, want team-a", metadata["namespace"])
+	}
+	labels := metadata["labels"].(map[string]interface{})
+	if labels[LabelRelease] != "web" || labels[LabelTemplate] != "web" || labels["tier"] != "dev" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	if replicas := deploy["spec"].(map[string]interface{})["replicas"]; replicas != int64(1) {
+		t.Errorf("replicas = %#v, want int64(1)", replicas)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	release := releaseInfo{Name: "web", Namespace: "team-a"}
+	cases := map[string]string{
+		"missing value":   "apiVersion: v1\nkind: Pod\nmetadata:\n  name: {{ .Values.missing }}\n",
+		"missing kind":    "apiVersion: v1\nmetadata:\n  name: x\n",
+		"missing name":    "apiVersion: v1\nkind: Pod\nmetadata: {}\n",
+		"other namespace": "apiVersion: v1\nkind: Pod\nmetadata:\n  name: x\n  namespace: kube-system\n",
+		"duplicate":       "apiVersion: v1\nkind: Pod\nmetadata:\n  name: x\n---\napiVersion: v1\nkind: Pod\nmetadata:\n  name: x\n",
+		"empty":           "---\n",
+		"not an object":   "- a\n- b\n",
+	}
+	for name, content := range cases {
+		if _, err := render("t", content, release, map[string]interface{}{}); err == nil {
+			t.Errorf("%s: render succeeded", name)
+		}
+	}
+	if _, err := parseContent("t", "{{ .Values.x "); err == nil || !strings.Contains(err.Error(), "t") {
+		t.Errorf("malformed template accepted: %v", err)
+	}
+}
+
+const cronTemplate = `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: cleanup
+spec:
+  schedule: "0 3 * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: cleanup
+            image: busybox
+            securityContext:
+              runAsUser: 0
+            env:
+            - name: HOME
+              value: /scratch
+`
+
+func TestSetIdentity(t *testing.T) {
+	objects, err := render("cron", cronTemplate, releaseInfo{Name: "cron", Namespace: "team-a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := podSpec(objects[0])
+	if spec == nil {
+		t.Fatal("pod spec of the CronJob not found")
+	}
+	setIdentity(spec, (fakeIdentities{}).mustIdentity("alice"))
+
+	sc := spec["securityContext"].(map[string]interface{})
+	if sc["runAsUser"] != int64(10000) || sc["runAsGroup"] != int64(500) || sc["runAsNonRoot"] != true {
+		t.Errorf("pod security context is %v", sc)
+	}
+	c := spec["containers"].([]interface{})[0].(map[string]interface{})
+	if _, ok := c["securityContext"].(map[string]interface{})["runAsUser"]; ok {
+		t.Error("the container still sets its user")
+	}
+	mounts := c["volumeMounts"].([]interface{})
+	if len(mounts) != 1 || mounts[0].(map[string]interface{})["mountPath"] != "/home/alice" {
+		t.Errorf("mounts are %v", mounts)
+	}
+	env := fmt.Sprint(c["env"])
+	// the HOME of the template is kept
+	if strings.Count(env, "name:HOME") != 1 || !strings.Contains(env, "/scratch") || !strings.Contains(env, "name:USER value:alice") {
+		t.Errorf("env is %v", env)
+	}
+	volumes := spec["volumes"].([]interface{})
+	if len(volumes) != 1 || fmt.Sprint(volumes[0]) != "map[hostPath:map[path:/home/alice type:Directory] name:user-home]" {
+		t.Errorf("volumes are %v", volumes)
+	}
+
+	// a home volume of the template is replaced
+	spec = map[string]interface{}{"volumes": []interface{}{
+		map[string]interface{}{"name": "data", "emptyDir": map[string]interface{}{}},
+		map[string]interface{}{"name": "user-home", "hostPath": map[string]interface{}{"path": "/"}},
+	}}
+	setIdentity(spec, (fakeIdentities{}).mustIdentity("alice"))
+	volumes = spec["volumes"].([]interface{})
+	if len(volumes) != 2 || fmt.Sprint(volumes[1]) != "map[hostPath:map[path:/home/alice type:Directory] name:user-home]" {
+		t.Errorf("volumes are %v", volumes)
+	}
+	if podSpec(map[string]interface{}{"kind": "Service", "spec": map[string]interface{}{}}) != nil {
+		t.Error("a Service has a pod spec")
+	}
+}
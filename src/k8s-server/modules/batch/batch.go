@@ -0,0 +1,396 @@
+// Package batch runs Slurm-style batch scripts as Kubernetes Jobs. The
+// #SBATCH directives are translated to the resources, deadline and node
+// selectors of the Job, and the jobs are reported like squeue reports them,
+// so existing scripts keep working on the cluster.
+package batch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models"
+	"k8s-server/models/types"
+	"k8s-server/modules/account"
+	"k8s-server/modules/inventory"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// Labels and annotations of the Jobs created for batch jobs.
+const (
+	LabelJobID     = "batch.k8s-server/job-id"
+	AnnotationName = "batch.k8s-server/name"
+	AnnotationUser = "batch.k8s-server/user"
+)
+
+// ResourceGPU is the extended resource of the GPUs asked for with --gres.
+const ResourceGPU = "nvidia.com/gpu"
+
+var reNamespace = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// errJobNotFound is returned by a jobClient when the Job does not exist.
+var errJobNotFound = fmt.Errorf("job not found")
+
+// Observation is the state of a batch job read from its Job and pods.
+type Observation struct {
+	State     string
+	Reason    string
+	Nodes     []string
+	StartedAt *time.Time
+	EndedAt   *time.Time
+}
+
+// jobTemplate is the Job of a batch job.
+type jobTemplate struct {
+	Namespace    string
+	Name         string
+	Labels       map[string]string
+	Annotations  map[string]string
+	Deadline     time.Duration
+	NodeSelector map[string]string
+	Image        string
+	Script       string
+	Env          [][2]string
+	MilliCPU     int64
+	MemoryMB     int64
+	GPUs         int
+	// Identity is the user the pods run as, nil runs them as the image
+	// user.
+	Identity *account.Identity
+}
+
+// ImageResolver returns the image reference of an image catalog entry.
+type ImageResolver interface {
+	Resolve(name string) (string, error)
+}
+
+// IdentityProvider returns the identity the pods of a user run with.
+type IdentityProvider interface {
+	Identity(username string) (*account.Identity, error)
+}
+
+// jobClient creates, observes and deletes the Jobs.
+type jobClient interface {
+	Create(t *jobTemplate) error
+	Observe(namespace, name string) (Observation, error)
+	// Delete deletes the Job and its pods, an already deleted Job is not
+	// an error.
+	Delete(namespace, name string) error
+}
+
+// Manager represents the batch job manager.
+type Manager struct {
+	store      models.BatchJobStore
+	jobs       jobClient
+	images     ImageResolver
+	identities IdentityProvider
+	now        func() time.Time
+	// lock serializes the state updates of Sync and Cancel
+	lock sync.Mutex
+}
+
+// SubmitRequest is a batch script with optional sbatch options, the options
+// take precedence over the #SBATCH directives.
+type SubmitRequest struct {
+	Script string   `json:"script"`
+	Args   []string `json:"args"`
+}
+
+// SubmitResult maps the job ID to the Kubernetes Job.
+type SubmitResult struct {
+	JobID     int64    `json:"jobId"`
+	Namespace string   `json:"namespace"`
+	KubeJob   string   `json:"kubeJob"`
+	Message   string   `json:"message"`
+	Ignored   []string `json:"ignored,omitempty"`
+}
+
+// NewManager returns the batch job manager backed by store, the Jobs are
+// created in the cluster configured by backend::KubeConfig. The
+// --container-image catalog entries are resolved by images, and the jobs run
+// as their submitter with the identity of identities.
+func NewManager(store models.BatchJobStore, images ImageResolver, identities IdentityProvider) (*Manager, error) {
+	return &Manager{store: store, jobs: kubeJobs{}, images: images, identities: identities, now: time.Now}, nil
+}
+
+// Run syncs the job states every JobCollectInterval until stop is closed.
+func (m *Manager) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(conf.JobCollectInterval()) * time.Second)
+	defer ticker.Stop()
+	for {
+		m.Sync()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Submit translates the batch script to a Job and creates it. The namespace
+// is the --account of the script if batch::Accounts allows it to the user,
+// else batch::Namespace.
+func (m *Manager) Submit(username string, req SubmitRequest) (*SubmitResult, error) {
+	spec, err := ParseScript(req.Script, req.Args)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid batch script")
+	}
+	// every pod would run the whole script, there is no srun to launch the
+	// tasks across the nodes
+	if spec.Nodes > 1 {
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "multi-node jobs are not supported, asked for %d nodes", spec.Nodes)
+	}
+	namespace, err := accountNamespace(spec.Account, username)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Name == "" {
+		spec.Name = "sbatch"
+	}
+	if spec.Image, err = m.resolveImage(spec.Image); err != nil {
+		return nil, err
+	}
+	var identity *account.Identity
+	if m.identities != nil {
+		if identity, err = m.identities.Identity(username); err != nil {
+			return nil, err
+		}
+	}
+	job := &types.BatchJob{
+		Name:        spec.Name,
+		Namespace:   namespace,
+		Partition:   spec.Partition,
+		Nodes:       spec.Nodes,
+		TimeLimit:   int64(spec.TimeLimit / time.Second),
+		Script:      req.Script,
+		State:       types.BatchPending,
+		SubmittedBy: username,
+		SubmittedAt: m.now(),
+	}
+	if err = m.store.AddBatchJob(job); err != nil {
+		return nil, errors.Wrap(err, def.ErrBatchSubmit, "save batch job failed")
+	}
+	t := newJobTemplate(job, spec)
+	t.Identity = identity
+	if err = m.jobs.Create(t); err != nil {
+		ended := m.now()
+		job.State, job.Reason, job.EndedAt = types.BatchFailed, "SubmitFailed", &ended
+		if uerr := m.store.UpdateBatchJob(job); uerr != nil {
+			logs.Error("update batch job %d failed: %v", job.ID, uerr)
+		}
+		return nil, errors.Wrap(err, def.ErrBatchSubmit, "create job failed")
+	}
+	logs.Info("batch job %d submitted by %s as job %s/%s", job.ID, username, namespace, KubeJobName(job.ID))
+	return &SubmitResult{
+		JobID:     job.ID,
+		Namespace: namespace,
+		KubeJob:   KubeJobName(job.ID),
+		Message:   fmt.Sprintf("Submitted batch job %d", job.ID),
+		Ignored:   spec.Ignored,
+	}, nil
+}
+
+// List returns the squeue statuses of the jobs matching filter.
+func (m *Manager) List(filter types.BatchJobFilter) ([]Status, error) {
+	jobs, err := m.store.ListBatchJobs(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrBatchList, "list batch jobs failed")
+	}
+	now := m.now()
+	statuses := make([]Status, 0, len(jobs))
+	for _, j := range jobs {
+		statuses = append(statuses, newStatus(j, now))
+	}
+	return statuses, nil
+}
+
+// Get returns the status of a job, read from the cluster if it did not end.
+func (m *Manager) Get(id int64) (*Status, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	job, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !job.Ended() {
+		if err = m.refresh(job); err != nil {
+			logs.Warn("refresh batch job %d failed: %v", id, err)
+		}
+	}
+	status := newStatus(*job, m.now())
+	return &status, nil
+}
+
+// Cancel deletes the Job of a pending or running job like scancel, only the
+// submitter may cancel a job.
+func (m *Manager) Cancel(username string, id int64) (*Status, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	job, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.SubmittedBy != username {
+		return nil, errors.Errorf(def.ErrGeneralForbidden, "batch job %d was submitted by another user", id)
+	}
+	if job.Ended() {
+		return nil, errors.Errorf(def.ErrGeneralConflict, "batch job %d already ended", id)
+	}
+	if err = m.jobs.Delete(job.Namespace, KubeJobName(id)); err != nil {
+		return nil, errors.Wrap(err, def.ErrBatchCancel, "delete job failed")
+	}
+	ended := m.now()
+	job.State, job.Reason, job.EndedAt = types.BatchCancelled, "", &ended
+	if err = m.store.UpdateBatchJob(job); err != nil {
+		return nil, errors.Wrap(err, def.ErrBatchCancel, "update batch job failed")
+	}
+	status := newStatus(*job, ended)
+	return &status, nil
+}
+
+// Sync reads the state of the jobs that did not end from the cluster.
+func (m *Manager) Sync() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	jobs, err := m.store.ListBatchJobs(types.BatchJobFilter{Active: true})
+	if err != nil {
+		logs.Error("list active batch jobs failed: %v", err)
+		return
+	}
+	for i := range jobs {
+		if err = m.refresh(&jobs[i]); err != nil {
+			logs.Warn("refresh batch job %d failed: %v", jobs[i].ID, err)
+		}
+	}
+}
+
+// resolveImage returns the image reference of --container-image. A value
+// without a registry, path or tag is the name of an image catalog entry,
+// anything else is taken as an image reference.
+func (m *Manager) resolveImage(image string) (string, error) {
+	if image == "" || strings.ContainsAny(image, "/:@") {
+		return image, nil
+	}
+	if m.images == nil {
+		return "", errors.Errorf(def.ErrGeneralBadRequest, "image %s is not an image reference", image)
+	}
+	ref, err := m.images.Resolve(image)
+	if err != nil {
+		return "", errors.Wrap(err, def.ErrGeneralBadRequest, "invalid container image")
+	}
+	return ref, nil
+}
+
+func (m *Manager) get(id int64) (*types.BatchJob, error) {
+	job, err := m.store.GetBatchJob(id)
+	if err == types.ErrNotFound {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "batch job %d not found", id)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrBatchList, "get batch job failed")
+	}
+	return job, nil
+}
+
+// refresh updates the job from its Job, a Job deleted behind our back
+// cancels the job.
+func (m *Manager) refresh(job *types.BatchJob) error {
+	o, err := m.jobs.Observe(job.Namespace, KubeJobName(job.ID))
+	if err == errJobNotFound {
+		ended := m.now()
+		o = Observation{State: types.BatchCancelled, Reason: "JobDeleted", EndedAt: &ended}
+	} else if err != nil {
+		return err
+	}
+	before := *job
+	job.State, job.Reason = o.State, o.Reason
+	if len(o.Nodes) > 0 {
+		job.NodeList = strings.Join(o.Nodes, ",")
+	}
+	if job.StartedAt == nil {
+		job.StartedAt = o.StartedAt
+	}
+	if job.Ended() && job.EndedAt == nil {
+		job.EndedAt = o.EndedAt
+		if job.EndedAt == nil {
+			ended := m.now()
+			job.EndedAt = &ended
+		}
+	}
+	if job.State == before.State && job.Reason == before.Reason && job.NodeList == before.NodeList &&
+		job.StartedAt == before.StartedAt && job.EndedAt == before.EndedAt {
+		return nil
+	}
+	return m.store.UpdateBatchJob(job)
+}
+
+// newJobTemplate returns the Job of a batch job, the job runs in one pod.
+func newJobTemplate(job *types.BatchJob, spec *Spec) *jobTemplate {
+	t := &jobTemplate{
+		Namespace: job.Namespace,
+		Name:      KubeJobName(job.ID),
+		Labels:    map[string]string{LabelJobID: strconv.FormatInt(job.ID, 10)},
+		Annotations: map[string]string{
+			AnnotationName: job.Name,
+			AnnotationUser: job.SubmittedBy,
+		},
+		Deadline:     spec.TimeLimit,
+		NodeSelector: make(map[string]string),
+		Image:        spec.Image,
+		Script:       job.Script,
+		Env:          spec.Env(job.ID, job.Namespace),
+		MilliCPU:     int64(spec.CPUsPerNode()) * 1000,
+		MemoryMB:     spec.MemoryPerNodeMB(),
+		GPUs:         spec.GPUs,
+	}
+	if t.Image == "" {
+		t.Image = conf.BatchImage()
+	}
+	if spec.Partition != "" {
+		t.NodeSelector[conf.BatchPartitionLabel()] = spec.Partition
+	}
+	if spec.GPUType != "" {
+		t.NodeSelector[inventory.LabelGPUModel] = gpuModel(spec.GPUType)
+	}
+	return t
+}
+
+// gpuModel returns the GPU model label value of a gres GPU type, the type
+// itself if batch::GPUTypes does not map it.
+// accountNamespace returns the namespace of the jobs charged to account, it
+// must be one of batch::Accounts open to the user.
+func accountNamespace(account, username string) (string, error) {
+	if account == "" {
+		return conf.BatchNamespace(), nil
+	}
+	if !reNamespace.MatchString(account) {
+		return "", errors.Errorf(def.ErrGeneralBadRequest, "account %q is not a valid namespace", account)
+	}
+	for _, pair := range conf.BatchAccounts() {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != account {
+			continue
+		}
+		for _, user := range strings.Split(kv[1], ",") {
+			if user = strings.TrimSpace(user); user == "*" || user == username {
+				return account, nil
+			}
+		}
+		return "", errors.Errorf(def.ErrGeneralForbidden, "user %s may not use account %s", username, account)
+	}
+	return "", errors.Errorf(def.ErrGeneralBadRequest, "invalid account %q", account)
+}
+
+func gpuModel(gpuType string) string {
+	for _, pair := range conf.BatchGPUTypes() {
+		if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == gpuType {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return gpuType
+}
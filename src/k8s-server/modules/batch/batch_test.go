@@ -0,0 +1,257 @@
+package batch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego"
+
+	"k8s-server/def"
+	"k8s-server/models/filedb"
+	"k8s-server/models/types"
+	"k8s-server/modules/account"
+	"k8s-server/modules/inventory"
+	"k8s-server/utils/errors"
+)
+
+type fakeJobs struct {
+	created      map[string]*jobTemplate
+	observations map[string]Observation
+	deleted      []string
+}
+
+func (f *fakeJobs) Create(t *jobTemplate) error {
+	f.created[t.Namespace+"/"+t.Name] = t
+	return nil
+}
+
+func (f *fakeJobs) Observe(namespace, name string) (Observation, error) {
+	o, ok := f.observations[namespace+"/"+name]
+	if !ok {
+		if _, ok = f.created[namespace+"/"+name]; !ok {
+			return o, errJobNotFound
+		}
+		o.State = types.BatchPending
+	}
+	return o, nil
+}
+
+func (f *fakeJobs) Delete(namespace, name string) error {
+	f.deleted = append(f.deleted, namespace+"/"+name)
+	delete(f.created, namespace+"/"+name)
+	return nil
+}
+
+// fakeImages is an image catalog of docker entries.
+type fakeImages map[string]string
+
+func (f fakeImages) Resolve(name string) (string, error) {
+	if ref, ok := f[name]; ok {
+		return ref, nil
+	}
+	return "", errors.Errorf(def.ErrGeneralNotFound, "image %s is not in the catalog", name)
+}
+
+// fakeIdentities maps the known users to their uid.
+type fakeIdentities map[string]int64
+
+func (f fakeIdentities) Identity(username string) (*account.Identity, error) {
+	uid, ok := f[username]
+	if !ok {
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "invalid username %q", username)
+	}
+	return &account.Identity{Username: username, UID: uid, GID: uid, Home: "/home/" + username}, nil
+}
+
+func newTestManager(t *testing.T) (*Manager, *fakeJobs, func()) {
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := filedb.NewModel(filepath.Join(dir, "test.db"), 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	jobs := &fakeJobs{created: make(map[string]*jobTemplate), observations: make(map[string]Observation)}
+	images := fakeImages{"pytorch": "registry.local/ml/pytorch:1.2-cuda10"}
+	identities := fakeIdentities{"alice": 10000, "bob": 10001}
+	m := &Manager{store: store, jobs: jobs, images: images, identities: identities, now: time.Now}
+	return m, jobs, func() { os.RemoveAll(dir) }
+}
+
+func TestSubmit(t *testing.T) {
+	beego.AppConfig.Set("batch::Accounts", "physics=alice,carol;shared=*")
+	defer beego.AppConfig.Set("batch::Accounts", "")
+	m, jobs, cleanup := newTestManager(t)
+	defer cleanup()
+
+	_, err := m.Submit("alice", SubmitRequest{Script: mpiScript, Args: []string{"-A", "physics"}})
+	if !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("multi-node job: %v", err)
+	}
+	res, err := m.Submit("alice", SubmitRequest{Script: mpiScript, Args: []string{"-A", "physics", "-N", "1", "-n", "32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.JobID != 1 || res.KubeJob != "slurm-1" || res.Namespace != "physics" ||
+		res.Message != "Submitted batch job 1" {
+		t.Errorf("result = %+v", res)
+	}
+	tmpl := jobs.created["physics/slurm-1"]
+	if tmpl == nil {
+		t.Fatalf("job not created: %v", jobs.created)
+	}
+	if tmpl.MilliCPU != 64000 || tmpl.MemoryMB != 96*1024 ||
+		tmpl.GPUs != 4 || tmpl.Deadline != 36*time.Hour || tmpl.Image == "" || tmpl.Script != mpiScript {
+		t.Errorf("template = %+v", tmpl)
+	}
+	if tmpl.Identity == nil || tmpl.Identity.UID != 10000 || tmpl.Identity.Home != "/home/alice" {
+		t.Errorf("identity = %+v", tmpl.Identity)
+	}
+	wantSelector := map[string]string{"k8s-server/partition": "gpu", inventory.LabelGPUModel: "v100"}
+	if !reflect.DeepEqual(tmpl.NodeSelector, wantSelector) {
+		t.Errorf("node selector = %v, want %v", tmpl.NodeSelector, wantSelector)
+	}
+	env := make(map[string]string)
+	for _, kv := range tmpl.Env {
+		env[kv[0]] = kv[1]
+	}
+	if env["SLURM_JOB_ID"] != "1" || env["SLURM_NTASKS_PER_NODE"] != "32" || env["SLURM_JOB_ACCOUNT"] != "physics" {
+		t.Errorf("env = %v", env)
+	}
+
+	// --container-image takes a catalog entry or an image reference
+	for image, want := range map[string]string{
+		"pytorch":              "registry.local/ml/pytorch:1.2-cuda10",
+		"docker.io/centos:7.6": "docker.io/centos:7.6",
+	} {
+		res, err = m.Submit("alice", SubmitRequest{Script: "#!/bin/sh\nhostname\n", Args: []string{"--container-image", image}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := jobs.created["batch/"+res.KubeJob].Image; got != want {
+			t.Errorf("image %s ran as %s, want %s", image, got, want)
+		}
+	}
+	if _, err = m.Submit("alice", SubmitRequest{Script: "#!/bin/sh\n#SBATCH --container-image=tensorflow\n"}); !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("image outside the catalog: %v", err)
+	}
+	if _, err = m.Submit("alice", SubmitRequest{Script: "srun hostname"}); !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("script without interpreter: %v", err)
+	}
+	if _, err = m.Submit("alice", SubmitRequest{Script: "#!/bin/sh\n#SBATCH -A Physics_Lab\n"}); !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("invalid account: %v", err)
+	}
+	if _, err = m.Submit("alice", SubmitRequest{Script: "#!/bin/sh\n#SBATCH -A kube-system\n"}); !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("account outside batch::Accounts: %v", err)
+	}
+	if _, err = m.Submit("bob", SubmitRequest{Script: "#!/bin/sh\n#SBATCH -A physics\n"}); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("account of other users: %v", err)
+	}
+	if res, err = m.Submit("bob", SubmitRequest{Script: "#!/bin/sh\n#SBATCH -A shared\n"}); err != nil || res.Namespace != "shared" {
+		t.Errorf("account open to everyone: %+v, %v", res, err)
+	}
+}
+
+func TestJobStates(t *testing.T) {
+	m, jobs, cleanup := newTestManager(t)
+	defer cleanup()
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		if _, err := m.Submit(user, SubmitRequest{Script: "#!/bin/sh\n#SBATCH -t 90 -J sim\nhostname\n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	started := now.Add(-65 * time.Minute)
+	jobs.observations["batch/slurm-1"] = Observation{State: types.BatchRunning, Nodes: []string{"node1"}, StartedAt: &started}
+	jobs.observations["batch/slurm-3"] = Observation{State: types.BatchPending, Reason: "Resources"}
+	m.Sync()
+
+	statuses, err := m.List(types.BatchJobFilter{User: "alice", Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `             JOBID PARTITION     NAME     USER ST       TIME  NODES NODELIST(REASON)
+                 1                sim    alice  R    1:05:00      1 node1
+                 3                sim    alice PD       0:00      1 (Resources)
+`
+	if got := FormatSqueue(statuses); got != want {
+		t.Errorf("squeue =\n%s\nwant\n%s", got, want)
+	}
+	if statuses[0].TimeLimit != "1:30:00" {
+		t.Errorf("time limit = %s", statuses[0].TimeLimit)
+	}
+
+	// cancel
+	if _, err = m.Cancel("bob", 1); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("cancel by another user: %v", err)
+	}
+	status, err := m.Cancel("alice", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != types.BatchCancelled || !reflect.DeepEqual(jobs.deleted, []string{"batch/slurm-3"}) {
+		t.Errorf("cancelled status %+v, deleted %v", status, jobs.deleted)
+	}
+	if _, err = m.Cancel("alice", 3); !hasCode(err, def.ErrGeneralConflict) {
+		t.Errorf("cancel twice: %v", err)
+	}
+	if _, err = m.Get(42); !hasCode(err, def.ErrGeneralNotFound) {
+		t.Errorf("get unknown job: %v", err)
+	}
+
+	// the job ends, the elapsed time stops
+	now = now.Add(10 * time.Minute)
+	ended := now.Add(-5 * time.Minute)
+	jobs.observations["batch/slurm-1"] = Observation{State: types.BatchTimeout, Nodes: []string{"node1"},
+		StartedAt: &started, EndedAt: &ended}
+	status, err = m.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != types.BatchTimeout || status.Time != "1:10:00" || status.EndedAt == nil {
+		t.Errorf("ended status = %+v", status)
+	}
+
+	// a Job deleted in the cluster cancels the job
+	delete(jobs.created, "batch/slurm-2")
+	m.Sync()
+	all, _ := m.List(types.BatchJobFilter{})
+	var states []string
+	for _, s := range all {
+		states = append(states, s.State)
+	}
+	if strings.Join(states, ",") != "TO,CA,CA" {
+		t.Errorf("states = %v, want TO,CA,CA", states)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                               "0:00",
+		59 * time.Second:                "0:59",
+		time.Hour + 2*time.Second:       "1:00:02",
+		50*time.Hour + 3*time.Minute:    "2-02:03:00",
+		-time.Second:                    "0:00",
+		23*time.Hour + 59*time.Minute:   "23:59:00",
+		10*time.Minute + 30*time.Second: "10:30",
+	} {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%v) = %s, want %s", d, got, want)
+		}
+	}
+}
+
+func hasCode(err error, code int) bool {
+	c := errors.ErrorCode(err)
+	return len(c) >= 4 && c[:4] == strconv.Itoa(code)
+}
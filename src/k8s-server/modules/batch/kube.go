@@ -0,0 +1,214 @@
+package batch
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s-server/models/types"
+	"k8s-server/modules/account"
+	"k8s-server/utils/kube"
+)
+
+// launcher writes the script from $SBATCH_SCRIPT to a file and executes it,
+// so the interpreter line of the script is honoured.
+const launcher = `printf '%s' "$SBATCH_SCRIPT" > /tmp/sbatch && chmod +x /tmp/sbatch && exec /tmp/sbatch`
+
+// kubeJobs is the jobClient of the cluster.
+type kubeJobs struct{}
+
+func (kubeJobs) Create(t *jobTemplate) error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	_, err = cs.BatchV1().Jobs(t.Namespace).Create(newJob(t))
+	if err != nil {
+		return fmt.Errorf("create job %s/%s failed: %v", t.Namespace, t.Name, err)
+	}
+	return nil
+}
+
+func (kubeJobs) Observe(namespace, name string) (Observation, error) {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return Observation{}, err
+	}
+	job, err := cs.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return Observation{}, errJobNotFound
+	} else if err != nil {
+		return Observation{}, fmt.Errorf("get job %s/%s failed: %v", namespace, name, err)
+	}
+	pods, err := cs.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: LabelJobID + "=" + job.Labels[LabelJobID],
+	})
+	if err != nil {
+		return Observation{}, fmt.Errorf("list pods of job %s/%s failed: %v", namespace, name, err)
+	}
+	return observe(job, pods.Items), nil
+}
+
+func (kubeJobs) Delete(namespace, name string) error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	policy := metav1.DeletePropagationBackground
+	err = cs.BatchV1().Jobs(namespace).Delete(name, &metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete job %s/%s failed: %v", namespace, name, err)
+	}
+	return nil
+}
+
+// observe maps the Job conditions and pod phases to the Slurm job states.
+func observe(job *batchv1.Job, pods []corev1.Pod) Observation {
+	o := Observation{State: types.BatchPending}
+	if job.Status.StartTime != nil {
+		started := job.Status.StartTime.Time
+		o.StartedAt = &started
+	}
+	nodes := make(map[string]bool)
+	running := false
+	reason := ""
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" {
+			nodes[pod.Spec.NodeName] = true
+		}
+		if pod.Status.Phase == corev1.PodRunning {
+			running = true
+		}
+		for _, c := range pod.Status.Conditions {
+			if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
+				reason = "Resources"
+			}
+		}
+	}
+	for n := range nodes {
+		o.Nodes = append(o.Nodes, n)
+	}
+	sort.Strings(o.Nodes)
+
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		ended := c.LastTransitionTime.Time
+		switch c.Type {
+		case batchv1.JobComplete:
+			o.State, o.EndedAt = types.BatchCompleted, &ended
+			if job.Status.CompletionTime != nil {
+				o.EndedAt = &job.Status.CompletionTime.Time
+			}
+			return o
+		case batchv1.JobFailed:
+			o.State, o.EndedAt = types.BatchFailed, &ended
+			if c.Reason == "DeadlineExceeded" {
+				o.State = types.BatchTimeout
+			} else {
+				o.Reason = "NonZeroExitCode"
+			}
+			return o
+		}
+	}
+	switch {
+	case job.DeletionTimestamp != nil:
+		o.State = types.BatchCompleting
+	case running:
+		o.State = types.BatchRunning
+	default:
+		// the pods are created but not scheduled or still pulling
+		o.Reason = reason
+		if reason == "" && len(nodes) > 0 {
+			o.Reason = "ContainerCreating"
+		}
+	}
+	return o
+}
+
+// newJob returns the Job of the template, it is not retried like a Slurm job
+// without --requeue.
+func newJob(t *jobTemplate) *batchv1.Job {
+	backoff := int32(0)
+	resources := corev1.ResourceList{
+		corev1.ResourceCPU: *resource.NewMilliQuantity(t.MilliCPU, resource.DecimalSI),
+	}
+	if t.MemoryMB > 0 {
+		resources[corev1.ResourceMemory] = *resource.NewQuantity(t.MemoryMB<<20, resource.BinarySI)
+	}
+	if t.GPUs > 0 {
+		resources[ResourceGPU] = *resource.NewQuantity(int64(t.GPUs), resource.DecimalSI)
+	}
+	env := []corev1.EnvVar{{Name: "SBATCH_SCRIPT", Value: t.Script}}
+	for _, kv := range t.Env {
+		env = append(env, corev1.EnvVar{Name: kv[0], Value: kv[1]})
+	}
+	podSpec := corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyNever,
+		NodeSelector:  t.NodeSelector,
+		Containers: []corev1.Container{{
+			Name:      "batch",
+			Image:     t.Image,
+			Command:   []string{"/bin/sh", "-c", launcher},
+			Env:       env,
+			Resources: corev1.ResourceRequirements{Requests: resources, Limits: resources},
+		}},
+	}
+	if t.Identity != nil {
+		setIdentity(&podSpec, t.Identity)
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        t.Name,
+			Namespace:   t.Namespace,
+			Labels:      t.Labels,
+			Annotations: t.Annotations,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoff,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: t.Labels, Annotations: t.Annotations},
+				Spec:       podSpec,
+			},
+		},
+	}
+	if t.Deadline > 0 {
+		deadline := int64(t.Deadline / time.Second)
+		job.Spec.ActiveDeadlineSeconds = &deadline
+	}
+	return job
+}
+
+// setIdentity runs the pod as the user with the home mounted, the script
+// starts in the home directory like on the login nodes.
+func setIdentity(spec *corev1.PodSpec, id *account.Identity) {
+	uid, gid, nonRoot := id.UID, id.GID, true
+	spec.SecurityContext = &corev1.PodSecurityContext{
+		RunAsUser:    &uid,
+		RunAsGroup:   &gid,
+		FSGroup:      &gid,
+		RunAsNonRoot: &nonRoot,
+	}
+	hostPathType := corev1.HostPathDirectory
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: account.HomeVolume,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{Path: id.Home, Type: &hostPathType},
+		},
+	})
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+		c.WorkingDir = id.Home
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: account.HomeVolume, MountPath: id.Home})
+		for _, kv := range id.Env() {
+			c.Env = append(c.Env, corev1.EnvVar{Name: kv[0], Value: kv[1]})
+		}
+	}
+}
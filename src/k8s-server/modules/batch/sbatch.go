@@ -0,0 +1,417 @@
+package batch
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec is the resource request of a batch script, read from its #SBATCH
+// directives and the sbatch options given on submission.
+type Spec struct {
+	Name      string `json:"name"`
+	Account   string `json:"account,omitempty"`
+	Partition string `json:"partition,omitempty"`
+	Nodes     int    `json:"nodes"`
+	NTasks    int    `json:"ntasks"`
+	// NTasksPerNode overrides the even spread of NTasks over the nodes.
+	NTasksPerNode int `json:"ntasksPerNode,omitempty"`
+	CPUsPerTask   int `json:"cpusPerTask"`
+	// MemoryMB is the memory per node, MemPerCPUMB the memory per allocated
+	// CPU, at most one of them is set.
+	MemoryMB    int64         `json:"memoryMB,omitempty"`
+	MemPerCPUMB int64         `json:"memPerCPUMB,omitempty"`
+	TimeLimit   time.Duration `json:"timeLimit,omitempty"`
+	// GPUs is the number of GPUs per node.
+	GPUs    int    `json:"gpus,omitempty"`
+	GPUType string `json:"gpuType,omitempty"`
+	Image   string `json:"image,omitempty"`
+	// Ignored lists the options without a Kubernetes equivalent, they are
+	// accepted so existing scripts keep working.
+	Ignored []string `json:"ignored,omitempty"`
+
+	// totalGPUs is --gpus, spread over the nodes once all options are read
+	totalGPUs int
+}
+
+// option is an sbatch option, short is its single letter alias.
+type option struct {
+	short string
+	set   func(s *Spec, value string) error
+}
+
+var options = map[string]option{
+	"job-name":        {"J", func(s *Spec, v string) error { s.Name = v; return nil }},
+	"account":         {"A", func(s *Spec, v string) error { s.Account = v; return nil }},
+	"partition":       {"p", func(s *Spec, v string) error { s.Partition = v; return nil }},
+	"nodes":           {"N", setNodes},
+	"ntasks":          {"n", intOption(func(s *Spec) *int { return &s.NTasks })},
+	"ntasks-per-node": {"", intOption(func(s *Spec) *int { return &s.NTasksPerNode })},
+	"cpus-per-task":   {"c", intOption(func(s *Spec) *int { return &s.CPUsPerTask })},
+	"mem":             {"", setMemory},
+	"mem-per-cpu":     {"", setMemPerCPU},
+	"time":            {"t", setTime},
+	"gres":            {"", setGres},
+	"gpus":            {"G", setGPUs},
+	"gpus-per-node":   {"", setGPUsPerNode},
+	"container-image": {"", func(s *Spec, v string) error { s.Image = v; return nil }},
+}
+
+// ignoredOptions have no effect on a Kubernetes Job, the value says whether
+// they take an argument.
+var ignoredOptions = map[string]bool{
+	"output": true, "o": true, "error": true, "e": true, "input": true, "i": true,
+	"mail-type": true, "mail-user": true, "comment": true, "qos": true, "q": true,
+	"chdir": true, "D": true, "export": true, "open-mode": true, "signal": true,
+	"exclusive": false, "requeue": false, "no-requeue": false, "parsable": false,
+	"hint": true, "distribution": true, "m": true,
+}
+
+// ParseScript reads the #SBATCH directives of a batch script, args are sbatch
+// options that take precedence over the directives. Like sbatch the script
+// must start with an interpreter line, and the directives end at the first
+// command.
+func ParseScript(script string, args []string) (*Spec, error) {
+	if !strings.HasPrefix(script, "#!") {
+		return nil, fmt.Errorf("the first line of a batch script must start with #! followed by the interpreter")
+	}
+	s := &Spec{Nodes: 1, CPUsPerTask: 1}
+	for i, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if i == 0 || line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if !strings.HasPrefix(line, "#SBATCH") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "#SBATCH"))
+		// a # after the options starts a comment
+		for j, f := range fields {
+			if strings.HasPrefix(f, "#") {
+				fields = fields[:j]
+				break
+			}
+		}
+		if err := s.apply(fields); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+	}
+	if err := s.apply(args); err != nil {
+		return nil, err
+	}
+	if err := s.finish(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// apply sets the options in args, in the --name=value, --name value, -Xvalue
+// or -X value forms.
+func (s *Spec) apply(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var name, value string
+		hasValue := false
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name = arg[2:]
+			if eq := strings.Index(name, "="); eq >= 0 {
+				name, value, hasValue = name[:eq], name[eq+1:], true
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) >= 2:
+			name = arg[1:2]
+			if len(arg) > 2 {
+				value, hasValue = strings.TrimPrefix(arg[2:], "="), true
+			}
+		default:
+			return fmt.Errorf("unexpected argument %q", arg)
+		}
+
+		opt, known := options[name]
+		if !known {
+			for long, o := range options {
+				if o.short != "" && o.short == name {
+					name, opt, known = long, o, true
+					break
+				}
+			}
+		}
+		takesValue, ignored := ignoredOptions[name]
+		if !known && !ignored {
+			return fmt.Errorf("unsupported option %s", arg)
+		}
+		if !hasValue && (known || takesValue) {
+			if i+1 == len(args) {
+				return fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			value = args[i]
+		}
+		if ignored {
+			s.Ignored = append(s.Ignored, name)
+			continue
+		}
+		if err := opt.set(s, value); err != nil {
+			return fmt.Errorf("invalid --%s %q: %v", name, value, err)
+		}
+	}
+	return nil
+}
+
+// finish checks the options against each other once all are read.
+func (s *Spec) finish() error {
+	if s.NTasks == 0 {
+		s.NTasks = s.Nodes
+		if s.NTasksPerNode > 0 {
+			s.NTasks *= s.NTasksPerNode
+		}
+	}
+	if s.NTasks < s.Nodes {
+		return fmt.Errorf("%d tasks can not run on %d nodes", s.NTasks, s.Nodes)
+	}
+	if s.NTasksPerNode > 0 && s.NTasksPerNode*s.Nodes < s.NTasks {
+		return fmt.Errorf("%d tasks do not fit %d nodes with %d tasks per node", s.NTasks, s.Nodes, s.NTasksPerNode)
+	}
+	if s.MemoryMB > 0 && s.MemPerCPUMB > 0 {
+		return fmt.Errorf("--mem and --mem-per-cpu are mutually exclusive")
+	}
+	if s.totalGPUs > 0 {
+		if s.totalGPUs%s.Nodes != 0 {
+			return fmt.Errorf("%d GPUs can not be spread evenly over %d nodes", s.totalGPUs, s.Nodes)
+		}
+		s.GPUs = s.totalGPUs / s.Nodes
+	}
+	sort.Strings(s.Ignored)
+	return nil
+}
+
+// TasksPerNode returns the number of tasks run on each node.
+func (s *Spec) TasksPerNode() int {
+	if s.NTasksPerNode > 0 {
+		return s.NTasksPerNode
+	}
+	return (s.NTasks + s.Nodes - 1) / s.Nodes
+}
+
+// CPUsPerNode returns the CPUs allocated on each node.
+func (s *Spec) CPUsPerNode() int {
+	return s.TasksPerNode() * s.CPUsPerTask
+}
+
+// MemoryPerNodeMB returns the memory allocated on each node, 0 if the script
+// did not ask for memory.
+func (s *Spec) MemoryPerNodeMB() int64 {
+	if s.MemPerCPUMB > 0 {
+		return s.MemPerCPUMB * int64(s.CPUsPerNode())
+	}
+	return s.MemoryMB
+}
+
+// Env returns the Slurm environment variables of the job, sorted by name.
+func (s *Spec) Env(jobID int64, namespace string) [][2]string {
+	env := map[string]string{
+		"SLURM_JOB_ID":          strconv.FormatInt(jobID, 10),
+		"SLURM_JOBID":           strconv.FormatInt(jobID, 10),
+		"SLURM_JOB_NAME":        s.Name,
+		"SLURM_JOB_NUM_NODES":   strconv.Itoa(s.Nodes),
+		"SLURM_NNODES":          strconv.Itoa(s.Nodes),
+		"SLURM_NTASKS":          strconv.Itoa(s.NTasks),
+		"SLURM_NPROCS":          strconv.Itoa(s.NTasks),
+		"SLURM_NTASKS_PER_NODE": strconv.Itoa(s.TasksPerNode()),
+		"SLURM_CPUS_PER_TASK":   strconv.Itoa(s.CPUsPerTask),
+		"SLURM_CPUS_ON_NODE":    strconv.Itoa(s.CPUsPerNode()),
+		"SLURM_JOB_ACCOUNT":     namespace,
+	}
+	if s.Partition != "" {
+		env["SLURM_JOB_PARTITION"] = s.Partition
+	}
+	if mem := s.MemoryPerNodeMB(); mem > 0 {
+		env["SLURM_MEM_PER_NODE"] = strconv.FormatInt(mem, 10)
+	}
+	if s.GPUs > 0 {
+		env["SLURM_GPUS_ON_NODE"] = strconv.Itoa(s.GPUs)
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	vars := make([][2]string, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, [2]string{k, env[k]})
+	}
+	return vars
+}
+
+func intOption(field func(s *Spec) *int) func(s *Spec, value string) error {
+	return func(s *Spec, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("not a positive number")
+		}
+		*field(s) = n
+		return nil
+	}
+}
+
+// setNodes reads --nodes as a count or a min-max range, the job runs on the
+// minimum.
+func setNodes(s *Spec, value string) error {
+	if dash := strings.Index(value, "-"); dash >= 0 {
+		value = value[:dash]
+	}
+	return intOption(func(s *Spec) *int { return &s.Nodes })(s, value)
+}
+
+func setMemory(s *Spec, value string) error {
+	mb, err := parseMemory(value)
+	s.MemoryMB = mb
+	return err
+}
+
+func setMemPerCPU(s *Spec, value string) error {
+	mb, err := parseMemory(value)
+	s.MemPerCPUMB = mb
+	return err
+}
+
+// parseMemory reads a size in megabytes with an optional K, M, G or T suffix.
+// 0 asks for all the memory of a node, it sets no limit.
+func parseMemory(value string) (int64, error) {
+	value = strings.TrimSuffix(strings.ToUpper(value), "B")
+	unit := 1.0
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'K':
+			unit = 1.0 / 1024
+		case 'M':
+			unit = 1
+		case 'G':
+			unit = 1024
+		case 'T':
+			unit = 1024 * 1024
+		}
+		if value[n-1] < '0' || value[n-1] > '9' {
+			value = value[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("not a size")
+	}
+	return int64(f*unit + 0.999), nil
+}
+
+func setTime(s *Spec, value string) error {
+	d, err := ParseTimeLimit(value)
+	s.TimeLimit = d
+	return err
+}
+
+// ParseTimeLimit reads a Slurm time limit, "minutes", "minutes:seconds",
+// "hours:minutes:seconds", "days-hours", "days-hours:minutes" or
+// "days-hours:minutes:seconds". UNLIMITED is returned as 0.
+func ParseTimeLimit(value string) (time.Duration, error) {
+	switch strings.ToUpper(value) {
+	case "UNLIMITED", "INFINITE", "-1":
+		return 0, nil
+	}
+	var days int
+	rest := value
+	hasDays := false
+	if dash := strings.Index(value, "-"); dash >= 0 {
+		d, err := strconv.Atoi(value[:dash])
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid days")
+		}
+		days, rest, hasDays = d, value[dash+1:], true
+	}
+	var parts []int
+	for _, p := range strings.Split(rest, ":") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("not a time limit")
+		}
+		parts = append(parts, n)
+	}
+	var h, m, sec int
+	switch {
+	case len(parts) > 3:
+		return 0, fmt.Errorf("not a time limit")
+	case hasDays:
+		// days-hours[:minutes[:seconds]]
+		h = parts[0]
+		if len(parts) > 1 {
+			m = parts[1]
+		}
+		if len(parts) > 2 {
+			sec = parts[2]
+		}
+	case len(parts) == 1:
+		m = parts[0]
+	case len(parts) == 2:
+		m, sec = parts[0], parts[1]
+	default:
+		h, m, sec = parts[0], parts[1], parts[2]
+	}
+	d := time.Duration(days)*24*time.Hour + time.Duration(h)*time.Hour +
+		time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	if d == 0 {
+		return 0, fmt.Errorf("the time limit must be positive")
+	}
+	return d, nil
+}
+
+// setGres reads --gres, gpu[:type][:count] is the only generic resource
+// Kubernetes schedules.
+func setGres(s *Spec, value string) error {
+	for _, gres := range strings.Split(value, ",") {
+		parts := strings.Split(gres, ":")
+		if parts[0] != "gpu" {
+			return fmt.Errorf("generic resource %s is not supported", parts[0])
+		}
+		count := 1
+		switch len(parts) {
+		case 1:
+		case 2:
+			if n, err := strconv.Atoi(parts[1]); err == nil {
+				count = n
+			} else {
+				s.GPUType = parts[1]
+			}
+		case 3:
+			n, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return fmt.Errorf("invalid GPU count %s", parts[2])
+			}
+			s.GPUType, count = parts[1], n
+		default:
+			return fmt.Errorf("invalid generic resource %s", gres)
+		}
+		if count < 1 {
+			return fmt.Errorf("invalid GPU count %d", count)
+		}
+		s.GPUs = count
+	}
+	return nil
+}
+
+// setGPUs reads --gpus [type:]count, the total over all nodes.
+func setGPUs(s *Spec, value string) error {
+	if colon := strings.LastIndex(value, ":"); colon >= 0 {
+		s.GPUType, value = value[:colon], value[colon+1:]
+	}
+	return intOption(func(s *Spec) *int { return &s.totalGPUs })(s, value)
+}
+
+func setGPUsPerNode(s *Spec, value string) error {
+	if colon := strings.LastIndex(value, ":"); colon >= 0 {
+		s.GPUType, value = value[:colon], value[colon+1:]
+	}
+	return intOption(func(s *Spec) *int { return &s.GPUs })(s, value)
+}
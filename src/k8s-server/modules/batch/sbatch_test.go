@@ -0,0 +1,99 @@
+package batch
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const mpiScript = `#!/bin/bash
+#SBATCH --job-name=lammps
+#SBATCH -N 2
+#SBATCH --ntasks=64 # one task per core
+#SBATCH -c 2
+#SBATCH --mem=96G
+#SBATCH --time=1-12:00:00
+#SBATCH -p gpu
+#SBATCH --gres=gpu:v100:4
+#SBATCH -o lammps-%j.out --exclusive
+#SBATCH --mail-type=END
+
+module load lammps
+#SBATCH --job-name=ignored-after-the-first-command
+srun lmp -in in.lj
+`
+
+func TestParseScript(t *testing.T) {
+	s, err := ParseScript(mpiScript, []string{"--time", "2:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Spec{
+		Name:        "lammps",
+		Partition:   "gpu",
+		Nodes:       2,
+		NTasks:      64,
+		CPUsPerTask: 2,
+		MemoryMB:    96 * 1024,
+		TimeLimit:   2 * time.Hour,
+		GPUs:        4,
+		GPUType:     "v100",
+		Ignored:     []string{"exclusive", "mail-type", "o"},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("spec = %+v, want %+v", s, want)
+	}
+	if s.TasksPerNode() != 32 || s.CPUsPerNode() != 64 || s.MemoryPerNodeMB() != 96*1024 {
+		t.Errorf("per node %d tasks, %d CPUs, %d MB", s.TasksPerNode(), s.CPUsPerNode(), s.MemoryPerNodeMB())
+	}
+
+	s, err = ParseScript("#!/bin/sh\n#SBATCH --ntasks-per-node=4 --nodes=3-5 --mem-per-cpu=512M\n#SBATCH -G 6\nhostname\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Nodes != 3 || s.NTasks != 12 || s.MemoryPerNodeMB() != 2048 || s.GPUs != 2 {
+		t.Errorf("spec = %+v", s)
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	for _, c := range []struct {
+		script string
+		args   []string
+	}{
+		{"hostname\n", nil},
+		{"#!/bin/sh\n#SBATCH --array=1-10\n", nil},
+		{"#!/bin/sh\n#SBATCH --gres=mic:2\n", nil},
+		{"#!/bin/sh\n#SBATCH -N 4 -n 2\n", nil},
+		{"#!/bin/sh\n#SBATCH --mem=1G --mem-per-cpu=1G\n", nil},
+		{"#!/bin/sh\n#SBATCH --time=soon\n", nil},
+		{"#!/bin/sh\n", []string{"--nodes"}},
+		{"#!/bin/sh\n", []string{"-N", "3", "--gpus=4"}},
+	} {
+		if s, err := ParseScript(c.script, c.args); err == nil {
+			t.Errorf("%q %v accepted: %+v", c.script, c.args, s)
+		}
+	}
+}
+
+func TestParseTimeLimit(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"30":         30 * time.Minute,
+		"30:15":      30*time.Minute + 15*time.Second,
+		"4:00:00":    4 * time.Hour,
+		"2-0":        48 * time.Hour,
+		"1-06:30":    30*time.Hour + 30*time.Minute,
+		"0-00:00:90": 90 * time.Second,
+		"UNLIMITED":  0,
+	} {
+		got, err := ParseTimeLimit(value)
+		if err != nil || got != want {
+			t.Errorf("ParseTimeLimit(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "0", "1:2:3:4", "x-1", "-5"} {
+		if d, err := ParseTimeLimit(value); err == nil {
+			t.Errorf("ParseTimeLimit(%q) = %v accepted", value, d)
+		}
+	}
+}
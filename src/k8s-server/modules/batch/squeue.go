@@ -0,0 +1,112 @@
+package batch
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s-server/models/types"
+)
+
+// Status is a batch job as listed by squeue. NodeList holds the nodes of a
+// started job and the (Reason) of a pending one.
+type Status struct {
+	JobID       int64      `json:"jobId"`
+	Partition   string     `json:"partition"`
+	Name        string     `json:"name"`
+	User        string     `json:"user"`
+	State       string     `json:"state"`
+	Time        string     `json:"time"`
+	TimeLimit   string     `json:"timeLimit"`
+	Nodes       int        `json:"nodes"`
+	NodeList    string     `json:"nodeList"`
+	Namespace   string     `json:"namespace"`
+	KubeJob     string     `json:"kubeJob"`
+	SubmittedAt time.Time  `json:"submittedAt"`
+	StartedAt   *time.Time `json:"startedAt"`
+	EndedAt     *time.Time `json:"endedAt"`
+}
+
+// KubeJobName returns the name of the Kubernetes Job of a batch job.
+func KubeJobName(id int64) string {
+	return "slurm-" + strconv.FormatInt(id, 10)
+}
+
+func newStatus(j types.BatchJob, now time.Time) Status {
+	s := Status{
+		JobID:       j.ID,
+		Partition:   j.Partition,
+		Name:        j.Name,
+		User:        j.SubmittedBy,
+		State:       j.State,
+		Time:        formatDuration(0),
+		TimeLimit:   "UNLIMITED",
+		Nodes:       j.Nodes,
+		NodeList:    j.NodeList,
+		Namespace:   j.Namespace,
+		KubeJob:     KubeJobName(j.ID),
+		SubmittedAt: j.SubmittedAt,
+		StartedAt:   j.StartedAt,
+		EndedAt:     j.EndedAt,
+	}
+	if j.TimeLimit > 0 {
+		s.TimeLimit = formatDuration(time.Duration(j.TimeLimit) * time.Second)
+	}
+	if j.StartedAt != nil {
+		end := now
+		if j.EndedAt != nil {
+			end = *j.EndedAt
+		}
+		s.Time = formatDuration(end.Sub(*j.StartedAt))
+	}
+	if s.NodeList == "" || j.State == types.BatchPending {
+		reason := j.Reason
+		if reason == "" {
+			reason = "None"
+		}
+		s.NodeList = "(" + reason + ")"
+	}
+	return s
+}
+
+// formatDuration formats a duration like squeue, M:SS, H:MM:SS or
+// D-HH:MM:SS.
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	sec := int64(d / time.Second)
+	days, h, m, s := sec/86400, sec/3600%24, sec/60%60, sec%60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%d-%02d:%02d:%02d", days, h, m, s)
+	case h > 0:
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// FormatSqueue formats the statuses like the default squeue output, fields
+// wider than their column are truncated.
+func FormatSqueue(statuses []Status) string {
+	var buf bytes.Buffer
+	row := func(id, partition, name, user, state, elapsed, nodes, nodeList string) {
+		fmt.Fprintf(&buf, "%18s %9s %8s %8s %2s %10s %6s %s\n", truncate(id, 18),
+			truncate(partition, 9), truncate(name, 8), truncate(user, 8), truncate(state, 2),
+			truncate(elapsed, 10), truncate(nodes, 6), nodeList)
+	}
+	row("JOBID", "PARTITION", "NAME", "USER", "ST", "TIME", "NODES", "NODELIST(REASON)")
+	for _, s := range statuses {
+		row(strconv.FormatInt(s.JobID, 10), s.Partition, s.Name, s.User, s.State, s.Time,
+			strconv.Itoa(s.Nodes), s.NodeList)
+	}
+	return buf.String()
+}
+
+func truncate(s string, width int) string {
+	if len(s) > width {
+		return s[:width]
+	}
+	return s
+}
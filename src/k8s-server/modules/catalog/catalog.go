@@ -0,0 +1,281 @@
+// Package catalog serves the Docker and Singularity image catalogs, the YAML
+// files configured by backend::DockerImages and backend::SingularityImages.
+// Workloads refer to a catalog entry by name instead of a raw image
+// reference.
+package catalog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// Image types.
+const (
+	TypeDocker      = "docker"
+	TypeSingularity = "singularity"
+)
+
+var (
+	reName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9_.]{0,61}[a-z0-9])?$`)
+	// reReference is a docker image reference, [registry[:port]/]path[:tag][@digest]
+	reReference = regexp.MustCompile(`^([a-zA-Z0-9]([-a-zA-Z0-9.]*[a-zA-Z0-9])?(:[0-9]+)?/)?` +
+		`[a-z0-9]+([._-]+[a-z0-9]+)*(/[a-z0-9]+([._-]+[a-z0-9]+)*)*` +
+		`(:[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+	// singularitySchemes are the remote sources singularity pulls from
+	singularitySchemes = []string{"library://", "docker://", "shub://", "oras://"}
+)
+
+// Entry is an image of a catalog file. Docker entries set Image, Singularity
+// entries set Path to an image file or a remote source.
+type Entry struct {
+	Name        string   `yaml:"name" json:"name"`
+	Type        string   `yaml:"-" json:"type"`
+	Image       string   `yaml:"image,omitempty" json:"image,omitempty"`
+	Path        string   `yaml:"path,omitempty" json:"path,omitempty"`
+	Description string   `yaml:"description,omitempty" json:"description,omitempty"`
+	Tags        []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	GPU         bool     `yaml:"gpu,omitempty" json:"gpu"`
+}
+
+// file is the content of a catalog file.
+type file struct {
+	Images []Entry `yaml:"images"`
+}
+
+// Catalog is the loaded catalog, Errors lists the catalog files that could
+// not be loaded, their previous entries are kept.
+type Catalog struct {
+	Images   []Entry   `json:"images"`
+	Errors   []string  `json:"errors,omitempty"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+// source is a catalog file.
+type source struct {
+	typ     string
+	path    string
+	modTime time.Time
+	size    int64
+	entries []Entry
+	err     error
+}
+
+// Manager represents the image catalog manager.
+type Manager struct {
+	sources  []*source
+	loadedAt time.Time
+	lock     sync.RWMutex
+}
+
+// NewManager returns the catalog of the enabled image types and loads it.
+func NewManager() (*Manager, error) {
+	var paths [][2]string
+	if conf.DockerEnabled() {
+		paths = append(paths, [2]string{TypeDocker, conf.DockerImages()})
+	}
+	if conf.SingularityEnabled() {
+		paths = append(paths, [2]string{TypeSingularity, conf.SingularityImages()})
+	}
+	m := newManager(paths)
+	m.Reload(false)
+	return m, nil
+}
+
+func newManager(paths [][2]string) *Manager {
+	m := &Manager{}
+	for _, p := range paths {
+		m.sources = append(m.sources, &source{typ: p[0], path: p[1]})
+	}
+	return m
+}
+
+// Run reloads the changed catalog files every ImageCatalogInterval until stop
+// is closed, it returns at once if the interval is not positive.
+func (m *Manager) Run(stop <-chan struct{}) {
+	interval := conf.ImageCatalogInterval()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.Reload(false)
+		}
+	}
+}
+
+// Reload loads the catalog files that changed since they were loaded, or all
+// of them if force is set. A file that fails to load keeps its previous
+// entries.
+func (m *Manager) Reload(force bool) Catalog {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, s := range m.sources {
+		info, err := os.Stat(s.path)
+		if err != nil {
+			if s.err == nil || s.entries != nil {
+				logs.Warn("image catalog %s: %v", s.path, err)
+			}
+			s.entries, s.err, s.modTime, s.size = nil, err, time.Time{}, 0
+			continue
+		}
+		if !force && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+			continue
+		}
+		s.modTime, s.size = info.ModTime(), info.Size()
+		entries, err := load(s.typ, s.path)
+		if err != nil {
+			logs.Error("load image catalog failed: %v", err)
+			s.err = err
+			continue
+		}
+		logs.Info("loaded %d %s images from %s", len(entries), s.typ, s.path)
+		s.entries, s.err = entries, nil
+	}
+	m.loadedAt = time.Now()
+	return m.catalog("")
+}
+
+// List returns the catalog, typ selects the docker or singularity images.
+func (m *Manager) List(typ string) (Catalog, error) {
+	if typ != "" && typ != TypeDocker && typ != TypeSingularity {
+		return Catalog{}, errors.Errorf(def.ErrGeneralBadRequest, "unknown image type %q", typ)
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.catalog(typ), nil
+}
+
+// Get returns a catalog entry by name.
+func (m *Manager) Get(name string) (Entry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, e := range m.catalog("").Images {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return Entry{}, errors.Errorf(def.ErrGeneralNotFound, "image %s is not in the catalog", name)
+}
+
+// Resolve returns the image reference of a docker catalog entry, the image
+// a Kubernetes workload runs.
+func (m *Manager) Resolve(name string) (string, error) {
+	e, err := m.Get(name)
+	if err != nil {
+		return "", errors.Wrap(err, def.ErrGeneralBadRequest, "unknown image")
+	}
+	if e.Type != TypeDocker {
+		return "", errors.Errorf(def.ErrGeneralBadRequest,
+			"%s is a %s image and can not run as a container", name, e.Type)
+	}
+	return e.Image, nil
+}
+
+// catalog merges the sources, an entry whose name is taken by an earlier
+// source is dropped.
+func (m *Manager) catalog(typ string) Catalog {
+	c := Catalog{Images: []Entry{}, LoadedAt: m.loadedAt}
+	seen := make(map[string]string)
+	for _, s := range m.sources {
+		if s.err != nil {
+			c.Errors = append(c.Errors, s.err.Error())
+		}
+		for _, e := range s.entries {
+			if other, ok := seen[e.Name]; ok {
+				c.Errors = append(c.Errors, fmt.Sprintf("%s: image %s is already defined in %s", s.path, e.Name, other))
+				continue
+			}
+			seen[e.Name] = s.path
+			if typ == "" || e.Type == typ {
+				c.Images = append(c.Images, e)
+			}
+		}
+	}
+	sort.Slice(c.Images, func(i, j int) bool { return c.Images[i].Name < c.Images[j].Name })
+	return c
+}
+
+// load reads and validates a catalog file.
+func load(typ, path string) ([]Entry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err = yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, fmt.Errorf("parse %s failed: %v", path, err)
+	}
+	names := make(map[string]bool, len(f.Images))
+	for i := range f.Images {
+		e := &f.Images[i]
+		e.Type = typ
+		if err = e.validate(); err != nil {
+			return nil, fmt.Errorf("%s: image %d: %v", path, i, err)
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("%s: duplicated image %s", path, e.Name)
+		}
+		names[e.Name] = true
+	}
+	return f.Images, nil
+}
+
+func (e *Entry) validate() error {
+	if !reName.MatchString(e.Name) {
+		return fmt.Errorf("invalid name %q", e.Name)
+	}
+	switch e.Type {
+	case TypeDocker:
+		if e.Path != "" {
+			return fmt.Errorf("docker image %s has a path", e.Name)
+		}
+		if !reReference.MatchString(e.Image) {
+			return fmt.Errorf("invalid image reference %q of %s", e.Image, e.Name)
+		}
+	case TypeSingularity:
+		if e.Image != "" {
+			return fmt.Errorf("singularity image %s has an image reference, use path", e.Name)
+		}
+		if !validSingularityPath(e.Path) {
+			return fmt.Errorf("invalid singularity image %q of %s", e.Path, e.Name)
+		}
+	}
+	return nil
+}
+
+// validSingularityPath accepts absolute image files and remote sources.
+func validSingularityPath(path string) bool {
+	for _, scheme := range singularitySchemes {
+		if strings.HasPrefix(path, scheme) {
+			return len(path) > len(scheme)
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	for _, ext := range []string{".sif", ".simg", ".img"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	// a sandbox directory
+	return strings.HasSuffix(path, "/")
+}
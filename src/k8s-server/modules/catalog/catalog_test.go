@@ -0,0 +1,196 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego"
+	"k8s-server/def"
+	"k8s-server/utils/errors"
+)
+
+const dockerImages = `images:
+- name: pytorch
+  image: registry.local/ml/pytorch:1.2-cuda10
+  description: PyTorch 1.2 with CUDA 10
+  tags: [ml, python]
+  gpu: true
+- name: centos7
+  image: centos:7
+`
+
+const singularityImages = `images:
+- name: lammps
+  path: /opt/images/lammps.sif
+- name: gromacs
+  path: library://sylabs/hpc/gromacs:2019
+- name: sandbox
+  path: /opt/images/sandbox/
+`
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestManager(t *testing.T) (*Manager, string, func()) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "images.yaml"), dockerImages)
+	writeFile(t, filepath.Join(dir, "singularity.yml"), singularityImages)
+	m := newManager([][2]string{
+		{TypeDocker, filepath.Join(dir, "images.yaml")},
+		{TypeSingularity, filepath.Join(dir, "singularity.yml")},
+	})
+	m.Reload(false)
+	return m, dir, func() { os.RemoveAll(dir) }
+}
+
+func names(c Catalog) string {
+	var s []string
+	for _, e := range c.Images {
+		s = append(s, e.Name)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestCatalog(t *testing.T) {
+	m, _, cleanup := newTestManager(t)
+	defer cleanup()
+
+	c, err := m.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(c); got != "centos7,gromacs,lammps,pytorch,sandbox" || len(c.Errors) != 0 {
+		t.Errorf("catalog = %s, errors %v", got, c.Errors)
+	}
+	c, _ = m.List(TypeSingularity)
+	if got := names(c); got != "gromacs,lammps,sandbox" {
+		t.Errorf("singularity catalog = %s", got)
+	}
+	if _, err = m.List("rkt"); !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("unknown type: %v", err)
+	}
+
+	e, err := m.Get("pytorch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != TypeDocker || !e.GPU || len(e.Tags) != 2 {
+		t.Errorf("entry = %+v", e)
+	}
+	if _, err = m.Get("tensorflow"); !hasCode(err, def.ErrGeneralNotFound) {
+		t.Errorf("unknown image: %v", err)
+	}
+
+	ref, err := m.Resolve("centos7")
+	if err != nil || ref != "centos:7" {
+		t.Errorf("resolve centos7 = %s, %v", ref, err)
+	}
+	if _, err = m.Resolve("lammps"); !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("singularity image resolved: %v", err)
+	}
+	if _, err = m.Resolve("tensorflow"); !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("unknown image resolved: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	m, dir, cleanup := newTestManager(t)
+	defer cleanup()
+	path := filepath.Join(dir, "images.yaml")
+	touch := func(content string) {
+		writeFile(t, path, content)
+		// the modification time has to change for an unforced reload
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	touch(dockerImages + "- name: tensorflow\n  image: tensorflow/tensorflow:1.14.0-gpu\n")
+	if c := m.Reload(false); names(c) != "centos7,gromacs,lammps,pytorch,sandbox,tensorflow" {
+		t.Errorf("catalog after adding an image = %s", names(c))
+	}
+
+	// a broken file keeps the previous images and reports the error
+	touch(dockerImages + "- name: Bad Name\n  image: centos:7\n")
+	c := m.Reload(false)
+	if names(c) != "centos7,gromacs,lammps,pytorch,sandbox,tensorflow" || len(c.Errors) != 1 {
+		t.Errorf("catalog after a broken change = %s, errors %v", names(c), c.Errors)
+	}
+
+	// an image name is taken by the first file defining it
+	touch("images:\n- name: lammps\n  image: lammps/lammps:stable\n")
+	c = m.Reload(true)
+	if names(c) != "gromacs,lammps,sandbox" || len(c.Errors) != 1 {
+		t.Errorf("catalog with a duplicate = %s, errors %v", names(c), c.Errors)
+	}
+	if e, _ := m.Get("lammps"); e.Type != TypeDocker {
+		t.Errorf("lammps = %+v, want the docker image", e)
+	}
+
+	os.Remove(path)
+	if c = m.Reload(false); names(c) != "gromacs,lammps,sandbox" || len(c.Errors) != 1 {
+		t.Errorf("catalog without the docker file = %s, errors %v", names(c), c.Errors)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "images.yaml")
+
+	for _, c := range []struct {
+		typ, content string
+	}{
+		{TypeDocker, "images:\n- name: a\n  image: centos:7\n  registry: x\n"},
+		{TypeDocker, "images:\n- name: a\n  image: CentOS\n"},
+		{TypeDocker, "images:\n- name: a\n  image: centos:7\n  path: /opt/a.sif\n"},
+		{TypeDocker, "images:\n- name: a\n  image: centos:7\n- name: a\n  image: centos:6\n"},
+		{TypeDocker, "images:\n- name: -a\n  image: centos:7\n"},
+		{TypeSingularity, "images:\n- name: a\n  path: images/a.sif\n"},
+		{TypeSingularity, "images:\n- name: a\n  path: /opt/images/a.tar\n"},
+		{TypeSingularity, "images:\n- name: a\n  path: docker://\n"},
+		{TypeSingularity, "images:\n- name: a\n  image: centos:7\n  path: /opt/a.sif\n"},
+		{TypeDocker, "images: {name: a}\n"},
+	} {
+		writeFile(t, path, c.content)
+		if entries, err := load(c.typ, path); err == nil {
+			t.Errorf("%s %q accepted: %+v", c.typ, c.content, entries)
+		}
+	}
+}
+
+func hasCode(err error, code int) bool {
+	c := errors.ErrorCode(err)
+	return len(c) >= 4 && c[:4] == strconv.Itoa(code)
+}
+
+func TestRunDisabled(t *testing.T) {
+	beego.AppConfig.Set("backend::ImageCatalogInterval", "0")
+	defer beego.AppConfig.Set("backend::ImageCatalogInterval", "")
+
+	done := make(chan struct{})
+	go func() {
+		newManager(nil).Run(make(chan struct{}))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return for a zero interval")
+	}
+}
@@ -0,0 +1,345 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metaHostname is the service meta holding the hostname of the agent.
+const metaHostname = "hostname"
+
+// errKeyNotFound is returned by the Consul API for missing KV keys.
+var errKeyNotFound = fmt.Errorf("key not found")
+
+// Consul is the discovery of a Consul cluster. The agents register with
+// their local Consul agent as instances of one service with a TTL check that
+// every heartbeat passes, and the overrides of a host are the KV keys below
+// <prefix>/<hostname>/.
+type Consul struct {
+	addrs   []string
+	service string
+	prefix  string
+	// deregisterAfter removes instances critical for longer, 0 keeps them
+	// until they are deregistered.
+	deregisterAfter time.Duration
+	client          *http.Client
+
+	// registered is set once the instance of this agent is registered
+	registered bool
+	lock       sync.Mutex
+}
+
+// NewConsul returns the discovery of the Consul servers at addrs, they are
+// tried in order. The agents register as instances of service and the
+// overrides are kept below prefix.
+func NewConsul(addrs []string, service, prefix string, deregisterAfter time.Duration) (*Consul, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no consul address")
+	}
+	if service == "" || strings.Contains(service, "/") {
+		return nil, fmt.Errorf("invalid consul service %q", service)
+	}
+	c := &Consul{
+		service:         service,
+		prefix:          strings.Trim(prefix, "/"),
+		deregisterAfter: deregisterAfter,
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		c.addrs = append(c.addrs, strings.TrimRight(addr, "/"))
+	}
+	return c, nil
+}
+
+// serviceRegistration is the body of /v1/agent/service/register.
+type serviceRegistration struct {
+	ID      string            `json:"ID"`
+	Name    string            `json:"Name"`
+	Address string            `json:"Address"`
+	Port    int               `json:"Port"`
+	Meta    map[string]string `json:"Meta"`
+	Check   serviceCheck      `json:"Check"`
+}
+
+type serviceCheck struct {
+	CheckID                        string `json:"CheckID"`
+	Name                           string `json:"Name"`
+	TTL                            string `json:"TTL"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter,omitempty"`
+}
+
+// healthEntry is an entry of /v1/health/service/:service.
+type healthEntry struct {
+	Service struct {
+		ID      string            `json:"ID"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Meta    map[string]string `json:"Meta"`
+	} `json:"Service"`
+	Checks []struct {
+		CheckID string `json:"CheckID"`
+		Status  string `json:"Status"`
+		Output  string `json:"Output"`
+	} `json:"Checks"`
+}
+
+// catalogEntry is an entry of /v1/catalog/service/:service, Address is the
+// address of the node.
+type catalogEntry struct {
+	Node      string `json:"Node"`
+	Address   string `json:"Address"`
+	ServiceID string `json:"ServiceID"`
+}
+
+// kvEntry is an entry of /v1/kv/:key, Value is base64 encoded in JSON.
+type kvEntry struct {
+	Key   string `json:"Key"`
+	Value []byte `json:"Value"`
+}
+
+// Heartbeat registers the instance with the local Consul agent on the first
+// call and passes its TTL check, the time of the heartbeat is the check
+// output. The instance is registered again after a failed heartbeat, the
+// Consul agent may have been restarted without it.
+func (c *Consul) Heartbeat(inst Instance, ttl time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	id := c.serviceID(inst.Hostname)
+	if !c.registered {
+		meta := map[string]string{metaHostname: inst.Hostname}
+		for k, v := range inst.Meta {
+			meta[k] = v
+		}
+		reg := serviceRegistration{
+			ID:      id,
+			Name:    c.service,
+			Address: inst.Address,
+			Port:    inst.Port,
+			Meta:    meta,
+			Check: serviceCheck{
+				CheckID: checkID(id),
+				Name:    "agent heartbeat",
+				TTL:     ttl.String(),
+			},
+		}
+		if c.deregisterAfter > 0 {
+			reg.Check.DeregisterCriticalServiceAfter = c.deregisterAfter.String()
+		}
+		if err := c.do(http.MethodPut, "/v1/agent/service/register", reg, nil); err != nil {
+			return fmt.Errorf("register service %s failed: %v", id, err)
+		}
+		c.registered = true
+	}
+	note := url.QueryEscape(inst.SentAt.UTC().Format(time.RFC3339))
+	err := c.do(http.MethodPut, "/v1/agent/check/pass/"+url.PathEscape(checkID(id))+"?note="+note, nil, nil)
+	if err != nil {
+		c.registered = false
+		return fmt.Errorf("pass check of %s failed: %v", id, err)
+	}
+	return nil
+}
+
+// Instances returns the instances of the service, an instance is healthy if
+// all its checks and the checks of its node pass.
+func (c *Consul) Instances() ([]Instance, error) {
+	var entries []healthEntry
+	if err := c.do(http.MethodGet, "/v1/health/service/"+url.PathEscape(c.service), nil, &entries); err != nil {
+		return nil, fmt.Errorf("list service %s failed: %v", c.service, err)
+	}
+	instances := make([]Instance, 0, len(entries))
+	for _, e := range entries {
+		hostname := e.Service.Meta[metaHostname]
+		if hostname == "" {
+			continue
+		}
+		inst := Instance{
+			Hostname: hostname,
+			Address:  e.Service.Address,
+			Port:     e.Service.Port,
+			Meta:     make(map[string]string),
+			Healthy:  true,
+		}
+		for k, v := range e.Service.Meta {
+			if k != metaHostname {
+				inst.Meta[k] = v
+			}
+		}
+		for _, check := range e.Checks {
+			if check.Status != "passing" {
+				inst.Healthy = false
+			}
+			if check.CheckID == checkID(e.Service.ID) {
+				inst.SentAt, _ = time.Parse(time.RFC3339, strings.TrimSpace(check.Output))
+			}
+		}
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+// Deregister removes the instance through the Consul agent of the node it is
+// registered on, a catalog deregistration would be restored by the agent's
+// anti-entropy sync. It is not an error if the instance is not registered.
+// The agent of the node is expected at the port of the Consul servers.
+func (c *Consul) Deregister(hostname string) error {
+	id := c.serviceID(hostname)
+	var entries []catalogEntry
+	if err := c.do(http.MethodGet, "/v1/catalog/service/"+url.PathEscape(c.service), nil, &entries); err != nil {
+		return fmt.Errorf("list service %s failed: %v", c.service, err)
+	}
+	for _, e := range entries {
+		if e.ServiceID != id {
+			continue
+		}
+		agent, err := c.agentAddr(e.Address)
+		if err != nil {
+			return fmt.Errorf("deregister service %s from node %s failed: %v", id, e.Node, err)
+		}
+		if err = c.send(agent, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(id), nil, nil); err != nil {
+			return fmt.Errorf("deregister service %s from node %s failed: %v", id, e.Node, err)
+		}
+	}
+	return nil
+}
+
+// agentAddr returns the base URL of the Consul agent on the node at address,
+// with the scheme and port of the first Consul server.
+func (c *Consul) agentAddr(address string) (string, error) {
+	if address == "" {
+		return "", fmt.Errorf("node has no address")
+	}
+	u, err := url.Parse(c.addrs[0])
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "8500"
+	}
+	u.Host = net.JoinHostPort(address, port)
+	return u.String(), nil
+}
+
+// Overrides returns the KV keys below <prefix>/<hostname>/.
+func (c *Consul) Overrides(hostname string) (map[string]string, error) {
+	base := c.overridePath(hostname, "")
+	var entries []kvEntry
+	err := c.do(http.MethodGet, "/v1/kv/"+base+"?recurse=true", nil, &entries)
+	if err != nil && err != errKeyNotFound {
+		return nil, fmt.Errorf("read overrides of %s failed: %v", hostname, err)
+	}
+	overrides := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if key := strings.TrimPrefix(e.Key, base); key != "" && !strings.HasSuffix(key, "/") {
+			overrides[key] = string(e.Value)
+		}
+	}
+	return overrides, nil
+}
+
+// SetOverride sets an override of the host.
+func (c *Consul) SetOverride(hostname, key, value string) error {
+	var ok bool
+	if err := c.do(http.MethodPut, "/v1/kv/"+c.overridePath(hostname, key), strings.NewReader(value), &ok); err != nil {
+		return fmt.Errorf("set override %s of %s failed: %v", key, hostname, err)
+	}
+	if !ok {
+		return fmt.Errorf("set override %s of %s failed", key, hostname)
+	}
+	return nil
+}
+
+// DeleteOverride deletes an override of the host.
+func (c *Consul) DeleteOverride(hostname, key string) error {
+	if err := c.do(http.MethodDelete, "/v1/kv/"+c.overridePath(hostname, key), nil, nil); err != nil {
+		return fmt.Errorf("delete override %s of %s failed: %v", key, hostname, err)
+	}
+	return nil
+}
+
+func (c *Consul) serviceID(hostname string) string {
+	return c.service + "-" + hostname
+}
+
+func (c *Consul) overridePath(hostname, key string) string {
+	p := url.PathEscape(hostname) + "/" + url.PathEscape(key)
+	if c.prefix != "" {
+		p = c.prefix + "/" + p
+	}
+	return p
+}
+
+func checkID(serviceID string) string {
+	return "service:" + serviceID
+}
+
+// do sends the request to the first Consul server that answers. A body of
+// type io.Reader is sent as is, other bodies as JSON, and the JSON response
+// is decoded into out if it is not nil.
+func (c *Consul) do(method, p string, body, out interface{}) error {
+	var payload []byte
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		var err error
+		if payload, err = ioutil.ReadAll(b); err != nil {
+			return err
+		}
+	default:
+		var err error
+		if payload, err = json.Marshal(b); err != nil {
+			return err
+		}
+	}
+	var lastErr error
+	for _, addr := range c.addrs {
+		err := c.send(addr, method, p, payload, out)
+		if _, ok := err.(unreachable); ok {
+			// try the next server
+			lastErr = err
+			continue
+		}
+		return err
+	}
+	return lastErr
+}
+
+// unreachable is the error of a Consul server that did not answer.
+type unreachable struct{ error }
+
+// send sends the request with the encoded body to the Consul server or agent
+// at addr and decodes the JSON response into out if it is not nil.
+func (c *Consul) send(addr, method, p string, payload []byte, out interface{}) error {
+	req, err := http.NewRequest(method, addr+p, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return unreachable{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && strings.HasPrefix(p, "/v1/kv/") {
+		return errKeyNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, p, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,258 @@
+package discovery
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul is an in-memory stand-in for the Consul HTTP API with the
+// endpoints used by the discovery. All services run on the node consul1 at
+// 127.0.0.1, whose agent is the fake itself.
+type fakeConsul struct {
+	server        *httptest.Server
+	lock          sync.Mutex
+	services      map[string]serviceRegistration
+	checks        map[string]*fakeCheck
+	kv            map[string][]byte
+	registrations int
+}
+
+type fakeCheck struct {
+	status, output string
+}
+
+func newFakeConsul() *fakeConsul {
+	c := &fakeConsul{
+		services: make(map[string]serviceRegistration),
+		checks:   make(map[string]*fakeCheck),
+		kv:       make(map[string][]byte),
+	}
+	c.server = httptest.NewServer(http.HandlerFunc(c.handle))
+	return c
+}
+
+// restart drops the registrations as a restarted Consul agent does.
+func (c *fakeConsul) restart() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.services = make(map[string]serviceRegistration)
+	c.checks = make(map[string]*fakeCheck)
+}
+
+// expire fails the TTL check of the service as if no heartbeat came in time.
+func (c *fakeConsul) expire(serviceID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checks[checkID(serviceID)].status = "critical"
+}
+
+func (c *fakeConsul) handle(w http.ResponseWriter, r *http.Request) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p := r.URL.Path
+	switch {
+	case r.Method == http.MethodPut && p == "/v1/agent/service/register":
+		var reg serviceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.services[reg.ID] = reg
+		c.checks[reg.Check.CheckID] = &fakeCheck{status: "critical"}
+		c.registrations++
+	case r.Method == http.MethodPut && strings.HasPrefix(p, "/v1/agent/check/pass/"):
+		check, ok := c.checks[strings.TrimPrefix(p, "/v1/agent/check/pass/")]
+		if !ok {
+			http.Error(w, "Unknown check", http.StatusNotFound)
+			return
+		}
+		check.status, check.output = "passing", r.URL.Query().Get("note")
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "/v1/health/service/"):
+		var entries []interface{}
+		for _, reg := range c.services {
+			check := c.checks[reg.Check.CheckID]
+			entries = append(entries, map[string]interface{}{
+				"Service": map[string]interface{}{
+					"ID": reg.ID, "Address": reg.Address, "Port": reg.Port, "Meta": reg.Meta,
+				},
+				"Checks": []map[string]string{
+					{"CheckID": "serfHealth", "Status": "passing"},
+					{"CheckID": reg.Check.CheckID, "Status": check.status, "Output": check.output},
+				},
+			})
+		}
+		json.NewEncoder(w).Encode(entries)
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "/v1/catalog/service/"):
+		var entries []catalogEntry
+		for id := range c.services {
+			entries = append(entries, catalogEntry{Node: "consul1", Address: "127.0.0.1", ServiceID: id})
+		}
+		json.NewEncoder(w).Encode(entries)
+	case r.Method == http.MethodPut && strings.HasPrefix(p, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(p, "/v1/agent/service/deregister/")
+		reg, ok := c.services[id]
+		if !ok {
+			http.Error(w, "Unknown service ID", http.StatusNotFound)
+			return
+		}
+		delete(c.checks, reg.Check.CheckID)
+		delete(c.services, id)
+	case strings.HasPrefix(p, "/v1/kv/"):
+		key := strings.TrimPrefix(p, "/v1/kv/")
+		switch r.Method {
+		case http.MethodGet:
+			var entries []kvEntry
+			for k, v := range c.kv {
+				if k == key || (r.URL.Query().Get("recurse") != "" && strings.HasPrefix(k, key)) {
+					entries = append(entries, kvEntry{Key: k, Value: v})
+				}
+			}
+			if len(entries) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(entries)
+		case http.MethodPut:
+			c.kv[key], _ = ioutil.ReadAll(r.Body)
+			w.Write([]byte("true"))
+		case http.MethodDelete:
+			delete(c.kv, key)
+			w.Write([]byte("true"))
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestConsul(t *testing.T, addrs ...string) *Consul {
+	c, err := NewConsul(addrs, "hpc-agent", "/hpc-agent/", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestHeartbeat(t *testing.T) {
+	fake := newFakeConsul()
+	defer fake.server.Close()
+	c := newTestConsul(t, fake.server.URL)
+
+	sentAt := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+	inst := Instance{
+		Hostname: "node1",
+		Address:  "10.0.0.1",
+		Port:     6380,
+		Meta:     map[string]string{"version": "1.0.0"},
+		SentAt:   sentAt,
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Heartbeat(inst, 30*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reg := fake.services["hpc-agent-node1"]
+	if fake.registrations != 1 || reg.Check.TTL != "30s" || reg.Check.DeregisterCriticalServiceAfter != "1m0s" {
+		t.Errorf("%d registrations of %+v", fake.registrations, reg)
+	}
+
+	instances, err := c.Instances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("unexpected instances %+v", instances)
+	}
+	got := instances[0]
+	if got.Hostname != "node1" || got.Address != "10.0.0.1" || got.Port != 6380 || !got.Healthy ||
+		!got.SentAt.Equal(sentAt) || got.Meta["version"] != "1.0.0" || len(got.Meta) != 1 {
+		t.Errorf("unexpected instance %+v", got)
+	}
+
+	fake.expire("hpc-agent-node1")
+	if instances, err = c.Instances(); err != nil || len(instances) != 1 || instances[0].Healthy {
+		t.Errorf("expired instance is %+v: %v", instances, err)
+	}
+
+	// the instance is registered again once the Consul agent lost it
+	fake.restart()
+	if err = c.Heartbeat(inst, 30*time.Second); err == nil {
+		t.Error("heartbeat of a lost instance succeeded")
+	}
+	if err = c.Heartbeat(inst, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if fake.registrations != 2 {
+		t.Errorf("%d registrations, want 2", fake.registrations)
+	}
+
+	if err = c.Deregister("node1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Deregister("node2"); err != nil {
+		t.Errorf("deregister an unknown instance: %v", err)
+	}
+	if instances, err = c.Instances(); err != nil || len(instances) != 0 {
+		t.Errorf("instances %+v left after deregistration: %v", instances, err)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	fake := newFakeConsul()
+	defer fake.server.Close()
+	c := newTestConsul(t, down.URL, strings.TrimPrefix(fake.server.URL, "http://"))
+
+	if err := c.Heartbeat(Instance{Hostname: "node1", SentAt: time.Now()}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if instances, err := c.Instances(); err != nil || len(instances) != 1 {
+		t.Errorf("instances %+v: %v", instances, err)
+	}
+
+	c = newTestConsul(t, down.URL)
+	if _, err := c.Instances(); err == nil {
+		t.Error("list instances without a Consul server succeeded")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	fake := newFakeConsul()
+	defer fake.server.Close()
+	c := newTestConsul(t, fake.server.URL)
+
+	overrides, err := c.Overrides("node1")
+	if err != nil || len(overrides) != 0 {
+		t.Errorf("overrides of a new host are %v: %v", overrides, err)
+	}
+	for key, value := range map[string]string{
+		"agent::HeartBeatInterval": "10",
+		"agent::GCInterval":        "600",
+	} {
+		if err = c.SetOverride("node1", key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = c.SetOverride("node2", "agent::GCInterval", "60"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteOverride("node1", "agent::GCInterval"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.kv["hpc-agent/node1/agent::HeartBeatInterval"]; !ok {
+		t.Errorf("unexpected keys %v", fake.kv)
+	}
+	overrides, err = c.Overrides("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 1 || overrides["agent::HeartBeatInterval"] != "10" {
+		t.Errorf("unexpected overrides %v", overrides)
+	}
+}
@@ -0,0 +1,38 @@
+// Package discovery registers the node agents and lets the server find them.
+// The agents announce themselves with heartbeats, an agent whose heartbeats
+// stop is unhealthy until it is deregistered. Per-node overrides of the agent
+// configuration are kept beside the registrations.
+package discovery
+
+import (
+	"time"
+)
+
+// Instance is an agent as registered with the discovery.
+type Instance struct {
+	Hostname string            `json:"hostname"`
+	Address  string            `json:"address"`
+	Port     int               `json:"port"`
+	Meta     map[string]string `json:"meta"`
+	// SentAt is the time of the latest heartbeat, Healthy is false once the
+	// heartbeats stopped.
+	SentAt  time.Time `json:"sentAt"`
+	Healthy bool      `json:"healthy"`
+}
+
+// Discovery registers the agents and finds them.
+type Discovery interface {
+	// Heartbeat registers the instance if needed and marks it healthy for
+	// ttl, the agents call it every heartbeat interval.
+	Heartbeat(inst Instance, ttl time.Duration) error
+	// Instances returns the registered instances, the unhealthy ones
+	// included.
+	Instances() ([]Instance, error)
+	// Deregister removes the instance of the host.
+	Deregister(hostname string) error
+
+	// Overrides returns the configuration overrides of the host.
+	Overrides(hostname string) (map[string]string, error)
+	SetOverride(hostname, key, value string) error
+	DeleteOverride(hostname, key string) error
+}
@@ -0,0 +1,369 @@
+package harbor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity names of the vulnerability summaries, Harbor reports them as
+// numbers.
+var severities = map[int]string{
+	1: "none",
+	2: "unknown",
+	3: "low",
+	4: "medium",
+	5: "high",
+	6: "critical",
+}
+
+// Client is a client of the Harbor v1 REST API.
+type Client struct {
+	server   string
+	username string
+	password string
+	pageSize int
+	http     *http.Client
+}
+
+// Project is a Harbor project.
+type Project struct {
+	ID           int64             `json:"project_id"`
+	Name         string            `json:"name"`
+	RepoCount    int               `json:"repo_count"`
+	CreationTime time.Time         `json:"creation_time"`
+	Metadata     map[string]string `json:"metadata"`
+}
+
+// Public reports whether the project can be pulled anonymously.
+func (p Project) Public() bool {
+	return p.Metadata["public"] == "true"
+}
+
+// Repository is a repository in a Harbor project, Name includes the project.
+type Repository struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	ProjectID    int64     `json:"project_id"`
+	Description  string    `json:"description"`
+	PullCount    int64     `json:"pull_count"`
+	TagsCount    int64     `json:"tags_count"`
+	CreationTime time.Time `json:"creation_time"`
+	UpdateTime   time.Time `json:"update_time"`
+}
+
+// Tag is an image tag with its vulnerability summary, ScanOverview is nil if
+// the image has not been scanned.
+type Tag struct {
+	Name          string        `json:"name"`
+	Digest        string        `json:"digest"`
+	Size          int64         `json:"size"`
+	Architecture  string        `json:"architecture"`
+	OS            string        `json:"os"`
+	Created       time.Time     `json:"created"`
+	ScanOverview  *ScanOverview `json:"scan_overview,omitempty"`
+	Vulnerability *VulnSummary  `json:"vulnerability,omitempty"`
+}
+
+// ScanOverview is the raw scan result of an image.
+type ScanOverview struct {
+	Status     string `json:"scan_status"`
+	Severity   int    `json:"severity"`
+	Components struct {
+		Total   int `json:"total"`
+		Summary []struct {
+			Severity int `json:"severity"`
+			Count    int `json:"count"`
+		} `json:"summary"`
+	} `json:"components"`
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// Robot is a robot account of a project, Token is only returned on
+// creation. Harbor prefixes the names with robot$.
+type Robot struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Token     string `json:"token,omitempty"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// RobotAccess is a permission of a robot account, e.g. push to
+// /project/2/repository.
+type RobotAccess struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+}
+
+// VulnSummary counts the vulnerable components of an image by severity name.
+type VulnSummary struct {
+	Status   string         `json:"status"`
+	Severity string         `json:"severity"`
+	Total    int            `json:"total"`
+	Counts   map[string]int `json:"counts"`
+}
+
+// Summary converts the raw scan overview.
+func (o *ScanOverview) Summary() *VulnSummary {
+	s := &VulnSummary{
+		Status:   o.Status,
+		Severity: severityName(o.Severity),
+		Total:    o.Components.Total,
+		Counts:   make(map[string]int, len(o.Components.Summary)),
+	}
+	for _, c := range o.Components.Summary {
+		s.Counts[severityName(c.Severity)] += c.Count
+	}
+	return s
+}
+
+func severityName(n int) string {
+	if name, ok := severities[n]; ok {
+		return name
+	}
+	return severities[2]
+}
+
+// APIError is returned for non-2xx responses.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("harbor responded %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 response.
+func IsNotFound(err error) bool {
+	e, ok := err.(*APIError)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// NewClient returns a client of the Harbor server, requests are authenticated
+// with basic auth. List calls page through the results pageSize at a time.
+func NewClient(server, username, password string, pageSize int) *Client {
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	return &Client{
+		server:   strings.TrimRight(server, "/"),
+		username: username,
+		password: password,
+		pageSize: pageSize,
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// ListProjects returns the projects visible to the client account, name
+// filters the projects by a substring.
+func (c *Client) ListProjects(name string) ([]Project, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+	var projects []Project
+	err := c.list("/api/projects", query, func(page []byte) (int, error) {
+		var items []Project
+		if err := json.Unmarshal(page, &items); err != nil {
+			return 0, err
+		}
+		projects = append(projects, items...)
+		return len(items), nil
+	})
+	return projects, err
+}
+
+// GetProject returns the project by name.
+func (c *Client) GetProject(name string) (*Project, error) {
+	projects, err := c.ListProjects(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range projects {
+		if p.Name == name {
+			return &p, nil
+		}
+	}
+	return nil, &APIError{StatusCode: http.StatusNotFound, Message: "project " + name + " not found"}
+}
+
+// ListRepositories returns the repositories of the project.
+func (c *Client) ListRepositories(projectID int64) ([]Repository, error) {
+	query := url.Values{"project_id": {strconv.FormatInt(projectID, 10)}}
+	var repos []Repository
+	err := c.list("/api/repositories", query, func(page []byte) (int, error) {
+		var items []Repository
+		if err := json.Unmarshal(page, &items); err != nil {
+			return 0, err
+		}
+		repos = append(repos, items...)
+		return len(items), nil
+	})
+	return repos, err
+}
+
+// ListTags returns the tags of a repository with their vulnerability
+// summaries, repo is the full name including the project.
+func (c *Client) ListTags(repo string) ([]Tag, error) {
+	var tags []Tag
+	if err := c.get("/api/repositories/"+escapeRepo(repo)+"/tags", nil, &tags); err != nil {
+		return nil, err
+	}
+	for i := range tags {
+		if tags[i].ScanOverview != nil {
+			tags[i].Vulnerability = tags[i].ScanOverview.Summary()
+		}
+	}
+	return tags, nil
+}
+
+// GetTag returns a tag of a repository, repo is the full name including the
+// project.
+func (c *Client) GetTag(repo, tag string) (*Tag, error) {
+	var t Tag
+	path := "/api/repositories/" + escapeRepo(repo) + "/tags/" + url.PathEscape(tag)
+	if err := c.get(path, nil, &t); err != nil {
+		return nil, err
+	}
+	if t.ScanOverview != nil {
+		t.Vulnerability = t.ScanOverview.Summary()
+	}
+	return &t, nil
+}
+
+// DeleteTag deletes a tag of a repository, the image is removed from the
+// registry by the next garbage collection.
+func (c *Client) DeleteTag(repo, tag string) error {
+	_, err := c.do(http.MethodDelete, "/api/repositories/"+escapeRepo(repo)+"/tags/"+url.PathEscape(tag), nil)
+	return err
+}
+
+// CreateRobot creates a robot account of the project that expires at
+// expiresAt, the returned robot carries its token.
+func (c *Client) CreateRobot(projectID int64, name, description string, expiresAt time.Time,
+	access []RobotAccess) (*Robot, error) {
+	req := map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"expires_at":  expiresAt.Unix(),
+		"access":      access,
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	path := "/api/projects/" + strconv.FormatInt(projectID, 10) + "/robots"
+	resp, err := c.send(http.MethodPost, path, nil, body)
+	if err != nil {
+		return nil, err
+	}
+	var robot Robot
+	if err = json.Unmarshal(resp, &robot); err != nil {
+		return nil, fmt.Errorf("decode %s failed: %v", path, err)
+	}
+	return &robot, nil
+}
+
+// ListRobots returns the robot accounts of the project.
+func (c *Client) ListRobots(projectID int64) ([]Robot, error) {
+	var robots []Robot
+	err := c.get("/api/projects/"+strconv.FormatInt(projectID, 10)+"/robots", nil, &robots)
+	return robots, err
+}
+
+// DeleteRobot deletes a robot account of the project.
+func (c *Client) DeleteRobot(projectID, robotID int64) error {
+	path := "/api/projects/" + strconv.FormatInt(projectID, 10) + "/robots/" + strconv.FormatInt(robotID, 10)
+	_, err := c.do(http.MethodDelete, path, nil)
+	return err
+}
+
+// list fetches path page by page, add decodes a page and returns the number
+// of items in it. Paging stops at the first short page.
+func (c *Client) list(path string, query url.Values, add func(page []byte) (int, error)) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("page_size", strconv.Itoa(c.pageSize))
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		body, err := c.do(http.MethodGet, path, query)
+		if err != nil {
+			return err
+		}
+		n, err := add(body)
+		if err != nil {
+			return fmt.Errorf("decode %s failed: %v", path, err)
+		}
+		if n < c.pageSize {
+			return nil
+		}
+	}
+}
+
+func (c *Client) get(path string, query url.Values, v interface{}) error {
+	body, err := c.do(http.MethodGet, path, query)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("decode %s failed: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Client) do(method, path string, query url.Values) ([]byte, error) {
+	return c.send(method, path, query, nil)
+}
+
+// send sends the request with a JSON body, a nil body sends none.
+func (c *Client) send(method, path string, query url.Values, body []byte) ([]byte, error) {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var payload io.Reader
+	if body != nil {
+		payload = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(respBody))}
+	}
+	return respBody, nil
+}
+
+// escapeRepo escapes the segments of a repository name, the slash between
+// project and repository is kept as Harbor expects.
+func escapeRepo(repo string) string {
+	parts := strings.Split(repo, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,238 @@
+package harbor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStandIn returns a Harbor API stand-in with one project "hpc" holding
+// five repositories, only requests authenticated as admin are served.
+func newStandIn(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	var robots []Robot
+	mux.HandleFunc("/api/projects/2/robots", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(robots)
+			return
+		}
+		var req struct {
+			Name   string        `json:"name"`
+			Access []RobotAccess `json:"access"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Access) == 0 {
+			http.Error(w, "invalid robot", http.StatusBadRequest)
+			return
+		}
+		for _, robot := range robots {
+			if robot.Name == robotPrefix+req.Name {
+				http.Error(w, "conflict", http.StatusConflict)
+				return
+			}
+		}
+		robot := Robot{ID: int64(len(robots) + 1), Name: robotPrefix + req.Name}
+		robots = append(robots, robot)
+		robot.Token = "token-" + req.Name
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(robot)
+	})
+	mux.HandleFunc("/api/projects/2/robots/", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseInt(r.URL.Path[len("/api/projects/2/robots/"):], 10, 64)
+		for i, robot := range robots {
+			if robot.ID == id && r.Method == http.MethodDelete {
+				robots = append(robots[:i], robots[i+1:]...)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/api/projects", func(w http.ResponseWriter, r *http.Request) {
+		projects := []map[string]interface{}{
+			{"project_id": 2, "name": "hpc", "repo_count": 5, "metadata": map[string]string{"public": "false"}},
+		}
+		if name := r.URL.Query().Get("name"); name != "" && name != "hpc" {
+			projects = nil
+		}
+		json.NewEncoder(w).Encode(projects)
+	})
+	mux.HandleFunc("/api/repositories", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("project_id") != "2" {
+			json.NewEncoder(w).Encode([]interface{}{})
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+		var repos []map[string]interface{}
+		for i := (page - 1) * size; i < page*size && i < 5; i++ {
+			repos = append(repos, map[string]interface{}{
+				"id": i + 1, "name": fmt.Sprintf("hpc/app%d", i), "project_id": 2,
+			})
+		}
+		json.NewEncoder(w).Encode(repos)
+	})
+	mux.HandleFunc("/api/repositories/hpc/app0/tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"name": "v1", "digest": "sha256:aa", "size": 1024},
+			{"name": "v2", "digest": "sha256:bb", "size": 2048, "scan_overview": {
+				"scan_status": "finished", "severity": 5,
+				"components": {"total": 10, "summary": [
+					{"severity": 1, "count": 7}, {"severity": 3, "count": 2}, {"severity": 5, "count": 1}
+				]}
+			}}
+		]`))
+	})
+	mux.HandleFunc("/api/repositories/hpc/app0/tags/v1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			return
+		}
+		w.Write([]byte(`{"name": "v1", "digest": "sha256:aa", "size": 1024}`))
+	})
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func TestClientBrowse(t *testing.T) {
+	server := newStandIn(t)
+	defer server.Close()
+	c := NewClient(server.URL+"/", "admin", "secret", 2)
+
+	project, err := c.GetProject("hpc")
+	if err != nil {
+		t.Fatalf("get project failed: %v", err)
+	}
+	if project.ID != 2 || project.Public() {
+		t.Errorf("unexpected project %+v", project)
+	}
+	if _, err = c.GetProject("missing"); !IsNotFound(err) {
+		t.Errorf("missing project: got %v, want not found", err)
+	}
+
+	repos, err := c.ListRepositories(project.ID)
+	if err != nil {
+		t.Fatalf("list repositories failed: %v", err)
+	}
+	if len(repos) != 5 || repos[4].Name != "hpc/app4" {
+		t.Errorf("paging returned %d repositories: %+v", len(repos), repos)
+	}
+
+	tags, err := c.ListTags("hpc/app0")
+	if err != nil {
+		t.Fatalf("list tags failed: %v", err)
+	}
+	if len(tags) != 2 || tags[0].Vulnerability != nil {
+		t.Fatalf("unexpected tags %+v", tags)
+	}
+	v := tags[1].Vulnerability
+	if v == nil || v.Severity != "high" || v.Total != 10 || v.Counts["low"] != 2 || v.Counts["high"] != 1 {
+		t.Errorf("unexpected vulnerability summary %+v", v)
+	}
+	if _, err = c.ListTags("hpc/missing"); !IsNotFound(err) {
+		t.Errorf("missing repository: got %v, want not found", err)
+	}
+
+	tag, err := c.GetTag("hpc/app0", "v1")
+	if err != nil || tag.Size != 1024 {
+		t.Errorf("get tag = %+v, %v", tag, err)
+	}
+	if _, err = c.GetTag("hpc/app0", "v3"); !IsNotFound(err) {
+		t.Errorf("missing tag: got %v, want not found", err)
+	}
+	if err = c.DeleteTag("hpc/app0", "v1"); err != nil {
+		t.Errorf("delete tag failed: %v", err)
+	}
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	server := newStandIn(t)
+	defer server.Close()
+	_, err := NewClient(server.URL, "admin", "wrong", 10).ListProjects("")
+	if e, ok := err.(*APIError); !ok || e.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got %v, want 401", err)
+	}
+}
+
+func TestDockerConfigJSON(t *testing.T) {
+	var config struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(dockerConfigJSON("harbor.hpc.com", "robot", "pw"), &config); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := config.Auths["harbor.hpc.com"]
+	if !ok || entry.Username != "robot" {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if auth, _ := base64.StdEncoding.DecodeString(entry.Auth); string(auth) != "robot:pw" {
+		t.Errorf("auth = %q, want robot:pw", auth)
+	}
+}
+
+func TestPullConfigJSON(t *testing.T) {
+	m := &Manager{client: NewClient("https://harbor.hpc.com", "admin", "secret", 10), registry: "harbor.hpc.com"}
+	if config := string(m.PullConfigJSON()); config != `{"auths":{}}` {
+		t.Errorf("pull config without a pull account is %s", config)
+	}
+	m.pullUsername, m.pullPassword = "robot$pull", "token"
+	if config := string(m.PullConfigJSON()); !strings.Contains(config, `"username":"robot$pull"`) {
+		t.Errorf("pull config %s", config)
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	for server, want := range map[string]string{
+		"https://Harbor.HPC.com":       "harbor.hpc.com",
+		"https://harbor.hpc.com:443/":  "harbor.hpc.com",
+		"https://harbor.hpc.com:8443":  "harbor.hpc.com:8443",
+		"http://10.0.0.5:5000/harbor/": "10.0.0.5:5000",
+	} {
+		if got, err := registryHost(server); err != nil || got != want {
+			t.Errorf("registryHost(%q) = %q, %v, want %q", server, got, err, want)
+		}
+	}
+	if _, err := registryHost("harbor.hpc.com"); err == nil {
+		t.Error("server without a scheme accepted")
+	}
+}
+
+func TestPushAccount(t *testing.T) {
+	server := newStandIn(t)
+	defer server.Close()
+	m := &Manager{client: NewClient(server.URL, "admin", "secret", 10), registry: "harbor.hpc.com"}
+
+	// a second account of the same name replaces the first
+	for i := 0; i < 2; i++ {
+		config, err := m.CreatePushAccount("image-build-1", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(config), `"username":"robot$image-build-1"`) {
+			t.Errorf("push config %s", config)
+		}
+	}
+	if robots, _ := m.client.ListRobots(2); len(robots) != 1 {
+		t.Errorf("robots %+v, want one", robots)
+	}
+	if err := m.DeletePushAccount("image-build-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeletePushAccount("image-build-1"); err != nil {
+		t.Errorf("delete a missing account: %v", err)
+	}
+	if robots, _ := m.client.ListRobots(2); len(robots) != 0 {
+		t.Errorf("robots %+v left", robots)
+	}
+}
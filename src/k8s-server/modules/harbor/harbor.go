@@ -0,0 +1,288 @@
+// Package harbor browses the images of the Harbor registry and keeps an image
+// pull secret for it in every namespace.
+package harbor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// LabelManaged marks the pull secrets created by the sync, secrets without it
+// are never overwritten.
+const LabelManaged = "k8s-server/managed-by"
+
+// robotPrefix is prepended by Harbor to the names of robot accounts.
+const robotPrefix = "robot$"
+
+// Manager represents the Harbor registry manager.
+type Manager struct {
+	client *Client
+
+	registry     string
+	secretName   string
+	pullUsername string
+	pullPassword string
+	excluded     map[string]bool
+}
+
+// NewManager returns a manager of the configured Harbor server, all calls
+// fail if backend::HarborServer is not set. The images are pulled from the
+// host of the server URL, the pull secrets are keyed on it.
+func NewManager() (*Manager, error) {
+	m := &Manager{
+		registry:     conf.DockerRegistry(),
+		secretName:   conf.HarborPullSecretName(),
+		pullUsername: conf.HarborPullUserName(),
+		pullPassword: conf.HarborPullPassword(),
+		excluded:     make(map[string]bool),
+	}
+	for _, ns := range conf.HarborSecretExcludeNamespaces() {
+		m.excluded[ns] = true
+	}
+	if server := conf.HarborServer(); server != "" {
+		pageSize, err := strconv.Atoi(conf.HarborDefaultPageSize())
+		if err != nil {
+			return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid HarborDefaultPageSize")
+		}
+		if m.registry, err = registryHost(server); err != nil {
+			return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid HarborServer")
+		}
+		m.client = NewClient(server, conf.HarborUserName(), conf.HarborPassword(), pageSize)
+	}
+	return m, nil
+}
+
+// registryHost returns the registry host of the Harbor server URL as image
+// references name it, the default HTTPS port is left out.
+func registryHost(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("no host in %q", server)
+	}
+	host := strings.ToLower(u.Host)
+	if u.Scheme == "https" {
+		host = strings.TrimSuffix(host, ":443")
+	}
+	return host, nil
+}
+
+// Enabled reports whether a Harbor server is configured.
+func (m *Manager) Enabled() bool {
+	return m.client != nil
+}
+
+// ListProjects returns the projects visible to the configured account.
+func (m *Manager) ListProjects() ([]Project, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	projects, err := m.client.ListProjects("")
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrHarborRequest, "list projects failed")
+	}
+	return projects, nil
+}
+
+// ListRepositories returns the repositories of the project, an empty project
+// means the default backend::HarborProject.
+func (m *Manager) ListRepositories(project string) ([]Repository, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	if project == "" {
+		project = conf.HarborProject()
+	}
+	p, err := m.client.GetProject(project)
+	if IsNotFound(err) {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "project %s not found", project)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrHarborRequest, "get project failed")
+	}
+	repos, err := m.client.ListRepositories(p.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrHarborRequest, "list repositories failed")
+	}
+	return repos, nil
+}
+
+// ListTags returns the tags of a repository with their vulnerability
+// summaries.
+func (m *Manager) ListTags(repo string) ([]Tag, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	tags, err := m.client.ListTags(repo)
+	if IsNotFound(err) {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "repository %s not found", repo)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrHarborRequest, "list tags failed")
+	}
+	return tags, nil
+}
+
+// GetTag returns a tag of a repository.
+func (m *Manager) GetTag(repo, tag string) (*Tag, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	t, err := m.client.GetTag(repo, tag)
+	if IsNotFound(err) {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "image %s:%s not found", repo, tag)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrHarborRequest, "get tag failed")
+	}
+	return t, nil
+}
+
+// DeleteTag deletes a tag of a repository, a missing tag is not an error.
+func (m *Manager) DeleteTag(repo, tag string) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	if err := m.client.DeleteTag(repo, tag); err != nil && !IsNotFound(err) {
+		return errors.Wrap(err, def.ErrHarborRequest, "delete tag failed")
+	}
+	return nil
+}
+
+// Registry returns the registry host the Harbor images are pulled from and
+// pushed to.
+func (m *Manager) Registry() string {
+	return m.registry
+}
+
+// PullConfigJSON returns the content of a kubernetes.io/dockerconfigjson
+// secret that can pull from the registry, it holds the pull account of the
+// image pull secrets. Without a pull account it only pulls public images.
+func (m *Manager) PullConfigJSON() []byte {
+	if !m.pullConfigured() {
+		return []byte(`{"auths":{}}`)
+	}
+	return dockerConfigJSON(m.registry, m.pullUsername, m.pullPassword)
+}
+
+// pullConfigured reports whether the pull account is set, the admin account
+// of the client is never handed out as pull account.
+func (m *Manager) pullConfigured() bool {
+	return m.pullUsername != "" && m.pullPassword != ""
+}
+
+// CreatePushAccount creates a robot account named name that can push to the
+// backend::HarborProject until ttl passed and returns the content of a
+// kubernetes.io/dockerconfigjson secret holding it. An account of the same
+// name is replaced. Harbor scopes robot accounts to a whole project, the
+// account must only be handed to code pushing a fixed image.
+func (m *Manager) CreatePushAccount(name string, ttl time.Duration) ([]byte, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	project, err := m.client.GetProject(conf.HarborProject())
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrHarborRequest, "get project failed")
+	}
+	if err = m.deleteRobot(project.ID, name); err != nil {
+		return nil, err
+	}
+	resource := "/project/" + strconv.FormatInt(project.ID, 10) + "/repository"
+	robot, err := m.client.CreateRobot(project.ID, name, "push account of "+name, time.Now().Add(ttl),
+		[]RobotAccess{{Resource: resource, Action: "push"}, {Resource: resource, Action: "pull"}})
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrHarborRequest, "create robot account failed")
+	}
+	return dockerConfigJSON(m.registry, robot.Name, robot.Token), nil
+}
+
+// DeletePushAccount deletes the robot account of CreatePushAccount, a missing
+// account is not an error.
+func (m *Manager) DeletePushAccount(name string) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	project, err := m.client.GetProject(conf.HarborProject())
+	if err != nil {
+		return errors.Wrap(err, def.ErrHarborRequest, "get project failed")
+	}
+	return m.deleteRobot(project.ID, name)
+}
+
+func (m *Manager) deleteRobot(projectID int64, name string) error {
+	robots, err := m.client.ListRobots(projectID)
+	if err != nil {
+		return errors.Wrap(err, def.ErrHarborRequest, "list robot accounts failed")
+	}
+	for _, r := range robots {
+		if r.Name != robotPrefix+name {
+			continue
+		}
+		if err = m.client.DeleteRobot(projectID, r.ID); err != nil && !IsNotFound(err) {
+			return errors.Wrap(err, def.ErrHarborRequest, "delete robot account failed")
+		}
+	}
+	return nil
+}
+
+// Run syncs the image pull secrets every backend::HarborSecretSyncInterval
+// until stop is closed. Nothing is synced without a pull account.
+func (m *Manager) Run(stop <-chan struct{}) {
+	interval := conf.HarborSecretSyncInterval()
+	if !m.Enabled() || interval <= 0 {
+		return
+	}
+	if !m.pullConfigured() {
+		logs.Warn("image pull secret sync disabled, backend::HarborPullUserName and HarborPullPassword are not set")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.SyncPullSecrets(); err != nil {
+			logs.Error("sync image pull secrets failed: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) check() error {
+	if !m.Enabled() {
+		return errors.New(def.ErrHarborNotConfigured, "harbor server is not configured")
+	}
+	return nil
+}
+
+// dockerConfigJSON returns the content of a kubernetes.io/dockerconfigjson
+// secret for the registry.
+func dockerConfigJSON(registry, username, password string) []byte {
+	type entry struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	}
+	config := map[string]map[string]entry{
+		"auths": {
+			registry: {
+				Username: username,
+				Password: password,
+				Auth:     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+			},
+		},
+	}
+	b, _ := json.Marshal(config)
+	return b
+}
@@ -0,0 +1,102 @@
+package harbor
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s-server/def"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/kube"
+	"k8s-server/utils/logs"
+)
+
+// SyncPullSecrets makes sure every namespace, except the excluded ones, has
+// the image pull secret of the registry and that its default service account
+// uses it. A failed namespace does not stop the others. The sync is skipped
+// without a pull account.
+func (m *Manager) SyncPullSecrets() error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	if !m.pullConfigured() {
+		logs.Warn("skip image pull secret sync, backend::HarborPullUserName and HarborPullPassword are not set")
+		return nil
+	}
+	cs, err := kube.Clientset()
+	if err != nil {
+		return errors.Wrap(err, def.ErrHarborSecretSync, "connect kubernetes failed")
+	}
+	namespaces, err := cs.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, def.ErrHarborSecretSync, "list namespaces failed")
+	}
+	data := dockerConfigJSON(m.registry, m.pullUsername, m.pullPassword)
+	var failed []string
+	for _, ns := range namespaces.Items {
+		if m.excluded[ns.Name] || ns.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		if err = m.syncSecret(cs, ns.Name, data); err != nil {
+			logs.Warn("sync image pull secret into %s failed: %v", ns.Name, err)
+			failed = append(failed, ns.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf(def.ErrHarborSecretSync, "sync image pull secret failed in %s",
+			strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (m *Manager) syncSecret(cs kubernetes.Interface, namespace string, data []byte) error {
+	secrets := cs.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(m.secretName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.secretName,
+				Namespace: namespace,
+				Labels:    map[string]string{LabelManaged: "k8s-server"},
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: data},
+		}
+		if _, err = secrets.Create(secret); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case secret.Labels[LabelManaged] == "":
+		return fmt.Errorf("secret %s exists and is not managed by k8s-server", m.secretName)
+	case !bytes.Equal(secret.Data[corev1.DockerConfigJsonKey], data):
+		secret.Data = map[string][]byte{corev1.DockerConfigJsonKey: data}
+		if _, err = secrets.Update(secret); err != nil {
+			return err
+		}
+	}
+
+	accounts := cs.CoreV1().ServiceAccounts(namespace)
+	sa, err := accounts.Get("default", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// the service account controller has not created it yet, the next
+		// sync picks it up
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, ref := range sa.ImagePullSecrets {
+		if ref.Name == m.secretName {
+			return nil
+		}
+	}
+	sa.ImagePullSecrets = append(sa.ImagePullSecrets, corev1.LocalObjectReference{Name: m.secretName})
+	_, err = accounts.Update(sa)
+	return err
+}
@@ -0,0 +1,468 @@
+// Package imagebuild builds the custom images of the users. The Dockerfile and
+// context are uploaded to backend::CustomImagePath, which must be on a shared
+// filesystem mounted at the same path on every node, built by a Kubernetes Job
+// running a rootless builder and pushed to the user's repository in Harbor
+// with a robot account of the build.
+// The images of a user are limited by backend::ImageMaxNumber and
+// backend::ImageMaxSpace.
+package imagebuild
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models"
+	"k8s-server/models/types"
+	"k8s-server/modules/harbor"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// LabelBuildID labels the Jobs and pods of the builds.
+const LabelBuildID = "build.k8s-server/build-id"
+
+var (
+	// reName is a repository path component
+	reName = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+	reTag  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// errBuildNotFound is returned by a builder when the Job does not exist.
+var errBuildNotFound = fmt.Errorf("build job not found")
+
+// Observation is the state of a build read from its Job and pod.
+type Observation struct {
+	State      string
+	Message    string
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+// buildTemplate is the Job of a build.
+type buildTemplate struct {
+	Namespace string
+	Name      string
+	Labels    map[string]string
+	// Image is the reference the image is pushed to.
+	Image string
+	// ContextDir holds the Dockerfile and the context archive ContextFile,
+	// ContextFile is empty for a build without context.
+	ContextDir  string
+	ContextFile string
+	// PullConfig is given to the build for the base images, PushConfig only
+	// to the push of the built image, which runs no code of the user.
+	PullConfig []byte
+	PushConfig []byte
+	Deadline   time.Duration
+}
+
+// builder runs the build Jobs.
+type builder interface {
+	Create(t *buildTemplate) error
+	Observe(namespace, name string) (Observation, error)
+	// Delete deletes the Job and its pod, an already deleted Job is not an
+	// error.
+	Delete(namespace, name string) error
+	// Logs returns the output of the builder, follow streams it until the
+	// build finishes.
+	Logs(namespace, name string, follow bool) (io.ReadCloser, error)
+}
+
+// registry is the Harbor registry the images are pushed to.
+type registry interface {
+	Enabled() bool
+	Registry() string
+	PullConfigJSON() []byte
+	// CreatePushAccount returns the dockerconfigjson of a new account that
+	// can push until ttl passed.
+	CreatePushAccount(name string, ttl time.Duration) ([]byte, error)
+	DeletePushAccount(name string) error
+	GetTag(repo, tag string) (*harbor.Tag, error)
+	DeleteTag(repo, tag string) error
+}
+
+// Manager represents the custom image build manager.
+type Manager struct {
+	store     models.ImageBuildStore
+	registry  registry
+	builder   builder
+	dir       string
+	namespace string
+	now       func() time.Time
+	// unshared disables the builds if dir is not shared with the nodes
+	unshared error
+	// lock serializes the quota checks and state updates
+	lock sync.Mutex
+}
+
+// BuildRequest is an uploaded build, Context is a tar archive, optionally
+// gzipped, and may be nil.
+type BuildRequest struct {
+	Name       string
+	Tag        string
+	Dockerfile string
+	Context    io.Reader
+}
+
+// Quota is the image quota of a user, the space is in bytes.
+type Quota struct {
+	Images    int   `json:"images"`
+	MaxImages int   `json:"maxImages"`
+	Used      int64 `json:"used"`
+	MaxSpace  int64 `json:"maxSpace"`
+}
+
+// NewManager returns the build manager pushing to the Harbor registry of
+// registry, the build Jobs run in backend::ImageBuildNamespace. Builds are
+// refused if backend::CustomImagePath is not on a shared filesystem.
+func NewManager(store models.ImageBuildStore, registry *harbor.Manager) (*Manager, error) {
+	m := &Manager{
+		store:     store,
+		registry:  registry,
+		builder:   kubeBuilder{},
+		dir:       conf.CustomImagePath(),
+		namespace: conf.ImageBuildNamespace(),
+		now:       time.Now,
+	}
+	if m.unshared = checkShared(m.dir); m.unshared != nil {
+		logs.Error("image builds are disabled: %v", m.unshared)
+	}
+	return m, nil
+}
+
+// Run syncs the build states every JobCollectInterval until stop is closed.
+func (m *Manager) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(conf.JobCollectInterval()) * time.Second)
+	defer ticker.Stop()
+	for {
+		m.Sync()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Submit saves the upload and starts the build. The build counts as an image
+// of the user from now on, a build of an existing name and tag replaces the
+// image.
+func (m *Manager) Submit(owner string, req BuildRequest) (*types.ImageBuild, error) {
+	if req.Tag == "" {
+		req.Tag = "latest"
+	}
+	switch {
+	case !reName.MatchString(owner):
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "username %s can not be a repository name", owner)
+	case !reName.MatchString(req.Name):
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "invalid image name %q", req.Name)
+	case !reTag.MatchString(req.Tag):
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "invalid image tag %q", req.Tag)
+	case req.Dockerfile == "":
+		return nil, errors.New(def.ErrGeneralBadRequest, "the Dockerfile is empty")
+	}
+	if !m.registry.Enabled() {
+		return nil, errors.New(def.ErrHarborNotConfigured, "harbor server is not configured")
+	}
+	if m.unshared != nil {
+		return nil, errors.Wrap(m.unshared, def.ErrBuildSubmit, "image builds are disabled")
+	}
+	registry := m.registry.Registry()
+	repo := conf.HarborProject() + "/" + owner + "/" + req.Name
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	builds, err := m.store.ListImageBuilds(types.ImageBuildFilter{Owner: owner})
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrBuildList, "list image builds failed")
+	}
+	quota := m.quota(builds, repo, req.Tag)
+	for _, b := range builds {
+		if b.Repository == repo && b.Tag == req.Tag && !b.Finished() {
+			return nil, errors.Errorf(def.ErrGeneralConflict, "%s:%s is being built by build %d", req.Name, req.Tag, b.ID)
+		}
+	}
+	if quota.Images >= quota.MaxImages {
+		return nil, errors.Errorf(def.ErrGeneralForbidden, "image quota exceeded, %d of %d images", quota.Images, quota.MaxImages)
+	}
+	if quota.Used >= quota.MaxSpace {
+		return nil, errors.Errorf(def.ErrGeneralForbidden, "image space quota exceeded, %d of %d MB used",
+			quota.Used>>20, quota.MaxSpace>>20)
+	}
+
+	build := &types.ImageBuild{
+		Owner:      owner,
+		Name:       req.Name,
+		Tag:        req.Tag,
+		Repository: repo,
+		Image:      registry + "/" + repo + ":" + req.Tag,
+		State:      types.BuildPending,
+		CreatedAt:  m.now(),
+	}
+	if err = m.store.AddImageBuild(build); err != nil {
+		return nil, errors.Wrap(err, def.ErrBuildSubmit, "save image build failed")
+	}
+	t := &buildTemplate{
+		Namespace:  m.namespace,
+		Name:       JobName(build.ID),
+		Labels:     map[string]string{LabelBuildID: strconv.FormatInt(build.ID, 10)},
+		Image:      build.Image,
+		ContextDir: m.contextDir(build),
+		PullConfig: m.registry.PullConfigJSON(),
+		Deadline:   conf.ImageBuildTimeout(),
+	}
+	t.ContextFile, build.ContextSize, err = saveUpload(t.ContextDir, req.Dockerfile, req.Context, conf.GetUploadLimit()<<20)
+	if err != nil {
+		m.fail(build, err.Error())
+		return nil, err
+	}
+	// the account outlives the deadline by the time the pod may be pending,
+	// it is deleted as soon as the build finishes
+	if t.PushConfig, err = m.registry.CreatePushAccount(t.Name, t.Deadline+time.Hour); err != nil {
+		m.fail(build, err.Error())
+		return nil, errors.Wrap(err, def.ErrBuildSubmit, "create push account failed")
+	}
+	if err = m.builder.Create(t); err != nil {
+		m.fail(build, err.Error())
+		return nil, errors.Wrap(err, def.ErrBuildSubmit, "start image build failed")
+	}
+	if err = m.store.UpdateImageBuild(build); err != nil {
+		logs.Error("update image build %d failed: %v", build.ID, err)
+	}
+	logs.Info("image build %d of %s started by %s", build.ID, build.Image, owner)
+	return build, nil
+}
+
+// List returns the builds matching filter.
+func (m *Manager) List(filter types.ImageBuildFilter) ([]types.ImageBuild, error) {
+	builds, err := m.store.ListImageBuilds(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrBuildList, "list image builds failed")
+	}
+	if builds == nil {
+		builds = []types.ImageBuild{}
+	}
+	return builds, nil
+}
+
+// Get returns a build, read from the cluster if it did not finish.
+func (m *Manager) Get(id int64) (*types.ImageBuild, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	build, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !build.Finished() {
+		if err = m.refresh(build); err != nil {
+			logs.Warn("refresh image build %d failed: %v", id, err)
+		}
+	}
+	return build, nil
+}
+
+// Quota returns the image quota of a user.
+func (m *Manager) Quota(owner string) (*Quota, error) {
+	builds, err := m.store.ListImageBuilds(types.ImageBuildFilter{Owner: owner})
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrBuildList, "list image builds failed")
+	}
+	quota := m.quota(builds, "", "")
+	return &quota, nil
+}
+
+// Logs returns the builder output of a build of owner.
+func (m *Manager) Logs(owner string, id int64, follow bool) (io.ReadCloser, error) {
+	build, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if build.Owner != owner {
+		return nil, errors.Errorf(def.ErrGeneralForbidden, "image build %d belongs to another user", id)
+	}
+	rc, err := m.builder.Logs(m.namespace, JobName(id), follow && !build.Finished())
+	if err == errBuildNotFound {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "the logs of image build %d are gone", id)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralConflict, "read build logs failed")
+	}
+	return rc, nil
+}
+
+// Delete cancels a running build, or deletes a finished build and the image
+// it pushed, which frees its quota.
+func (m *Manager) Delete(owner string, id int64) (*types.ImageBuild, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	build, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if build.Owner != owner {
+		return nil, errors.Errorf(def.ErrGeneralForbidden, "image build %d belongs to another user", id)
+	}
+	if err = m.builder.Delete(m.namespace, JobName(id)); err != nil {
+		return nil, errors.Wrap(err, def.ErrBuildDelete, "delete build job failed")
+	}
+	m.removeContext(build)
+	if !build.Finished() {
+		if err = m.finish(build, types.BuildCancelled, ""); err != nil {
+			return nil, errors.Wrap(err, def.ErrBuildDelete, "update image build failed")
+		}
+		return build, nil
+	}
+	if build.State == types.BuildSucceeded {
+		if err = m.registry.DeleteTag(build.Repository, build.Tag); err != nil {
+			return nil, errors.Wrap(err, def.ErrBuildDelete, "delete image failed")
+		}
+	}
+	if err = m.store.DeleteImageBuild(id); err != nil {
+		return nil, errors.Wrap(err, def.ErrBuildDelete, "delete image build failed")
+	}
+	logs.Info("image build %d of %s deleted by %s", id, build.Image, owner)
+	return build, nil
+}
+
+// Sync reads the state of the builds that did not finish from the cluster.
+func (m *Manager) Sync() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	builds, err := m.store.ListImageBuilds(types.ImageBuildFilter{Active: true})
+	if err != nil {
+		logs.Error("list active image builds failed: %v", err)
+		return
+	}
+	for i := range builds {
+		if err = m.refresh(&builds[i]); err != nil {
+			logs.Warn("refresh image build %d failed: %v", builds[i].ID, err)
+		}
+	}
+}
+
+// JobName returns the name of the Job of a build.
+func JobName(id int64) string {
+	return "image-build-" + strconv.FormatInt(id, 10)
+}
+
+func (m *Manager) get(id int64) (*types.ImageBuild, error) {
+	build, err := m.store.GetImageBuild(id)
+	if err == types.ErrNotFound {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "image build %d not found", id)
+	} else if err != nil {
+		return nil, errors.Wrap(err, def.ErrBuildList, "get image build failed")
+	}
+	return build, nil
+}
+
+// quota counts the images of builds that did not fail, skipping the image
+// repo:tag that is about to be replaced.
+func (m *Manager) quota(builds []types.ImageBuild, repo, tag string) Quota {
+	q := Quota{MaxImages: conf.ImageMaxNumber(), MaxSpace: int64(conf.ImageMaxSpace()) << 20}
+	for _, b := range builds {
+		if b.State == types.BuildFailed || b.State == types.BuildCancelled ||
+			(b.State == types.BuildSucceeded && b.Repository == repo && b.Tag == tag) {
+			continue
+		}
+		q.Images++
+		q.Used += b.ImageSize
+	}
+	return q
+}
+
+// refresh updates the build from its Job. A succeeded build takes over the
+// image of an earlier build of the same tag, and is undone if the pushed
+// image exceeds the space quota.
+func (m *Manager) refresh(build *types.ImageBuild) error {
+	o, err := m.builder.Observe(m.namespace, JobName(build.ID))
+	if err == errBuildNotFound {
+		m.removeContext(build)
+		return m.finish(build, types.BuildCancelled, "the build job was deleted")
+	} else if err != nil {
+		return err
+	}
+	if build.StartedAt == nil {
+		build.StartedAt = o.StartedAt
+	}
+	switch o.State {
+	case types.BuildSucceeded:
+		m.removeContext(build)
+		return m.succeed(build)
+	case types.BuildFailed:
+		m.removeContext(build)
+		return m.finish(build, types.BuildFailed, o.Message)
+	}
+	if o.State == build.State && o.Message == build.Message {
+		return nil
+	}
+	build.State, build.Message = o.State, o.Message
+	return m.store.UpdateImageBuild(build)
+}
+
+func (m *Manager) succeed(build *types.ImageBuild) error {
+	tag, err := m.registry.GetTag(build.Repository, build.Tag)
+	if err != nil {
+		return err
+	}
+	builds, err := m.store.ListImageBuilds(types.ImageBuildFilter{Owner: build.Owner})
+	if err != nil {
+		return err
+	}
+	for _, b := range builds {
+		if b.ID != build.ID && b.State == types.BuildSucceeded && b.Repository == build.Repository && b.Tag == build.Tag {
+			// the push replaced the image of b
+			if err = m.store.DeleteImageBuild(b.ID); err != nil {
+				return err
+			}
+		}
+	}
+	build.ImageSize = tag.Size
+	quota := m.quota(builds, build.Repository, build.Tag)
+	if quota.Used+tag.Size > quota.MaxSpace {
+		build.ImageSize = 0
+		if err = m.registry.DeleteTag(build.Repository, build.Tag); err != nil {
+			return err
+		}
+		return m.finish(build, types.BuildFailed, fmt.Sprintf(
+			"the image of %d MB exceeds the space quota, %d of %d MB used",
+			tag.Size>>20, quota.Used>>20, quota.MaxSpace>>20))
+	}
+	logs.Info("image build %d pushed %s, %d bytes", build.ID, build.Image, tag.Size)
+	return m.finish(build, types.BuildSucceeded, "")
+}
+
+func (m *Manager) finish(build *types.ImageBuild, state, message string) error {
+	finished := m.now()
+	build.State, build.Message, build.FinishedAt = state, message, &finished
+	return m.store.UpdateImageBuild(build)
+}
+
+// fail finishes a build that could not be started.
+func (m *Manager) fail(build *types.ImageBuild, message string) {
+	m.removeContext(build)
+	if err := m.finish(build, types.BuildFailed, message); err != nil {
+		logs.Error("update image build %d failed: %v", build.ID, err)
+	}
+}
+
+func (m *Manager) contextDir(build *types.ImageBuild) string {
+	return filepath.Join(m.dir, build.Owner, strconv.FormatInt(build.ID, 10))
+}
+
+// removeContext removes the uploaded Dockerfile and context and the push
+// account of the build, they are not needed once the build finished.
+func (m *Manager) removeContext(build *types.ImageBuild) {
+	if err := os.RemoveAll(m.contextDir(build)); err != nil {
+		logs.Warn("remove the context of image build %d failed: %v", build.ID, err)
+	}
+	if err := m.registry.DeletePushAccount(JobName(build.ID)); err != nil {
+		logs.Warn("delete the push account of image build %d failed: %v", build.ID, err)
+	}
+}
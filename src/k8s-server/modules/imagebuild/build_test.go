@@ -0,0 +1,344 @@
+package imagebuild
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-server/def"
+	"k8s-server/models/filedb"
+	"k8s-server/models/types"
+	"k8s-server/modules/harbor"
+	"k8s-server/utils/errors"
+)
+
+type fakeBuilder struct {
+	created      map[string]*buildTemplate
+	observations map[string]Observation
+	deleted      []string
+}
+
+func (f *fakeBuilder) Create(t *buildTemplate) error {
+	f.created[t.Name] = t
+	return nil
+}
+
+func (f *fakeBuilder) Observe(namespace, name string) (Observation, error) {
+	if _, ok := f.created[name]; !ok {
+		return Observation{}, errBuildNotFound
+	}
+	if o, ok := f.observations[name]; ok {
+		return o, nil
+	}
+	return Observation{State: types.BuildPending}, nil
+}
+
+func (f *fakeBuilder) Delete(namespace, name string) error {
+	f.deleted = append(f.deleted, name)
+	delete(f.created, name)
+	return nil
+}
+
+func (f *fakeBuilder) Logs(namespace, name string, follow bool) (io.ReadCloser, error) {
+	if _, ok := f.created[name]; !ok {
+		return nil, errBuildNotFound
+	}
+	return ioutil.NopCloser(strings.NewReader("#1 [internal] load build definition from Dockerfile\n")), nil
+}
+
+// fakeRegistry holds the pushed tags and their sizes by repo:tag and the
+// push accounts.
+type fakeRegistry struct {
+	tags     map[string]int64
+	deleted  []string
+	accounts map[string]bool
+}
+
+func (f *fakeRegistry) Enabled() bool          { return true }
+func (f *fakeRegistry) Registry() string       { return "harbor.hpc.com" }
+func (f *fakeRegistry) PullConfigJSON() []byte { return []byte(`{"auths":{"harbor.hpc.com":{}}}`) }
+
+func (f *fakeRegistry) CreatePushAccount(name string, ttl time.Duration) ([]byte, error) {
+	f.accounts[name] = true
+	return []byte(`{"auths":{"harbor.hpc.com":{"username":"robot$` + name + `"}}}`), nil
+}
+
+func (f *fakeRegistry) DeletePushAccount(name string) error {
+	delete(f.accounts, name)
+	return nil
+}
+
+func (f *fakeRegistry) GetTag(repo, tag string) (*harbor.Tag, error) {
+	size, ok := f.tags[repo+":"+tag]
+	if !ok {
+		return nil, errors.Errorf(def.ErrGeneralNotFound, "image %s:%s not found", repo, tag)
+	}
+	return &harbor.Tag{Name: tag, Size: size}, nil
+}
+
+func (f *fakeRegistry) DeleteTag(repo, tag string) error {
+	f.deleted = append(f.deleted, repo+":"+tag)
+	delete(f.tags, repo+":"+tag)
+	return nil
+}
+
+func newTestManager(t *testing.T) (*Manager, *fakeBuilder, *fakeRegistry, func()) {
+	dir, err := ioutil.TempDir("", "imagebuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := filedb.NewModel(filepath.Join(dir, "test.db"), 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	builder := &fakeBuilder{created: make(map[string]*buildTemplate), observations: make(map[string]Observation)}
+	registry := &fakeRegistry{tags: make(map[string]int64), accounts: make(map[string]bool)}
+	m := &Manager{
+		store:     store,
+		registry:  registry,
+		builder:   builder,
+		dir:       filepath.Join(dir, "images"),
+		namespace: "image-build",
+		now:       time.Now,
+	}
+	return m, builder, registry, func() { os.RemoveAll(dir) }
+}
+
+// archive returns a tar archive of files, gzipped if gz is set.
+func archive(t *testing.T, gz bool, files ...string) io.Reader {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if gz {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, name := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(name))
+	}
+	tw.Close()
+	if zw != nil {
+		zw.Close()
+	}
+	return &buf
+}
+
+// push completes the build Job of id, the pushed image has size bytes.
+func push(m *Manager, builder *fakeBuilder, registry *fakeRegistry, id int64, size int64) {
+	b, _ := m.store.GetImageBuild(id)
+	registry.tags[b.Repository+":"+b.Tag] = size
+	builder.observations[JobName(id)] = Observation{State: types.BuildSucceeded}
+	m.Sync()
+}
+
+func TestSubmit(t *testing.T) {
+	m, builder, registry, cleanup := newTestManager(t)
+	defer cleanup()
+
+	build, err := m.Submit("alice", BuildRequest{
+		Name:       "lammps",
+		Tag:        "2019",
+		Dockerfile: "FROM centos:7\nCOPY . /opt/lammps\n",
+		Context:    archive(t, true, "./", "src/main.cpp", "Makefile"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.Image != "harbor.hpc.com/hpc/alice/lammps:2019" || build.State != types.BuildPending || build.ContextSize == 0 {
+		t.Errorf("build = %+v", build)
+	}
+	tmpl := builder.created[JobName(build.ID)]
+	if tmpl == nil || tmpl.ContextFile != contextTarGz || tmpl.Image != build.Image || len(tmpl.PullConfig) == 0 {
+		t.Fatalf("template = %+v", tmpl)
+	}
+	if !strings.Contains(string(tmpl.PushConfig), "robot$"+JobName(build.ID)) || !registry.accounts[JobName(build.ID)] {
+		t.Errorf("push config %s, accounts %v", tmpl.PushConfig, registry.accounts)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(tmpl.ContextDir, dockerfileName)); err != nil || !strings.HasPrefix(string(b), "FROM") {
+		t.Errorf("Dockerfile = %q, %v", b, err)
+	}
+
+	// a build of the same tag is running
+	_, err = m.Submit("alice", BuildRequest{Name: "lammps", Tag: "2019", Dockerfile: "FROM centos:7\n"})
+	if !hasCode(err, def.ErrGeneralConflict) {
+		t.Errorf("concurrent build: %v", err)
+	}
+
+	for i, req := range []BuildRequest{
+		{Name: "LAMMPS", Dockerfile: "FROM centos:7\n"},
+		{Name: "lammps", Tag: "-x", Dockerfile: "FROM centos:7\n"},
+		{Name: "lammps"},
+		{Name: "escape", Dockerfile: "FROM centos:7\n", Context: archive(t, false, "../../etc/passwd")},
+		{Name: "absolute", Dockerfile: "FROM centos:7\n", Context: archive(t, false, "/etc/passwd")},
+		{Name: "garbage", Dockerfile: "FROM centos:7\n", Context: strings.NewReader("not a tar archive at all")},
+	} {
+		if _, err = m.Submit("alice", req); !hasCode(err, def.ErrGeneralBadRequest) {
+			t.Errorf("case %d: %v", i, err)
+		}
+	}
+	// the rejected uploads are not kept
+	builds, _ := m.List(types.ImageBuildFilter{Owner: "alice", Active: true})
+	if len(builds) != 1 {
+		t.Errorf("%d active builds, want 1", len(builds))
+	}
+}
+
+func TestBuildLifecycle(t *testing.T) {
+	m, builder, registry, cleanup := newTestManager(t)
+	defer cleanup()
+
+	build, err := m.Submit("alice", BuildRequest{Name: "gromacs", Dockerfile: "FROM centos:7\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	builder.observations[JobName(build.ID)] = Observation{State: types.BuildRunning, StartedAt: &started}
+	if build, err = m.Get(build.ID); err != nil || build.State != types.BuildRunning || build.StartedAt == nil {
+		t.Fatalf("running build = %+v, %v", build, err)
+	}
+	rc, err := m.Logs("alice", build.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if _, err = m.Logs("bob", build.ID, false); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("logs of another user: %v", err)
+	}
+
+	push(m, builder, registry, build.ID, 200<<20)
+	build, _ = m.Get(build.ID)
+	if build.State != types.BuildSucceeded || build.ImageSize != 200<<20 || build.FinishedAt == nil {
+		t.Errorf("succeeded build = %+v", build)
+	}
+	if _, err = os.Stat(m.contextDir(build)); !os.IsNotExist(err) {
+		t.Errorf("context kept after the build: %v", err)
+	}
+	if registry.accounts[JobName(build.ID)] {
+		t.Error("push account kept after the build")
+	}
+
+	// rebuilding the tag replaces the image
+	rebuild, err := m.Submit("alice", BuildRequest{Name: "gromacs", Dockerfile: "FROM centos:7.6\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	push(m, builder, registry, rebuild.ID, 250<<20)
+	builds, _ := m.List(types.ImageBuildFilter{Owner: "alice"})
+	if len(builds) != 1 || builds[0].ID != rebuild.ID || builds[0].State != types.BuildSucceeded {
+		t.Errorf("builds after the rebuild = %+v", builds)
+	}
+
+	// deleting the image frees its quota
+	if _, err = m.Delete("bob", rebuild.ID); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("delete by another user: %v", err)
+	}
+	if _, err = m.Delete("alice", rebuild.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(registry.deleted) != 1 || registry.deleted[0] != "hpc/alice/gromacs:latest" {
+		t.Errorf("deleted tags = %v", registry.deleted)
+	}
+	if q, _ := m.Quota("alice"); q.Images != 0 || q.Used != 0 {
+		t.Errorf("quota after delete = %+v", q)
+	}
+
+	// cancel a pending build
+	build, _ = m.Submit("alice", BuildRequest{Name: "gromacs", Dockerfile: "FROM centos:7\n"})
+	if build, err = m.Delete("alice", build.ID); err != nil || build.State != types.BuildCancelled {
+		t.Errorf("cancelled build = %+v, %v", build, err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	m, builder, registry, cleanup := newTestManager(t)
+	defer cleanup()
+
+	// backend::ImageMaxNumber and backend::ImageMaxSpace default to 10
+	// images and 500 MB
+	var ids []int64
+	for i := 0; i < 10; i++ {
+		build, err := m.Submit("alice", BuildRequest{Name: "app" + strconv.Itoa(i), Dockerfile: "FROM centos:7\n"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, build.ID)
+	}
+	if _, err := m.Submit("alice", BuildRequest{Name: "app10", Dockerfile: "FROM centos:7\n"}); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("11th image: %v", err)
+	}
+	if _, err := m.Submit("bob", BuildRequest{Name: "app10", Dockerfile: "FROM centos:7\n"}); err != nil {
+		t.Errorf("image of another user: %v", err)
+	}
+	// a failed build does not count
+	builder.observations[JobName(ids[9])] = Observation{State: types.BuildFailed, Message: "the build failed"}
+	m.Sync()
+	if _, err := m.Submit("alice", BuildRequest{Name: "app10", Dockerfile: "FROM centos:7\n"}); err != nil {
+		t.Errorf("image after a failed build: %v", err)
+	}
+
+	push(m, builder, registry, ids[0], 300<<20)
+	// the second image does not fit, it is deleted again
+	push(m, builder, registry, ids[1], 300<<20)
+	build, _ := m.Get(ids[1])
+	if build.State != types.BuildFailed || !strings.Contains(build.Message, "quota") {
+		t.Errorf("build over quota = %+v", build)
+	}
+	if _, ok := registry.tags["hpc/alice/app1:latest"]; ok {
+		t.Error("image over quota kept")
+	}
+	push(m, builder, registry, ids[2], 200<<20)
+	q, _ := m.Quota("alice")
+	if q.Images != 9 || q.Used != 500<<20 || q.MaxSpace != 500<<20 {
+		t.Errorf("quota = %+v", q)
+	}
+	if _, err := m.Submit("alice", BuildRequest{Name: "app1", Dockerfile: "FROM centos:7\n"}); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("build with a full space quota: %v", err)
+	}
+}
+
+func TestSaveUploadLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imagebuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	context := archive(t, false, "a", "b", "c")
+	if _, _, err = saveUpload(dir, "FROM centos:7\n", context, 2048); !hasCode(err, def.ErrGeneralBadRequest) {
+		t.Errorf("upload over the limit: %v", err)
+	}
+	name, size, err := saveUpload(dir, "FROM centos:7\n", archive(t, false, "a"), 1<<20)
+	if err != nil || name != contextTar || size == 0 {
+		t.Errorf("upload = %s, %d, %v", name, size, err)
+	}
+}
+
+func hasCode(err error, code int) bool {
+	c := errors.ErrorCode(err)
+	return len(c) >= 4 && c[:4] == strconv.Itoa(code)
+}
+
+func TestUnshared(t *testing.T) {
+	m, _, _, cleanup := newTestManager(t)
+	defer cleanup()
+	// the temporary directory of the tests is local
+	if err := checkShared(filepath.Join(m.dir, "missing")); err == nil {
+		t.Skip("the temporary directory is on a shared filesystem")
+	}
+	m.unshared = checkShared(m.dir)
+	if _, err := m.Submit("alice", BuildRequest{Name: "lammps", Dockerfile: "FROM centos:7\n"}); !hasCode(err, def.ErrBuildSubmit) {
+		t.Errorf("build with a local upload directory: %v", err)
+	}
+}
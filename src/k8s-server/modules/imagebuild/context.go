@@ -0,0 +1,148 @@
+package imagebuild
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"k8s-server/def"
+	"k8s-server/utils/errors"
+)
+
+// Names of the uploaded files in the context directory of a build.
+const (
+	dockerfileName = "Dockerfile"
+	contextTar     = "context.tar"
+	contextTarGz   = "context.tar.gz"
+)
+
+// sharedFileSystems are the statfs magic numbers of the network and cluster
+// filesystems the upload directory can be shared with the nodes on.
+var sharedFileSystems = map[uint32]string{
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x00c36400: "ceph",
+	0x0bd00bd0: "lustre",
+	0x47504653: "gpfs",
+	0x19830326: "beegfs",
+	0x01161970: "gfs2",
+	0x7461636f: "ocfs2",
+	0x65735546: "fuse",
+}
+
+// checkShared returns an error unless dir is on a shared filesystem, a
+// missing dir is checked at its nearest existing parent. The build pods mount
+// the upload directory from their node, which must see the server's uploads.
+func checkShared(dir string) error {
+	for p := dir; ; p = filepath.Dir(p) {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(p, &st)
+		if os.IsNotExist(err) && p != filepath.Dir(p) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, ok := sharedFileSystems[uint32(st.Type)]; !ok {
+			return fmt.Errorf("%s is not on a shared filesystem but on type %#x", dir, uint32(st.Type))
+		}
+		return nil
+	}
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// saveUpload writes the Dockerfile and the context archive to dir and returns
+// the name and size of the archive. The archive is checked to be a tar file,
+// gzipped or not, whose entries stay inside the context, and to be at most
+// limit bytes.
+func saveUpload(dir, dockerfile string, context io.Reader, limit int64) (string, int64, error) {
+	if int64(len(dockerfile)) > limit {
+		return "", 0, errors.Errorf(def.ErrGeneralBadRequest, "the Dockerfile exceeds the upload limit of %d MB", limit>>20)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, errors.Wrap(err, def.ErrBuildSubmit, "create context directory failed")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, dockerfileName), []byte(dockerfile), 0644); err != nil {
+		return "", 0, errors.Wrap(err, def.ErrBuildSubmit, "save Dockerfile failed")
+	}
+	if context == nil {
+		return "", 0, nil
+	}
+
+	r := bufio.NewReader(io.LimitReader(context, limit-int64(len(dockerfile))+1))
+	name := contextTar
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		name = contextTarGz
+	}
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return "", 0, errors.Wrap(err, def.ErrBuildSubmit, "save context failed")
+	}
+	defer f.Close()
+	counter := &countWriter{}
+	tee := io.TeeReader(r, io.MultiWriter(f, counter))
+	if err = checkArchive(tee, name == contextTarGz); err == nil {
+		// the padding after the end of the archive
+		_, err = io.Copy(ioutil.Discard, tee)
+	}
+	if counter.n+int64(len(dockerfile)) > limit {
+		return "", 0, errors.Errorf(def.ErrGeneralBadRequest, "the upload exceeds the limit of %d MB", limit>>20)
+	}
+	if err != nil {
+		return "", 0, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid context archive")
+	}
+	if err = f.Close(); err != nil {
+		return "", 0, errors.Wrap(err, def.ErrBuildSubmit, "save context failed")
+	}
+	return name, counter.n, nil
+}
+
+// checkArchive reads the tar archive of r and rejects the entries that would
+// be extracted outside of the context directory.
+func checkArchive(r io.Reader, gzipped bool) error {
+	if gzipped {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !insideContext(h.Name) {
+			return errors.Errorf(def.ErrGeneralBadRequest, "entry %s is outside of the context", h.Name)
+		}
+		if h.Typeflag == tar.TypeLink && !insideContext(h.Linkname) {
+			return errors.Errorf(def.ErrGeneralBadRequest, "hard link %s points outside of the context", h.Name)
+		}
+	}
+}
+
+func insideContext(name string) bool {
+	clean := path.Clean(name)
+	return !path.IsAbs(name) && clean != ".." && !strings.HasPrefix(clean, "../")
+}
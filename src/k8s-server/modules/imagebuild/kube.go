@@ -0,0 +1,293 @@
+package imagebuild
+
+import (
+	"fmt"
+	"io"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s-server/conf"
+	"k8s-server/models/types"
+	"k8s-server/utils/kube"
+)
+
+const (
+	// pullSecret holds the Harbor account the builder pulls the base images
+	// with
+	pullSecret = "image-build-pull"
+	// builderUID is the unprivileged user of the rootless builder image
+	builderUID = 1000
+)
+
+// kubeBuilder is the builder of the cluster, it runs BuildKit rootless
+// without a daemon.
+type kubeBuilder struct{}
+
+func (kubeBuilder) Create(t *buildTemplate) error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	if err = syncPullSecret(cs, t.Namespace, t.PullConfig); err != nil {
+		return fmt.Errorf("sync pull secret failed: %v", err)
+	}
+	job, err := cs.BatchV1().Jobs(t.Namespace).Create(newJob(t))
+	if err != nil {
+		return fmt.Errorf("create job %s/%s failed: %v", t.Namespace, t.Name, err)
+	}
+	// the pod waits for the push secret, which is deleted with the Job
+	if _, err = cs.CoreV1().Secrets(t.Namespace).Create(newPushSecret(t, job)); err != nil {
+		policy := metav1.DeletePropagationBackground
+		cs.BatchV1().Jobs(t.Namespace).Delete(t.Name, &metav1.DeleteOptions{PropagationPolicy: &policy})
+		return fmt.Errorf("create push secret of job %s/%s failed: %v", t.Namespace, t.Name, err)
+	}
+	return nil
+}
+
+func (kubeBuilder) Observe(namespace, name string) (Observation, error) {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return Observation{}, err
+	}
+	job, err := cs.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return Observation{}, errBuildNotFound
+	} else if err != nil {
+		return Observation{}, fmt.Errorf("get job %s/%s failed: %v", namespace, name, err)
+	}
+	o := Observation{State: types.BuildPending}
+	if job.Status.StartTime != nil {
+		started := job.Status.StartTime.Time
+		o.StartedAt = &started
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		finished := c.LastTransitionTime.Time
+		switch c.Type {
+		case batchv1.JobComplete:
+			o.State, o.FinishedAt = types.BuildSucceeded, &finished
+			return o, nil
+		case batchv1.JobFailed:
+			o.State, o.FinishedAt, o.Message = types.BuildFailed, &finished, "the build failed, see its logs"
+			if c.Reason == "DeadlineExceeded" {
+				o.Message = "the build timed out"
+			}
+			return o, nil
+		}
+	}
+	if job.Status.Active > 0 {
+		pod, err := buildPod(cs, namespace, name)
+		if err != nil {
+			return Observation{}, err
+		}
+		if pod != nil && pod.Status.Phase == corev1.PodRunning {
+			o.State = types.BuildRunning
+		}
+	}
+	return o, nil
+}
+
+func (kubeBuilder) Delete(namespace, name string) error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	policy := metav1.DeletePropagationBackground
+	err = cs.BatchV1().Jobs(namespace).Delete(name, &metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete job %s/%s failed: %v", namespace, name, err)
+	}
+	return nil
+}
+
+func (kubeBuilder) Logs(namespace, name string, follow bool) (io.ReadCloser, error) {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return nil, err
+	}
+	pod, err := buildPod(cs, namespace, name)
+	if err != nil {
+		return nil, err
+	} else if pod == nil {
+		if _, err = cs.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+			return nil, errBuildNotFound
+		}
+		return nil, fmt.Errorf("the pod of job %s/%s is not created yet", namespace, name)
+	}
+	container := "push"
+	for _, s := range pod.Status.InitContainerStatuses {
+		if s.State.Terminated == nil || s.State.Terminated.ExitCode != 0 {
+			// the context is not extracted or the image not built yet, or
+			// they failed
+			container = s.Name
+			break
+		}
+	}
+	return cs.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: container,
+		Follow:    follow,
+	}).Stream()
+}
+
+// buildPod returns the latest pod of a build Job, nil if there is none.
+func buildPod(cs kubernetes.Interface, namespace, name string) (*corev1.Pod, error) {
+	pods, err := cs.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: "job-name=" + name})
+	if err != nil {
+		return nil, fmt.Errorf("list pods of job %s/%s failed: %v", namespace, name, err)
+	}
+	var latest *corev1.Pod
+	for i := range pods.Items {
+		if latest == nil || latest.CreationTimestamp.Before(&pods.Items[i].CreationTimestamp) {
+			latest = &pods.Items[i]
+		}
+	}
+	return latest, nil
+}
+
+// syncPullSecret creates or updates the secret the builder pulls with.
+func syncPullSecret(cs kubernetes.Interface, namespace string, config []byte) error {
+	secrets := cs.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(pullSecret, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: pullSecret, Namespace: namespace},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: config},
+		})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if string(secret.Data[corev1.DockerConfigJsonKey]) == string(config) {
+		return nil
+	}
+	secret.Data = map[string][]byte{corev1.DockerConfigJsonKey: config}
+	_, err = secrets.Update(secret)
+	return err
+}
+
+// pushSecretName returns the name of the secret a build pushes with.
+func pushSecretName(t *buildTemplate) string {
+	return t.Name + "-push"
+}
+
+// newPushSecret returns the secret holding the push account of a build, it
+// is owned by the Job of the build.
+func newPushSecret(t *buildTemplate, job *batchv1.Job) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pushSecretName(t),
+			Namespace: t.Namespace,
+			Labels:    t.Labels,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1",
+				Kind:       "Job",
+				Name:       job.Name,
+				UID:        job.UID,
+			}},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: t.PushConfig},
+	}
+}
+
+// newJob returns the Job of a build. Init containers extract the context
+// from the upload directory, which is shared with the nodes, and build the
+// Dockerfile with BuildKit into an OCI archive, then skopeo pushes the
+// archive. Only the push container, which runs no code of the user, sees the
+// push account.
+func newJob(t *buildTemplate) *batchv1.Job {
+	backoff := int32(0)
+	uid := int64(builderUID)
+	deadline := int64(t.Deadline.Seconds())
+	extract := "true"
+	switch t.ContextFile {
+	case contextTar:
+		extract = "tar -xf /upload/" + contextTar + " -C /workspace"
+	case contextTarGz:
+		extract = "tar -xzf /upload/" + contextTarGz + " -C /workspace"
+	}
+	mounts := []corev1.VolumeMount{
+		{Name: "upload", MountPath: "/upload", ReadOnly: true},
+		{Name: "workspace", MountPath: "/workspace"},
+	}
+	annotations := map[string]string{
+		// rootless BuildKit creates its own user and PID namespaces for the
+		// build steps
+		"container.apparmor.security.beta.kubernetes.io/build": "unconfined",
+		"container.seccomp.security.alpha.kubernetes.io/build": "unconfined",
+	}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: t.Name, Namespace: t.Namespace, Labels: t.Labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoff,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: t.Labels, Annotations: annotations},
+				Spec: corev1.PodSpec{
+					RestartPolicy:   corev1.RestartPolicyNever,
+					SecurityContext: &corev1.PodSecurityContext{RunAsUser: &uid, FSGroup: &uid},
+					InitContainers: []corev1.Container{{
+						Name:         "context",
+						Image:        conf.ImageBuilderImage(),
+						Command:      []string{"/bin/sh", "-c", extract},
+						VolumeMounts: mounts,
+					}, {
+						Name:  "build",
+						Image: conf.ImageBuilderImage(),
+						Command: []string{"buildctl-daemonless.sh", "build",
+							"--frontend", "dockerfile.v0",
+							"--local", "context=/workspace",
+							"--local", "dockerfile=/upload",
+							"--opt", "filename=" + dockerfileName,
+							"--output", "type=oci,dest=/output/image.tar",
+						},
+						Env: []corev1.EnvVar{{Name: "DOCKER_CONFIG", Value: "/home/user/.docker"}},
+						VolumeMounts: append(mounts,
+							corev1.VolumeMount{Name: "buildkitd", MountPath: "/home/user/.local/share/buildkit"},
+							corev1.VolumeMount{Name: "pull-config", MountPath: "/home/user/.docker", ReadOnly: true},
+							corev1.VolumeMount{Name: "output", MountPath: "/output"},
+						),
+					}},
+					Containers: []corev1.Container{{
+						Name:  "push",
+						Image: conf.ImagePushImage(),
+						Command: []string{"skopeo", "copy", "--authfile", "/auth/config.json",
+							"oci-archive:/output/image.tar", "docker://" + t.Image},
+						Env: []corev1.EnvVar{{Name: "TMPDIR", Value: "/output"}},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "output", MountPath: "/output"},
+							{Name: "push-config", MountPath: "/auth", ReadOnly: true},
+						},
+					}},
+					Volumes: []corev1.Volume{
+						{Name: "upload", VolumeSource: corev1.VolumeSource{
+							HostPath: &corev1.HostPathVolumeSource{Path: t.ContextDir},
+						}},
+						{Name: "workspace", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+						{Name: "buildkitd", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+						{Name: "output", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+						{Name: "pull-config", VolumeSource: corev1.VolumeSource{
+							Secret: &corev1.SecretVolumeSource{
+								SecretName: pullSecret,
+								Items:      []corev1.KeyToPath{{Key: corev1.DockerConfigJsonKey, Path: "config.json"}},
+							},
+						}},
+						{Name: "push-config", VolumeSource: corev1.VolumeSource{
+							Secret: &corev1.SecretVolumeSource{
+								SecretName: pushSecretName(t),
+								Items:      []corev1.KeyToPath{{Key: corev1.DockerConfigJsonKey, Path: "config.json"}},
+							},
+						}},
+					},
+				},
+			},
+		},
+	}
+}
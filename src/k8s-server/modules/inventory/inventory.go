@@ -0,0 +1,313 @@
+// Package inventory joins the hardware inventory of the node agents with the
+// Kubernetes nodes and keeps hardware labels on the nodes in sync.
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/modules/agent/sysinfo"
+	"k8s-server/modules/registry"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// Entry is an agent, a node or both joined.
+type Entry struct {
+	Node     string `json:"node,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Address  string `json:"address,omitempty"`
+	MAC      string `json:"mac,omitempty"`
+	Online   bool   `json:"online"`
+	CPUModel string `json:"cpuModel,omitempty"`
+	GPUModel string `json:"gpuModel,omitempty"`
+	GPUCount int    `json:"gpuCount"`
+	MemoryKB int    `json:"memoryKB"`
+	// CollectedAt is when the hardware was read from the agent, it is
+	// zero if the agent was never reachable.
+	CollectedAt time.Time `json:"collectedAt"`
+}
+
+// Report is the result of a reconciliation.
+type Report struct {
+	Nodes             []Entry       `json:"nodes"`
+	AgentsWithoutNode []Entry       `json:"agentsWithoutNode"`
+	NodesWithoutAgent []string      `json:"nodesWithoutAgent"`
+	LabelChanges      []LabelChange `json:"labelChanges"`
+	DryRun            bool          `json:"dryRun"`
+	Errors            []string      `json:"errors,omitempty"`
+	GeneratedAt       time.Time     `json:"generatedAt"`
+}
+
+// agentLister lists the registered agents.
+type agentLister interface {
+	List() []registry.Agent
+}
+
+// Manager represents the node inventory manager.
+type Manager struct {
+	agents  agentLister
+	nodes   nodeClient
+	fetch   func(a registry.Agent) (sysinfo.Info, error)
+	allowed map[string]bool
+	dryRun  bool
+
+	// hardware caches the last inventory of every agent, so offline
+	// agents keep their labels
+	hardware map[string]sysinfo.Info
+	last     *Report
+	lock     sync.Mutex
+}
+
+// NewManager returns an inventory of the agents in the registry. Only the
+// labels in backend::NodeLabelAllowlist are synced, none are written if
+// backend::NodeLabelDryRun is set.
+func NewManager(agents *registry.Manager) (*Manager, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return newManager(agents, kubeNodes{}, func(a registry.Agent) (sysinfo.Info, error) {
+		return fetchSysinfo(client, a)
+	}, conf.NodeLabelAllowlist(), conf.NodeLabelDryRun()), nil
+}
+
+func newManager(agents agentLister, nodes nodeClient, fetch func(registry.Agent) (sysinfo.Info, error),
+	allowlist []string, dryRun bool) *Manager {
+	if len(allowlist) == 0 {
+		allowlist = DefaultLabels
+	}
+	m := &Manager{
+		agents:   agents,
+		nodes:    nodes,
+		fetch:    fetch,
+		allowed:  make(map[string]bool),
+		dryRun:   dryRun,
+		hardware: make(map[string]sysinfo.Info),
+	}
+	for _, key := range allowlist {
+		m.allowed[key] = true
+	}
+	return m
+}
+
+// Run reconciles every backend::InventoryInterval until stop is closed.
+func (m *Manager) Run(stop <-chan struct{}) {
+	interval := conf.InventoryInterval()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.Reconcile(m.dryRun); err != nil {
+			logs.Error("reconcile node inventory failed: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile joins the agents with the nodes and syncs the hardware labels of
+// the joined nodes. With dryRun, or if the manager is configured for dry
+// runs, the label changes are only reported. Unreachable agents and failed
+// patches are listed in the report errors.
+func (m *Manager) Reconcile(dryRun bool) (Report, error) {
+	dryRun = dryRun || m.dryRun
+	nodes, err := m.nodes.ListNodes()
+	if err != nil {
+		return Report{}, errors.Wrap(err, def.ErrInventoryReconcile, "list nodes failed")
+	}
+	agents := m.agents.List()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	report := Report{
+		Nodes:             []Entry{},
+		AgentsWithoutNode: []Entry{},
+		NodesWithoutAgent: []string{},
+		LabelChanges:      []LabelChange{},
+		DryRun:            dryRun,
+		GeneratedAt:       time.Now(),
+	}
+	for _, a := range agents {
+		if !a.Online {
+			continue
+		}
+		info, err := m.fetch(a)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("agent %s: %v", a.Hostname, err))
+			continue
+		}
+		m.hardware[a.Hostname] = info
+	}
+
+	index := newNodeIndex(nodes)
+	joined := make(map[string]bool)
+	for _, a := range agents {
+		entry := m.entry(a)
+		node := index.match(a)
+		if node == nil {
+			report.AgentsWithoutNode = append(report.AgentsWithoutNode, entry)
+			continue
+		}
+		joined[node.Name] = true
+		entry.Node = node.Name
+		report.Nodes = append(report.Nodes, entry)
+
+		info, ok := m.hardware[a.Hostname]
+		if !ok {
+			continue
+		}
+		changes := labelChanges(node.Name, node.Labels, hardwareLabels(info), m.allowed)
+		if len(changes) == 0 {
+			continue
+		}
+		report.LabelChanges = append(report.LabelChanges, changes...)
+		if dryRun {
+			continue
+		}
+		if err := m.nodes.PatchNode(node.Name, labelPatch(changes)); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		} else {
+			logs.Info("node %s hardware labels updated", node.Name)
+		}
+	}
+	for _, n := range nodes {
+		if !joined[n.Name] {
+			report.NodesWithoutAgent = append(report.NodesWithoutAgent, n.Name)
+		}
+	}
+	sort.Strings(report.NodesWithoutAgent)
+	m.last = &report
+	return report, nil
+}
+
+// LastReport returns the report of the latest reconciliation.
+func (m *Manager) LastReport() (Report, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.last == nil {
+		return Report{}, errors.New(def.ErrGeneralNotFound, "the node inventory was not reconciled yet")
+	}
+	return *m.last, nil
+}
+
+// NodeName returns the Kubernetes node the agent was joined to by the latest
+// reconciliation, the hostname if it was not joined.
+func (m *Manager) NodeName(hostname string) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.last != nil {
+		for _, e := range m.last.Nodes {
+			if e.Hostname == hostname {
+				return e.Node
+			}
+		}
+	}
+	return hostname
+}
+
+// Sysinfo returns the latest inventory of every agent that was reachable
+// once, by hostname.
+func (m *Manager) Sysinfo() map[string]sysinfo.Info {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	infos := make(map[string]sysinfo.Info, len(m.hardware))
+	for hostname, info := range m.hardware {
+		infos[hostname] = info
+	}
+	return infos
+}
+
+func (m *Manager) entry(a registry.Agent) Entry {
+	e := Entry{Hostname: a.Hostname, Address: a.Address, MAC: a.MAC, Online: a.Online}
+	if info, ok := m.hardware[a.Hostname]; ok {
+		e.CPUModel = info.CPU.Model
+		e.MemoryKB = info.Memory.MemTotal
+		e.GPUCount = len(info.GPU.Devices)
+		if e.GPUCount > 0 {
+			e.GPUModel = info.GPU.Devices[0].Name
+		}
+		e.CollectedAt = info.CollectedAt
+	}
+	return e
+}
+
+// nodeIndex finds the node of an agent by hostname, short hostname or
+// internal IP, in that order.
+type nodeIndex struct {
+	byName  map[string]*Node
+	byShort map[string]*Node
+	byIP    map[string]*Node
+}
+
+func newNodeIndex(nodes []Node) *nodeIndex {
+	idx := &nodeIndex{
+		byName:  make(map[string]*Node),
+		byShort: make(map[string]*Node),
+		byIP:    make(map[string]*Node),
+	}
+	for i := range nodes {
+		n := &nodes[i]
+		for _, name := range []string{n.Name, n.Hostname} {
+			if name != "" {
+				idx.byName[name] = n
+				idx.byShort[shortName(name)] = n
+			}
+		}
+		for _, ip := range n.InternalIPs {
+			idx.byIP[ip] = n
+		}
+	}
+	return idx
+}
+
+func (idx *nodeIndex) match(a registry.Agent) *Node {
+	if n, ok := idx.byName[a.Hostname]; ok {
+		return n
+	}
+	if n, ok := idx.byShort[shortName(a.Hostname)]; ok {
+		return n
+	}
+	if a.Address != "" {
+		return idx.byIP[a.Address]
+	}
+	return nil
+}
+
+func shortName(hostname string) string {
+	return strings.SplitN(hostname, ".", 2)[0]
+}
+
+// fetchSysinfo reads the hardware inventory from the agent API.
+func fetchSysinfo(client *http.Client, a registry.Agent) (sysinfo.Info, error) {
+	var info sysinfo.Info
+	if a.Address == "" {
+		return info, fmt.Errorf("no management address")
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s:%d/api/v1/sysinfo", a.Address, a.Port), nil)
+	if err != nil {
+		return info, err
+	}
+	if token := conf.AgentToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("sysinfo responded %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	return info, err
+}
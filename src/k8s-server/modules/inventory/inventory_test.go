@@ -0,0 +1,174 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"k8s-server/modules/agent"
+	"k8s-server/modules/agent/sysinfo"
+	"k8s-server/modules/registry"
+)
+
+type fakeNodes struct {
+	nodes   []Node
+	patches map[string]map[string]interface{}
+}
+
+func (f *fakeNodes) ListNodes() ([]Node, error) {
+	return f.nodes, nil
+}
+
+func (f *fakeNodes) PatchNode(name string, patch []byte) error {
+	var p struct {
+		Metadata struct {
+			Labels map[string]interface{} `json:"labels"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return err
+	}
+	f.patches[name] = p.Metadata.Labels
+	return nil
+}
+
+type fakeAgents []registry.Agent
+
+func (f fakeAgents) List() []registry.Agent {
+	return f
+}
+
+func newAgent(hostname, address string, online bool) registry.Agent {
+	return registry.Agent{Heartbeat: agent.Heartbeat{Hostname: hostname, Address: address, Port: 6380}, Online: online}
+}
+
+func gpuInfo(model string, count int, memKB int) sysinfo.Info {
+	info := sysinfo.Info{}
+	info.CPU.Model = "Intel(R) Xeon(R) Gold 6130 CPU @ 2.10GHz"
+	info.Memory.MemTotal = memKB
+	for i := 0; i < count; i++ {
+		info.GPU.Devices = append(info.GPU.Devices, sysinfo.GPUDevice{Index: i, Name: model})
+	}
+	return info
+}
+
+func TestReconcile(t *testing.T) {
+	nodes := &fakeNodes{
+		nodes: []Node{
+			{Name: "node1.example.com", Labels: map[string]string{}},
+			{Name: "k8s-gpu", InternalIPs: []string{"10.0.0.2"}, Labels: map[string]string{
+				LabelMemoryGiB:     "64",
+				LabelGPUModel:      "manual",
+				"kubernetes.io/os": "linux",
+			}},
+			{Name: "orphan"},
+		},
+		patches: make(map[string]map[string]interface{}),
+	}
+	agents := fakeAgents{
+		newAgent("node1", "10.0.0.1", true),
+		newAgent("gpu01", "10.0.0.2", true),
+		newAgent("lonely", "10.0.0.9", true),
+	}
+	hardware := map[string]sysinfo.Info{
+		"node1": gpuInfo("", 0, 16314744),
+		"gpu01": gpuInfo("Tesla V100-PCIE-32GB", 4, 394870724),
+	}
+	fetched := 0
+	fetch := func(a registry.Agent) (sysinfo.Info, error) {
+		fetched++
+		if info, ok := hardware[a.Hostname]; ok {
+			return info, nil
+		}
+		return sysinfo.Info{}, fmt.Errorf("connection refused")
+	}
+	allowlist := []string{LabelCPUModel, LabelGPUCount, LabelMemoryGiB}
+	m := newManager(agents, nodes, fetch, allowlist, false)
+
+	report, err := m.Reconcile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes.patches) != 0 {
+		t.Errorf("dry run patched %v", nodes.patches)
+	}
+	if len(report.Nodes) != 2 || report.Nodes[0].Node != "node1.example.com" || report.Nodes[1].Node != "k8s-gpu" {
+		t.Errorf("unexpected joined nodes %+v", report.Nodes)
+	}
+	if len(report.AgentsWithoutNode) != 1 || report.AgentsWithoutNode[0].Hostname != "lonely" {
+		t.Errorf("agents without node = %+v", report.AgentsWithoutNode)
+	}
+	if !reflect.DeepEqual(report.NodesWithoutAgent, []string{"orphan"}) {
+		t.Errorf("nodes without agent = %v", report.NodesWithoutAgent)
+	}
+	if len(report.Errors) != 1 {
+		t.Errorf("unreachable agent not reported: %v", report.Errors)
+	}
+
+	if _, err = m.Reconcile(false); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]interface{}{
+		"node1.example.com": {
+			LabelCPUModel:  "Intel-R-Xeon-R-Gold-6130-CPU-2.10GHz",
+			LabelMemoryGiB: "16",
+		},
+		"k8s-gpu": {
+			LabelCPUModel:  "Intel-R-Xeon-R-Gold-6130-CPU-2.10GHz",
+			LabelGPUCount:  "4",
+			LabelMemoryGiB: "377",
+		},
+	}
+	if !reflect.DeepEqual(nodes.patches, want) {
+		t.Errorf("patches = %v, want %v", nodes.patches, want)
+	}
+
+	// an offline agent keeps its labels from the cached inventory
+	agents[0].Online = false
+	nodes.nodes[0].Labels = map[string]string{
+		LabelCPUModel: "Intel-R-Xeon-R-Gold-6130-CPU-2.10GHz", LabelMemoryGiB: "16",
+	}
+	fetched = 0
+	report, err = m.Reconcile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 2 {
+		t.Errorf("fetched %d agents, want the 2 online ones", fetched)
+	}
+	for _, c := range report.LabelChanges {
+		if c.Node == "node1.example.com" {
+			t.Errorf("offline agent changed labels: %+v", c)
+		}
+	}
+	if last, err := m.LastReport(); err != nil || !last.DryRun {
+		t.Errorf("last report = %+v, %v", last, err)
+	}
+}
+
+func TestLabelChangesRemoveOwnedKeys(t *testing.T) {
+	current := map[string]string{LabelGPUCount: "2", LabelGPUModel: "Tesla-P100", "team": "ml"}
+	allowed := map[string]bool{LabelGPUCount: true, LabelGPUModel: true, "team": true}
+	changes := labelChanges("n", current, map[string]string{}, allowed)
+	if len(changes) != 2 || changes[0].Key != LabelGPUCount || changes[0].To != "" {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+	if string(labelPatch(changes)) != `{"metadata":{"labels":{"hardware.k8s-server/gpu-count":null,"hardware.k8s-server/gpu-model":null}}}` {
+		t.Errorf("unexpected patch %s", labelPatch(changes))
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	for in, want := range map[string]string{
+		"Tesla V100-PCIE-32GB":            "Tesla-V100-PCIE-32GB",
+		"AMD EPYC 7742 64-Core Processor": "AMD-EPYC-7742-64-Core-Processor",
+		"(unknown)":                       "unknown",
+		"":                                "",
+		"a very long model name that goes on and on and on past sixty three": "a-very-long-model-name-that-goes-on-and-on-and-on-past-sixty-th",
+	} {
+		if got := labelValue(in); got != want {
+			t.Errorf("labelValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
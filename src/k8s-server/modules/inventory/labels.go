@@ -0,0 +1,111 @@
+package inventory
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s-server/modules/agent/sysinfo"
+)
+
+// Hardware labels derived from the agent inventory, every key under
+// LabelPrefix is owned by the inventory.
+const (
+	LabelPrefix    = "hardware.k8s-server/"
+	LabelCPUModel  = LabelPrefix + "cpu-model"
+	LabelGPUModel  = LabelPrefix + "gpu-model"
+	LabelGPUCount  = LabelPrefix + "gpu-count"
+	LabelMemoryGiB = LabelPrefix + "memory-gi"
+)
+
+// DefaultLabels are the labels synced when no allowlist is configured.
+var DefaultLabels = []string{LabelCPUModel, LabelGPUModel, LabelGPUCount, LabelMemoryGiB}
+
+// LabelChange is a label update of a node, an empty To removes the label.
+type LabelChange struct {
+	Node string `json:"node"`
+	Key  string `json:"key"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// hardwareLabels returns the labels describing the hardware of a node.
+func hardwareLabels(info sysinfo.Info) map[string]string {
+	labels := make(map[string]string)
+	if v := labelValue(info.CPU.Model); v != "" {
+		labels[LabelCPUModel] = v
+	}
+	if len(info.GPU.Devices) > 0 {
+		labels[LabelGPUCount] = strconv.Itoa(len(info.GPU.Devices))
+		if v := labelValue(info.GPU.Devices[0].Name); v != "" {
+			labels[LabelGPUModel] = v
+		}
+	}
+	if info.Memory.MemTotal > 0 {
+		// MemTotal is a little below the installed memory, round to the
+		// nearest GiB
+		labels[LabelMemoryGiB] = strconv.Itoa((info.Memory.MemTotal + 1<<19) >> 20)
+	}
+	return labels
+}
+
+// labelChanges returns the changes turning the current labels of a node into
+// the desired ones. Only keys in allowed are touched, allowed keys under
+// LabelPrefix that are no longer desired are removed.
+func labelChanges(node string, current, desired map[string]string, allowed map[string]bool) []LabelChange {
+	var changes []LabelChange
+	for key, to := range desired {
+		if allowed[key] && current[key] != to {
+			changes = append(changes, LabelChange{Node: node, Key: key, From: current[key], To: to})
+		}
+	}
+	for key, from := range current {
+		if _, ok := desired[key]; !ok && allowed[key] && strings.HasPrefix(key, LabelPrefix) {
+			changes = append(changes, LabelChange{Node: node, Key: key, From: from})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// labelPatch returns the JSON merge patch applying the changes.
+func labelPatch(changes []LabelChange) []byte {
+	labels := make(map[string]interface{}, len(changes))
+	for _, c := range changes {
+		if c.To == "" {
+			labels[c.Key] = nil
+		} else {
+			labels[c.Key] = c.To
+		}
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	return b
+}
+
+// labelValue turns s into a valid label value: at most 63 characters of
+// alphanumerics, '-', '_' and '.', beginning and ending with an alphanumeric.
+// Other characters are replaced by '-'.
+func labelValue(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.':
+			b.WriteRune(r)
+			dash = false
+		case !dash:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	v := b.String()
+	if len(v) > 63 {
+		v = v[:63]
+	}
+	return strings.TrimFunc(v, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+}
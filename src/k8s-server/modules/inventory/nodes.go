@@ -0,0 +1,66 @@
+package inventory
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"k8s-server/utils/kube"
+)
+
+// Node is the part of a Kubernetes Node the inventory joins agents on.
+type Node struct {
+	Name        string
+	Hostname    string // kubernetes.io/hostname label
+	InternalIPs []string
+	Labels      map[string]string
+}
+
+// nodeClient lists and labels the cluster nodes.
+type nodeClient interface {
+	ListNodes() ([]Node, error)
+	// PatchNode applies a JSON merge patch to the node.
+	PatchNode(name string, patch []byte) error
+}
+
+// kubeNodes is the nodeClient of the cluster.
+type kubeNodes struct{}
+
+func (kubeNodes) ListNodes() ([]Node, error) {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return nil, err
+	}
+	list, err := cs.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list nodes failed: %v", err)
+	}
+	nodes := make([]Node, 0, len(list.Items))
+	for _, n := range list.Items {
+		node := Node{
+			Name:     n.Name,
+			Hostname: n.Labels[corev1.LabelHostname],
+			Labels:   n.Labels,
+		}
+		for _, addr := range n.Status.Addresses {
+			if addr.Type == corev1.NodeInternalIP {
+				node.InternalIPs = append(node.InternalIPs, addr.Address)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (kubeNodes) PatchNode(name string, patch []byte) error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	if _, err = cs.CoreV1().Nodes().Patch(name, types.MergePatchType, patch); err != nil {
+		return fmt.Errorf("patch node %s failed: %v", name, err)
+	}
+	return nil
+}
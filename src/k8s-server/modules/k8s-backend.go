@@ -1,34 +1,148 @@
 package modules
 
 import (
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models"
+	"k8s-server/modules/account"
+	"k8s-server/modules/agent"
+	"k8s-server/modules/apptemplate"
+	"k8s-server/modules/batch"
+	"k8s-server/modules/catalog"
+	"k8s-server/modules/harbor"
+	"k8s-server/modules/imagebuild"
+	"k8s-server/modules/inventory"
 	"k8s-server/modules/pod"
+	"k8s-server/modules/power"
+	"k8s-server/modules/registry"
+	"k8s-server/modules/storage"
+	"k8s-server/modules/token"
 	"k8s-server/utils/errors"
-	"k8s-server/models"
+	"k8s-server/utils/redisutil"
+
+	"github.com/gomodule/redigo/redis"
 )
+
 var KubernetesServer *Backend
 
 type Backend struct {
-	DB models.Model
-	PodManager *pod.Manager
-	inited    bool
+	DB               models.Model
+	PodManager       *pod.Manager
+	TokenManager     *token.Manager
+	TemplateManager  *apptemplate.Manager
+	HarborManager    *harbor.Manager
+	RegistryManager  *registry.Manager
+	InventoryManager *inventory.Manager
+	PowerManager     *power.Manager
+	BatchManager     *batch.Manager
+	CatalogManager   *catalog.Manager
+	BuildManager     *imagebuild.Manager
+	StorageManager   *storage.Manager
+	AccountManager   *account.Manager
+	inited           bool
 }
 
 func NewBackend() (*Backend, error) {
 	if KubernetesServer != nil && KubernetesServer.inited {
 		return KubernetesServer, nil
 	}
-	podManager,err := pod.NewManager()
+	podManager, err := pod.NewManager()
 	if err != nil {
-		return nil, errors.Wrap(err, def.ErrPodModule,"init pod module failed")
+		return nil, errors.Wrap(err, def.ErrPodModule, "init pod module failed")
 	}
 	m, err := models.GetModel()
 	if err != nil {
 		return nil, errors.Wrap(err, def.ErrGeneralDBConnect,
 			"init database failed")
 	}
+	tokenManager, err := token.NewManager(m)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrTokenModule,
+			"init token module failed")
+	}
+	catalogManager, err := catalog.NewManager()
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrCatalogModule,
+			"init image catalog failed")
+	}
+	go catalogManager.Run(nil)
+	accountManager, err := account.NewManager(m)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrAccountModule,
+			"init account module failed")
+	}
+	templateManager, err := apptemplate.NewManager(m, catalogManager, accountManager)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrTemplateModule,
+			"init template module failed")
+	}
+	harborManager, err := harbor.NewManager()
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrHarborModule,
+			"init harbor module failed")
+	}
+	// the pull secrets are synced for the lifetime of the server
+	go harborManager.Run(nil)
+	var pool *redis.Pool
+	if conf.RedisHost() != "" {
+		pool = redisutil.NewPool(conf.MgmtRedisConfig())
+	}
+	disc, err := agent.NewDiscovery(pool)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrRegistryModule,
+			"init agent discovery failed")
+	}
+	registryManager, err := registry.NewManager(pool, disc, m)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrRegistryModule,
+			"init agent registry failed")
+	}
+	go registryManager.Run(nil)
+	inventoryManager, err := inventory.NewManager(registryManager)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrInventoryModule,
+			"init inventory module failed")
+	}
+	go inventoryManager.Run(nil)
+	powerManager, err := power.NewManager(registryManager, inventoryManager, m)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrPowerModule,
+			"init power module failed")
+	}
+	go powerManager.Run(nil)
+	batchManager, err := batch.NewManager(m, catalogManager, accountManager)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrBatchModule,
+			"init batch module failed")
+	}
+	go batchManager.Run(nil)
+	buildManager, err := imagebuild.NewManager(m, harborManager)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrBuildModule,
+			"init image build module failed")
+	}
+	go buildManager.Run(nil)
+	storageManager, err := storage.NewManager(inventoryManager)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrStorageModule,
+			"init storage module failed")
+	}
 	backend := &Backend{
-		DB:             m,
-		PodManager: podManager,
-		inited: true,
+		DB:               m,
+		PodManager:       podManager,
+		TokenManager:     tokenManager,
+		TemplateManager:  templateManager,
+		HarborManager:    harborManager,
+		RegistryManager:  registryManager,
+		InventoryManager: inventoryManager,
+		PowerManager:     powerManager,
+		BatchManager:     batchManager,
+		CatalogManager:   catalogManager,
+		BuildManager:     buildManager,
+		StorageManager:   storageManager,
+		AccountManager:   accountManager,
+		inited:           true,
 	}
-}
\ No newline at end of file
+	KubernetesServer = backend
+	return backend, nil
+}
@@ -0,0 +1,99 @@
+package power
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s-server/utils/kube"
+)
+
+// drainPollInterval is how often the pods of a draining node are listed.
+const drainPollInterval = 5 * time.Second
+
+// kubeDrainer is the nodeDrainer of the cluster, it evicts the pods like
+// kubectl drain --ignore-daemonsets so PodDisruptionBudgets are honoured.
+type kubeDrainer struct{}
+
+func (kubeDrainer) Drain(node string, timeout time.Duration) error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	if err = setUnschedulable(cs, node, true); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := cs.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+			FieldSelector: "spec.nodeName=" + node,
+		})
+		if err != nil {
+			return fmt.Errorf("list pods of node %s failed: %v", node, err)
+		}
+		pending := 0
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !evictable(pod) {
+				continue
+			}
+			pending++
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			err = cs.CoreV1().Pods(pod.Namespace).Evict(&policyv1beta1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			})
+			// 429 means a disruption budget blocks the eviction for now
+			if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsTooManyRequests(err) {
+				return fmt.Errorf("evict pod %s/%s failed: %v", pod.Namespace, pod.Name, err)
+			}
+		}
+		if pending == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d pods still on node %s after %s", pending, node, timeout)
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+func (kubeDrainer) Uncordon(node string) error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	return setUnschedulable(cs, node, false)
+}
+
+func setUnschedulable(cs kubernetes.Interface, node string, unschedulable bool) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable))
+	if _, err := cs.CoreV1().Nodes().Patch(node, types.MergePatchType, patch); err != nil {
+		return fmt.Errorf("patch node %s failed: %v", node, err)
+	}
+	return nil
+}
+
+// evictable reports whether the pod is evicted by a drain, DaemonSet pods
+// would be recreated and mirror pods belong to the kubelet.
+func evictable(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller != nil && *ref.Controller && ref.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
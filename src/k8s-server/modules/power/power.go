@@ -0,0 +1,440 @@
+// Package power wakes the bare-metal nodes with Wake-on-LAN and shuts them
+// down or reboots them through their agents after draining the Kubernetes
+// node, so compute nodes can be scaled with demand.
+package power
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models"
+	"k8s-server/models/types"
+	"k8s-server/modules/agent"
+	"k8s-server/modules/inventory"
+	"k8s-server/modules/registry"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// Power states. The transitional states end when the agent goes offline or
+// comes back, or as StateUnknown after backend::PowerTimeout.
+const (
+	StateOn           = "on"
+	StateOff          = "off"
+	StateWaking       = "waking"
+	StateDraining     = "draining"
+	StateShuttingDown = "shutting-down"
+	StateRebooting    = "rebooting"
+	StateUnknown      = "unknown"
+)
+
+// EventKind is the kind of the events recorded for power actions.
+const EventKind = "Power"
+
+// Reasons of the power events.
+const (
+	ReasonWakeSent          = "WakeSent"
+	ReasonDraining          = "Draining"
+	ReasonDrainFailed       = "DrainFailed"
+	ReasonPowerOffRequested = "PowerOffRequested"
+	ReasonRebootRequested   = "RebootRequested"
+	ReasonPowerActionFailed = "PowerActionFailed"
+	ReasonPoweredOn         = "PoweredOn"
+	ReasonPoweredOff        = "PoweredOff"
+	ReasonTimedOut          = "TimedOut"
+)
+
+// State is the last known power state of a node.
+type State struct {
+	Hostname string    `json:"hostname"`
+	Node     string    `json:"node,omitempty"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Message  string    `json:"message,omitempty"`
+	// Deadline ends a transitional state.
+	Deadline time.Time `json:"deadline,omitempty"`
+}
+
+func (s *State) transitional() bool {
+	switch s.State {
+	case StateWaking, StateDraining, StateShuttingDown, StateRebooting:
+		return true
+	}
+	return false
+}
+
+// agentSource looks up the agents.
+type agentSource interface {
+	Get(hostname string) (registry.Agent, error)
+	List() []registry.Agent
+}
+
+// nodeResolver maps an agent to its Kubernetes node.
+type nodeResolver interface {
+	NodeName(hostname string) string
+}
+
+// nodeDrainer cordons and drains the Kubernetes nodes.
+type nodeDrainer interface {
+	// Drain cordons the node and evicts its pods, DaemonSet and mirror pods
+	// are left.
+	Drain(node string, timeout time.Duration) error
+	Uncordon(node string) error
+}
+
+// Manager represents the node power manager.
+type Manager struct {
+	agents       agentSource
+	nodes        nodeResolver
+	drainer      nodeDrainer
+	events       models.EventStore
+	wake         func(mac string) error
+	client       *http.Client
+	drainTimeout time.Duration
+	timeout      time.Duration
+
+	states map[string]*State
+	// wg tracks the running power off actions
+	wg   sync.WaitGroup
+	lock sync.Mutex
+}
+
+// NewManager returns the power manager of the agents in the registry, the
+// node of an agent is looked up in the inventory.
+func NewManager(agents *registry.Manager, nodes *inventory.Manager, events models.EventStore) (*Manager, error) {
+	return newManager(agents, nodes, kubeDrainer{}, events, func(mac string) error {
+		return SendWoL(conf.WolBroadcastAddr(), mac)
+	}), nil
+}
+
+func newManager(agents agentSource, nodes nodeResolver, drainer nodeDrainer, events models.EventStore,
+	wake func(mac string) error) *Manager {
+	return &Manager{
+		agents:       agents,
+		nodes:        nodes,
+		drainer:      drainer,
+		events:       events,
+		wake:         wake,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		drainTimeout: conf.DrainTimeout(),
+		timeout:      conf.PowerTimeout(),
+		states:       make(map[string]*State),
+	}
+}
+
+// Run syncs the power states every HeartbeatInterval until stop is closed, it
+// returns at once if the interval is not positive.
+func (m *Manager) Run(stop <-chan struct{}) {
+	interval := conf.HeartbeatInterval()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.Sync()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync derives the power states from the agent liveness. A node is on while
+// its agent is online, it is off once its agent went offline after a power
+// off. A node that comes back after a wake or reboot is uncordoned.
+func (m *Manager) Sync() {
+	now := time.Now()
+	agents := m.agents.List()
+	// the agents first seen are looked up without the lock held
+	var unseen []registry.Agent
+	m.lock.Lock()
+	for _, a := range agents {
+		if _, ok := m.states[a.Hostname]; !ok {
+			unseen = append(unseen, a)
+		}
+	}
+	m.lock.Unlock()
+	initial := make(map[string]*State, len(unseen))
+	for _, a := range unseen {
+		initial[a.Hostname] = m.initialState(a, now)
+	}
+
+	var events []types.Event
+	var uncordon []string
+	m.lock.Lock()
+	for _, a := range agents {
+		st, ok := m.states[a.Hostname]
+		if !ok {
+			m.states[a.Hostname] = initial[a.Hostname]
+			continue
+		}
+		switch {
+		case st.State == StateDraining:
+			// the power off action owns the state
+		case a.Online && (st.State == StateWaking || st.State == StateOff || st.State == StateUnknown ||
+			st.State == StateRebooting && a.StartedAt.After(st.Since)):
+			if st.State != StateUnknown || st.Message != "" {
+				events = append(events, newEvent(types.EventNormal, a.Hostname, ReasonPoweredOn,
+					"node %s is on", a.Hostname))
+			}
+			if st.State == StateWaking || st.State == StateRebooting {
+				uncordon = append(uncordon, st.Node)
+			}
+			m.set(st, StateOn, now, "")
+		case !a.Online && st.State == StateShuttingDown:
+			events = append(events, newEvent(types.EventNormal, a.Hostname, ReasonPoweredOff,
+				"node %s is off", a.Hostname))
+			m.set(st, StateOff, now, "")
+		case !a.Online && st.State == StateOn:
+			m.set(st, StateUnknown, now, "agent went offline")
+		case st.transitional() && now.After(st.Deadline):
+			events = append(events, newEvent(types.EventWarning, a.Hostname, ReasonTimedOut,
+				"node %s still %s after %s", a.Hostname, st.State, m.timeout))
+			m.set(st, StateUnknown, now, fmt.Sprintf("%s timed out", st.State))
+		}
+	}
+	m.lock.Unlock()
+
+	for _, node := range uncordon {
+		if node == "" {
+			continue
+		}
+		if err := m.drainer.Uncordon(node); err != nil {
+			logs.Error("uncordon node %s failed: %v", node, err)
+		}
+	}
+	m.record(events)
+}
+
+// initialState is the state of an agent first seen, e.g. after a server
+// restart. An offline agent is off if its last power event powered it off.
+func (m *Manager) initialState(a registry.Agent, now time.Time) *State {
+	st := &State{Hostname: a.Hostname, Node: m.nodes.NodeName(a.Hostname), State: StateOn, Since: now}
+	if a.Online {
+		return st
+	}
+	st.State = StateUnknown
+	last, err := m.events.ListEvents(types.EventFilter{Kind: EventKind, Object: a.Hostname, Limit: 1})
+	if err == nil && len(last) == 1 {
+		switch last[0].Reason {
+		case ReasonPoweredOff, ReasonPowerOffRequested:
+			st.State, st.Since = StateOff, last[0].CreatedAt
+		}
+	}
+	return st
+}
+
+// List returns the power states sorted by hostname.
+func (m *Manager) List() []State {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	states := make([]State, 0, len(m.states))
+	for _, st := range m.states {
+		states = append(states, *st)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Hostname < states[j].Hostname })
+	return states
+}
+
+// Get returns the power state of a node.
+func (m *Manager) Get(hostname string) (State, error) {
+	if _, err := m.agents.Get(hostname); err != nil {
+		return State{}, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	st, ok := m.states[hostname]
+	if !ok {
+		return State{Hostname: hostname, State: StateUnknown}, nil
+	}
+	return *st, nil
+}
+
+// Wake sends the Wake-on-LAN magic packet to the MAC address the agent last
+// reported.
+func (m *Manager) Wake(hostname string) (State, error) {
+	a, st, err := m.begin(hostname, false)
+	if err != nil {
+		return State{}, err
+	}
+	defer m.lock.Unlock()
+	if a.MAC == "" {
+		return State{}, errors.Errorf(def.ErrGeneralBadRequest, "agent %s did not report a MAC address", hostname)
+	}
+	if err = m.wake(a.MAC); err != nil {
+		return State{}, errors.Wrap(err, def.ErrPowerAction, "send wake-on-lan packet failed")
+	}
+	m.set(st, StateWaking, time.Now(), "")
+	st.Deadline = st.Since.Add(m.timeout)
+	m.recordLocked(newEvent(types.EventNormal, hostname, ReasonWakeSent,
+		"wake-on-lan packet sent to %s", a.MAC))
+	return *st, nil
+}
+
+// Shutdown drains the node and powers it off through its agent.
+func (m *Manager) Shutdown(hostname string) (State, error) {
+	return m.powerOff(hostname, agent.PowerOff)
+}
+
+// Reboot drains the node and reboots it through its agent, the node is
+// uncordoned when the agent comes back.
+func (m *Manager) Reboot(hostname string) (State, error) {
+	return m.powerOff(hostname, agent.PowerReboot)
+}
+
+func (m *Manager) powerOff(hostname, action string) (State, error) {
+	a, st, err := m.begin(hostname, true)
+	if err != nil {
+		return State{}, err
+	}
+	defer m.lock.Unlock()
+	m.set(st, StateDraining, time.Now(), "")
+	st.Deadline = st.Since.Add(m.drainTimeout)
+	m.recordLocked(newEvent(types.EventNormal, hostname, ReasonDraining,
+		"draining node %s before %s", st.Node, action))
+	node := st.Node
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.drainAndPowerOff(a, node, action)
+	}()
+	return *st, nil
+}
+
+// begin looks up the agent and locks the manager for an action, the agent
+// must be online to power off and offline to wake.
+func (m *Manager) begin(hostname string, online bool) (registry.Agent, *State, error) {
+	a, err := m.agents.Get(hostname)
+	if err != nil {
+		return a, nil, err
+	}
+	if a.Online != online {
+		if online {
+			return a, nil, errors.Errorf(def.ErrGeneralConflict, "agent %s is offline", hostname)
+		}
+		return a, nil, errors.Errorf(def.ErrGeneralConflict, "node %s is already on", hostname)
+	}
+	node := m.nodes.NodeName(hostname)
+	m.lock.Lock()
+	st, ok := m.states[hostname]
+	if !ok {
+		st = &State{Hostname: hostname}
+		m.states[hostname] = st
+	}
+	if st.transitional() {
+		m.lock.Unlock()
+		return a, nil, errors.Errorf(def.ErrGeneralConflict, "node %s is %s", hostname, st.State)
+	}
+	st.Node = node
+	return a, st, nil
+}
+
+func (m *Manager) drainAndPowerOff(a registry.Agent, node, action string) {
+	var err error
+	reason := ReasonDrainFailed
+	if node != "" {
+		err = m.drainer.Drain(node, m.drainTimeout)
+	}
+	if err == nil {
+		reason = ReasonPowerActionFailed
+		err = m.requestPowerAction(a, action)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	st := m.states[a.Hostname]
+	now := time.Now()
+	if err != nil {
+		m.set(st, StateOn, now, err.Error())
+		m.recordLocked(newEvent(types.EventWarning, a.Hostname, reason, "%s aborted: %v", action, err))
+		if node != "" {
+			if uerr := m.drainer.Uncordon(node); uerr != nil {
+				logs.Error("uncordon node %s failed: %v", node, uerr)
+			}
+		}
+		return
+	}
+	if action == agent.PowerReboot {
+		m.set(st, StateRebooting, now, "")
+		m.recordLocked(newEvent(types.EventNormal, a.Hostname, ReasonRebootRequested, "node %s drained, rebooting", node))
+	} else {
+		m.set(st, StateShuttingDown, now, "")
+		m.recordLocked(newEvent(types.EventNormal, a.Hostname, ReasonPowerOffRequested, "node %s drained, powering off", node))
+	}
+	st.Deadline = now.Add(m.timeout)
+}
+
+// requestPowerAction asks the agent to power off or reboot the node.
+func (m *Manager) requestPowerAction(a registry.Agent, action string) error {
+	body, _ := json.Marshal(map[string]string{"action": action})
+	url := fmt.Sprintf("http://%s:%d/api/v1/power", a.Address, a.Port)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := conf.AgentToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("agent responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Events returns the latest power events of a node, newest first.
+func (m *Manager) Events(hostname string, limit int) ([]types.Event, error) {
+	events, err := m.events.ListEvents(types.EventFilter{Kind: EventKind, Object: hostname, Limit: limit})
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrPowerAction, "list power events failed")
+	}
+	return events, nil
+}
+
+func (m *Manager) set(st *State, state string, now time.Time, message string) {
+	st.State, st.Since, st.Message, st.Deadline = state, now, message, time.Time{}
+}
+
+func (m *Manager) record(events []types.Event) {
+	for i := range events {
+		m.recordLocked(events[i])
+	}
+}
+
+// recordLocked records an event, it does not take the manager lock and may
+// be called with it held.
+func (m *Manager) recordLocked(e types.Event) {
+	if e.Type == types.EventWarning {
+		logs.Warn("power %s: %s", e.Object, e.Message)
+	} else {
+		logs.Info("power %s: %s", e.Object, e.Message)
+	}
+	if err := m.events.AddEvent(&e); err != nil {
+		logs.Error("record power event failed: %v", err)
+	}
+}
+
+func newEvent(eventType, hostname, reason, format string, args ...interface{}) types.Event {
+	return types.Event{
+		Type:      eventType,
+		Kind:      EventKind,
+		Object:    hostname,
+		Reason:    reason,
+		Message:   fmt.Sprintf(format, args...),
+		CreatedAt: time.Now(),
+	}
+}
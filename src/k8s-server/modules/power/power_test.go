@@ -0,0 +1,297 @@
+package power
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s-server/def"
+	"k8s-server/models/filedb"
+	"k8s-server/models/types"
+	"k8s-server/modules/agent"
+	"k8s-server/modules/registry"
+	"k8s-server/utils/errors"
+)
+
+func TestSendWoL(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = SendWoL(conn.LocalAddr().String(), "0c:c4:7a:01:02:03"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 256)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Repeat([]byte{0xFF}, 6)
+	for i := 0; i < 16; i++ {
+		want = append(want, 0x0c, 0xc4, 0x7a, 0x01, 0x02, 0x03)
+	}
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("magic packet %x, want %x", buf[:n], want)
+	}
+	if _, err = MagicPacket("00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01"); err == nil {
+		t.Error("IPoIB address accepted")
+	}
+}
+
+type fakeAgents struct {
+	agents map[string]registry.Agent
+	lock   sync.Mutex
+}
+
+func (f *fakeAgents) Get(hostname string) (registry.Agent, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	a, ok := f.agents[hostname]
+	if !ok {
+		return a, errors.Errorf(def.ErrGeneralNotFound, "agent %s not found", hostname)
+	}
+	return a, nil
+}
+
+func (f *fakeAgents) List() []registry.Agent {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var agents []registry.Agent
+	for _, a := range f.agents {
+		agents = append(agents, a)
+	}
+	return agents
+}
+
+func (f *fakeAgents) set(hostname string, online bool, startedAt time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	a := f.agents[hostname]
+	a.Online = online
+	if !startedAt.IsZero() {
+		a.StartedAt = startedAt
+	}
+	f.agents[hostname] = a
+}
+
+type fakeNodes map[string]string
+
+func (f fakeNodes) NodeName(hostname string) string {
+	if node, ok := f[hostname]; ok {
+		return node
+	}
+	return hostname
+}
+
+type fakeDrainer struct {
+	// gate blocks the drains until it is closed
+	gate      chan struct{}
+	fail      error
+	drained   []string
+	uncordons []string
+	lock      sync.Mutex
+}
+
+func (f *fakeDrainer) Drain(node string, timeout time.Duration) error {
+	if f.gate != nil {
+		<-f.gate
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.drained = append(f.drained, node)
+	return f.fail
+}
+
+func (f *fakeDrainer) Uncordon(node string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.uncordons = append(f.uncordons, node)
+	return nil
+}
+
+func (f *fakeDrainer) reset() ([]string, []string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	drained, uncordons := f.drained, f.uncordons
+	f.drained, f.uncordons = nil, nil
+	return drained, uncordons
+}
+
+func hasCode(err error, code int) bool {
+	c := errors.ErrorCode(err)
+	return len(c) >= 4 && c[:4] == strconv.Itoa(code)
+}
+
+func state(t *testing.T, m *Manager, hostname, want string) State {
+	st, err := m.Get(hostname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != want {
+		t.Fatalf("%s is %s (%s), want %s", hostname, st.State, st.Message, want)
+	}
+	return st
+}
+
+func reasons(t *testing.T, m *Manager, hostname string) []string {
+	events, err := m.Events(hostname, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r []string
+	for _, e := range events {
+		r = append(r, e.Reason)
+	}
+	return r
+}
+
+func TestPowerActions(t *testing.T) {
+	var actions []string
+	var lock sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req agent.PowerRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		lock.Lock()
+		actions = append(actions, r.URL.Path+" "+req.Action)
+		lock.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	dir, err := ioutil.TempDir("", "power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := filedb.NewModel(filepath.Join(dir, "test.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now().Add(-time.Hour)
+	agents := &fakeAgents{agents: map[string]registry.Agent{
+		"node1": {Heartbeat: agent.Heartbeat{Hostname: "node1", Address: host, Port: portNum,
+			MAC: "0c:c4:7a:00:00:01", StartedAt: started}, Online: true},
+		"node2": {Heartbeat: agent.Heartbeat{Hostname: "node2", Address: host, Port: portNum,
+			MAC: "0c:c4:7a:00:00:02", StartedAt: started}},
+	}}
+	drainer := &fakeDrainer{}
+	var woken []string
+	m := newManager(agents, fakeNodes{"node2": "k8s-node2"}, drainer, store, func(mac string) error {
+		woken = append(woken, mac)
+		return nil
+	})
+
+	m.Sync()
+	state(t, m, "node1", StateOn)
+	state(t, m, "node2", StateUnknown)
+	if _, err = m.Get("node3"); !hasCode(err, def.ErrGeneralNotFound) {
+		t.Errorf("get unknown agent: %v", err)
+	}
+
+	// wake
+	if _, err = m.Wake("node1"); !hasCode(err, def.ErrGeneralConflict) {
+		t.Errorf("woke an online node: %v", err)
+	}
+	if _, err = m.Wake("node2"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(woken, []string{"0c:c4:7a:00:00:02"}) {
+		t.Errorf("woken %v", woken)
+	}
+	state(t, m, "node2", StateWaking)
+	agents.set("node2", true, time.Now())
+	m.Sync()
+	state(t, m, "node2", StateOn)
+	if _, uncordons := drainer.reset(); !reflect.DeepEqual(uncordons, []string{"k8s-node2"}) {
+		t.Errorf("uncordoned %v after wake, want [k8s-node2]", uncordons)
+	}
+
+	// shutdown
+	drainer.gate = make(chan struct{})
+	if _, err = m.Shutdown("node1"); err != nil {
+		t.Fatal(err)
+	}
+	state(t, m, "node1", StateDraining)
+	if _, err = m.Reboot("node1"); !hasCode(err, def.ErrGeneralConflict) {
+		t.Errorf("reboot accepted while draining: %v", err)
+	}
+	close(drainer.gate)
+	m.wg.Wait()
+	drainer.gate = nil
+	state(t, m, "node1", StateShuttingDown)
+	if drained, _ := drainer.reset(); !reflect.DeepEqual(drained, []string{"node1"}) {
+		t.Errorf("drained %v, want [node1]", drained)
+	}
+	agents.set("node1", false, time.Time{})
+	m.Sync()
+	state(t, m, "node1", StateOff)
+	want := []string{ReasonPoweredOff, ReasonPowerOffRequested, ReasonDraining}
+	if r := reasons(t, m, "node1"); !reflect.DeepEqual(r, want) {
+		t.Errorf("node1 events %v, want %v", r, want)
+	}
+
+	// reboot aborted by a failed drain
+	drainer.fail = fmt.Errorf("pdb blocks eviction")
+	if _, err = m.Reboot("node2"); err != nil {
+		t.Fatal(err)
+	}
+	m.wg.Wait()
+	st := state(t, m, "node2", StateOn)
+	if st.Message != "pdb blocks eviction" {
+		t.Errorf("aborted reboot message %q", st.Message)
+	}
+	if _, uncordons := drainer.reset(); !reflect.DeepEqual(uncordons, []string{"k8s-node2"}) {
+		t.Errorf("uncordoned %v after failed drain, want [k8s-node2]", uncordons)
+	}
+
+	// reboot, the node is back once the agent restarted
+	drainer.fail = nil
+	if _, err = m.Reboot("node2"); err != nil {
+		t.Fatal(err)
+	}
+	m.wg.Wait()
+	state(t, m, "node2", StateRebooting)
+	m.Sync()
+	state(t, m, "node2", StateRebooting)
+	agents.set("node2", true, time.Now())
+	m.Sync()
+	state(t, m, "node2", StateOn)
+	drainer.reset()
+
+	wantActions := []string{"/api/v1/power poweroff", "/api/v1/power reboot"}
+	if !reflect.DeepEqual(actions, wantActions) {
+		t.Errorf("agent actions %v, want %v", actions, wantActions)
+	}
+
+	// a wake that never comes back times out
+	m.timeout = -time.Second
+	if _, err = m.Wake("node1"); err != nil {
+		t.Fatal(err)
+	}
+	m.Sync()
+	state(t, m, "node1", StateUnknown)
+
+	// the state of a powered off node survives a restart
+	agents.set("node1", false, time.Time{})
+	off := newEvent(types.EventNormal, "node1", ReasonPoweredOff, "node1 is off")
+	store.AddEvent(&off)
+	restarted := newManager(agents, fakeNodes{}, drainer, store, nil)
+	restarted.Sync()
+	state(t, restarted, "node1", StateOff)
+}
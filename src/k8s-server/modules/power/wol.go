@@ -0,0 +1,40 @@
+package power
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// MagicPacket returns the Wake-on-LAN magic packet of the MAC address, six
+// 0xFF bytes followed by the address repeated 16 times.
+func MagicPacket(mac string) ([]byte, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("%s is not an EUI-48 address", mac)
+	}
+	packet := bytes.Repeat([]byte{0xFF}, 6)
+	for i := 0; i < 16; i++ {
+		packet = append(packet, hw...)
+	}
+	return packet, nil
+}
+
+// SendWoL sends the magic packet of the MAC address to the UDP broadcast
+// address, e.g. 255.255.255.255:9.
+func SendWoL(addr, mac string) error {
+	packet, err := MagicPacket(mac)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
@@ -0,0 +1,235 @@
+package registry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeRedis is an in-memory stand-in speaking the Redis protocol with the
+// commands used by the agent registry. Keys only expire through expire, the
+// TTLs set by clients are recorded in ttl.
+type fakeRedis struct {
+	listener net.Listener
+	lock     sync.Mutex
+	values   map[string]string
+	hashes   map[string]map[string]string
+	sets     map[string]map[string]bool
+	ttl      map[string]int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{
+		listener: l,
+		values:   make(map[string]string),
+		hashes:   make(map[string]map[string]string),
+		sets:     make(map[string]map[string]bool),
+		ttl:      make(map[string]int),
+	}
+	go r.serve()
+	return r
+}
+
+func (r *fakeRedis) pool() *redis.Pool {
+	addr := r.listener.Addr().String()
+	return &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", addr) }}
+}
+
+func (r *fakeRedis) close() {
+	r.listener.Close()
+}
+
+// expire drops the key as if its TTL ran out.
+func (r *fakeRedis) expire(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.values, key)
+	delete(r.hashes, key)
+	delete(r.ttl, key)
+}
+
+func (r *fakeRedis) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "MULTI":
+			inMulti, queued = true, nil
+			w.WriteString("+OK\r\n")
+		case cmd == "EXEC":
+			fmt.Fprintf(w, "*%d\r\n", len(queued))
+			for _, q := range queued {
+				r.exec(w, q)
+			}
+			inMulti = false
+		case inMulti:
+			queued = append(queued, args)
+			w.WriteString("+QUEUED\r\n")
+		default:
+			r.exec(w, args)
+		}
+		if br.Buffered() == 0 {
+			w.Flush()
+		}
+	}
+}
+
+func (r *fakeRedis) exec(w *bufio.Writer, args []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		w.WriteString("+PONG\r\n")
+	case "SET":
+		r.values[args[1]] = args[2]
+		if len(args) == 5 && strings.ToUpper(args[3]) == "EX" {
+			r.ttl[args[1]], _ = strconv.Atoi(args[4])
+		}
+		w.WriteString("+OK\r\n")
+	case "GET":
+		if v, ok := r.values[args[1]]; ok {
+			writeBulk(w, v)
+		} else {
+			w.WriteString("$-1\r\n")
+		}
+	case "HMSET", "HSET":
+		h := r.hashes[args[1]]
+		if h == nil {
+			h = make(map[string]string)
+			r.hashes[args[1]] = h
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			h[args[i]] = args[i+1]
+		}
+		if strings.ToUpper(args[0]) == "HSET" {
+			fmt.Fprintf(w, ":%d\r\n", (len(args)-2)/2)
+		} else {
+			w.WriteString("+OK\r\n")
+		}
+	case "HDEL":
+		for _, field := range args[2:] {
+			delete(r.hashes[args[1]], field)
+		}
+		fmt.Fprintf(w, ":%d\r\n", len(args)-2)
+	case "HGETALL":
+		h := r.hashes[args[1]]
+		keys := make([]string, 0, len(h))
+		for k := range h {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "*%d\r\n", 2*len(keys))
+		for _, k := range keys {
+			writeBulk(w, k)
+			writeBulk(w, h[k])
+		}
+	case "EXPIRE":
+		seconds, _ := strconv.Atoi(args[2])
+		r.ttl[args[1]] = seconds
+		w.WriteString(":1\r\n")
+	case "SADD", "SREM":
+		s := r.sets[args[1]]
+		if s == nil {
+			s = make(map[string]bool)
+			r.sets[args[1]] = s
+		}
+		for _, m := range args[2:] {
+			if strings.ToUpper(args[0]) == "SADD" {
+				s[m] = true
+			} else {
+				delete(s, m)
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", len(args)-2)
+	case "SMEMBERS":
+		members := make([]string, 0, len(r.sets[args[1]]))
+		for m := range r.sets[args[1]] {
+			members = append(members, m)
+		}
+		sort.Strings(members)
+		fmt.Fprintf(w, "*%d\r\n", len(members))
+		for _, m := range members {
+			writeBulk(w, m)
+		}
+	case "DEL":
+		for _, key := range args[1:] {
+			delete(r.values, key)
+			delete(r.hashes, key)
+			delete(r.sets, key)
+			delete(r.ttl, key)
+		}
+		fmt.Fprintf(w, ":%d\r\n", len(args)-1)
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
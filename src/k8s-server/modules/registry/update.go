@@ -0,0 +1,262 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models/types"
+	"k8s-server/modules/agent"
+	"k8s-server/utils/errors"
+)
+
+// Reasons of the agent update events.
+const (
+	ReasonUpdatePushed   = "UpdatePushed"
+	ReasonUpdateFailed   = "UpdateFailed"
+	ReasonUpdated        = "Updated"
+	ReasonUpdateTimedOut = "UpdateTimedOut"
+)
+
+// pushParallelism is the number of agents an update is pushed to at once.
+const pushParallelism = 8
+
+// VersionReport is the version skew between the server and the agents.
+type VersionReport struct {
+	ServerVersion string `json:"serverVersion"`
+	// Versions lists the online agents by version.
+	Versions map[string][]string `json:"versions"`
+	// Outdated lists the online agents not running the server version.
+	Outdated []string `json:"outdated"`
+	// Updating lists the agents with an unconfirmed update by target version.
+	Updating map[string]string `json:"updating"`
+}
+
+// Update is a signed agent binary, the signature covers the manifest of
+// agent.UpdateMessage.
+type Update struct {
+	Version        string
+	Binary         []byte
+	Expires        time.Time
+	AllowDowngrade bool
+	Signature      []byte
+}
+
+// manifest returns the signed manifest of the update.
+func (u Update) manifest() agent.UpdateManifest {
+	return agent.UpdateManifest{
+		Version:        u.Version,
+		Checksum:       agent.Checksum(u.Binary),
+		Expires:        u.Expires,
+		AllowDowngrade: u.AllowDowngrade,
+	}
+}
+
+// PushResult is the outcome of pushing an update to an agent.
+type PushResult struct {
+	Hostname string    `json:"hostname"`
+	Error    string    `json:"error,omitempty"`
+	Deadline time.Time `json:"deadline,omitempty"`
+}
+
+// pendingUpdate is an update pushed to an agent that did not report the new
+// version yet.
+type pendingUpdate struct {
+	version  string
+	previous string
+	deadline time.Time
+}
+
+// Versions returns the version skew of the online agents.
+func (m *Manager) Versions() VersionReport {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	report := VersionReport{
+		ServerVersion: m.version,
+		Versions:      make(map[string][]string),
+		Outdated:      []string{},
+		Updating:      make(map[string]string, len(m.pending)),
+	}
+	for hostname, a := range m.agents {
+		if !a.Online {
+			continue
+		}
+		report.Versions[a.Version] = append(report.Versions[a.Version], hostname)
+		if a.Version != m.version {
+			report.Outdated = append(report.Outdated, hostname)
+		}
+	}
+	for _, hostnames := range report.Versions {
+		sort.Strings(hostnames)
+	}
+	sort.Strings(report.Outdated)
+	for hostname, p := range m.pending {
+		report.Updating[hostname] = p.version
+	}
+	return report
+}
+
+// PushUpdate verifies the update and pushes it to the agents of hostnames,
+// to every online agent not running its version if hostnames is empty. An
+// agent restarts with the new binary and rolls back unless it heartbeats
+// within agent::UpdateConfirmTimeout, Sync records the outcome.
+func (m *Manager) PushUpdate(u Update, hostnames []string) ([]PushResult, error) {
+	if m.updateKey == nil {
+		return nil, errors.New(def.ErrGeneralForbidden, "agent updates are disabled")
+	}
+	manifest := u.manifest()
+	if err := agent.VerifyUpdate(m.updateKey, manifest, u.Signature); err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid agent update")
+	}
+
+	var targets []Agent
+	var results []PushResult
+	if len(hostnames) == 0 {
+		for _, a := range m.List() {
+			if a.Online && a.Version != u.Version {
+				targets = append(targets, a)
+			}
+		}
+	}
+	for _, hostname := range hostnames {
+		a, err := m.Get(hostname)
+		switch {
+		case err != nil:
+			results = append(results, PushResult{Hostname: hostname, Error: err.Error()})
+		case !a.Online:
+			results = append(results, PushResult{Hostname: hostname, Error: "agent is offline"})
+		default:
+			targets = append(targets, a)
+		}
+	}
+
+	pushed := make([]PushResult, len(targets))
+	sem := make(chan struct{}, pushParallelism)
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			pushed[i] = m.push(targets[i], u, manifest)
+		}(i)
+	}
+	wg.Wait()
+
+	var events []types.Event
+	m.lock.Lock()
+	for i, r := range pushed {
+		a := targets[i]
+		if r.Error != "" {
+			events = append(events, newEvent(types.EventWarning, a.Hostname, ReasonUpdateFailed,
+				"push agent %s failed: %s", u.Version, r.Error))
+			continue
+		}
+		// the agent confirms with its first heartbeat, allow one more
+		// registry sync to see it
+		m.pending[a.Hostname] = pendingUpdate{
+			version:  u.Version,
+			previous: a.Version,
+			deadline: r.Deadline.Add(conf.HeartbeatInterval()),
+		}
+		events = append(events, newEvent(types.EventNormal, a.Hostname, ReasonUpdatePushed,
+			"agent %s pushed, replacing %s", u.Version, a.Version))
+	}
+	m.lock.Unlock()
+	m.record(events)
+
+	results = append(results, pushed...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Hostname < results[j].Hostname })
+	return results, nil
+}
+
+// push uploads the update to the agent API.
+func (m *Manager) push(a Agent, u Update, manifest agent.UpdateManifest) PushResult {
+	result := PushResult{Hostname: a.Hostname}
+	url := fmt.Sprintf("http://%s:%d/api/v1/update", a.Address, a.Port)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(u.Binary))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(agent.HeaderUpdateVersion, manifest.Version)
+	req.Header.Set(agent.HeaderUpdateChecksum, manifest.Checksum)
+	req.Header.Set(agent.HeaderUpdateExpires, strconv.FormatInt(manifest.Expires.Unix(), 10))
+	req.Header.Set(agent.HeaderUpdateAllowDowngrade, strconv.FormatBool(manifest.AllowDowngrade))
+	req.Header.Set(agent.HeaderUpdateSignature, base64.StdEncoding.EncodeToString(u.Signature))
+	if token := conf.AgentToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusAccepted {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			result.Error = fmt.Sprintf("agent responded %s: %s", resp.Status, e.Error)
+		} else {
+			result.Error = "agent responded " + resp.Status
+		}
+		return result
+	}
+	var p agent.PendingUpdate
+	if err = json.Unmarshal(body, &p); err != nil {
+		result.Error = fmt.Sprintf("invalid agent response: %v", err)
+		return result
+	}
+	result.Deadline = p.Deadline
+	return result
+}
+
+// checkUpdates records the pending updates the agents confirmed by reporting
+// the new version or did not confirm in time, the caller holds the lock.
+func (m *Manager) checkUpdates(now time.Time) []types.Event {
+	var events []types.Event
+	for hostname, p := range m.pending {
+		a, ok := m.agents[hostname]
+		switch {
+		case ok && a.Online && a.Version == p.version:
+			events = append(events, newEvent(types.EventNormal, hostname, ReasonUpdated,
+				"agent updated from %s to %s", p.previous, p.version))
+		case now.After(p.deadline):
+			events = append(events, newEvent(types.EventWarning, hostname, ReasonUpdateTimedOut,
+				"agent %s did not heartbeat before %s and rolls back to %s", p.version,
+				p.deadline.Format(time.RFC3339), p.previous))
+		default:
+			continue
+		}
+		delete(m.pending, hostname)
+	}
+	return events
+}
+
+// parseUpdateKey decodes agent::UpdatePublicKey, updates are disabled if it
+// is empty.
+func parseUpdateKey(key string) (ed25519.PublicKey, error) {
+	if key == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid agent::UpdatePublicKey")
+	}
+	return ed25519.PublicKey(b), nil
+}
@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/crypto/ed25519"
+
+	"k8s-server/models/filedb"
+	"k8s-server/modules/agent"
+)
+
+// fakeAgent accepts updates and reports the given confirmation deadline.
+func fakeAgent(t *testing.T, publicKey ed25519.PublicKey, deadline time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		binary, _ := ioutil.ReadAll(r.Body)
+		signature, _ := base64.StdEncoding.DecodeString(r.Header.Get(agent.HeaderUpdateSignature))
+		version := r.Header.Get(agent.HeaderUpdateVersion)
+		checksum := r.Header.Get(agent.HeaderUpdateChecksum)
+		expires, _ := strconv.ParseInt(r.Header.Get(agent.HeaderUpdateExpires), 10, 64)
+		downgrade, _ := strconv.ParseBool(r.Header.Get(agent.HeaderUpdateAllowDowngrade))
+		manifest := agent.UpdateManifest{Version: version, Checksum: checksum,
+			Expires: time.Unix(expires, 0), AllowDowngrade: downgrade}
+		if r.URL.Path != "/api/v1/update" || checksum != agent.Checksum(binary) ||
+			agent.VerifyUpdate(publicKey, manifest, signature) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(agent.PendingUpdate{Version: version, Deadline: deadline})
+	}))
+}
+
+func publishAgent(t *testing.T, pool *redis.Pool, hostname, version, url string) {
+	host, port, _ := net.SplitHostPort(url[len("http://"):])
+	hb := agent.Heartbeat{Hostname: hostname, Address: host, Version: version,
+		StartedAt: time.Now(), SentAt: time.Now()}
+	hb.Port, _ = strconv.Atoi(port)
+	conn := pool.Get()
+	defer conn.Close()
+	if err := agent.PublishHeartbeat(conn, hb, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushUpdate(t *testing.T) {
+	fake := newFakeRedis(t)
+	defer fake.close()
+	pool := fake.pool()
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := filedb.NewModel(filepath.Join(dir, "test.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(pool, agent.NewRedisDiscovery(pool), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.version = "1.1.0"
+
+	healthy := fakeAgent(t, publicKey, time.Now().Add(time.Minute))
+	defer healthy.Close()
+	broken := fakeAgent(t, publicKey, time.Now().Add(-time.Minute))
+	defer broken.Close()
+	publishAgent(t, pool, "node1", "1.0.0", healthy.URL)
+	publishAgent(t, pool, "node2", "1.0.0", broken.URL)
+	publishAgent(t, pool, "node3", "1.1.0", healthy.URL)
+	syncAgents(t, m)
+
+	v := m.Versions()
+	if len(v.Outdated) != 2 || v.Outdated[0] != "node1" || len(v.Versions["1.1.0"]) != 1 {
+		t.Errorf("unexpected version report %+v", v)
+	}
+
+	binary := []byte("agent 1.1.0")
+	update := Update{Version: "1.1.0", Binary: binary, Expires: time.Now().Add(time.Hour).Truncate(time.Second)}
+	update.Signature = ed25519.Sign(privateKey, agent.UpdateMessage(update.manifest()))
+	if _, err = m.PushUpdate(update, nil); err == nil {
+		t.Error("push with updates disabled succeeded")
+	}
+	m.updateKey = publicKey
+	forged := update
+	forged.Binary = []byte("agent 6.6.6")
+	if _, err = m.PushUpdate(forged, nil); err == nil {
+		t.Error("push of a forged binary succeeded")
+	}
+	expired := update
+	expired.Expires = time.Now().Add(-time.Minute)
+	expired.Signature = ed25519.Sign(privateKey, agent.UpdateMessage(expired.manifest()))
+	if _, err = m.PushUpdate(expired, nil); err == nil {
+		t.Error("push of an expired update succeeded")
+	}
+
+	results, err := m.PushUpdate(update, nil)
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Hostname != "node1" || results[0].Error != "" ||
+		results[1].Hostname != "node2" || results[1].Error != "" {
+		t.Fatalf("unexpected push results %+v", results)
+	}
+	results, err = m.PushUpdate(update, []string{"node4"})
+	if err != nil || len(results) != 1 || results[0].Error == "" {
+		t.Errorf("push to unknown agent returned %+v, %v", results, err)
+	}
+	if v = m.Versions(); len(v.Updating) != 2 {
+		t.Errorf("updating agents = %v, want node1 and node2", v.Updating)
+	}
+
+	publishAgent(t, pool, "node1", "1.1.0", healthy.URL)
+	syncAgents(t, m)
+	if r := reasons(t, m, "node1"); len(r) != 3 || r[0] != ReasonUpdated {
+		t.Errorf("node1 events = %v, want Updated, VersionChanged, UpdatePushed", r)
+	}
+	if r := reasons(t, m, "node2"); len(r) != 2 || r[0] != ReasonUpdateTimedOut {
+		t.Errorf("node2 events = %v, want UpdateTimedOut, UpdatePushed", r)
+	}
+	if v = m.Versions(); len(v.Updating) != 0 || len(v.Outdated) != 1 {
+		t.Errorf("unexpected version report %+v", v)
+	}
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s-server/utils/kube"
+)
+
+// annotationDefaultClass marks the default storage class.
+const annotationDefaultClass = "storageclass.kubernetes.io/is-default-class"
+
+// kubeStorage is the cluster of backend::KubeConfig.
+type kubeStorage struct{}
+
+func (kubeStorage) ListVolumes() ([]Volume, error) {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return nil, err
+	}
+	list, err := cs.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list persistent volumes failed: %v", err)
+	}
+	volumes := make([]Volume, 0, len(list.Items))
+	for _, pv := range list.Items {
+		volumes = append(volumes, newVolume(&pv))
+	}
+	return volumes, nil
+}
+
+func (kubeStorage) ListClaims(namespace string) ([]Claim, error) {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return nil, err
+	}
+	list, err := cs.CoreV1().PersistentVolumeClaims(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list persistent volume claims failed: %v", err)
+	}
+	claims := make([]Claim, 0, len(list.Items))
+	for _, pvc := range list.Items {
+		c := Claim{
+			Namespace:   pvc.Namespace,
+			Name:        pvc.Name,
+			Phase:       string(pvc.Status.Phase),
+			Volume:      pvc.Spec.VolumeName,
+			AccessModes: accessModes(pvc.Spec.AccessModes),
+		}
+		if q, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+			c.Request = q.Value()
+		}
+		if q, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+			c.Capacity = q.Value()
+		}
+		if pvc.Spec.StorageClassName != nil {
+			c.StorageClass = *pvc.Spec.StorageClassName
+		}
+		claims = append(claims, c)
+	}
+	return claims, nil
+}
+
+func (kubeStorage) ListStorageClasses() ([]StorageClass, error) {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return nil, err
+	}
+	list, err := cs.StorageV1().StorageClasses().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list storage classes failed: %v", err)
+	}
+	classes := make([]StorageClass, 0, len(list.Items))
+	for _, sc := range list.Items {
+		c := StorageClass{
+			Name:        sc.Name,
+			Provisioner: sc.Provisioner,
+			Default:     sc.Annotations[annotationDefaultClass] == "true",
+		}
+		if sc.ReclaimPolicy != nil {
+			c.ReclaimPolicy = string(*sc.ReclaimPolicy)
+		}
+		if sc.VolumeBindingMode != nil {
+			c.VolumeBindingMode = string(*sc.VolumeBindingMode)
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+func (kubeStorage) ListMounts(namespace string) (map[string][]Mount, error) {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return nil, err
+	}
+	pods, err := cs.CoreV1().Pods(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list pods failed: %v", err)
+	}
+	mounts := make(map[string][]Mount)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim == nil {
+				continue
+			}
+			mounts[pod.Namespace] = append(mounts[pod.Namespace], Mount{
+				Pod:      pod.Name,
+				Node:     pod.Spec.NodeName,
+				ReadOnly: v.PersistentVolumeClaim.ReadOnly,
+				Claim:    v.PersistentVolumeClaim.ClaimName,
+			})
+		}
+	}
+	return mounts, nil
+}
+
+func (kubeStorage) CreateVolume(t *volumeTemplate) (*Volume, error) {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return nil, err
+	}
+	capacity, err := resource.ParseQuantity(t.Capacity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid capacity %q: %v", t.Capacity, err)
+	}
+	mode := corev1.ReadWriteMany
+	if t.ReadOnly {
+		mode = corev1.ReadOnlyMany
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: t.Name, Labels: t.Labels},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: capacity},
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{mode},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			StorageClassName:              t.StorageClass,
+		},
+	}
+	if t.Server != "" {
+		pv.Spec.NFS = &corev1.NFSVolumeSource{Server: t.Server, Path: t.Path, ReadOnly: t.ReadOnly}
+	} else {
+		pathType := corev1.HostPathDirectoryOrCreate
+		pv.Spec.HostPath = &corev1.HostPathVolumeSource{Path: t.Path, Type: &pathType}
+	}
+	if t.ClaimNamespace != "" {
+		// reserve the volume for its claim
+		pv.Spec.ClaimRef = &corev1.ObjectReference{Namespace: t.ClaimNamespace, Name: t.Name}
+	}
+	created, err := cs.CoreV1().PersistentVolumes().Create(pv)
+	if err != nil {
+		return nil, fmt.Errorf("create persistent volume %s failed: %v", t.Name, err)
+	}
+	if t.ClaimNamespace != "" {
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: t.Name, Namespace: t.ClaimNamespace, Labels: t.Labels},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      []corev1.PersistentVolumeAccessMode{mode},
+				StorageClassName: &t.StorageClass,
+				VolumeName:       t.Name,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: capacity},
+				},
+			},
+		}
+		if _, err = cs.CoreV1().PersistentVolumeClaims(t.ClaimNamespace).Create(pvc); err != nil {
+			return nil, fmt.Errorf("create persistent volume claim %s/%s failed: %v", t.ClaimNamespace, t.Name, err)
+		}
+	}
+	v := newVolume(created)
+	return &v, nil
+}
+
+func (kubeStorage) DeleteVolume(name string) error {
+	cs, err := kube.Clientset()
+	if err != nil {
+		return err
+	}
+	if err = cs.CoreV1().PersistentVolumes().Delete(name, &metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("delete persistent volume %s failed: %v", name, err)
+	}
+	return nil
+}
+
+func newVolume(pv *corev1.PersistentVolume) Volume {
+	v := Volume{
+		Name:          pv.Name,
+		AccessModes:   accessModes(pv.Spec.AccessModes),
+		ReclaimPolicy: string(pv.Spec.PersistentVolumeReclaimPolicy),
+		StorageClass:  pv.Spec.StorageClassName,
+		Phase:         string(pv.Status.Phase),
+		Owner:         pv.Labels[LabelOwner],
+		Kind:          pv.Labels[LabelKind],
+		CreatedAt:     pv.CreationTimestamp.Time,
+	}
+	if q, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok {
+		v.Capacity = q.Value()
+	}
+	if ref := pv.Spec.ClaimRef; ref != nil && pv.Status.Phase == corev1.VolumeBound {
+		v.Claim = ref.Namespace + "/" + ref.Name
+	}
+	switch {
+	case pv.Spec.HostPath != nil:
+		v.Source, v.Path = SourceHostPath, pv.Spec.HostPath.Path
+	case pv.Spec.NFS != nil:
+		v.Source, v.Server, v.Path = SourceNFS, pv.Spec.NFS.Server, pv.Spec.NFS.Path
+	case pv.Spec.CSI != nil:
+		v.Source = "csi:" + pv.Spec.CSI.Driver
+	default:
+		v.Source = "other"
+	}
+	return v
+}
+
+func accessModes(modes []corev1.PersistentVolumeAccessMode) []string {
+	s := make([]string, 0, len(modes))
+	for _, m := range modes {
+		s = append(s, string(m))
+	}
+	return s
+}
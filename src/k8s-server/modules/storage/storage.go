@@ -0,0 +1,373 @@
+// Package storage manages the volumes of the cluster shared filesystem. It
+// lists the PersistentVolumes, claims and storage classes, provisions static
+// volumes below the SharedStoragePath for users and projects, and reports the
+// filesystem usage read by the node agents.
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/modules/account"
+	"k8s-server/modules/agent/sysinfo"
+	"k8s-server/modules/inventory"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+// Labels of the provisioned volumes.
+const (
+	LabelOwner = "storage.k8s-server/owner"
+	LabelKind  = "storage.k8s-server/kind"
+)
+
+// Owner kinds of the provisioned volumes.
+const (
+	KindUser    = "user"
+	KindProject = "project"
+)
+
+// Volume sources.
+const (
+	SourceHostPath = "hostPath"
+	SourceNFS      = "nfs"
+)
+
+var (
+	reName     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,50}[a-z0-9])?$`)
+	reQuantity = regexp.MustCompile(`^[1-9][0-9]*([KMGTPE]i|[kMGTPE])?$`)
+)
+
+// Volume is a PersistentVolume, Claim is the namespace/name of the claim
+// bound to it.
+type Volume struct {
+	Name          string    `json:"name"`
+	Capacity      int64     `json:"capacity"`
+	AccessModes   []string  `json:"accessModes"`
+	ReclaimPolicy string    `json:"reclaimPolicy"`
+	StorageClass  string    `json:"storageClass"`
+	Phase         string    `json:"phase"`
+	Claim         string    `json:"claim,omitempty"`
+	Source        string    `json:"source"`
+	Server        string    `json:"server,omitempty"`
+	Path          string    `json:"path,omitempty"`
+	Owner         string    `json:"owner,omitempty"`
+	Kind          string    `json:"kind,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// Claim is a PersistentVolumeClaim with the pods mounting it.
+type Claim struct {
+	Namespace    string   `json:"namespace"`
+	Name         string   `json:"name"`
+	Phase        string   `json:"phase"`
+	Volume       string   `json:"volume"`
+	Request      int64    `json:"request"`
+	Capacity     int64    `json:"capacity"`
+	AccessModes  []string `json:"accessModes"`
+	StorageClass string   `json:"storageClass"`
+	MountedBy    []Mount  `json:"mountedBy"`
+}
+
+// Mount is a pod mounting a claim.
+type Mount struct {
+	Pod      string `json:"pod"`
+	Node     string `json:"node"`
+	ReadOnly bool   `json:"readOnly"`
+	// Claim is the claim name in the namespace of the pod.
+	Claim string `json:"-"`
+}
+
+// StorageClass is a storage class of the cluster.
+type StorageClass struct {
+	Name              string `json:"name"`
+	Provisioner       string `json:"provisioner"`
+	ReclaimPolicy     string `json:"reclaimPolicy"`
+	VolumeBindingMode string `json:"volumeBindingMode"`
+	Default           bool   `json:"default"`
+}
+
+// Usage is the usage of a filesystem holding the shared storage as reported
+// by the agents mounting it, sizes are in bytes.
+type Usage struct {
+	Device     string    `json:"device"`
+	Type       string    `json:"type"`
+	MountPoint string    `json:"mountPoint"`
+	Total      uint64    `json:"total"`
+	Used       uint64    `json:"used"`
+	Available  uint64    `json:"available"`
+	Nodes      []string  `json:"nodes"`
+	ReportedAt time.Time `json:"reportedAt"`
+}
+
+// ProvisionRequest asks for the shared volume of a user or project. A claim
+// bound to the volume is created in Namespace if it is set.
+type ProvisionRequest struct {
+	Kind      string `json:"kind"`
+	Owner     string `json:"owner"`
+	Capacity  string `json:"capacity"`
+	ReadOnly  bool   `json:"readOnly"`
+	Namespace string `json:"namespace"`
+}
+
+// volumeTemplate is a static volume and its optional claim.
+type volumeTemplate struct {
+	Name           string
+	Labels         map[string]string
+	Capacity       string
+	ReadOnly       bool
+	StorageClass   string
+	Server         string
+	Path           string
+	ClaimNamespace string
+}
+
+// cluster reads and creates the storage objects.
+type cluster interface {
+	ListVolumes() ([]Volume, error)
+	ListClaims(namespace string) ([]Claim, error)
+	ListStorageClasses() ([]StorageClass, error)
+	// ListMounts returns the claims mounted by running pods by namespace.
+	ListMounts(namespace string) (map[string][]Mount, error)
+	// CreateVolume creates the volume and, if ClaimNamespace is set, a
+	// claim of the same name bound to it.
+	CreateVolume(t *volumeTemplate) (*Volume, error)
+	// DeleteVolume deletes a volume, the data is retained.
+	DeleteVolume(name string) error
+}
+
+// sysinfoSource returns the latest inventory of the agents by hostname.
+type sysinfoSource interface {
+	Sysinfo() map[string]sysinfo.Info
+}
+
+// Manager represents the shared storage manager.
+type Manager struct {
+	cluster cluster
+	agents  sysinfoSource
+	// sharedPath is the shared filesystem on the nodes, localPath is where
+	// this server mounts it
+	sharedPath string
+	localPath  string
+	mkdir      func(path string) error
+}
+
+// NewManager returns the storage manager of the shared filesystem, the usage
+// is read from the filesystems the inventory collected from the agents.
+func NewManager(nodes *inventory.Manager) (*Manager, error) {
+	return &Manager{
+		cluster:    kubeStorage{},
+		agents:     nodes,
+		sharedPath: conf.SharedStoragePath(),
+		localPath:  conf.ClientMountPath(),
+		mkdir:      func(path string) error { return os.MkdirAll(path, 0755) },
+	}, nil
+}
+
+// ListVolumes returns the PersistentVolumes.
+func (m *Manager) ListVolumes() ([]Volume, error) {
+	volumes, err := m.cluster.ListVolumes()
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrStorageList, "list volumes failed")
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+// ListClaims returns the claims of a namespace, of all namespaces if it is
+// empty, with the pods mounting them.
+func (m *Manager) ListClaims(namespace string) ([]Claim, error) {
+	claims, err := m.cluster.ListClaims(namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrStorageList, "list claims failed")
+	}
+	mounts, err := m.cluster.ListMounts(namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrStorageList, "list pods failed")
+	}
+	for i := range claims {
+		c := &claims[i]
+		c.MountedBy = []Mount{}
+		for _, mount := range mounts[c.Namespace] {
+			if mount.Claim == c.Name {
+				c.MountedBy = append(c.MountedBy, mount)
+			}
+		}
+	}
+	sort.Slice(claims, func(i, j int) bool {
+		if claims[i].Namespace != claims[j].Namespace {
+			return claims[i].Namespace < claims[j].Namespace
+		}
+		return claims[i].Name < claims[j].Name
+	})
+	return claims, nil
+}
+
+// ListStorageClasses returns the storage classes.
+func (m *Manager) ListStorageClasses() ([]StorageClass, error) {
+	classes, err := m.cluster.ListStorageClasses()
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrStorageList, "list storage classes failed")
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i].Name < classes[j].Name })
+	return classes, nil
+}
+
+// Provision creates the static volume of a user or project below the shared
+// path, an NFS volume if storage::NFSServer is set and a hostPath volume
+// otherwise. The directory is created through the local mount of the shared
+// filesystem. Users provision their own volume, the uid::Admins provision
+// any volume and the project volumes.
+func (m *Manager) Provision(caller string, req ProvisionRequest) (*Volume, error) {
+	if !reName.MatchString(req.Owner) {
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "invalid owner %q", req.Owner)
+	}
+	if (req.Kind != KindUser || req.Owner != caller) && !account.IsAdmin(caller) {
+		return nil, errors.Errorf(def.ErrGeneralForbidden, "%s may not provision the %s volume of %s",
+			caller, req.Kind, req.Owner)
+	}
+	if req.Namespace != "" && !reName.MatchString(req.Namespace) {
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "invalid namespace %q", req.Namespace)
+	}
+	if !reQuantity.MatchString(req.Capacity) {
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "invalid capacity %q, e.g. 100Gi", req.Capacity)
+	}
+	var dir string
+	switch req.Kind {
+	case KindUser:
+		// the user directories share the shared path with the project
+		// directory, a user named like it would own every project
+		if req.Owner == strings.SplitN(path.Clean(conf.ProjectStorageDir()), "/", 2)[0] {
+			return nil, errors.Errorf(def.ErrGeneralBadRequest, "user %q is reserved for the project volumes", req.Owner)
+		}
+		dir = req.Owner
+	case KindProject:
+		dir = path.Join(conf.ProjectStorageDir(), req.Owner)
+	default:
+		return nil, errors.Errorf(def.ErrGeneralBadRequest, "unknown owner kind %q", req.Kind)
+	}
+	t := &volumeTemplate{
+		Name:           VolumeName(req.Kind, req.Owner),
+		Labels:         map[string]string{LabelOwner: req.Owner, LabelKind: req.Kind},
+		Capacity:       req.Capacity,
+		ReadOnly:       req.ReadOnly,
+		StorageClass:   conf.SharedStorageClass(),
+		Path:           path.Join(m.sharedPath, dir),
+		ClaimNamespace: req.Namespace,
+	}
+	if server := conf.SharedStorageNFSServer(); server != "" {
+		t.Server = server
+		t.Path = path.Join(conf.SharedStorageNFSExport(), dir)
+	}
+
+	volumes, err := m.cluster.ListVolumes()
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrStorageProvision, "list volumes failed")
+	}
+	for _, v := range volumes {
+		if v.Name == t.Name {
+			return nil, errors.Errorf(def.ErrGeneralConflict, "volume %s already exists", t.Name)
+		}
+	}
+	if err = m.mkdir(filepath.Join(m.localPath, dir)); err != nil {
+		if t.Server != "" {
+			return nil, errors.Wrap(err, def.ErrStorageProvision, "create the volume directory failed")
+		}
+		// the hostPath volume creates it on the node
+		logs.Warn("create the directory of volume %s failed: %v", t.Name, err)
+	}
+	v, err := m.cluster.CreateVolume(t)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrStorageProvision, "create volume failed")
+	}
+	logs.Info("shared volume %s provisioned at %s", v.Name, t.Path)
+	return v, nil
+}
+
+// DeleteVolume deletes a provisioned volume, the directory and its data are
+// kept. Volumes that were not provisioned here are refused, only the
+// uid::Admins delete volumes.
+func (m *Manager) DeleteVolume(caller, name string) error {
+	if !account.IsAdmin(caller) {
+		return errors.Errorf(def.ErrGeneralForbidden, "%s may not delete volumes", caller)
+	}
+	volumes, err := m.cluster.ListVolumes()
+	if err != nil {
+		return errors.Wrap(err, def.ErrStorageDelete, "list volumes failed")
+	}
+	for _, v := range volumes {
+		if v.Name != name {
+			continue
+		}
+		if v.Owner == "" {
+			return errors.Errorf(def.ErrGeneralForbidden, "volume %s is not a shared volume", name)
+		}
+		if v.Claim != "" {
+			return errors.Errorf(def.ErrGeneralConflict, "volume %s is bound to %s", name, v.Claim)
+		}
+		if err = m.cluster.DeleteVolume(name); err != nil {
+			return errors.Wrap(err, def.ErrStorageDelete, "delete volume failed")
+		}
+		return nil
+	}
+	return errors.Errorf(def.ErrGeneralNotFound, "volume %s not found", name)
+}
+
+// Usage returns the filesystems holding the shared path as reported by the
+// agents, a filesystem mounted by several nodes is reported once with the
+// figures of the latest report.
+func (m *Manager) Usage() []Usage {
+	byDevice := make(map[string]*Usage)
+	for hostname, info := range m.agents.Sysinfo() {
+		fs, ok := holding(info.FileSystems, m.sharedPath)
+		if !ok {
+			continue
+		}
+		u, ok := byDevice[fs.Device]
+		if !ok {
+			u = &Usage{Device: fs.Device, Type: fs.Type, MountPoint: fs.MountPoint}
+			byDevice[fs.Device] = u
+		}
+		u.Nodes = append(u.Nodes, hostname)
+		if !info.CollectedAt.Before(u.ReportedAt) {
+			u.Total, u.Used, u.Available = fs.Total, fs.Used, fs.Available
+			u.ReportedAt = info.CollectedAt
+		}
+	}
+	usage := make([]Usage, 0, len(byDevice))
+	for _, u := range byDevice {
+		sort.Strings(u.Nodes)
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Device < usage[j].Device })
+	return usage
+}
+
+// VolumeName returns the name of the shared volume of a user or project.
+func VolumeName(kind, owner string) string {
+	return fmt.Sprintf("shared-%s-%s", kind, owner)
+}
+
+// holding returns the filesystem with the longest mount point containing p.
+func holding(filesystems []sysinfo.FileSystem, p string) (sysinfo.FileSystem, bool) {
+	var best sysinfo.FileSystem
+	found := false
+	for _, fs := range filesystems {
+		mp := strings.TrimRight(fs.MountPoint, "/")
+		if p != mp && !strings.HasPrefix(p, mp+"/") {
+			continue
+		}
+		if !found || len(fs.MountPoint) > len(best.MountPoint) {
+			best, found = fs, true
+		}
+	}
+	return best, found
+}
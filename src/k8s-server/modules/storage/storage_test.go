@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego"
+
+	"k8s-server/def"
+	"k8s-server/modules/agent/sysinfo"
+	"k8s-server/utils/errors"
+)
+
+type fakeCluster struct {
+	volumes []Volume
+	claims  []Claim
+	mounts  map[string][]Mount
+	created []*volumeTemplate
+	deleted []string
+}
+
+func (f *fakeCluster) ListVolumes() ([]Volume, error) {
+	return append([]Volume(nil), f.volumes...), nil
+}
+
+func (f *fakeCluster) ListClaims(namespace string) ([]Claim, error) {
+	var claims []Claim
+	for _, c := range f.claims {
+		if namespace == "" || c.Namespace == namespace {
+			claims = append(claims, c)
+		}
+	}
+	return claims, nil
+}
+
+func (f *fakeCluster) ListStorageClasses() ([]StorageClass, error) {
+	return []StorageClass{{Name: "shared"}, {Name: "local", Default: true}}, nil
+}
+
+func (f *fakeCluster) ListMounts(namespace string) (map[string][]Mount, error) {
+	return f.mounts, nil
+}
+
+func (f *fakeCluster) CreateVolume(t *volumeTemplate) (*Volume, error) {
+	f.created = append(f.created, t)
+	v := Volume{Name: t.Name, Path: t.Path, Server: t.Server, Owner: t.Labels[LabelOwner], Kind: t.Labels[LabelKind]}
+	f.volumes = append(f.volumes, v)
+	return &v, nil
+}
+
+func (f *fakeCluster) DeleteVolume(name string) error {
+	f.deleted = append(f.deleted, name)
+	return nil
+}
+
+type fakeAgents map[string]sysinfo.Info
+
+func (f fakeAgents) Sysinfo() map[string]sysinfo.Info { return f }
+
+func hasCode(err error, code int) bool {
+	c := errors.ErrorCode(err)
+	return len(c) >= 4 && c[:4] == strconv.Itoa(code)
+}
+
+func newTestManager(c *fakeCluster, agents fakeAgents) (*Manager, *[]string) {
+	var dirs []string
+	return &Manager{
+		cluster:    c,
+		agents:     agents,
+		sharedPath: "/share",
+		localPath:  "/mnt/share",
+		mkdir: func(path string) error {
+			dirs = append(dirs, path)
+			return nil
+		},
+	}, &dirs
+}
+
+func TestProvision(t *testing.T) {
+	beego.AppConfig.Set("uid::Admins", "root")
+	defer beego.AppConfig.Set("uid::Admins", "")
+	c := &fakeCluster{}
+	m, dirs := newTestManager(c, nil)
+
+	if _, err := m.Provision("alice", ProvisionRequest{Kind: KindUser, Owner: "alice", Capacity: "100Gi", Namespace: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Provision("root", ProvisionRequest{Kind: KindProject, Owner: "md", Capacity: "1Ti", ReadOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.created) != 2 {
+		t.Fatalf("%d volumes created, want 2", len(c.created))
+	}
+	user, project := c.created[0], c.created[1]
+	if user.Name != "shared-user-alice" || user.Path != "/share/alice" || user.Server != "" ||
+		user.ClaimNamespace != "alice" || user.StorageClass != "shared" {
+		t.Errorf("user volume is %+v", user)
+	}
+	if project.Name != "shared-project-md" || project.Path != "/share/projects/md" || !project.ReadOnly ||
+		project.ClaimNamespace != "" {
+		t.Errorf("project volume is %+v", project)
+	}
+	if want := []string{"/mnt/share/alice", "/mnt/share/projects/md"}; !reflect.DeepEqual(*dirs, want) {
+		t.Errorf("directories %v created, want %v", *dirs, want)
+	}
+
+	if _, err := m.Provision("alice", ProvisionRequest{Kind: KindUser, Owner: "alice", Capacity: "1Gi"}); !hasCode(err, def.ErrGeneralConflict) {
+		t.Errorf("provision an existing volume: %v", err)
+	}
+	for _, req := range []ProvisionRequest{
+		{Kind: KindUser, Owner: "Bob", Capacity: "1Gi"},
+		{Kind: KindUser, Owner: "bob", Capacity: "1 GB"},
+		{Kind: KindUser, Owner: "bob"},
+		{Kind: KindUser, Owner: "projects", Capacity: "1Gi"},
+		{Kind: "group", Owner: "bob", Capacity: "1Gi"},
+		{Kind: KindUser, Owner: "bob", Capacity: "1Gi", Namespace: "../x"},
+	} {
+		if _, err := m.Provision("root", req); !hasCode(err, def.ErrGeneralBadRequest) {
+			t.Errorf("provision %+v: %v", req, err)
+		}
+	}
+}
+
+func TestProvisionNFS(t *testing.T) {
+	beego.AppConfig.Set("storage::NFSServer", "nas1")
+	beego.AppConfig.Set("storage::NFSExport", "/export/home")
+	defer func() {
+		// empty values fall back to the defaults
+		beego.AppConfig.Set("storage::NFSServer", "")
+		beego.AppConfig.Set("storage::NFSExport", "")
+	}()
+	c := &fakeCluster{}
+	m, _ := newTestManager(c, nil)
+	m.mkdir = func(string) error { return fmt.Errorf("permission denied") }
+	if _, err := m.Provision("alice", ProvisionRequest{Kind: KindUser, Owner: "alice", Capacity: "1Gi"}); !hasCode(err, def.ErrStorageProvision) {
+		t.Fatalf("provision without the directory: %v", err)
+	}
+
+	m.mkdir = func(string) error { return nil }
+	if _, err := m.Provision("alice", ProvisionRequest{Kind: KindUser, Owner: "alice", Capacity: "1Gi"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.created[0]; v.Server != "nas1" || v.Path != "/export/home/alice" {
+		t.Errorf("NFS volume is %+v", v)
+	}
+}
+
+func TestProvisionOthers(t *testing.T) {
+	beego.AppConfig.Set("uid::Admins", "root")
+	defer beego.AppConfig.Set("uid::Admins", "")
+	c := &fakeCluster{}
+	m, _ := newTestManager(c, nil)
+	for _, req := range []ProvisionRequest{
+		{Kind: KindUser, Owner: "alice", Capacity: "1Gi", Namespace: "bob"},
+		{Kind: KindProject, Owner: "md", Capacity: "1Gi", Namespace: "bob"},
+		{Kind: KindProject, Owner: "bob", Capacity: "1Gi"},
+	} {
+		if _, err := m.Provision("bob", req); !hasCode(err, def.ErrGeneralForbidden) {
+			t.Errorf("bob provisioned %+v: %v", req, err)
+		}
+	}
+	if _, err := m.Provision("root", ProvisionRequest{Kind: KindUser, Owner: "alice", Capacity: "1Gi"}); err != nil {
+		t.Errorf("admin provision: %v", err)
+	}
+	if len(c.created) != 1 {
+		t.Errorf("%d volumes created, want 1", len(c.created))
+	}
+}
+
+func TestListClaims(t *testing.T) {
+	c := &fakeCluster{
+		claims: []Claim{
+			{Namespace: "bob", Name: "data"},
+			{Namespace: "alice", Name: "home"},
+			{Namespace: "alice", Name: "scratch"},
+		},
+		mounts: map[string][]Mount{
+			"alice": {
+				{Pod: "desktop", Node: "node1", Claim: "home"},
+				{Pod: "job-1", Node: "node2", Claim: "home", ReadOnly: true},
+			},
+			"bob": {{Pod: "desktop", Node: "node1", Claim: "home"}},
+		},
+	}
+	m, _ := newTestManager(c, nil)
+	claims, err := m.ListClaims("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, claim := range claims {
+		s := claim.Namespace + "/" + claim.Name + ":"
+		for _, mount := range claim.MountedBy {
+			s += " " + mount.Pod + "@" + mount.Node
+		}
+		got = append(got, s)
+	}
+	want := []string{"alice/home: desktop@node1 job-1@node2", "alice/scratch:", "bob/data:"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("claims are %q, want %q", got, want)
+	}
+}
+
+func TestDeleteVolume(t *testing.T) {
+	c := &fakeCluster{volumes: []Volume{
+		{Name: "shared-user-alice", Owner: "alice", Claim: "alice/shared-user-alice"},
+		{Name: "shared-user-bob", Owner: "bob"},
+		{Name: "pvc-1234"},
+	}}
+	beego.AppConfig.Set("uid::Admins", "root")
+	defer beego.AppConfig.Set("uid::Admins", "")
+	m, _ := newTestManager(c, nil)
+	if err := m.DeleteVolume("bob", "shared-user-bob"); !hasCode(err, def.ErrGeneralForbidden) {
+		t.Errorf("bob deleted their volume: %v", err)
+	}
+	for name, code := range map[string]int{
+		"shared-user-alice": def.ErrGeneralConflict,
+		"pvc-1234":          def.ErrGeneralForbidden,
+		"shared-user-carol": def.ErrGeneralNotFound,
+	} {
+		if err := m.DeleteVolume("root", name); !hasCode(err, code) {
+			t.Errorf("delete %s: %v, want code %d", name, err, code)
+		}
+	}
+	if err := m.DeleteVolume("root", "shared-user-bob"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.deleted, []string{"shared-user-bob"}) {
+		t.Errorf("deleted %v", c.deleted)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	now := time.Now()
+	root := sysinfo.FileSystem{Device: "/dev/sda1", MountPoint: "/", Type: "ext4", Total: 100}
+	nfs := func(used uint64) sysinfo.FileSystem {
+		return sysinfo.FileSystem{Device: "nas1:/export", MountPoint: "/share", Type: "nfs4", Total: 1000, Used: used}
+	}
+	agents := fakeAgents{
+		"node1": {CollectedAt: now.Add(-time.Minute), FileSystems: []sysinfo.FileSystem{root, nfs(300)}},
+		"node2": {CollectedAt: now, FileSystems: []sysinfo.FileSystem{root, nfs(400)}},
+		// the shared path is not mounted, it is on the root filesystem
+		"node3": {CollectedAt: now, FileSystems: []sysinfo.FileSystem{
+			{Device: "/dev/nvme0n1p2", MountPoint: "/", Type: "xfs", Total: 50},
+			{Device: "/dev/nvme0n1p3", MountPoint: "/sharedata", Type: "xfs", Total: 50},
+		}},
+	}
+	m, _ := newTestManager(&fakeCluster{}, agents)
+	usage := m.Usage()
+	if len(usage) != 2 {
+		t.Fatalf("usage is %+v, want 2 filesystems", usage)
+	}
+	if u := usage[0]; u.Device != "/dev/nvme0n1p2" || !reflect.DeepEqual(u.Nodes, []string{"node3"}) {
+		t.Errorf("root usage is %+v", u)
+	}
+	if u := usage[1]; u.Device != "nas1:/export" || u.Used != 400 || !u.ReportedAt.Equal(now) ||
+		!reflect.DeepEqual(u.Nodes, []string{"node1", "node2"}) {
+		t.Errorf("shared usage is %+v", u)
+	}
+}
@@ -0,0 +1,78 @@
+package token
+
+import (
+	"fmt"
+)
+
+// Verbs understood by token rules, Any matches every verb or resource.
+const (
+	VerbGet    = "get"
+	VerbCreate = "create"
+	VerbUpdate = "update"
+	VerbDelete = "delete"
+	Any        = "*"
+)
+
+var verbs = map[string]bool{
+	VerbGet:    true,
+	VerbCreate: true,
+	VerbUpdate: true,
+	VerbDelete: true,
+	Any:        true,
+}
+
+// Rule allows the listed verbs on the listed API resources, resources are the
+// first path segment under /api, e.g. "pods".
+type Rule struct {
+	Resources []string `json:"resources"`
+	Verbs     []string `json:"verbs"`
+}
+
+// Scope limits what a token is allowed to do. An empty Namespaces list means
+// the rules apply to every namespace.
+type Scope struct {
+	Rules      []Rule   `json:"rules"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Validate checks that the scope grants something and only uses known verbs.
+func (s Scope) Validate() error {
+	if len(s.Rules) == 0 {
+		return fmt.Errorf("at least one rule is required")
+	}
+	for i, r := range s.Rules {
+		if len(r.Resources) == 0 || len(r.Verbs) == 0 {
+			return fmt.Errorf("rule %d must list resources and verbs", i)
+		}
+		for _, v := range r.Verbs {
+			if !verbs[v] {
+				return fmt.Errorf("rule %d has unknown verb %q", i, v)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the scope permits verb on resource in namespace, an
+// empty namespace means a cluster wide request, which a namespace restricted
+// scope never permits.
+func (s Scope) Allows(verb, resource, namespace string) bool {
+	if len(s.Namespaces) > 0 && !contains(s.Namespaces, namespace) {
+		return false
+	}
+	for _, r := range s.Rules {
+		if contains(r.Resources, resource) && contains(r.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s || item == Any {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s-server/conf"
+	"k8s-server/def"
+	"k8s-server/models/types"
+	"k8s-server/utils/errors"
+	"k8s-server/utils/logs"
+)
+
+const (
+	// Prefix marks a bearer credential as a personal access token, so the
+	// auth filter can tell it from a JWT session without a database lookup.
+	Prefix = "kst_"
+
+	secretBytes   = 32
+	displayLength = len(Prefix) + 6
+	// touchInterval throttles last-used updates to one write per token per
+	// interval unless the client IP changes.
+	touchInterval = time.Minute
+)
+
+// Store persists access tokens.
+type Store interface {
+	AddAccessToken(token *types.AccessToken) error
+	GetAccessTokenByHash(hash string) (*types.AccessToken, error)
+	ListAccessTokens(username string) ([]types.AccessToken, error)
+	RevokeAccessToken(id int64, username string) (int64, error)
+	TouchAccessToken(id int64, ip string, at time.Time) error
+}
+
+// Manager represents the access token manager.
+type Manager struct {
+	store Store
+
+	lock    sync.Mutex
+	touched map[int64]touch
+}
+
+type touch struct {
+	at time.Time
+	ip string
+}
+
+// CreateRequest describes a token to be created.
+type CreateRequest struct {
+	Name string `json:"name"`
+	// TTL is the token lifetime in seconds, zero means the configured max age.
+	TTL   int64 `json:"ttl"`
+	Scope Scope `json:"scope"`
+}
+
+// Token is the API view of a stored access token.
+type Token struct {
+	types.AccessToken
+	Scope Scope `json:"scope"`
+	// Secret is only filled in the response of Create.
+	Secret string `json:"secret,omitempty"`
+}
+
+// NewManager returns a token manager backed by store.
+func NewManager(store Store) (*Manager, error) {
+	return &Manager{
+		store:   store,
+		touched: make(map[int64]touch),
+	}, nil
+}
+
+// Create issues a token for username, the returned Secret is the only copy of
+// the plain token and can not be recovered later.
+func (m *Manager) Create(username string, req CreateRequest) (*Token, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, errors.New(def.ErrGeneralBadRequest, "token name is required")
+	}
+	if err := req.Scope.Validate(); err != nil {
+		return nil, errors.Wrap(err, def.ErrGeneralBadRequest, "invalid token scope")
+	}
+	scope, err := json.Marshal(req.Scope)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrTokenCreate, "encode token scope failed")
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrTokenCreate, "generate token failed")
+	}
+
+	now := time.Now()
+	ttl := time.Duration(req.TTL) * time.Second
+	if maxAge := conf.AccessTokenMaxAge(); ttl <= 0 || (maxAge > 0 && ttl > maxAge) {
+		ttl = maxAge
+	}
+	record := types.AccessToken{
+		Username:  username,
+		Name:      req.Name,
+		Prefix:    secret[:displayLength],
+		Hash:      hash(secret),
+		Scope:     string(scope),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		record.ExpiresAt = &expiresAt
+	}
+	if err = m.store.AddAccessToken(&record); err != nil {
+		return nil, errors.Wrap(err, def.ErrTokenCreate, "save token failed")
+	}
+	return &Token{AccessToken: record, Scope: req.Scope, Secret: secret}, nil
+}
+
+// List returns the tokens owned by username.
+func (m *Manager) List(username string) ([]Token, error) {
+	records, err := m.store.ListAccessTokens(username)
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrTokenList, "list tokens failed")
+	}
+	tokens := make([]Token, 0, len(records))
+	for _, r := range records {
+		tokens = append(tokens, Token{AccessToken: r, Scope: decodeScope(r.Scope)})
+	}
+	return tokens, nil
+}
+
+// Revoke revokes the token id owned by username.
+func (m *Manager) Revoke(username string, id int64) error {
+	n, err := m.store.RevokeAccessToken(id, username)
+	if err != nil {
+		return errors.Wrap(err, def.ErrTokenRevoke, "revoke token failed")
+	}
+	if n == 0 {
+		return errors.Errorf(def.ErrGeneralNotFound, "token %d not found", id)
+	}
+	return nil
+}
+
+// Authenticate validates the plain secret presented by a client at ip and
+// returns the matching token, it records the last-used time and IP.
+func (m *Manager) Authenticate(secret, ip string) (*Token, error) {
+	if !strings.HasPrefix(secret, Prefix) {
+		return nil, errors.New(def.ErrTokenInvalid, "malformed access token")
+	}
+	record, err := m.store.GetAccessTokenByHash(hash(secret))
+	if err != nil {
+		return nil, errors.Wrap(err, def.ErrTokenInvalid, "access token not found")
+	}
+	if record.Revoked {
+		return nil, errors.New(def.ErrTokenRevoked, "access token has been revoked")
+	}
+	now := time.Now()
+	if record.ExpiresAt != nil && now.After(*record.ExpiresAt) {
+		return nil, errors.New(def.ErrTokenExpired, "access token has expired")
+	}
+	if m.shouldTouch(record.ID, ip, now) {
+		if err = m.store.TouchAccessToken(record.ID, ip, now); err != nil {
+			logs.Warn("update last used of token %d failed: %v", record.ID, err)
+		}
+		record.LastUsedAt, record.LastUsedIP = &now, ip
+	}
+	return &Token{AccessToken: *record, Scope: decodeScope(record.Scope)}, nil
+}
+
+func (m *Manager) shouldTouch(id int64, ip string, now time.Time) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	last, ok := m.touched[id]
+	if ok && last.ip == ip && now.Sub(last.at) < touchInterval {
+		return false
+	}
+	m.touched[id] = touch{at: now, ip: ip}
+	return true
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return Prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeScope decodes a stored scope, a corrupted scope grants nothing.
+func decodeScope(text string) Scope {
+	var scope Scope
+	if err := json.Unmarshal([]byte(text), &scope); err != nil {
+		logs.Error("decode token scope failed: %v", err)
+		return Scope{}
+	}
+	return scope
+}
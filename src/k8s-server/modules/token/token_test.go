@@ -0,0 +1,151 @@
+package token
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-server/models/types"
+)
+
+type memStore struct {
+	tokens  []*types.AccessToken
+	touches int
+}
+
+func (s *memStore) AddAccessToken(t *types.AccessToken) error {
+	t.ID = int64(len(s.tokens) + 1)
+	s.tokens = append(s.tokens, t)
+	return nil
+}
+
+func (s *memStore) GetAccessTokenByHash(hash string) (*types.AccessToken, error) {
+	for _, t := range s.tokens {
+		if t.Hash == hash {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, types.ErrNotFound
+}
+
+func (s *memStore) ListAccessTokens(username string) ([]types.AccessToken, error) {
+	var list []types.AccessToken
+	for _, t := range s.tokens {
+		if t.Username == username {
+			list = append(list, *t)
+		}
+	}
+	return list, nil
+}
+
+func (s *memStore) RevokeAccessToken(id int64, username string) (int64, error) {
+	for _, t := range s.tokens {
+		if t.ID == id && t.Username == username {
+			t.Revoked = true
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (s *memStore) TouchAccessToken(id int64, ip string, at time.Time) error {
+	s.touches++
+	return nil
+}
+
+var podsReader = Scope{
+	Rules:      []Rule{{Resources: []string{"pods"}, Verbs: []string{VerbGet}}},
+	Namespaces: []string{"ci"},
+}
+
+func TestScopeAllows(t *testing.T) {
+	cases := []struct {
+		scope                     Scope
+		verb, resource, namespace string
+		want                      bool
+	}{
+		{podsReader, VerbGet, "pods", "ci", true},
+		{podsReader, VerbDelete, "pods", "ci", false},
+		{podsReader, VerbGet, "pods", "prod", false},
+		{podsReader, VerbGet, "pods", "", false},
+		{podsReader, VerbGet, "tokens", "ci", false},
+		{Scope{Rules: []Rule{{Resources: []string{Any}, Verbs: []string{Any}}}},
+			VerbDelete, "pods", "", true},
+	}
+	for i, c := range cases {
+		if got := c.scope.Allows(c.verb, c.resource, c.namespace); got != c.want {
+			t.Errorf("case %d: Allows(%s, %s, %s) = %v, want %v", i, c.verb,
+				c.resource, c.namespace, got, c.want)
+		}
+	}
+}
+
+func TestScopeValidate(t *testing.T) {
+	if err := (Scope{}).Validate(); err == nil {
+		t.Error("empty scope should be rejected")
+	}
+	bad := Scope{Rules: []Rule{{Resources: []string{"pods"}, Verbs: []string{"exec"}}}}
+	if err := bad.Validate(); err == nil {
+		t.Error("unknown verb should be rejected")
+	}
+	if err := podsReader.Validate(); err != nil {
+		t.Errorf("valid scope rejected: %v", err)
+	}
+}
+
+func TestCreateAuthenticateRevoke(t *testing.T) {
+	store := &memStore{}
+	m, _ := NewManager(store)
+
+	created, err := m.Create("alice", CreateRequest{Name: "ci", TTL: 3600, Scope: podsReader})
+	if err != nil {
+		t.Fatalf("create token failed: %v", err)
+	}
+	if !strings.HasPrefix(created.Secret, Prefix) {
+		t.Fatalf("secret %q lacks prefix %q", created.Secret, Prefix)
+	}
+	if store.tokens[0].Hash == created.Secret || strings.Contains(store.tokens[0].Hash, created.Secret) {
+		t.Fatal("plain secret must not be stored")
+	}
+
+	got, err := m.Authenticate(created.Secret, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("authenticate failed: %v", err)
+	}
+	if got.Username != "alice" || !got.Scope.Allows(VerbGet, "pods", "ci") {
+		t.Fatalf("unexpected token %+v", got)
+	}
+	m.Authenticate(created.Secret, "10.0.0.1")
+	m.Authenticate(created.Secret, "10.0.0.2")
+	if store.touches != 2 {
+		t.Errorf("last used recorded %d times, want 2", store.touches)
+	}
+
+	if _, err = m.Authenticate(Prefix+"unknown", "10.0.0.1"); err == nil {
+		t.Error("unknown token accepted")
+	}
+	if err = m.Revoke("bob", created.ID); err == nil {
+		t.Error("token revoked by another user")
+	}
+	if err = m.Revoke("alice", created.ID); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if _, err = m.Authenticate(created.Secret, "10.0.0.1"); err == nil {
+		t.Error("revoked token accepted")
+	}
+}
+
+func TestAuthenticateExpired(t *testing.T) {
+	store := &memStore{}
+	m, _ := NewManager(store)
+	created, err := m.Create("alice", CreateRequest{Name: "old", Scope: podsReader})
+	if err != nil {
+		t.Fatalf("create token failed: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	store.tokens[0].ExpiresAt = &past
+	if _, err = m.Authenticate(created.Secret, "10.0.0.1"); err == nil {
+		t.Error("expired token accepted")
+	}
+}
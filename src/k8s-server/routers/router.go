@@ -2,17 +2,83 @@ package routers
 
 import (
 	"k8s-server/controllers"
+	"k8s-server/filters"
+	"k8s-server/utils/metrics"
 	"github.com/astaxie/beego"
 )
 
 func init() {
 	beego.Router("/", &controllers.MainController{})
+	beego.InsertFilter("/*", beego.BeforeRouter, filters.RequestStart)
+	beego.InsertFilter("/api/*", beego.BeforeRouter, filters.Auth)
+	beego.InsertFilter("/*", beego.FinishRouter, filters.RequestFinish, false)
+	beego.Handler("/metrics", metrics.Handler(filters.Metrics))
 	APIs := beego.NewNamespace("/api",
 		beego.NSNamespace("/pods",
 			beego.NSInclude(
 				&controllers.Pod{},	
 			),
 		),
+		beego.NSNamespace("/tokens",
+			beego.NSInclude(
+				&controllers.Token{},
+			),
+		),
+		beego.NSNamespace("/templates",
+			beego.NSInclude(
+				&controllers.Template{},
+			),
+		),
+		beego.NSNamespace("/releases",
+			beego.NSInclude(
+				&controllers.Release{},
+			),
+		),
+		beego.NSNamespace("/harbor",
+			beego.NSInclude(
+				&controllers.Harbor{},
+			),
+		),
+		beego.NSNamespace("/agents",
+			beego.NSInclude(
+				&controllers.Agent{},
+			),
+		),
+		beego.NSNamespace("/inventory",
+			beego.NSInclude(
+				&controllers.Inventory{},
+			),
+		),
+		beego.NSNamespace("/power",
+			beego.NSInclude(
+				&controllers.Power{},
+			),
+		),
+		beego.NSNamespace("/jobs",
+			beego.NSInclude(
+				&controllers.Job{},
+			),
+		),
+		beego.NSNamespace("/images",
+			beego.NSInclude(
+				&controllers.Image{},
+			),
+		),
+		beego.NSNamespace("/builds",
+			beego.NSInclude(
+				&controllers.Build{},
+			),
+		),
+		beego.NSNamespace("/storage",
+			beego.NSInclude(
+				&controllers.Storage{},
+			),
+		),
+		beego.NSNamespace("/accounts",
+			beego.NSInclude(
+				&controllers.Account{},
+			),
+		),
 	)
-	beego.AddNamespaces(APIs)
+	beego.AddNamespace(APIs)
 }
@@ -0,0 +1,104 @@
+// Package kube builds the Kubernetes clients shared by the server modules,
+// clients are created on first use and reused afterwards.
+package kube
+
+import (
+	"sync"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"k8s-server/conf"
+)
+
+var (
+	lock          sync.Mutex
+	config        *rest.Config
+	clientset     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	mapper        *restmapper.DeferredDiscoveryRESTMapper
+)
+
+// Config returns the REST config loaded from backend::KubeConfig, or the
+// in-cluster config if no kubeconfig is set.
+func Config() (*rest.Config, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	return loadConfig()
+}
+
+func loadConfig() (*rest.Config, error) {
+	if config != nil {
+		return config, nil
+	}
+	c, err := clientcmd.BuildConfigFromFlags("", conf.KubeConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	config = c
+	return config, nil
+}
+
+// Clientset returns the typed Kubernetes client.
+func Clientset() (kubernetes.Interface, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if clientset != nil {
+		return clientset, nil
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	clientset = cs
+	return clientset, nil
+}
+
+// Dynamic returns the dynamic client used for arbitrary object kinds.
+func Dynamic() (dynamic.Interface, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if dynamicClient != nil {
+		return dynamicClient, nil
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	dc, err := dynamic.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient = dc
+	return dynamicClient, nil
+}
+
+// Mapper returns a RESTMapper backed by cached discovery, callers should
+// Reset it and retry once when a kind is not found, e.g. after a CRD was
+// installed.
+func Mapper() (*restmapper.DeferredDiscoveryRESTMapper, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if mapper != nil {
+		return mapper, nil
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))
+	return mapper, nil
+}
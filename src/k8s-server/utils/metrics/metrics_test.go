@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	gauge := Family{Name: "temperature_celsius", Help: "Temperature\nin C.", Type: Gauge}
+	gauge.Add(45, Labels{"gpu": "0", "name": `Tesla "V100"`})
+	gauge.Add(math.Inf(1), Labels{"gpu": "1"})
+	families := []Family{
+		gauge,
+		{Name: "empty", Help: "Skipped.", Type: Gauge},
+		NewGauge("up", "Up.", 1),
+	}
+
+	var b bytes.Buffer
+	if err := Write(&b, families); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP temperature_celsius Temperature\nin C.
+# TYPE temperature_celsius gauge
+temperature_celsius{gpu="0",name="Tesla \"V100\""} 45
+temperature_celsius{gpu="1"} +Inf
+# HELP up Up.
+# TYPE up gauge
+up 1
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/a")
+	h.Observe(0.1, "/a")
+	h.Observe(3, "/a")
+
+	var b bytes.Buffer
+	if err := Write(&b, []Family{h.Collect()}); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1",route="/a"} 2
+latency_seconds_bucket{le="1",route="/a"} 2
+latency_seconds_bucket{le="+Inf",route="/a"} 3
+latency_seconds_sum{route="/a"} 3.15
+latency_seconds_count{route="/a"} 3
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("requests_total", "Requests.", "code")
+	c.Inc("500")
+	c.Inc("200")
+	c.Add(2, "200")
+
+	f := c.Collect()
+	if len(f.Samples) != 2 || f.Samples[0].Labels["code"] != "200" || f.Samples[0].Value != 3 {
+		t.Errorf("unexpected samples %+v", f.Samples)
+	}
+}
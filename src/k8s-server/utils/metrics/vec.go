@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets in seconds, they suit the
+// latency of API requests.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// vec keeps one value per combination of label values.
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+	keys       []string
+	lock       sync.Mutex
+}
+
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labelNames) {
+		panic("metrics: " + v.name + " needs the label values of " + strings.Join(v.labelNames, ", "))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (v *vec) labels(key string) Labels {
+	labels := make(Labels, len(v.labelNames))
+	for i, value := range strings.Split(key, "\xff") {
+		if i < len(v.labelNames) {
+			labels[v.labelNames[i]] = value
+		}
+	}
+	return labels
+}
+
+// sortedKeys returns the keys in a stable order so scrapes are comparable.
+func (v *vec) sortedKeys() []string {
+	keys := append([]string(nil), v.keys...)
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounterVec returns a counter with the given label names.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		vec:    vec{name: name, help: help, labelNames: labelNames},
+		values: make(map[string]float64),
+	}
+}
+
+// Inc increments the counter of the label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta to the counter of the label values.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.values[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.values[key] += delta
+}
+
+// Collect returns the counter family.
+func (c *CounterVec) Collect() Family {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	f := Family{Name: c.name, Help: c.help, Type: Counter}
+	for _, key := range c.sortedKeys() {
+		f.Add(c.values[key], c.labels(key))
+	}
+	return f
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec returns a histogram with the given upper bounds, which
+// must be sorted, and label names.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{
+		vec:     vec{name: name, help: help, labelNames: labelNames},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+}
+
+// Observe adds an observation to the histogram of the label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+		h.keys = append(h.keys, key)
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.sum += value
+	hist.count++
+}
+
+// Collect returns the histogram family with cumulative buckets.
+func (h *HistogramVec) Collect() Family {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	f := Family{Name: h.name, Help: h.help, Type: Histogram}
+	for _, key := range h.sortedKeys() {
+		hist := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			f.Samples = append(f.Samples, Sample{
+				Suffix: "_bucket",
+				Labels: h.bucketLabels(key, formatValue(bound)),
+				Value:  float64(cumulative),
+			})
+		}
+		f.Samples = append(f.Samples,
+			Sample{Suffix: "_bucket", Labels: h.bucketLabels(key, "+Inf"), Value: float64(hist.count)},
+			Sample{Suffix: "_sum", Labels: h.labels(key), Value: hist.sum},
+			Sample{Suffix: "_count", Labels: h.labels(key), Value: float64(hist.count)},
+		)
+	}
+	return f
+}
+
+func (h *HistogramVec) bucketLabels(key, le string) Labels {
+	labels := h.labels(key)
+	labels["le"] = le
+	return labels
+}
@@ -0,0 +1,36 @@
+// Package redisutil builds Redis connection pools from the configuration.
+package redisutil
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"k8s-server/conf"
+)
+
+// NewPool returns a connection pool of the Redis server described by config,
+// connections are dialed lazily.
+func NewPool(config *conf.RedisConfig) *redis.Pool {
+	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	timeout := time.Duration(config.ConnectTimeout) * time.Second
+	return &redis.Pool{
+		MaxIdle:     config.MaxIdle,
+		MaxActive:   config.MaxActive,
+		IdleTimeout: time.Duration(config.IdleTimeout) * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr,
+				redis.DialPassword(config.Password),
+				redis.DialDatabase(config.Db),
+				redis.DialConnectTimeout(timeout))
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}